@@ -0,0 +1,97 @@
+package goldb
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+	"github.com/hasssanezzz/goldb/internal/shared"
+	"github.com/hasssanezzz/goldb/internal/wal"
+)
+
+// maxExtents bounds the records a value appended to is spread over, past it
+// Append writes the value again as a single record.
+const maxExtents = 64
+
+// Append adds data at the end of the value of key, creating the key when it does
+// not exist, and keeps its TTL. Only data is written: it is stored as a record
+// of its own in the value file and the key points at the records of its value
+// followed by the new one, so appending to a growing value does not read and
+// write it again. A value spread over maxExtents records is written again as a
+// single one, small values kept inline are simply concatenated. Appending
+// nothing does nothing.
+func (e *Engine) Append(key string, data []byte) error {
+	if len([]byte(key)) > int(e.Config.KeySize) {
+		return &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
+	}
+	if err := checkUserKey(key); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	for {
+		err := e.append(key, data)
+		if err != errKeyRaced {
+			return err
+		}
+	}
+}
+
+func (e *Engine) append(key string, data []byte) error {
+	current, found, err := e.indexManager.Lookup(key)
+	if err != nil {
+		return fmt.Errorf("db engine can not locate key (%q): %v", key, err)
+	}
+	_, err = e.indexManager.Get(key)
+	if _, ok := err.(*shared.ErrKeyNotFound); err != nil && !ok {
+		return fmt.Errorf("db engine can not locate key (%q): %v", key, err)
+	}
+	live := err == nil
+	// a garbage collection of the value file would drop the new record, no key points at it yet
+	generation := e.values.generation.Load()
+
+	entry := wal.WALEntry{Key: key, Value: data, Timestamp: time.Now().UnixNano()}
+	switch {
+	case !live:
+	case current.Inline != nil:
+		entry.Value, entry.ExpiresAt = append(slices.Clone(current.Inline), data...), current.ExpiresAt
+	case len(current.Extents)+1 >= maxExtents:
+		e.values.mu.RLock()
+		value, err := e.storageManager.ReadValue(current)
+		e.values.mu.RUnlock()
+		if err != nil {
+			return fmt.Errorf("db engine can not read key (%q): %v", key, err)
+		}
+		entry.Value, entry.ExpiresAt = append(value, data...), current.ExpiresAt
+	default:
+		record, err := e.storageManager.WriteValue(data, true)
+		if err != nil {
+			return fmt.Errorf("db engine can not write (%q, %x): %v", key, data, err)
+		}
+		// the new record is only linked in the WAL, it must outlive a crash
+		if err := e.syncValues(); err != nil {
+			return err
+		}
+		node := current
+		node.Extents = append(slices.Clone(current.Extents), memtable.Extent{Offset: record.Offset, Size: record.Size, Compressed: record.Compressed})
+		entry = wal.WALEntry{Key: key, Timestamp: entry.Timestamp, Link: &node}
+	}
+
+	req := writeRequest{entry: entry, done: make(chan error, 1)}
+	// the value appended to must still be the one of key when the batch commits
+	validate := func() error {
+		now, ok, err := e.indexManager.Lookup(key)
+		if err != nil {
+			return fmt.Errorf("db engine can not locate key (%q): %v", key, err)
+		}
+		if ok != found || ok && !now.Equal(current) || e.values.generation.Load() != generation {
+			return errKeyRaced
+		}
+		return nil
+	}
+	e.pipeline.submitBatch(&commitBatch{requests: []writeRequest{req}, validate: validate})
+	return <-req.done
+}
@@ -0,0 +1,184 @@
+package goldb
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archiveDir is the directory of the home holding the files waiting to be
+// shipped by the archiver.
+const archiveDir = "archive"
+
+const (
+	defaultArchiveInterval = 10 * time.Second
+	maxArchiveBackoff      = 5 * time.Minute
+)
+
+// DirArchive is an ArchiveDestination storing the files in a directory, like one
+// on another disk or a mounted network share. Object stores such as S3 need a
+// client the engine does not ship with, they can be used through an
+// ArchiveDestination of their own.
+type DirArchive string
+
+// Put writes content to a temporary file synced to disk, then renames it to name.
+func (d DirArchive) Put(name string, content io.Reader) error {
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(string(d), name)
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func (d DirArchive) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), name))
+}
+
+// archiver ships the files of the archive directory to the destination in the
+// background, oldest first. A file is only removed once the copy read back from
+// the destination has the same checksum. Failures are retried with a backoff
+// doubling up to maxArchiveBackoff, the files wait in the directory meanwhile,
+// across restarts too.
+type archiver struct {
+	destination ArchiveDestination
+	dir         string
+	mu          sync.Mutex // Serializes the shipping passes.
+	stop        chan struct{}
+	wg          sync.WaitGroup
+}
+
+func newArchiver(destination ArchiveDestination, dir string, interval time.Duration) *archiver {
+	if interval <= 0 {
+		interval = defaultArchiveInterval
+	}
+	a := &archiver{destination: destination, dir: dir, stop: make(chan struct{})}
+	a.wg.Add(1)
+	go labeled("archiver", func() { a.run(interval) })
+	return a
+}
+
+func (a *archiver) run(interval time.Duration) {
+	defer a.wg.Done()
+
+	backoff := interval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if err := a.ship(); err != nil {
+				log.Printf("engine can not archive, retrying in %v: %v\n", backoff, err)
+				timer.Reset(backoff)
+				backoff = min(backoff*2, maxArchiveBackoff)
+				continue
+			}
+			backoff = interval
+			timer.Reset(interval)
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// ship ships the pending files in the order they were closed, and stops at the
+// first one failing so the archive never skips one.
+func (a *archiver) ship() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entries, err := os.ReadDir(a.dir)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasSuffix(entry.Name(), ".tmp") {
+			names = append(names, entry.Name())
+		}
+	}
+	// segment numbers are zero padded, the WAL written before segments goes first
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := a.shipFile(name); err != nil {
+			return fmt.Errorf("can not ship %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// shipFile puts the file to the destination, verifies the copy and removes the file.
+func (a *archiver) shipFile(name string) error {
+	path := filepath.Join(a.dir, name)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	checksum := crc32.NewIEEE()
+	err = a.destination.Put(name, io.TeeReader(file, checksum))
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	copied, err := a.destination.Open(name)
+	if err != nil {
+		return fmt.Errorf("can not read the copy back: %v", err)
+	}
+	copiedChecksum := crc32.NewIEEE()
+	_, err = io.Copy(copiedChecksum, copied)
+	copied.Close()
+	if err != nil {
+		return fmt.Errorf("can not read the copy back: %v", err)
+	}
+	if copiedChecksum.Sum32() != checksum.Sum32() {
+		return fmt.Errorf("the copy has checksum %08x, expected %08x", copiedChecksum.Sum32(), checksum.Sum32())
+	}
+
+	return os.Remove(path)
+}
+
+func (a *archiver) close() {
+	close(a.stop)
+	a.wg.Wait()
+}
+
+// ShipArchive ships the closed WAL segments waiting for the archiver right away,
+// returning the first failure. Segments are shipped in the background anyway,
+// this suits making sure the archive is current before a planned shutdown.
+// The segment the WAL is writing to is only shipped once it is closed, by a
+// rotation or a flush.
+func (e *Engine) ShipArchive() error {
+	if e.archiver == nil {
+		return fmt.Errorf("db engine can not ship the archive: no Archive is configured")
+	}
+	if err := e.archiver.ship(); err != nil {
+		return fmt.Errorf("db engine can not ship the archive: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package goldb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+	"github.com/hasssanezzz/goldb/internal/wal"
+)
+
+// asyncQueueSize is the number of asynchronous writes queued before SetAsync
+// and DeleteAsync block.
+const asyncQueueSize = maxCoalescedWrites
+
+// Future is the outcome of a write issued with SetAsync or DeleteAsync.
+type Future struct {
+	done      chan error
+	mu        sync.Mutex
+	completed bool
+	err       error
+}
+
+// Wait blocks until the write is visible to readers, or failed, and returns its error.
+func (f *Future) Wait() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.completed {
+		f.err = <-f.done
+		f.completed = true
+	}
+	return f.err
+}
+
+// Poll reports whether the write completed, and its error if so, without waiting for it.
+func (f *Future) Poll() (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.completed {
+		select {
+		case f.err = <-f.done:
+			f.completed = true
+		default:
+		}
+	}
+	return f.completed, f.err
+}
+
+// asyncQueue collects the asynchronous writes and hands whatever accumulated to
+// the commit pipeline as one batch, so many writes can be in flight without a
+// goroutine each.
+type asyncQueue struct {
+	pipeline *pipeline
+	requests chan writeRequest
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newAsyncQueue(p *pipeline) *asyncQueue {
+	q := &asyncQueue{
+		pipeline: p,
+		requests: make(chan writeRequest, asyncQueueSize),
+		stop:     make(chan struct{}),
+	}
+	q.wg.Add(1)
+	go labeled("async-writes", q.run)
+	return q
+}
+
+func (q *asyncQueue) submit(req writeRequest) {
+	select {
+	case q.requests <- req:
+	case <-q.stop:
+		req.done <- errEngineClosed
+	}
+}
+
+func (q *asyncQueue) run() {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case req := <-q.requests:
+			q.pipeline.submit(q.collect(req))
+		case <-q.stop:
+			// commit what was queued before closing
+			for len(q.requests) > 0 {
+				q.pipeline.submit(q.collect(<-q.requests))
+			}
+			return
+		}
+	}
+}
+
+// collect groups req with the requests already waiting, without waiting for more.
+func (q *asyncQueue) collect(req writeRequest) []writeRequest {
+	batch := []writeRequest{req}
+	for len(batch) < maxCoalescedWrites {
+		select {
+		case req := <-q.requests:
+			batch = append(batch, req)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (q *asyncQueue) close() {
+	close(q.stop)
+	q.wg.Wait()
+}
+
+// SetAsync queues a write and returns without waiting for it, the returned future
+// tells when it is durable and visible to readers. Writes issued from the same
+// goroutine are committed in order. SetAsync blocks only when a large number of
+// writes is already queued.
+//
+//	futures := make([]*goldb.Future, len(keys))
+//	for i, key := range keys {
+//		futures[i] = db.SetAsync(key, values[i])
+//	}
+//	for _, future := range futures {
+//		if err := future.Wait(); err != nil {
+//			return err
+//		}
+//	}
+func (e *Engine) SetAsync(key string, value []byte) *Future {
+	return e.commitAsync(key, value)
+}
+
+// DeleteAsync queues a delete, see SetAsync.
+func (e *Engine) DeleteAsync(key string) *Future {
+	return e.commitAsync(key, []byte{})
+}
+
+func (e *Engine) commitAsync(key string, value []byte) *Future {
+	req := writeRequest{
+		entry: wal.WALEntry{Key: key, Value: value, Timestamp: time.Now().UnixNano()},
+		done:  make(chan error, 1),
+	}
+	future := &Future{done: req.done}
+
+	switch {
+	case shared.IsSystemKey(key):
+		req.done <- &ErrReservedKey{Key: key}
+	case len([]byte(key)) > int(e.Config.KeySize):
+		req.done <- &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
+	case e.Config.RelaxedWrites:
+		// relaxed writes do not wait for the WAL already
+		req.done <- e.pipeline.commitRelaxed(req)
+	default:
+		e.asyncQueue.submit(req)
+	}
+	return future
+}
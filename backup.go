@@ -0,0 +1,590 @@
+package goldb
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/index_manager"
+	"github.com/hasssanezzz/goldb/internal/memtable"
+	"github.com/hasssanezzz/goldb/internal/shared"
+	"github.com/hasssanezzz/goldb/internal/storage_manager"
+	"github.com/hasssanezzz/goldb/internal/wal"
+)
+
+// BackupPrefix writes the keys starting with prefix to a new database at dir,
+// which must not exist or be empty, and returns the number of keys backed up.
+// The pairs are written as a single table and their values copied as they are
+// stored, keeping their write times and expiries; the keys of the other
+// namespaces are left out. The backup holds the keys as of its start, writes go
+// on meanwhile. RestorePrefix brings the namespace back, RestoreBackup and
+// OpenSnapshot read the backup like any other.
+func (e *Engine) BackupPrefix(dir, prefix string) (int, error) {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return 0, fmt.Errorf("db engine can not back up prefix (%q) to %q: the directory is not empty", prefix, dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("db engine can not back up prefix (%q): %v", prefix, err)
+	}
+
+	opts := index_manager.NewScanOptions()
+	opts.Start, opts.End = prefix, shared.PrefixEnd(prefix)
+
+	// a garbage collection of the value file would move the values being copied
+	e.values.mu.RLock()
+	pairs, err := e.indexManager.Items(opts)
+	if err != nil {
+		e.values.mu.RUnlock()
+		return 0, fmt.Errorf("db engine can not list prefix (%q): %v", prefix, err)
+	}
+	nodes := []memtable.IndexNode{}
+	copied := map[uint32]struct{}{}
+	for _, pair := range pairs {
+		// deduplicated values are shared by several keys
+		for _, record := range pair.Value.Records() {
+			if _, ok := copied[record.Offset]; !ok {
+				copied[record.Offset] = struct{}{}
+				nodes = append(nodes, record)
+			}
+		}
+	}
+	moved, err := e.storageManager.CopyTo(filepath.Join(dir, "data.bin"), nodes)
+	e.values.mu.RUnlock()
+	if err != nil {
+		return 0, fmt.Errorf("db engine can not back up prefix (%q): %v", prefix, err)
+	}
+	if len(pairs) == 0 {
+		return 0, nil
+	}
+	for i := range pairs {
+		if pairs[i].Value.Inline == nil {
+			pairs[i].Value.Relocate(moved)
+		}
+	}
+
+	config := e.Config
+	config.Homepath = dir
+	indexManager, err := index_manager.New(&config)
+	if err != nil {
+		return 0, fmt.Errorf("db engine can not back up prefix (%q): %v", prefix, err)
+	}
+	defer indexManager.Close()
+	if err := indexManager.Ingest(pairs); err != nil {
+		return 0, fmt.Errorf("db engine can not back up prefix (%q): %v", prefix, err)
+	}
+	return len(pairs), nil
+}
+
+// RestorePrefix brings back the keys starting with prefix from the backup at
+// backupDir, as written by BackupPrefix or Backup or a copy of the directory of
+// a whole database, leaving the other keys untouched: the keys under the prefix take
+// the values they had in the backup and the ones the backup does not hold are
+// deleted. The tables of the backup are read over the range of the prefix and
+// its WAL, followed by the archived segments of options.ArchiveDir, is replayed
+// for the keys of the prefix only.
+//
+// The backup is verified first like RestoreBackup does, and with DryRun nothing
+// is written; the report counts the keys of the prefix. The restored keys are
+// written in as few batches as the batch limits allow, a restore spanning
+// several batches is not atomic.
+func (e *Engine) RestorePrefix(backupDir, prefix string, options RestoreOptions) (RestoreReport, error) {
+	source, manifest, remove, err := stageBackup(backupDir, "")
+	if err != nil {
+		return RestoreReport{}, fmt.Errorf("db engine can not restore backup %q: %v", backupDir, err)
+	}
+	defer remove()
+	config := e.Config
+	config.Homepath = source
+
+	report, segments, err := verifyBackup(&config, options.ArchiveDir)
+	report.Number, report.Sequence = manifest.Number, manifest.Sequence
+	if err != nil {
+		return report, fmt.Errorf("db engine can not restore backup %q: %v", backupDir, err)
+	}
+	restored, err := readPrefix(&config, segments, prefix)
+	if err != nil {
+		return report, fmt.Errorf("db engine can not restore prefix (%q) from backup %q: %v", prefix, backupDir, err)
+	}
+	report.Keys = len(restored)
+	if options.DryRun {
+		return report, nil
+	}
+
+	opts := index_manager.NewScanOptions()
+	opts.Start, opts.End = prefix, shared.PrefixEnd(prefix)
+	opts.KeysOnly = true
+	live, err := e.indexManager.Items(opts)
+	if err != nil {
+		return report, fmt.Errorf("db engine can not list prefix (%q): %v", prefix, err)
+	}
+
+	batch := e.NewBatch()
+	makeRoom := func(key string, value []byte) error {
+		if batch.fits(key, value) {
+			return nil
+		}
+		if err := batch.Commit(); err != nil {
+			return err
+		}
+		batch = e.NewBatch()
+		return nil
+	}
+	kept := make(map[string]struct{}, len(restored))
+	for _, entry := range restored {
+		kept[entry.Key] = struct{}{}
+	}
+	for _, pair := range live {
+		if _, ok := kept[pair.Key]; ok {
+			continue
+		}
+		if err := makeRoom(pair.Key, nil); err != nil {
+			return report, err
+		}
+		batch.Delete(pair.Key)
+	}
+	for _, entry := range restored {
+		if err := makeRoom(entry.Key, entry.Value); err != nil {
+			return report, err
+		}
+		batch.setExpiring(entry.Key, entry.Value, entry.ExpiresAt)
+	}
+	if err := batch.Commit(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// readPrefix returns the keys starting with prefix held by the backup at
+// config.Homepath once the WAL segments are replayed, with their values and
+// expiries, in ascending order.
+func readPrefix(config *shared.EngineConfig, segments []string, prefix string) ([]wal.WALEntry, error) {
+	indexManager, err := index_manager.New(config)
+	if err != nil {
+		return nil, err
+	}
+	defer indexManager.Close()
+
+	storageManager, err := storage_manager.NewReadOnly(filepath.Join(config.Homepath, "data.bin"))
+	if err != nil {
+		return nil, err
+	}
+	defer storageManager.Close()
+	storageManager.SetCompressor(config.Compression)
+
+	opts := index_manager.NewScanOptions()
+	opts.Start, opts.End = prefix, shared.PrefixEnd(prefix)
+	pairs, err := indexManager.Items(opts)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]wal.WALEntry, len(pairs))
+	for _, pair := range pairs {
+		value, err := storageManager.ReadValue(pair.Value)
+		if err != nil {
+			return nil, fmt.Errorf("can not read key (%q): %v", pair.Key, err)
+		}
+		keys[pair.Key] = wal.WALEntry{Key: pair.Key, Value: value, ExpiresAt: pair.Value.ExpiresAt}
+	}
+
+	walKeys := append([][]byte{config.WALEncryptionKey}, config.WALDecryptionKeys...)
+	entries, err := wal.Inspect(segments, config.KeySize, config.WALKeyProvider, walKeys...)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Key, prefix) || shared.IsSystemKey(entry.Key) {
+			continue
+		}
+		switch {
+		case entry.Link != nil:
+			value, err := storageManager.ReadValue(*entry.Link)
+			if err != nil {
+				return nil, fmt.Errorf("can not read the value linked to key (%q): %v", entry.Key, err)
+			}
+			keys[entry.Key] = wal.WALEntry{Key: entry.Key, Value: value, ExpiresAt: entry.Link.ExpiresAt}
+		case len(entry.Value) > 0:
+			keys[entry.Key] = wal.WALEntry{Key: entry.Key, Value: entry.Value, ExpiresAt: entry.ExpiresAt}
+		default:
+			delete(keys, entry.Key)
+		}
+	}
+
+	now := time.Now().UnixNano()
+	restored := make([]wal.WALEntry, 0, len(keys))
+	for _, entry := range keys {
+		// keys expiring in between are not worth bringing back
+		if entry.ExpiresAt != 0 && entry.ExpiresAt <= now {
+			continue
+		}
+		restored = append(restored, entry)
+	}
+	sort.Slice(restored, func(i, j int) bool { return restored[i].Key < restored[j].Key })
+	return restored, nil
+}
+
+// backupManifestFile lists the files of a backup of a chain, see Backup.
+const backupManifestFile = "backup.json"
+
+// BackupInfo describes a backup written by Backup.
+type BackupInfo struct {
+	Number      int    // Position of the backup in its chain, the first one is 1.
+	Sequence    uint64 // Sequence number of the last write the backup holds.
+	Files       int    // Files of the database the backup is made of.
+	Transferred int    // Files copied in full or in part, the others are held by earlier backups of the chain.
+	Bytes       int64  // Bytes copied.
+}
+
+// backupManifest lists the files of the database as of a backup of the chain
+// and the backups holding their bytes.
+type backupManifest struct {
+	Number   int
+	Sequence uint64
+	Time     int64
+	Files    []backupFile
+}
+
+// backupFile is a file of the database, made of parts held by the backups of
+// the chain one after the other.
+type backupFile struct {
+	Name    string
+	Size    int64
+	ModTime int64
+	CRC     uint32 // CRC-32 of the whole file.
+	Parts   []backupPart
+}
+
+// backupPart is a run of bytes of a file, stored under the name of the file in
+// the directory of the backup holding it.
+type backupPart struct {
+	Backup int // Number of the backup holding the part.
+	Offset int64
+	Size   int64
+	CRC    uint32
+}
+
+// Backup adds a backup of the database to the chain of backups at dir, which
+// is created if needed, and returns what it holds. Every backup is a numbered
+// directory (000001, 000002, ...) listing the files of the database in its
+// backup.json; only the files that are new or changed since the previous backup
+// of the chain are copied into it, the others are taken from the backups
+// holding them. Tables are immutable and copied once, files that only grew,
+// like the value file, have their new bytes copied. The first backup of a chain
+// is a full one.
+//
+// The memtable is flushed first and writes wait until the files to copy are
+// opened, the copy itself goes on along with the writes and the compactions.
+// The backup holds every write acknowledged before it started. RestoreBackup
+// lays a backup of the chain out as a database again.
+func (e *Engine) Backup(dir string) (BackupInfo, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return BackupInfo{}, fmt.Errorf("db engine can not back up to %q: %v", dir, err)
+	}
+	previous, err := lastBackup(dir)
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("db engine can not back up to %q: %v", dir, err)
+	}
+
+	var files []*os.File
+	var sizes map[*os.File]int64
+	info := BackupInfo{Number: previous.Number + 1}
+	err = e.pipeline.exclusive(func() error {
+		if e.indexManager.MemtableSize() > 0 {
+			e.flush()
+		}
+		if err := e.syncValues(); err != nil {
+			return err
+		}
+		info.Sequence = e.sequence.Load()
+
+		var err error
+		files, sizes, err = e.openFiles()
+		return err
+	})
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("db engine can not back up to %q: %v", dir, err)
+	}
+
+	target := filepath.Join(dir, fmt.Sprintf("%06d", info.Number))
+	// a backup that did not get to write its manifest is left over
+	if err := os.RemoveAll(target); err != nil {
+		return BackupInfo{}, fmt.Errorf("db engine can not back up to %q: %v", dir, err)
+	}
+	if err := os.Mkdir(target, 0755); err != nil {
+		return BackupInfo{}, fmt.Errorf("db engine can not back up to %q: %v", dir, err)
+	}
+
+	known := map[string]backupFile{}
+	for _, file := range previous.Files {
+		known[file.Name] = file
+	}
+	manifest := backupManifest{Number: info.Number, Sequence: info.Sequence, Time: time.Now().UnixNano()}
+	for _, file := range files {
+		stat, err := file.Stat()
+		if err != nil {
+			return BackupInfo{}, fmt.Errorf("db engine can not back up to %q: %v", dir, err)
+		}
+		size, ok := sizes[file]
+		if !ok {
+			size = stat.Size()
+		}
+		copied, n, err := backupFileTo(target, file, size, stat.ModTime().UnixNano(), known[stat.Name()], info.Number)
+		if err != nil {
+			return BackupInfo{}, fmt.Errorf("db engine can not back up %q to %q: %v", stat.Name(), dir, err)
+		}
+		manifest.Files = append(manifest.Files, copied)
+		if n > 0 {
+			info.Transferred++
+			info.Bytes += n
+		}
+	}
+	info.Files = len(manifest.Files)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	if err := writeSynced(filepath.Join(target, backupManifestFile), data); err != nil {
+		return BackupInfo{}, fmt.Errorf("db engine can not back up to %q: %v", dir, err)
+	}
+	if err := syncPath(target); err != nil {
+		return BackupInfo{}, fmt.Errorf("db engine can not back up to %q: %v", dir, err)
+	}
+	if err := syncPath(dir); err != nil {
+		return BackupInfo{}, fmt.Errorf("db engine can not back up to %q: %v", dir, err)
+	}
+	return info, nil
+}
+
+// verifyBackupFile makes sure the parts of file, as listed by the backup
+// numbered number of the chain at dir, make up the whole file and pass their
+// checksums.
+func verifyBackupFile(dir string, number int, file backupFile) error {
+	// names come from the manifest, they must not lead out of the directories
+	if file.Name != filepath.Base(file.Name) || file.Name == "." || file.Name == ".." {
+		return fmt.Errorf("invalid file name")
+	}
+	offset := int64(0)
+	fileSum := &crcWriter{}
+	for _, part := range file.Parts {
+		if part.Offset != offset || part.Backup < 1 || part.Backup > number {
+			return fmt.Errorf("the part at offset %d of backup %d does not follow the previous parts", part.Offset, part.Backup)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%06d", part.Backup), file.Name)
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		partSum := &crcWriter{}
+		n, err := io.Copy(io.MultiWriter(partSum, fileSum), in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+		if n != part.Size {
+			return fmt.Errorf("the part held by backup %d is %d bytes long, %d expected", part.Backup, n, part.Size)
+		}
+		if partSum.sum != part.CRC {
+			return fmt.Errorf("the part held by backup %d does not match its checksum", part.Backup)
+		}
+		offset += part.Size
+	}
+	if offset != file.Size {
+		return fmt.Errorf("the parts hold %d bytes, %d expected", offset, file.Size)
+	}
+	if fileSum.sum != file.CRC {
+		return fmt.Errorf("the file does not match its checksum")
+	}
+	return nil
+}
+
+// restoreBackupFile writes file to homepath, joining its parts held by the
+// backups of the chain at dir.
+func restoreBackupFile(dir, homepath string, file backupFile) error {
+	out, err := os.Create(filepath.Join(homepath, file.Name))
+	if err != nil {
+		return err
+	}
+	for _, part := range file.Parts {
+		var in *os.File
+		if in, err = os.Open(filepath.Join(dir, fmt.Sprintf("%06d", part.Backup), file.Name)); err != nil {
+			break
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// openFiles opens the files making up the database, its tables first, and
+// returns the sizes of the ones that are appended to, the value file and the
+// WAL: their bytes past the current end belong to later writes. The caller
+// holds writeMu.
+func (e *Engine) openFiles() ([]*os.File, map[*os.File]int64, error) {
+	files, err := e.indexManager.OpenTableFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+	closeAll := func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+
+	sizes := map[*os.File]int64{}
+	segments, err := e.wal.Segments()
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	paths := append([]string{filepath.Join(e.Config.Homepath, "data.bin")}, segments...)
+	paths = append(paths, filepath.Join(e.Config.Homepath, collationsFile))
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		files = append(files, file)
+		stat, err := file.Stat()
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		sizes[file] = stat.Size()
+	}
+	return files, sizes, nil
+}
+
+// backupFileTo records the first size bytes of file in the backup at target.
+// Like the previous backup had it, the file is taken from the backups holding
+// it; when it only grew since, the new bytes are copied; otherwise the whole
+// file is. Returns the file as recorded and the number of bytes copied.
+func backupFileTo(target string, file *os.File, size, modTime int64, previous backupFile, number int) (backupFile, int64, error) {
+	name := filepath.Base(file.Name())
+	recorded := backupFile{Name: name, Size: size, ModTime: modTime}
+	if previous.Name == name && previous.Size == size && previous.ModTime == modTime {
+		recorded.CRC, recorded.Parts = previous.CRC, previous.Parts
+		return recorded, 0, nil
+	}
+
+	offset := int64(0)
+	if previous.Name == name && previous.Size > 0 && previous.Size <= size {
+		sum := &crcWriter{}
+		if _, err := io.Copy(sum, io.NewSectionReader(file, 0, previous.Size)); err != nil {
+			return recorded, 0, err
+		}
+		if sum.sum == previous.CRC {
+			offset = previous.Size
+			recorded.CRC, recorded.Parts = previous.CRC, previous.Parts
+		}
+	}
+	if offset == size {
+		return recorded, 0, nil
+	}
+
+	out, err := os.Create(filepath.Join(target, name))
+	if err != nil {
+		return recorded, 0, err
+	}
+	part := backupPart{Backup: number, Offset: offset, Size: size - offset}
+	partSum, fileSum := &crcWriter{}, &crcWriter{sum: recorded.CRC}
+	_, err = io.Copy(io.MultiWriter(out, partSum, fileSum), io.NewSectionReader(file, offset, part.Size))
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return recorded, 0, err
+	}
+
+	part.CRC, recorded.CRC = partSum.sum, fileSum.sum
+	recorded.Parts = append(append([]backupPart{}, recorded.Parts...), part)
+	return recorded, part.Size, nil
+}
+
+// lastBackup returns the manifest of the latest complete backup of the chain at
+// dir, an empty one when there is none.
+func lastBackup(dir string) (backupManifest, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return backupManifest{}, err
+	}
+	last := backupManifest{}
+	for _, entry := range entries {
+		n, err := strconv.Atoi(entry.Name())
+		if err != nil || n <= last.Number || !entry.IsDir() {
+			continue
+		}
+		manifest, err := readBackupManifest(filepath.Join(dir, entry.Name()))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return backupManifest{}, err
+		}
+		last = manifest
+	}
+	return last, nil
+}
+
+// readBackupManifest reads the manifest of the backup at dir.
+func readBackupManifest(dir string) (backupManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, backupManifestFile))
+	if err != nil {
+		return backupManifest{}, err
+	}
+	manifest := backupManifest{}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return backupManifest{}, fmt.Errorf("can not parse the manifest of backup %q: %v", filepath.Base(dir), err)
+	}
+	return manifest, nil
+}
+
+// crcWriter computes the CRC-32 of the bytes written to it, carrying on from sum.
+type crcWriter struct{ sum uint32 }
+
+func (w *crcWriter) Write(p []byte) (int, error) {
+	w.sum = crc32.Update(w.sum, crc32.IEEETable, p)
+	return len(p), nil
+}
+
+func writeSynced(path string, data []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
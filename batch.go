@@ -0,0 +1,235 @@
+package goldb
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+	"github.com/hasssanezzz/goldb/internal/wal"
+)
+
+// Batch groups writes that are committed together with a single WAL append.
+// A batch can also take part in a two-phase commit driven by an external
+// coordinator: Prepare persists the batch in the WAL without applying it, and a
+// later Commit or Rollback decides its fate, even across restarts.
+type Batch struct {
+	engine  *Engine
+	entries []wal.WALEntry
+	size    int    // Bytes of the keys and values in the batch.
+	id      string // Set once the batch is prepared.
+}
+
+// NewBatch returns an empty batch.
+func (e *Engine) NewBatch() *Batch {
+	return &Batch{engine: e}
+}
+
+// Set adds a set operation to the batch.
+func (b *Batch) Set(key string, value []byte) {
+	b.entries = append(b.entries, wal.WALEntry{Key: key, Value: value})
+	b.size += len(key) + len(value)
+}
+
+// SetWithTTL adds a set operation of a key expiring once ttl passed, see
+// Engine.SetWithTTL. With a ttl of zero or less the key never expires.
+func (b *Batch) SetWithTTL(key string, value []byte, ttl time.Duration) {
+	expiresAt := int64(0)
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl).UnixNano()
+	}
+	b.setExpiring(key, value, expiresAt)
+}
+
+// setExpiring adds a set operation of a key expiring at expiresAt, in unix
+// nanoseconds, or never when zero.
+func (b *Batch) setExpiring(key string, value []byte, expiresAt int64) {
+	b.entries = append(b.entries, wal.WALEntry{Key: key, Value: value, ExpiresAt: expiresAt})
+	b.size += len(key) + len(value)
+}
+
+// Delete adds a delete operation to the batch.
+func (b *Batch) Delete(key string) {
+	b.entries = append(b.entries, wal.WALEntry{Key: key, Value: []byte{}})
+	b.size += len(key)
+}
+
+// Len returns the number of operations in the batch.
+func (b *Batch) Len() int {
+	return len(b.entries)
+}
+
+// ID returns the id the batch was prepared under, empty if it is not prepared.
+func (b *Batch) ID() string {
+	return b.id
+}
+
+// Size returns the number of bytes of the keys and values in the batch.
+func (b *Batch) Size() int {
+	return b.size
+}
+
+// fits reports whether an operation on key and value can be added without going
+// over the batch limits. Bulk operations use it to split their work in batches.
+func (b *Batch) fits(key string, value []byte) bool {
+	if len(b.entries) == 0 {
+		return true
+	}
+	config := b.engine.Config
+	if config.MaxBatchEntries > 0 && len(b.entries)+1 > config.MaxBatchEntries {
+		return false
+	}
+	if config.MaxBatchBytes > 0 && b.size+len(key)+len(value) > config.MaxBatchBytes {
+		return false
+	}
+	return true
+}
+
+func (b *Batch) validate() error {
+	config := b.engine.Config
+	if (config.MaxBatchEntries > 0 && len(b.entries) > config.MaxBatchEntries) ||
+		(config.MaxBatchBytes > 0 && b.size > config.MaxBatchBytes) {
+		return &shared.ErrBatchTooLarge{
+			Entries:    len(b.entries),
+			Bytes:      b.size,
+			MaxEntries: config.MaxBatchEntries,
+			MaxBytes:   config.MaxBatchBytes,
+		}
+	}
+
+	for _, entry := range b.entries {
+		if len([]byte(entry.Key)) > int(b.engine.Config.KeySize) {
+			return &shared.ErrKeyTooLong{Key: entry.Key, KeySize: b.engine.Config.KeySize}
+		}
+		if err := checkUserKey(entry.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prepare persists the batch in the WAL under id without applying it.
+// The id must fit in the configured key size and be unique among prepared batches.
+func (b *Batch) Prepare(id string) error {
+	if b.id != "" {
+		return fmt.Errorf("batch is already prepared as %q", b.id)
+	}
+	if err := b.validate(); err != nil {
+		return err
+	}
+
+	e := b.engine
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	if _, ok := e.prepared[id]; ok {
+		return fmt.Errorf("a batch is already prepared as %q", id)
+	}
+
+	now := time.Now().UnixNano()
+	for i := range b.entries {
+		b.entries[i].Timestamp = now
+	}
+
+	if err := e.wal.LogPrepare(id, b.entries); err != nil {
+		return err
+	}
+
+	b.id = id
+	e.prepared[id] = b
+	return nil
+}
+
+// Commit applies the batch. For a prepared batch a commit record is logged in
+// place of the entries, which the WAL already holds.
+func (b *Batch) Commit() error {
+	if len(b.entries) == 0 {
+		if b.id != "" {
+			return b.decide(true)
+		}
+		return nil
+	}
+
+	if b.id != "" {
+		b.engine.writeMu.Lock()
+		_, ok := b.engine.prepared[b.id]
+		b.engine.writeMu.Unlock()
+		if !ok {
+			return fmt.Errorf("batch %q is not prepared", b.id)
+		}
+	} else {
+		if err := b.validate(); err != nil {
+			return err
+		}
+		now := time.Now().UnixNano()
+		for i := range b.entries {
+			b.entries[i].Timestamp = now
+		}
+	}
+
+	requests := make([]writeRequest, len(b.entries))
+	for i, entry := range b.entries {
+		requests[i] = writeRequest{entry: entry, done: make(chan error, 1)}
+	}
+
+	b.engine.pipeline.submitBatch(&commitBatch{requests: requests, txnID: b.id})
+
+	for _, req := range requests {
+		if err := <-req.done; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback discards a prepared batch and records the decision in the WAL.
+func (b *Batch) Rollback() error {
+	if b.id == "" {
+		b.entries = nil
+		return nil
+	}
+	return b.decide(false)
+}
+
+// decide logs the outcome of a prepared batch that has nothing to apply.
+func (b *Batch) decide(commit bool) error {
+	e := b.engine
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	if _, ok := e.prepared[b.id]; !ok {
+		return fmt.Errorf("batch %q is not prepared", b.id)
+	}
+
+	if err := e.wal.LogDecision(b.id, commit, 0); err != nil {
+		return err
+	}
+	delete(e.prepared, b.id)
+	return nil
+}
+
+// PreparedBatches returns the batches waiting for a Commit or Rollback, including
+// the ones recovered from the WAL when the database was opened.
+func (e *Engine) PreparedBatches() []*Batch {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	batches := []*Batch{}
+	for _, batch := range e.prepared {
+		batches = append(batches, batch)
+	}
+	sort.Slice(batches, func(i, j int) bool {
+		return batches[i].id < batches[j].id
+	})
+	return batches
+}
+
+// rotateWAL moves the WAL on to a new segment holding only the pending prepared
+// batches, and returns its number.
+func (e *Engine) rotateWAL() (int, error) {
+	prepared := []wal.PreparedBatch{}
+	for id, batch := range e.prepared {
+		prepared = append(prepared, wal.PreparedBatch{ID: id, Entries: batch.entries})
+	}
+	return e.wal.Rotate(prepared)
+}
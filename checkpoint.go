@@ -0,0 +1,99 @@
+package goldb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Checkpoint writes a copy of the database as of now to dir, which must not
+// exist or be empty. The memtable is flushed and the WAL synced first, so the
+// tables of the checkpoint hold every write acknowledged before it: the
+// directory is self-contained and opens read-only with OpenSnapshot, to serve
+// analytics or feed a replica, as well as with New.
+//
+// Tables are never written once in place, they are hard linked into dir when it
+// is on the same file system and copied otherwise; the value file and the WAL
+// are copied up to their size at the checkpoint. Writes wait until the files
+// are opened and linked, the copy goes on along with them.
+func (e *Engine) Checkpoint(dir string) error {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("db engine can not checkpoint to %q: the directory is not empty", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("db engine can not checkpoint to %q: %v", dir, err)
+	}
+
+	var files []*os.File
+	var sizes map[*os.File]int64
+	err := e.pipeline.exclusive(func() error {
+		if e.indexManager.MemtableSize() > 0 {
+			e.flush()
+		}
+		if err := e.syncValues(); err != nil {
+			return err
+		}
+		if err := e.wal.Sync(); err != nil {
+			return err
+		}
+
+		var err error
+		if files, sizes, err = e.openFiles(); err != nil {
+			return err
+		}
+		// a compaction may remove the tables once writes go on, they are linked now
+		copied := files[:0]
+		for _, file := range files {
+			if _, ok := sizes[file]; !ok && os.Link(file.Name(), filepath.Join(dir, filepath.Base(file.Name()))) == nil {
+				file.Close()
+				continue
+			}
+			copied = append(copied, file)
+		}
+		files = copied
+		return nil
+	})
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	if err != nil {
+		return fmt.Errorf("db engine can not checkpoint to %q: %v", dir, err)
+	}
+
+	for _, file := range files {
+		size, ok := sizes[file]
+		if !ok {
+			stat, err := file.Stat()
+			if err != nil {
+				return fmt.Errorf("db engine can not checkpoint to %q: %v", dir, err)
+			}
+			size = stat.Size()
+		}
+		if err := copySection(file, size, filepath.Join(dir, filepath.Base(file.Name()))); err != nil {
+			return fmt.Errorf("db engine can not checkpoint %q to %q: %v", filepath.Base(file.Name()), dir, err)
+		}
+	}
+	if err := syncPath(dir); err != nil {
+		return fmt.Errorf("db engine can not checkpoint to %q: %v", dir, err)
+	}
+	return nil
+}
+
+// copySection copies the first size bytes of file to a new file at dst.
+func copySection(file *os.File, size int64, dst string) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, io.NewSectionReader(file, 0, size))
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/hasssanezzz/goldb"
+)
+
+//go:embed admin.html
+var adminPage []byte
+
+// adminStatus is what the admin page polls, everything it shows in one reply.
+type adminStatus struct {
+	Time       time.Time
+	Features   goldb.Features
+	Stats      goldb.EngineStats
+	Sequence   uint64
+	Shape      goldb.TreeShape
+	BlockCache goldb.BlockCacheStats
+	DedupHits  uint64
+	Ops        []goldb.OpStats
+	SlowOps    []goldb.SlowOp
+}
+
+// adminPageHandler serves the admin page, which only reads the status.
+func (api *API) adminPageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(adminPage)
+}
+
+// adminStatusHandler replies with the live stats of the database as JSON.
+func (api *API) adminStatusHandler(w http.ResponseWriter, r *http.Request) {
+	status := adminStatus{
+		Time:       time.Now(),
+		Features:   api.DB.Features(),
+		Stats:      api.DB.Stats(),
+		Sequence:   api.DB.LastSequence(),
+		Shape:      api.DB.TreeShape(),
+		BlockCache: api.DB.BlockCacheStats(),
+		DedupHits:  api.DB.DedupHits(),
+		Ops:        api.DB.OpStats(),
+		SlowOps:    api.DB.SlowOps(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(status)
+}
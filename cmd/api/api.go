@@ -1,10 +1,12 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/hasssanezzz/goldb"
@@ -12,7 +14,8 @@ import (
 )
 
 type API struct {
-	DB *goldb.Engine
+	DB    *goldb.Engine
+	Admin bool // Serve the read-only admin page at /admin/.
 }
 
 func New(source string) (*API, error) {
@@ -27,7 +30,25 @@ func (api *API) getHandler(w http.ResponseWriter, r *http.Request) {
 	// check is this is a prefix scan query
 	prefix := r.Header.Get("prefix")
 	if len(prefix) > 0 {
-		results, err := api.DB.Scan(prefix)
+		var results []string
+		var err error
+		if limit := r.Header.Get("limit"); limit != "" {
+			// paginated scan, the next page is asked for with the returned cursor
+			n, convErr := strconv.Atoi(limit)
+			if convErr != nil || n <= 0 {
+				http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+				return
+			}
+			var next string
+			results, next, err = api.DB.ScanPage(prefix, n, r.Header.Get("cursor"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Next-Cursor", next)
+		} else {
+			results, err = api.DB.Scan(prefix)
+		}
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -49,7 +70,7 @@ func (api *API) getHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data, err := api.DB.Get(key)
+	err := api.DB.ServeValue(w, r, key)
 	if err != nil {
 		if _, ok := err.(*shared.ErrKeyNotFound); ok {
 			w.WriteHeader(http.StatusNotFound)
@@ -58,9 +79,6 @@ func (api *API) getHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-
-	w.WriteHeader(http.StatusOK)
-	w.Write(data)
 }
 
 func (api *API) postHandler(w http.ResponseWriter, r *http.Request) {
@@ -104,7 +122,41 @@ func (api *API) deleteHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// optionHandler changes a runtime option of the database, the body holds the new value.
+func (api *API) optionHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Unable to read body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	name := r.PathValue("name")
+	err = api.DB.SetOption(name, strings.TrimSpace(string(body)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("api: option %s set to %s\n", name, body)
+	w.WriteHeader(http.StatusOK)
+}
+
+// featuresHandler replies with the versions and the enabled subsystems of the
+// database as JSON, for clients to check what the server supports.
+func (api *API) featuresHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(api.DB.Features())
+}
+
 func (api *API) SetupRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("PUT /admin/options/{name}", api.optionHandler)
+	mux.HandleFunc("GET /admin/features", api.featuresHandler)
+	if api.Admin {
+		mux.Handle("GET /admin", http.RedirectHandler("/admin/", http.StatusMovedPermanently))
+		mux.HandleFunc("GET /admin/{$}", api.adminPageHandler)
+		mux.HandleFunc("GET /admin/status", api.adminStatusHandler)
+	}
 	mux.HandleFunc("GET /", api.getHandler)
 	mux.HandleFunc("POST /", api.postHandler)
 	mux.HandleFunc("PUT /", api.postHandler)
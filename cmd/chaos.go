@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hasssanezzz/goldb"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// chaosWorkerEnv marks the process chaos starts to run the workload, which is
+// the same binary running the same command.
+const chaosWorkerEnv = "GOLDB_CHAOS_WORKER"
+
+// chaosPrefix holds the keys written by the workload, the other keys of the
+// database are left alone.
+const chaosPrefix = "chaos/"
+
+type chaosOptions struct {
+	dir        string
+	killAfter  goldb.CrashPoint
+	rounds     int
+	ops        int
+	keys       int
+	memtable   int
+	syncAlways bool
+	seed       int64
+}
+
+// runChaos runs a workload against a database in a child process that kills
+// itself at a crash point, then reopens the database and checks that every
+// acknowledged write survived the crash. The writes are recorded in a ledger
+// next to the directory, so later runs go on where the last one stopped.
+func runChaos(args []string) error {
+	flags := flag.NewFlagSet("chaos", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: program chaos <dir> --kill-after=flush|compaction|wal-append [options]")
+		flags.PrintDefaults()
+	}
+	killAfter := flags.String("kill-after", "", "Crash point: flush, compaction or wal-append")
+	rounds := flags.Int("rounds", 5, "Number of crashes")
+	ops := flags.Int("ops", 20000, "Writes per round, the crash happens in the first half of them")
+	keys := flags.Int("keys", 1000, "Number of keys the workload writes")
+	memtable := flags.Int("memtable", 200, "Memtable size threshold, small values flush and compact often")
+	syncAlways := flags.Bool("sync-always", false, "Sync the WAL on every write")
+	seed := flags.Int64("seed", 1, "Seed of the workload and of the crash times")
+	round := flags.Int("round", 0, "Round run by a worker process")
+	armAt := flags.Int("arm-at", 0, "Write after which a worker process crashes")
+
+	// the directory comes first, the flags follow it
+	dir := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		dir, args = args[0], args[1:]
+	}
+	flags.Parse(args)
+	if dir == "" && flags.NArg() == 1 {
+		dir = flags.Arg(0)
+	}
+	if dir == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	point := goldb.CrashPoint(*killAfter)
+	switch point {
+	case goldb.CrashAfterFlush, goldb.CrashAfterCompaction, goldb.CrashAfterWALAppend:
+	default:
+		return fmt.Errorf("unknown crash point %q, use flush, compaction or wal-append", *killAfter)
+	}
+
+	opts := chaosOptions{
+		dir:        dir,
+		killAfter:  point,
+		rounds:     *rounds,
+		ops:        *ops,
+		keys:       max(*keys, 1),
+		memtable:   max(*memtable, 1),
+		syncAlways: *syncAlways,
+		seed:       *seed,
+	}
+	if os.Getenv(chaosWorkerEnv) != "" {
+		return runChaosWorker(opts, *round, *armAt)
+	}
+	return runChaosRounds(opts, args)
+}
+
+func chaosLedgerPath(dir string) string {
+	return filepath.Clean(dir) + ".chaos-ledger"
+}
+
+func chaosConfig(opts chaosOptions) shared.EngineConfig {
+	config := shared.DefaultConfig
+	config.MemtableSizeThreshold = uint32(opts.memtable)
+	config.CompactionThreshold = 3
+	if opts.syncAlways {
+		config.SyncMode = shared.SyncAlways
+	}
+	return config
+}
+
+func runChaosRounds(opts chaosOptions, args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("can not find the executable to run the workload: %v", err)
+	}
+	if err := os.MkdirAll(opts.dir, 0755); err != nil {
+		return err
+	}
+
+	rng := rand.New(rand.NewSource(opts.seed))
+	for round := 1; round <= opts.rounds; round++ {
+		armAt := rng.Intn(max(opts.ops/2, 1))
+		workerArgs := append([]string{"chaos", opts.dir}, args...)
+		workerArgs = append(workerArgs, "-round", strconv.Itoa(round), "-arm-at", strconv.Itoa(armAt))
+
+		output := &strings.Builder{}
+		cmd := exec.Command(executable, workerArgs...)
+		cmd.Env = append(os.Environ(), chaosWorkerEnv+"=1")
+		cmd.Stdout = io.Discard
+		cmd.Stderr = output
+		err := cmd.Run()
+
+		outcome := fmt.Sprintf("killed after %s", opts.killAfter)
+		var exitErr *exec.ExitError
+		switch {
+		case err == nil:
+			outcome = fmt.Sprintf("finished without reaching %s", opts.killAfter)
+		case errors.As(err, &exitErr) && !exitErr.Exited():
+			// killed by the signal the worker sent itself
+		default:
+			return fmt.Errorf("round %d: the workload failed: %v\n%s", round, err, output.String())
+		}
+
+		checked, err := verifyChaos(opts)
+		if err != nil {
+			return fmt.Errorf("round %d (%s): %v", round, outcome, err)
+		}
+		fmt.Printf("round %d: %s past write %d, %d keys verified\n", round, outcome, armAt, checked)
+	}
+	return nil
+}
+
+// runChaosWorker writes to the database, recording every write in the ledger
+// before it starts and once it is acknowledged, and kills the process the first
+// time the crash point is reached past the write armAt.
+func runChaosWorker(opts chaosOptions, round, armAt int) error {
+	ledger, err := os.OpenFile(chaosLedgerPath(opts.dir), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer ledger.Close()
+
+	armed := atomic.Bool{}
+	config := chaosConfig(opts)
+	config.CrashHook = func(point shared.CrashPoint) {
+		if point == opts.killAfter && armed.Load() {
+			process, _ := os.FindProcess(os.Getpid())
+			process.Kill()
+			select {} // the signal takes the process down before anything else runs
+		}
+	}
+
+	db, err := goldb.New(opts.dir, config)
+	if err != nil {
+		return fmt.Errorf("can not open db: %v", err)
+	}
+	defer db.Close()
+
+	rng := rand.New(rand.NewSource(opts.seed + int64(round)))
+	for op := 0; op < opts.ops; op++ {
+		if op == armAt {
+			armed.Store(true)
+		}
+
+		key := fmt.Sprintf("%s%06d", chaosPrefix, rng.Intn(opts.keys))
+		value := ""
+		if rng.Intn(5) > 0 {
+			value = fmt.Sprintf("r%d-w%d", round, op)
+		}
+		if _, err := fmt.Fprintf(ledger, "begin %s %s\n", key, value); err != nil {
+			return err
+		}
+
+		if value == "" {
+			err = db.Delete(key)
+			var notFound *shared.ErrKeyNotFound
+			if errors.As(err, &notFound) {
+				err = nil
+			}
+		} else {
+			err = db.Set(key, []byte(value))
+		}
+		if err != nil {
+			return fmt.Errorf("write %d failed: %v", op, err)
+		}
+
+		if _, err := fmt.Fprintln(ledger, "done"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chaosState is what a key holds after a write, an empty value is a delete.
+type chaosState struct {
+	value string
+}
+
+// verifyChaos reopens the database and checks it against the ledger: every key
+// holds its last acknowledged write, or the write in flight when the process
+// died, and no other key shows up under the prefix. A write in flight is ended
+// in the ledger on the way, so the next round starts from a clean record.
+func verifyChaos(opts chaosOptions) (int, error) {
+	acked, pending, err := readChaosLedger(opts.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	db, err := goldb.New(opts.dir, chaosConfig(opts))
+	if err != nil {
+		return 0, fmt.Errorf("recovery failed, can not open db: %v", err)
+	}
+	defer db.Close()
+
+	problems := []string{}
+	accepts := func(key, value string, found bool) bool {
+		candidates := []chaosState{acked[key]}
+		if state, ok := pending[key]; ok {
+			candidates = append(candidates, state)
+		}
+		for _, state := range candidates {
+			if !found && state.value == "" || found && state.value != "" && state.value == value {
+				return true
+			}
+		}
+		return false
+	}
+
+	keys := map[string]struct{}{}
+	for key := range acked {
+		keys[key] = struct{}{}
+	}
+	for key := range pending {
+		keys[key] = struct{}{}
+	}
+	for key := range keys {
+		value, err := db.Get(key)
+		var notFound *shared.ErrKeyNotFound
+		found := err == nil
+		if err != nil && !errors.As(err, &notFound) {
+			return 0, fmt.Errorf("can not read key %q: %v", key, err)
+		}
+		if !accepts(key, string(value), found) {
+			problems = append(problems, fmt.Sprintf("key %q holds %q (found %t), the ledger expects %q", key, value, found, acked[key].value))
+		}
+	}
+
+	listed, err := db.Scan(chaosPrefix)
+	if err != nil {
+		return 0, fmt.Errorf("can not list the keys: %v", err)
+	}
+	for _, key := range listed {
+		if _, ok := keys[key]; !ok {
+			problems = append(problems, fmt.Sprintf("key %q was never written", key))
+		}
+	}
+
+	if len(problems) > 0 {
+		for _, problem := range problems[:min(len(problems), 10)] {
+			fmt.Println(problem)
+		}
+		return 0, fmt.Errorf("%d keys do not match the acknowledged writes", len(problems))
+	}
+
+	// settle the write in flight with what recovery made of it
+	for key, state := range pending {
+		value, err := db.Get(key)
+		outcome := "lost"
+		if (err == nil) == (state.value != "") && string(value) == state.value {
+			outcome = "done"
+		}
+		if err := endChaosLedger(opts.dir, outcome); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// readChaosLedger returns the state of the keys after the acknowledged writes,
+// and the state of the key written when the process died, if any.
+func readChaosLedger(dir string) (map[string]chaosState, map[string]chaosState, error) {
+	acked := map[string]chaosState{}
+	pending := map[string]chaosState{}
+
+	file, err := os.Open(chaosLedgerPath(dir))
+	if os.IsNotExist(err) {
+		return acked, pending, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		switch {
+		case fields[0] == "done":
+			for key, state := range pending {
+				acked[key] = state
+			}
+			clear(pending)
+		case fields[0] == "begin" && len(fields) == 3:
+			clear(pending)
+			pending[fields[1]] = chaosState{value: fields[2]}
+		case fields[0] == "lost":
+			clear(pending)
+		default:
+			// a line cut short by the crash
+		}
+	}
+	return acked, pending, scanner.Err()
+}
+
+// endChaosLedger records the outcome of the write in flight, done when the
+// write survived and lost otherwise.
+func endChaosLedger(dir, outcome string) error {
+	ledger, err := os.OpenFile(chaosLedgerPath(dir), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer ledger.Close()
+	// a line cut short by the crash is ended first
+	_, err = fmt.Fprintf(ledger, "\n%s\n", outcome)
+	return err
+}
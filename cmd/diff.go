@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hasssanezzz/goldb"
+)
+
+// runDiff prints the keys that differ between two databases, one per line as
+// "<kind> <key>", and fails when there is any.
+func runDiff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: program diff <dirA> <dirB>")
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	a, err := goldb.New(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("can not open db %s: %v", flags.Arg(0), err)
+	}
+	defer a.Close()
+	b, err := goldb.New(flags.Arg(1))
+	if err != nil {
+		return fmt.Errorf("can not open db %s: %v", flags.Arg(1), err)
+	}
+	defer b.Close()
+
+	counts := map[goldb.DiffKind]int{}
+	err = goldb.Diff(a, b, func(entry goldb.DiffEntry) error {
+		counts[entry.Kind]++
+		_, err := fmt.Printf("%s %q %08x %08x\n", entry.Kind, entry.Key, entry.ChecksumA, entry.ChecksumB)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	total := counts[goldb.DiffOnlyA] + counts[goldb.DiffOnlyB] + counts[goldb.DiffChanged]
+	if total > 0 {
+		return fmt.Errorf("%d keys only in A, %d only in B, %d changed",
+			counts[goldb.DiffOnlyA], counts[goldb.DiffOnlyB], counts[goldb.DiffChanged])
+	}
+	return nil
+}
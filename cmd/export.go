@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/hasssanezzz/goldb"
+)
+
+// runExport opens the database and exports a checkpoint of it, taken once the
+// WAL is replayed, so the export holds every acknowledged write as of one point
+// in time. The checkpoint goes next to the database, where its tables are hard
+// linked rather than copied, and is removed once exported.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	source := flags.String("s", "~/.goldb", "Path to the source directory")
+	format := flags.String("f", "csv", "Output format, csv, jsonl or parquet")
+	output := flags.String("o", "", "Output file (default: stdout)")
+	flags.Parse(args)
+
+	path, err := resolveSource(*source)
+	if err != nil {
+		return err
+	}
+
+	engine, err := goldb.New(path)
+	if err != nil {
+		return fmt.Errorf("can not open db: %v", err)
+	}
+	defer engine.Close()
+
+	dir, err := os.MkdirTemp(filepath.Dir(filepath.Clean(path)), ".goldb-export-")
+	if err != nil {
+		return fmt.Errorf("can not create the checkpoint directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := engine.Checkpoint(dir); err != nil {
+		return err
+	}
+	db, err := goldb.OpenSnapshot(dir)
+	if err != nil {
+		return fmt.Errorf("can not open the checkpoint: %v", err)
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("can not create output file: %v", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	return db.Export(w, goldb.ExportFormat(*format))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/hasssanezzz/goldb"
+)
+
+// runGCFiles lists the orphan files of a database, and removes the ones the
+// engine left behind when asked to.
+func runGCFiles(args []string) error {
+	flags := flag.NewFlagSet("gc-files", flag.ExitOnError)
+	source := flags.String("s", "~/.goldb", "Path to the source directory")
+	remove := flags.Bool("delete", false, "Remove the orphan files left behind by the engine")
+	flags.Parse(args)
+
+	path, err := resolveSource(*source)
+	if err != nil {
+		return err
+	}
+
+	db, err := goldb.New(path)
+	if err != nil {
+		return fmt.Errorf("can not open db: %v", err)
+	}
+	defer db.Close()
+
+	orphans, err := db.OrphanFiles()
+	if err != nil {
+		return err
+	}
+	for _, orphan := range orphans {
+		action := "kept"
+		if orphan.Removable {
+			action = "removable"
+		}
+		fmt.Printf("%s\t%d bytes\t%s (%s)\n", orphan.Name, orphan.Size, orphan.Reason, action)
+	}
+
+	if !*remove {
+		return nil
+	}
+	removed, err := db.RemoveOrphanFiles()
+	fmt.Printf("removed %d files\n", len(removed))
+	return err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/hasssanezzz/goldb"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// runImport loads the string keys of a Redis dump, with their TTLs, into a
+// database, writing them in chunks through Engine.IngestWithTTL.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	from := flags.String("from", "", "Format of the dump, only redis is supported")
+	source := flags.String("s", "~/.goldb", "Path to the source directory")
+	db := flags.Int("db", 0, "Redis database to import")
+	prefix := flags.String("prefix", "", "Prefix added to every imported key")
+	chunkSize := flags.Int("chunk", 100000, "Keys written per table")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: program import --from redis [options] <dump.rdb|appendonly.aof|appendonlydir>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *from != "redis" {
+		return fmt.Errorf("can not import from %q, only redis is supported", *from)
+	}
+	if *chunkSize <= 0 {
+		return fmt.Errorf("can not import in chunks of %d keys", *chunkSize)
+	}
+
+	dump := newRedisDump(*db)
+	if err := dump.load(flags.Arg(0)); err != nil {
+		return fmt.Errorf("can not read %s: %v", flags.Arg(0), err)
+	}
+
+	path, err := resolveSource(*source)
+	if err != nil {
+		return err
+	}
+	engine, err := goldb.New(path)
+	if err != nil {
+		return fmt.Errorf("can not open db: %v", err)
+	}
+	defer engine.Close()
+
+	keys := make([]string, 0, len(dump.keys))
+	for key := range dump.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	imported, tables, expired, empty, invalid := 0, 0, 0, 0, 0
+	now := time.Now()
+	chunk, ttls := map[string][]byte{}, map[string]time.Duration{}
+	ingest := func() error {
+		if err := engine.IngestWithTTL(chunk, ttls); err != nil {
+			return err
+		}
+		imported += len(chunk)
+		tables++
+		chunk, ttls = map[string][]byte{}, map[string]time.Duration{}
+		return nil
+	}
+	for _, key := range keys {
+		entry := dump.keys[key]
+		name := *prefix + key
+		if len(name) > int(engine.Config.KeySize) || shared.IsSystemKey(name) {
+			invalid++
+			continue
+		}
+		// an empty value would read as a delete
+		if len(entry.value) == 0 {
+			empty++
+			continue
+		}
+		if entry.expireAt != 0 {
+			ttl := time.UnixMilli(entry.expireAt).Sub(now)
+			if ttl <= 0 {
+				expired++
+				continue
+			}
+			ttls[name] = ttl
+		}
+
+		chunk[name] = entry.value
+		if len(chunk) >= *chunkSize {
+			if err := ingest(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(chunk) > 0 {
+		if err := ingest(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("imported %d keys into %d tables, skipped %d expired, %d empty, %d with an invalid or too long name and %d of other types\n",
+		imported, tables, expired, empty, invalid, dump.otherTypes)
+	if dump.truncated {
+		fmt.Println("the AOF ended in the middle of a command, which was left out")
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hasssanezzz/goldb"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// runLoad seeds a database with the records of a JSON Lines or CSV file, taking
+// the key and the value of every record from the configured fields and writing
+// them in chunks through Engine.Ingest.
+func runLoad(args []string) error {
+	flags := flag.NewFlagSet("load", flag.ExitOnError)
+	format := flags.String("format", "jsonl", "Format of the file, jsonl or csv")
+	source := flags.String("s", "~/.goldb", "Path to the source directory")
+	keyField := flags.String("key-field", "key", "Field, or CSV column, holding the key")
+	valueField := flags.String("value-field", "value", "Field, or CSV column, holding the value, empty for the whole JSON record")
+	prefix := flags.String("prefix", "", "Prefix added to every loaded key")
+	chunkSize := flags.Int("chunk", 100000, "Records written per table")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: program load --format jsonl|csv [options] <file>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *chunkSize <= 0 {
+		return fmt.Errorf("can not load in chunks of %d records", *chunkSize)
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("can not open %s: %v", flags.Arg(0), err)
+	}
+	defer file.Close()
+
+	var records recordReader
+	switch *format {
+	case "jsonl":
+		records = newJSONLReader(file, *keyField, *valueField)
+	case "csv":
+		if *valueField == "" {
+			return fmt.Errorf("can not load csv without a value column")
+		}
+		records, err = newCSVReader(file, *keyField, *valueField)
+		if err != nil {
+			return fmt.Errorf("can not read %s: %v", flags.Arg(0), err)
+		}
+	default:
+		return fmt.Errorf("can not load %q files, only jsonl and csv are supported", *format)
+	}
+
+	path, err := resolveSource(*source)
+	if err != nil {
+		return err
+	}
+	engine, err := goldb.New(path)
+	if err != nil {
+		return fmt.Errorf("can not open db: %v", err)
+	}
+	defer engine.Close()
+
+	loaded, tables, skipped := 0, 0, 0
+	chunk := map[string][]byte{}
+	ingest := func() error {
+		if err := engine.Ingest(chunk); err != nil {
+			return err
+		}
+		loaded += len(chunk)
+		tables++
+		chunk = map[string][]byte{}
+		return nil
+	}
+	for {
+		key, value, err := records.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("can not read %s: %v", flags.Arg(0), err)
+		}
+		// an empty value would read as a delete
+		if key == "" || len(value) == 0 {
+			skipped++
+			continue
+		}
+		key = *prefix + key
+		if len(key) > int(engine.Config.KeySize) || shared.IsSystemKey(key) {
+			skipped++
+			continue
+		}
+
+		// a key repeated later in the file takes its last value
+		chunk[key] = value
+		if len(chunk) >= *chunkSize {
+			if err := ingest(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(chunk) > 0 {
+		if err := ingest(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("loaded %d keys into %d tables, skipped %d records without a key or a value, or with an invalid or too long key\n", loaded, tables, skipped)
+	return nil
+}
+
+// recordReader returns the key and the value of the records of a file one by
+// one, and io.EOF after the last one.
+type recordReader interface {
+	next() (string, []byte, error)
+}
+
+// jsonlReader reads records from JSON Lines, one object per line.
+type jsonlReader struct {
+	scanner    *bufio.Scanner
+	line       int
+	keyField   string
+	valueField string
+}
+
+func newJSONLReader(r io.Reader, keyField, valueField string) *jsonlReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	return &jsonlReader{scanner: scanner, keyField: keyField, valueField: valueField}
+}
+
+func (r *jsonlReader) next() (string, []byte, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		record := map[string]json.RawMessage{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			return "", nil, fmt.Errorf("line %d: %v", r.line, err)
+		}
+		key := string(fieldValue(record[r.keyField]))
+		if r.valueField == "" {
+			return key, bytes.Clone(line), nil
+		}
+		return key, fieldValue(record[r.valueField]), nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return "", nil, err
+	}
+	return "", nil, io.EOF
+}
+
+// fieldValue returns the content of a JSON string, or the JSON text of any other
+// value, like a number or a nested object. A missing field or null is empty.
+func fieldValue(raw json.RawMessage) []byte {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []byte(text)
+	}
+	return bytes.Clone(raw)
+}
+
+// csvReader reads records from a CSV file whose first row names the columns.
+type csvReader struct {
+	reader *csv.Reader
+	key    int
+	value  int
+}
+
+func newCSVReader(r io.Reader, keyColumn, valueColumn string) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("can not read the header: %v", err)
+	}
+
+	columns := &csvReader{reader: reader, key: -1, value: -1}
+	for i, name := range header {
+		switch name {
+		case keyColumn:
+			columns.key = i
+		case valueColumn:
+			columns.value = i
+		}
+	}
+	if columns.key < 0 || columns.value < 0 {
+		return nil, fmt.Errorf("the header has no %q or %q column", keyColumn, valueColumn)
+	}
+	return columns, nil
+}
+
+func (r *csvReader) next() (string, []byte, error) {
+	row, err := r.reader.Read()
+	if err != nil {
+		return "", nil, err
+	}
+	if r.key >= len(row) || r.value >= len(row) {
+		return "", nil, nil
+	}
+	return row[r.key], []byte(row[r.value]), nil
+}
@@ -32,11 +32,62 @@ func createHomeDir() (string, error) {
 	return dirPath, nil
 }
 
+// resolveSource returns the database directory, creating the default one when needed.
+func resolveSource(source string) (string, error) {
+	if source == "~/.goldb" {
+		return createHomeDir()
+	}
+	return source, nil
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExport(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImport(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "load" {
+		if err := runLoad(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "gc-files" {
+		if err := runGCFiles(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "stress" {
+		if err := runStress(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "chaos" {
+		if err := runChaos(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		if err := runDiff(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	host := flag.String("h", "localhost", "Host to bind the server to")
 	port := flag.String("p", "3011", "Port to listen on")
 	source := flag.String("s", "~/.goldb", "Path to the source directory")
+	admin := flag.Bool("admin", false, "Serve the read-only admin page at /admin/")
 
 	if len(os.Args) > 1 && os.Args[1] == "--help" {
 		fmt.Println(`Usage: program [options]
@@ -45,25 +96,34 @@ Options:
   -h, string        Host to bind the server to (default: "localhost")
   -p, string        Port to listen on (default: "3011")
   -s, string        Path to the source directory (default: "~/.goldb")
-  --help            Show this help message and exit`)
+  -admin            Serve the read-only admin page at /admin/
+  --help            Show this help message and exit
+
+Commands:
+  export            Export all the pairs, see "program export -help"
+  import            Load the string keys of a Redis dump, see "program import -help"
+  load <file>       Seed the database from a JSON Lines or CSV file, see "program load -help"
+  gc-files          List the orphan files, see "program gc-files -help"
+  diff <dirA> <dirB>  List the keys only in A, only in B, or with different values
+  chaos <dir>       Crash a workload at a chosen point and verify the recovery, see "program chaos -help"
+  stress <dir>      Run a long mixed workload checked against a model, see "program stress -help"`)
 		os.Exit(0)
 	}
 
 	flag.Parse()
 
-	if *source == "~/.goldb" {
-		path, err := createHomeDir()
-		if err != err {
-			log.Fatal(err)
-		}
-		*source = path
+	path, err := resolveSource(*source)
+	if err != nil {
+		log.Fatal(err)
 	}
+	*source = path
 
 	api, err := api.New(*source)
 	if err != nil {
 		log.Fatalf("can not open db: %v", err)
 	}
 	defer api.DB.Close()
+	api.Admin = *admin
 
 	mux := http.NewServeMux()
 	api.SetupRoutes(mux)
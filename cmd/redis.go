@@ -0,0 +1,829 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rdbMaxVersion is the newest RDB version the reader knows, the one of Redis 7.4.
+const rdbMaxVersion = 12
+
+// Opcodes of an RDB file, the other bytes in their place are value types.
+const (
+	rdbOpSlotInfo     = 0xF4
+	rdbOpFunction2    = 0xF5
+	rdbOpFunctionPre  = 0xF6
+	rdbOpModuleAux    = 0xF7
+	rdbOpIdle         = 0xF8
+	rdbOpFreq         = 0xF9
+	rdbOpAux          = 0xFA
+	rdbOpResizeDB     = 0xFB
+	rdbOpExpireTimeMS = 0xFC
+	rdbOpExpireTime   = 0xFD
+	rdbOpSelectDB     = 0xFE
+	rdbOpEOF          = 0xFF
+)
+
+// Value types of an RDB file.
+const (
+	rdbTypeString           = 0
+	rdbTypeList             = 1
+	rdbTypeSet              = 2
+	rdbTypeZset             = 3
+	rdbTypeHash             = 4
+	rdbTypeZset2            = 5
+	rdbTypeHashZipmap       = 9
+	rdbTypeListZiplist      = 10
+	rdbTypeSetIntset        = 11
+	rdbTypeZsetZiplist      = 12
+	rdbTypeHashZiplist      = 13
+	rdbTypeListQuicklist    = 14
+	rdbTypeStreamListpacks  = 15
+	rdbTypeHashListpack     = 16
+	rdbTypeZsetListpack     = 17
+	rdbTypeListQuicklist2   = 18
+	rdbTypeStreamListpacks2 = 19
+	rdbTypeSetListpack      = 20
+	rdbTypeStreamListpacks3 = 21
+	rdbTypeHashMetadata     = 24
+	rdbTypeHashListpackEx   = 25
+)
+
+// redisValue is a string key of a Redis dump.
+type redisValue struct {
+	value    []byte
+	expireAt int64 // Unix milliseconds, zero if the key never expires.
+}
+
+// redisDump is the state of the string keys of one Redis database, built from
+// the RDB snapshot and the AOF commands of a dump.
+type redisDump struct {
+	db         int // Database whose keys are kept.
+	selected   int // Database the AOF commands apply to.
+	keys       map[string]redisValue
+	otherTypes int  // Keys of the database holding lists, sets, hashes and the like, left out.
+	truncated  bool // The AOF ended in the middle of a command, which was dropped like Redis does.
+}
+
+func newRedisDump(db int) *redisDump {
+	return &redisDump{db: db, keys: map[string]redisValue{}}
+}
+
+// load reads a dump: an RDB file, an AOF file, possibly starting with an RDB
+// preamble, or the directory of a multi part AOF along with its manifest.
+func (d *redisDump) load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return d.loadFile(path)
+	}
+
+	files, err := manifestFiles(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		d.selected = 0
+		if err := d.loadFile(file); err != nil {
+			return fmt.Errorf("%s: %v", filepath.Base(file), err)
+		}
+	}
+	return nil
+}
+
+// manifestFiles returns the base file and the incremental files listed by the
+// manifest of the multi part AOF in dir, in the order to replay them.
+func manifestFiles(dir string) ([]string, error) {
+	manifests, err := filepath.Glob(filepath.Join(dir, "*.manifest"))
+	if err != nil {
+		return nil, err
+	}
+	if len(manifests) != 1 {
+		return nil, fmt.Errorf("expected one AOF manifest in %s, found %d", dir, len(manifests))
+	}
+	data, err := os.ReadFile(manifests[0])
+	if err != nil {
+		return nil, err
+	}
+
+	type part struct {
+		name string
+		seq  int
+	}
+	var base []string
+	var incr []part
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		info := map[string]string{}
+		for i := 0; i+1 < len(fields); i += 2 {
+			info[fields[i]] = fields[i+1]
+		}
+		if info["file"] == "" {
+			continue
+		}
+		switch info["type"] {
+		case "b":
+			base = append(base, filepath.Join(dir, info["file"]))
+		case "i":
+			seq, err := strconv.Atoi(info["seq"])
+			if err != nil {
+				return nil, fmt.Errorf("invalid manifest line %q", line)
+			}
+			incr = append(incr, part{filepath.Join(dir, info["file"]), seq})
+		}
+	}
+	sort.Slice(incr, func(i, j int) bool { return incr[i].seq < incr[j].seq })
+	for _, p := range incr {
+		base = append(base, p.name)
+	}
+	return base, nil
+}
+
+func (d *redisDump) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReaderSize(file, 1<<16)
+	if magic, err := r.Peek(5); err == nil && string(magic) == "REDIS" {
+		if err := d.loadRDB(r); err != nil {
+			return err
+		}
+	}
+	return d.loadAOF(r)
+}
+
+// loadRDB reads an RDB snapshot up to its end marker.
+func (d *redisDump) loadRDB(br *bufio.Reader) error {
+	r := &rdbReader{r: br}
+	header, err := r.full(9)
+	if err != nil {
+		return err
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil {
+		return fmt.Errorf("invalid RDB header %q", header)
+	}
+	if version > rdbMaxVersion {
+		return fmt.Errorf("RDB version %d is newer than %d, the newest supported", version, rdbMaxVersion)
+	}
+
+	db := 0
+	expireAt := int64(0)
+	for {
+		op, err := r.r.ReadByte()
+		if err != nil {
+			return r.fail(err)
+		}
+
+		switch op {
+		case rdbOpEOF:
+			if version >= 5 {
+				// the checksum, the dump is read to its end whatever it says
+				_, err = r.full(8)
+			}
+			return r.fail(err)
+		case rdbOpSelectDB:
+			var n uint64
+			n, err = r.length()
+			db = int(n)
+		case rdbOpExpireTime:
+			var buf []byte
+			if buf, err = r.full(4); err == nil {
+				expireAt = int64(binary.LittleEndian.Uint32(buf)) * 1000
+			}
+		case rdbOpExpireTimeMS:
+			var buf []byte
+			if buf, err = r.full(8); err == nil {
+				expireAt = int64(binary.LittleEndian.Uint64(buf))
+			}
+		case rdbOpResizeDB:
+			if _, err = r.length(); err == nil {
+				_, err = r.length()
+			}
+		case rdbOpAux:
+			if _, err = r.string(); err == nil {
+				_, err = r.string()
+			}
+		case rdbOpFreq:
+			_, err = r.r.ReadByte()
+		case rdbOpIdle:
+			_, err = r.length()
+		case rdbOpFunction2:
+			_, err = r.string()
+		case rdbOpSlotInfo:
+			for i := 0; i < 3 && err == nil; i++ {
+				_, err = r.length()
+			}
+		case rdbOpModuleAux, rdbOpFunctionPre:
+			return fmt.Errorf("RDB opcode %#x is not supported", op)
+		default:
+			err = d.loadRDBKey(r, op, db, expireAt)
+			expireAt = 0
+		}
+		if err != nil {
+			return r.fail(err)
+		}
+	}
+}
+
+// loadRDBKey reads a key of type typ and its value, keeping it when it is a
+// string of the imported database.
+func (d *redisDump) loadRDBKey(r *rdbReader, typ byte, db int, expireAt int64) error {
+	key, err := r.string()
+	if err != nil {
+		return err
+	}
+	if typ != rdbTypeString {
+		if err := r.skipValue(typ); err != nil {
+			return fmt.Errorf("key %q: %v", key, err)
+		}
+		if db == d.db {
+			d.otherTypes++
+		}
+		return nil
+	}
+
+	value, err := r.string()
+	if err != nil {
+		return err
+	}
+	if db == d.db {
+		d.keys[string(key)] = redisValue{value: value, expireAt: expireAt}
+	}
+	return nil
+}
+
+// rdbReader decodes the encodings of an RDB file.
+type rdbReader struct {
+	r *bufio.Reader
+}
+
+// fail turns an end of file in the middle of the snapshot into an error.
+func (r *rdbReader) fail(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (r *rdbReader) full(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r.r, buf)
+	return buf, err
+}
+
+// lengthOrEncoding reads a length, or the kind of a specially encoded string
+// when encoded is set.
+func (r *rdbReader) lengthOrEncoding() (n uint64, encoded bool, err error) {
+	first, err := r.r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case 0:
+		return uint64(first & 0x3F), false, nil
+	case 1:
+		next, err := r.r.ReadByte()
+		return uint64(first&0x3F)<<8 | uint64(next), false, err
+	case 2:
+		switch first {
+		case 0x80:
+			buf, err := r.full(4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		case 0x81:
+			buf, err := r.full(8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		}
+		return 0, false, fmt.Errorf("invalid length encoding %#x", first)
+	default:
+		return uint64(first & 0x3F), true, nil
+	}
+}
+
+func (r *rdbReader) length() (uint64, error) {
+	n, encoded, err := r.lengthOrEncoding()
+	if err == nil && encoded {
+		err = fmt.Errorf("expected a length, found a string encoding")
+	}
+	return n, err
+}
+
+// string reads a string, integers and compressed strings decoded.
+func (r *rdbReader) string() ([]byte, error) {
+	n, encoded, err := r.lengthOrEncoding()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return r.full(int(n))
+	}
+
+	switch n {
+	case 0:
+		b, err := r.r.ReadByte()
+		return []byte(strconv.Itoa(int(int8(b)))), err
+	case 1:
+		buf, err := r.full(2)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(buf))))), nil
+	case 2:
+		buf, err := r.full(4)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(buf))))), nil
+	case 3:
+		compressed, err := r.length()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.length()
+		if err != nil {
+			return nil, err
+		}
+		data, err := r.full(int(compressed))
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(data, int(size))
+	}
+	return nil, fmt.Errorf("invalid string encoding %d", n)
+}
+
+// skip reads n strings.
+func (r *rdbReader) skip(n uint64) error {
+	for ; n > 0; n-- {
+		if _, err := r.string(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipValue reads past a value of type typ.
+func (r *rdbReader) skipValue(typ byte) error {
+	switch typ {
+	case rdbTypeList, rdbTypeSet, rdbTypeListQuicklist:
+		n, err := r.length()
+		if err != nil {
+			return err
+		}
+		return r.skip(n)
+	case rdbTypeHash:
+		n, err := r.length()
+		if err != nil {
+			return err
+		}
+		return r.skip(2 * n)
+	case rdbTypeZset, rdbTypeZset2:
+		n, err := r.length()
+		if err != nil {
+			return err
+		}
+		for ; n > 0; n-- {
+			if _, err := r.string(); err != nil {
+				return err
+			}
+			if typ == rdbTypeZset2 {
+				_, err = r.full(8)
+			} else {
+				err = r.skipDouble()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case rdbTypeHashZipmap, rdbTypeListZiplist, rdbTypeSetIntset, rdbTypeZsetZiplist,
+		rdbTypeHashZiplist, rdbTypeHashListpack, rdbTypeZsetListpack, rdbTypeSetListpack:
+		_, err := r.string()
+		return err
+	case rdbTypeListQuicklist2:
+		n, err := r.length()
+		if err != nil {
+			return err
+		}
+		for ; n > 0; n-- {
+			if _, err := r.length(); err != nil {
+				return err
+			}
+			if _, err := r.string(); err != nil {
+				return err
+			}
+		}
+		return nil
+	case rdbTypeHashListpackEx:
+		if _, err := r.full(8); err != nil {
+			return err
+		}
+		_, err := r.string()
+		return err
+	case rdbTypeHashMetadata:
+		if _, err := r.full(8); err != nil {
+			return err
+		}
+		n, err := r.length()
+		if err != nil {
+			return err
+		}
+		for ; n > 0; n-- {
+			if _, err := r.length(); err != nil {
+				return err
+			}
+			if err := r.skip(2); err != nil {
+				return err
+			}
+		}
+		return nil
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		return r.skipStream(typ)
+	}
+	return fmt.Errorf("values of type %d are not supported", typ)
+}
+
+// skipDouble reads past a score of the first sorted set encoding, a string of
+// up to 252 bytes or a marker of an infinity or NaN.
+func (r *rdbReader) skipDouble() error {
+	n, err := r.r.ReadByte()
+	if err != nil || n >= 253 {
+		return err
+	}
+	_, err = r.full(int(n))
+	return err
+}
+
+// skipStream reads past a stream, its consumer groups included.
+func (r *rdbReader) skipStream(typ byte) error {
+	lengths := func(n int) error {
+		for ; n > 0; n-- {
+			if _, err := r.length(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	listpacks, err := r.length()
+	if err != nil {
+		return err
+	}
+	if err := r.skip(2 * listpacks); err != nil {
+		return err
+	}
+	// items, last id
+	fields := 3
+	if typ != rdbTypeStreamListpacks {
+		// first id, max deleted id, entries added
+		fields += 5
+	}
+	if err := lengths(fields); err != nil {
+		return err
+	}
+
+	groups, err := r.length()
+	if err != nil {
+		return err
+	}
+	for ; groups > 0; groups-- {
+		if _, err := r.string(); err != nil {
+			return err
+		}
+		fields := 2
+		if typ != rdbTypeStreamListpacks {
+			// entries read
+			fields++
+		}
+		if err := lengths(fields); err != nil {
+			return err
+		}
+		pending, err := r.length()
+		if err != nil {
+			return err
+		}
+		for ; pending > 0; pending-- {
+			// id, delivery time, delivery count
+			if _, err := r.full(16 + 8); err != nil {
+				return err
+			}
+			if _, err := r.length(); err != nil {
+				return err
+			}
+		}
+		consumers, err := r.length()
+		if err != nil {
+			return err
+		}
+		for ; consumers > 0; consumers-- {
+			if _, err := r.string(); err != nil {
+				return err
+			}
+			times := 8
+			if typ == rdbTypeStreamListpacks3 {
+				times += 8
+			}
+			if _, err := r.full(times); err != nil {
+				return err
+			}
+			pending, err := r.length()
+			if err != nil {
+				return err
+			}
+			if _, err := r.full(16 * int(pending)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lzfDecompress decodes the LZF compressed strings of RDB files.
+func lzfDecompress(in []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			n := ctrl + 1
+			if i+n > len(in) {
+				return nil, fmt.Errorf("corrupt compressed string")
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, fmt.Errorf("corrupt compressed string")
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, fmt.Errorf("corrupt compressed string")
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, fmt.Errorf("corrupt compressed string")
+		}
+		for j := 0; j < n+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != size {
+		return nil, fmt.Errorf("compressed string is %d bytes, expected %d", len(out), size)
+	}
+	return out, nil
+}
+
+// loadAOF replays the commands of an AOF file that write string keys.
+func (d *redisDump) loadAOF(r *bufio.Reader) error {
+	for {
+		args, err := readCommand(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			d.truncated = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		d.apply(args)
+	}
+}
+
+// readCommand reads a command in the RESP encoding AOF files use.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return nil, io.EOF
+	}
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("invalid AOF command %q", strings.TrimSpace(line))
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid AOF command %q", strings.TrimSpace(line))
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("invalid AOF argument %q", strings.TrimSpace(line))
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid AOF argument %q", strings.TrimSpace(line))
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// apply replays a command on the string keys. Commands on the other types are
+// ignored, along with the ones that only read.
+func (d *redisDump) apply(args []string) {
+	if len(args) == 0 {
+		return
+	}
+	name := strings.ToLower(args[0])
+	args = args[1:]
+
+	switch name {
+	case "select":
+		if len(args) == 1 {
+			d.selected, _ = strconv.Atoi(args[0])
+		}
+		return
+	case "flushall":
+		clear(d.keys)
+		return
+	}
+	if d.selected != d.db {
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	switch name {
+	case "flushdb":
+		clear(d.keys)
+	case "set":
+		if len(args) < 2 {
+			return
+		}
+		entry := redisValue{value: []byte(args[1])}
+		for i := 2; i < len(args); i++ {
+			option := strings.ToLower(args[i])
+			if option == "keepttl" {
+				entry.expireAt = d.keys[args[0]].expireAt
+				continue
+			}
+			if i+1 >= len(args) {
+				continue
+			}
+			if at, ok := expireTime(option, args[i+1], now); ok {
+				entry.expireAt = at
+				i++
+			}
+		}
+		d.set(args[0], entry, now)
+	case "setnx":
+		if len(args) == 2 {
+			if _, ok := d.keys[args[0]]; !ok {
+				d.keys[args[0]] = redisValue{value: []byte(args[1])}
+			}
+		}
+	case "setex", "psetex":
+		if len(args) == 3 {
+			option := "ex"
+			if name == "psetex" {
+				option = "px"
+			}
+			if at, ok := expireTime(option, args[1], now); ok {
+				d.set(args[0], redisValue{value: []byte(args[2]), expireAt: at}, now)
+			}
+		}
+	case "getset":
+		if len(args) == 2 {
+			d.keys[args[0]] = redisValue{value: []byte(args[1])}
+		}
+	case "mset", "msetnx":
+		for i := 0; i+1 < len(args); i += 2 {
+			d.keys[args[i]] = redisValue{value: []byte(args[i+1])}
+		}
+	case "append":
+		if len(args) == 2 {
+			entry := d.keys[args[0]]
+			entry.value = append(entry.value, args[1]...)
+			d.keys[args[0]] = entry
+		}
+	case "incr", "decr", "incrby", "decrby":
+		if len(args) == 0 {
+			return
+		}
+		delta := int64(1)
+		if len(args) == 2 {
+			var err error
+			if delta, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+				return
+			}
+		}
+		if strings.HasPrefix(name, "decr") {
+			delta = -delta
+		}
+		entry, ok := d.keys[args[0]]
+		current := int64(0)
+		if ok {
+			var err error
+			if current, err = strconv.ParseInt(string(entry.value), 10, 64); err != nil {
+				return
+			}
+		}
+		entry.value = []byte(strconv.FormatInt(current+delta, 10))
+		d.keys[args[0]] = entry
+	case "del", "unlink", "getdel":
+		for _, key := range args {
+			delete(d.keys, key)
+		}
+	case "expire", "pexpire", "expireat", "pexpireat":
+		if len(args) < 2 {
+			return
+		}
+		entry, ok := d.keys[args[0]]
+		if !ok {
+			return
+		}
+		option := map[string]string{"expire": "ex", "pexpire": "px", "expireat": "exat", "pexpireat": "pxat"}[name]
+		if at, ok := expireTime(option, args[1], now); ok {
+			entry.expireAt = at
+			d.set(args[0], entry, now)
+		}
+	case "persist":
+		if len(args) != 1 {
+			return
+		}
+		if entry, ok := d.keys[args[0]]; ok {
+			entry.expireAt = 0
+			d.keys[args[0]] = entry
+		}
+	case "rename", "renamenx":
+		if len(args) != 2 {
+			return
+		}
+		if entry, ok := d.keys[args[0]]; ok {
+			delete(d.keys, args[0])
+			d.keys[args[1]] = entry
+		}
+	}
+}
+
+// set stores entry under key, or drops the key when it already expired.
+func (d *redisDump) set(key string, entry redisValue, now int64) {
+	if entry.expireAt != 0 && entry.expireAt <= now {
+		delete(d.keys, key)
+		return
+	}
+	d.keys[key] = entry
+}
+
+// expireTime returns the expiry in unix milliseconds set by the option of SET
+// named option, ex, px, exat or pxat, with the argument value.
+func expireTime(option, value string, now int64) (int64, bool) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	switch option {
+	case "ex":
+		return now + n*1000, true
+	case "px":
+		return now + n, true
+	case "exat":
+		return n * 1000, true
+	case "pxat":
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hasssanezzz/goldb"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// stressPrefix holds the keys written by the stress workload, the keys already
+// under it are deleted when it starts.
+const stressPrefix = "stress/"
+
+// maxReportedDivergences caps the divergences printed, the others are counted.
+const maxReportedDivergences = 20
+
+type stressOptions struct {
+	dir         string
+	duration    time.Duration
+	verifyEvery time.Duration
+	workers     int
+	keys        int
+	valueSize   int
+	reopen      bool
+	config      shared.EngineConfig
+}
+
+// stressWorker runs the workload on the keys of its own namespace, so its model
+// of them is exact without coordinating with the other workers.
+type stressWorker struct {
+	id    int
+	rng   *rand.Rand
+	model map[string][]byte // Value of every live key of the namespace.
+	ops   int64
+}
+
+// stressRun is the state shared by the workers of a stress run.
+type stressRun struct {
+	opts        stressOptions
+	db          *goldb.Engine
+	divergences atomic.Int64
+}
+
+// runStress runs a mixed workload of writes, deletes, reads and iterations
+// against a database for a long time, checking every read against an in-memory
+// model of what the database should hold. The workers are paused every
+// verification interval to check every key, and the database reopened when
+// asked to. Any divergence is reported and fails the run.
+func runStress(args []string) error {
+	flags := flag.NewFlagSet("stress", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: program stress <dir> [options]")
+		flags.PrintDefaults()
+	}
+	duration := flags.Duration("duration", time.Hour, "How long the workload runs, interrupting it verifies and stops")
+	verifyEvery := flags.Duration("verify-every", time.Minute, "How often the workers pause for a full verification")
+	workers := flags.Int("workers", 4, "Number of concurrent workers")
+	keys := flags.Int("keys", 10000, "Number of keys of every worker")
+	valueSize := flags.Int("value-size", 256, "Maximum size of the values in bytes")
+	memtable := flags.Int("memtable", 1000, "Memtable size threshold, small values flush and compact often")
+	reopen := flags.Bool("reopen", true, "Close and reopen the database at every verification")
+	seed := flags.Int64("seed", time.Now().UnixNano(), "Seed of the workload")
+
+	// the directory comes first, the flags follow it
+	dir := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		dir, args = args[0], args[1:]
+	}
+	flags.Parse(args)
+	if dir == "" && flags.NArg() == 1 {
+		dir = flags.Arg(0)
+	}
+	if dir == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	config := shared.DefaultConfig
+	config.MemtableSizeThreshold = uint32(max(*memtable, 1))
+	opts := stressOptions{
+		dir:         dir,
+		duration:    *duration,
+		verifyEvery: max(*verifyEvery, time.Second),
+		workers:     max(*workers, 1),
+		keys:        max(*keys, 1),
+		valueSize:   max(*valueSize, 1),
+		reopen:      *reopen,
+		config:      config,
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	db, err := goldb.New(dir, config)
+	if err != nil {
+		return fmt.Errorf("can not open db: %v", err)
+	}
+	run := &stressRun{opts: opts, db: db}
+	defer func() { run.db.Close() }()
+
+	if err := run.clear(); err != nil {
+		return err
+	}
+
+	workerSet := make([]*stressWorker, opts.workers)
+	for i := range workerSet {
+		workerSet[i] = &stressWorker{id: i, rng: rand.New(rand.NewSource(*seed + int64(i))), model: map[string][]byte{}}
+	}
+	fmt.Printf("stress: %d workers on %d keys each for %s, seed %d\n", opts.workers, opts.keys, opts.duration, *seed)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	start := time.Now()
+	for round := 1; ; round++ {
+		interval := min(opts.verifyEvery, opts.duration-time.Since(start))
+		interrupted := run.runWorkers(workerSet, interval, interrupt)
+
+		if err := run.verify(workerSet); err != nil {
+			return err
+		}
+		if opts.reopen && !interrupted {
+			if err := run.reopenDB(); err != nil {
+				return err
+			}
+			if err := run.verify(workerSet); err != nil {
+				return err
+			}
+		}
+
+		ops, live := int64(0), 0
+		for _, worker := range workerSet {
+			ops += worker.ops
+			live += len(worker.model)
+		}
+		elapsed := time.Since(start)
+		fmt.Printf("[%s] round %d: %d ops (%.0f/s), %d live keys, %d divergences\n",
+			elapsed.Truncate(time.Second), round, ops, float64(ops)/elapsed.Seconds(), live, run.divergences.Load())
+
+		if run.divergences.Load() > 0 {
+			return fmt.Errorf("the database diverged from the model %d times", run.divergences.Load())
+		}
+		if interrupted || elapsed >= opts.duration {
+			return nil
+		}
+	}
+}
+
+// clear deletes the keys left under the prefix by an earlier run.
+func (r *stressRun) clear() error {
+	keys, err := r.db.Scan(stressPrefix)
+	if err != nil {
+		return fmt.Errorf("can not list the keys of an earlier run: %v", err)
+	}
+	for _, key := range keys {
+		if err := r.db.Delete(key); err != nil {
+			return fmt.Errorf("can not delete key %q of an earlier run: %v", key, err)
+		}
+	}
+	if len(keys) > 0 {
+		fmt.Printf("stress: deleted %d keys of an earlier run\n", len(keys))
+	}
+	return nil
+}
+
+// runWorkers runs the workers for interval, and reports whether the run was
+// interrupted in the meantime.
+func (r *stressRun) runWorkers(workers []*stressWorker, interval time.Duration, interrupt chan os.Signal) bool {
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for _, worker := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				r.step(worker)
+			}
+		}()
+	}
+
+	interrupted := false
+	select {
+	case <-time.After(interval):
+	case <-interrupt:
+		interrupted = true
+	}
+	close(stop)
+	wg.Wait()
+	return interrupted
+}
+
+func (r *stressRun) key(worker *stressWorker, n int) string {
+	return fmt.Sprintf("%sw%03d/%08d", stressPrefix, worker.id, n)
+}
+
+// step runs a single operation picked at random: 40% writes, 10% deletes, 40%
+// reads and 10% iterations over a few keys.
+func (r *stressRun) step(worker *stressWorker) {
+	worker.ops++
+	n := worker.rng.Intn(r.opts.keys)
+	key := r.key(worker, n)
+
+	switch op := worker.rng.Intn(10); {
+	case op < 4:
+		value := make([]byte, 1+worker.rng.Intn(r.opts.valueSize))
+		worker.rng.Read(value)
+		if err := r.db.Set(key, value); err != nil {
+			r.diverged("set %q failed: %v", key, err)
+			return
+		}
+		worker.model[key] = value
+	case op < 5:
+		err := r.db.Delete(key)
+		var notFound *shared.ErrKeyNotFound
+		if err != nil && !errors.As(err, &notFound) {
+			r.diverged("delete %q failed: %v", key, err)
+			return
+		}
+		delete(worker.model, key)
+	case op < 9:
+		r.checkKey(worker, key)
+	default:
+		r.checkRange(worker, n, min(n+50, r.opts.keys))
+	}
+}
+
+// checkKey reads key and compares it with the model.
+func (r *stressRun) checkKey(worker *stressWorker, key string) {
+	value, err := r.db.Get(key)
+	want, live := worker.model[key]
+
+	var notFound *shared.ErrKeyNotFound
+	switch {
+	case err != nil && !errors.As(err, &notFound):
+		r.diverged("get %q failed: %v", key, err)
+	case err != nil && live:
+		r.diverged("get %q found nothing, the model holds %d bytes", key, len(want))
+	case err == nil && !live:
+		r.diverged("get %q found %d bytes, the model holds nothing", key, len(value))
+	case err == nil && !bytes.Equal(value, want):
+		r.diverged("get %q found a different value of %d bytes, the model holds %d bytes", key, len(value), len(want))
+	}
+}
+
+// checkRange iterates over the keys numbered from first to last, excluded, and
+// compares them with the model.
+func (r *stressRun) checkRange(worker *stressWorker, first, last int) {
+	it, err := r.db.NewIterator(goldb.WithRange(r.key(worker, first), r.key(worker, last)))
+	if err != nil {
+		r.diverged("iterator over worker %d failed: %v", worker.id, err)
+		return
+	}
+	defer it.Close()
+
+	want := []string{}
+	for n := first; n < last; n++ {
+		if _, ok := worker.model[r.key(worker, n)]; ok {
+			want = append(want, r.key(worker, n))
+		}
+	}
+
+	i := 0
+	for it.Next() {
+		key := it.Key()
+		if i >= len(want) || key != want[i] {
+			expected := "the end"
+			if i < len(want) {
+				expected = fmt.Sprintf("%q", want[i])
+			}
+			r.diverged("iterator over worker %d returned %q where the model has %s", worker.id, key, expected)
+			return
+		}
+		value, err := it.Value()
+		if err != nil {
+			r.diverged("iterator can not read %q: %v", key, err)
+			return
+		}
+		if !bytes.Equal(value, worker.model[key]) {
+			r.diverged("iterator returned a different value for %q", key)
+			return
+		}
+		i++
+	}
+	if err := it.Err(); err != nil {
+		r.diverged("iterator over worker %d failed: %v", worker.id, err)
+		return
+	}
+	if i < len(want) {
+		r.diverged("iterator over worker %d stopped before %q", worker.id, want[i])
+	}
+}
+
+// verify checks every key of every worker, and that no other key shows up under
+// the prefix. The workers are paused.
+func (r *stressRun) verify(workers []*stressWorker) error {
+	for _, worker := range workers {
+		for n := 0; n < r.opts.keys; n++ {
+			r.checkKey(worker, r.key(worker, n))
+		}
+		r.checkRange(worker, 0, r.opts.keys)
+	}
+
+	keys, err := r.db.Scan(stressPrefix)
+	if err != nil {
+		return fmt.Errorf("can not list the keys: %v", err)
+	}
+	live := 0
+	for _, worker := range workers {
+		live += len(worker.model)
+	}
+	if len(keys) != live {
+		r.diverged("the database lists %d keys, the model holds %d", len(keys), live)
+	}
+	return nil
+}
+
+// reopenDB closes the database and opens it again, as a restart would.
+func (r *stressRun) reopenDB() error {
+	r.db.Close()
+	db, err := goldb.New(r.opts.dir, r.opts.config)
+	if err != nil {
+		return fmt.Errorf("can not reopen db: %v", err)
+	}
+	r.db = db
+	return nil
+}
+
+// diverged records a divergence from the model, printing the first ones.
+func (r *stressRun) diverged(format string, args ...any) {
+	if r.divergences.Add(1) > maxReportedDivergences {
+		return
+	}
+	fmt.Printf("%s divergence: %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, args...))
+}
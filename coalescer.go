@@ -0,0 +1,84 @@
+package goldb
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/wal"
+)
+
+// maxCoalescedWrites caps the number of writes grouped into a single WAL append.
+const maxCoalescedWrites = 1024
+
+var errEngineClosed = errors.New("db engine is closed")
+
+type writeRequest struct {
+	entry wal.WALEntry
+	done  chan error
+}
+
+// coalescer groups individual writes arriving within a small window into one
+// batch, committed with one WAL append and one acquisition of the write lock.
+// Every write waits up to the window for company, trading a little latency for
+// throughput under concurrent load.
+type coalescer struct {
+	pipeline *pipeline
+	window   time.Duration
+	requests chan writeRequest
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newCoalescer(p *pipeline, window time.Duration) *coalescer {
+	c := &coalescer{
+		pipeline: p,
+		window:   window,
+		requests: make(chan writeRequest),
+		stop:     make(chan struct{}),
+	}
+	c.wg.Add(1)
+	go labeled("coalescer", c.run)
+	return c
+}
+
+// submit hands a write to the coalescer.
+func (c *coalescer) submit(req writeRequest) {
+	select {
+	case c.requests <- req:
+	case <-c.stop:
+		req.done <- errEngineClosed
+	}
+}
+
+func (c *coalescer) run() {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case req := <-c.requests:
+			batch := []writeRequest{req}
+			timer := time.NewTimer(c.window)
+
+		collect:
+			for len(batch) < maxCoalescedWrites {
+				select {
+				case req := <-c.requests:
+					batch = append(batch, req)
+				case <-timer.C:
+					break collect
+				}
+			}
+
+			timer.Stop()
+			c.pipeline.submit(batch)
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *coalescer) close() {
+	close(c.stop)
+	c.wg.Wait()
+}
@@ -0,0 +1,144 @@
+package goldb
+
+import (
+	"cmp"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// collationsFile records the collation of every namespace, so a database is not
+// reopened with a different key order than it was created with.
+const collationsFile = "collations.json"
+
+// CaseInsensitiveCollation orders keys ignoring the case of ASCII and Unicode letters.
+type CaseInsensitiveCollation struct{}
+
+func (CaseInsensitiveCollation) Name() string { return "case-insensitive" }
+
+func (CaseInsensitiveCollation) Compare(a, b string) int {
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+// NaturalCollation orders runs of digits by their numeric value, so "item9"
+// sorts before "item10".
+type NaturalCollation struct{}
+
+func (NaturalCollation) Name() string { return "natural" }
+
+func (NaturalCollation) Compare(a, b string) int {
+	for a != "" && b != "" {
+		if !isDigit(a[0]) || !isDigit(b[0]) {
+			if a[0] != b[0] {
+				return cmp.Compare(a[0], b[0])
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+
+		lenA, lenB := digitRun(a), digitRun(b)
+		numA, numB := strings.TrimLeft(a[:lenA], "0"), strings.TrimLeft(b[:lenB], "0")
+		if len(numA) != len(numB) {
+			return cmp.Compare(len(numA), len(numB))
+		}
+		if c := strings.Compare(numA, numB); c != 0 {
+			return c
+		}
+		a, b = a[lenA:], b[lenB:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func digitRun(s string) int {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return i
+}
+
+// collatedLess orders keys byte-wise, except for two keys of the same namespace
+// which are ordered by its collation. Namespaces are never nested, so the keys of
+// each one stay together and the order is consistent across namespaces.
+func collatedLess(config *shared.EngineConfig, a, b string) bool {
+	namespaceA, okA := config.CollationFor(a)
+	namespaceB, okB := config.CollationFor(b)
+	if okA && okB && namespaceA.Prefix == namespaceB.Prefix {
+		prefix := len(namespaceA.Prefix)
+		if c := namespaceA.Collation.Compare(a[prefix:], b[prefix:]); c != 0 {
+			return c < 0
+		}
+	}
+	return a < b
+}
+
+// collateKeys reorders byte-wise sorted keys by the namespace collations.
+func collateKeys(config *shared.EngineConfig, keys []string) {
+	if len(config.Collations) == 0 {
+		return
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return collatedLess(config, keys[i], keys[j])
+	})
+}
+
+// collatePairs reorders byte-wise sorted pairs by the namespace collations.
+func collatePairs(config *shared.EngineConfig, pairs []memtable.KVPair) {
+	if len(config.Collations) == 0 {
+		return
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return collatedLess(config, pairs[i].Key, pairs[j].Key)
+	})
+}
+
+// checkCollations compares the configured collations with the ones the database
+// was created with. Namespaces seen for the first time are recorded when persist is set.
+func checkCollations(config *shared.EngineConfig, persist bool) error {
+	path := filepath.Join(config.Homepath, collationsFile)
+
+	stored := map[string]string{} // namespace prefix -> collation name
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &stored)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return &shared.ErrInvalidConfig{Field: "Collations", Reason: "can not read " + collationsFile + ": " + err.Error()}
+	}
+
+	configured := map[string]string{}
+	for _, namespace := range config.Collations {
+		configured[namespace.Prefix] = namespace.Collation.Name()
+	}
+
+	prefixes := []string{}
+	for prefix := range stored {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		if name := stored[prefix]; configured[prefix] != name {
+			return &shared.ErrInvalidConfig{Field: "Collations", Reason: "namespace " + prefix + " was created with collation " + name}
+		}
+	}
+
+	if !persist || len(configured) == len(stored) {
+		return nil
+	}
+
+	data, err = json.MarshalIndent(configured, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,81 @@
+package goldb
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hasssanezzz/goldb/internal/index_manager"
+)
+
+// compactor runs the compaction check in the background after the flushes, so
+// writes never wait for a compaction to finish. Flushes arriving while a check
+// runs are folded into a single next check.
+type compactor struct {
+	im      *index_manager.IndexManager
+	onError func(err error)
+	wake    chan struct{}
+	stop    chan struct{}
+	wg      sync.WaitGroup
+	running atomic.Bool // A compaction check is running.
+}
+
+func newCompactor(im *index_manager.IndexManager, onError func(err error)) *compactor {
+	c := &compactor{im: im, onError: onError, wake: make(chan struct{}, 1), stop: make(chan struct{})}
+	c.wg.Add(1)
+	go labeled("compactor", c.run)
+	return c
+}
+
+// trigger asks for a compaction check without waiting for it. It does nothing
+// before the compactor is started, while the WAL is replayed: the compactor
+// checks once when it starts.
+func (c *compactor) trigger() {
+	if c == nil {
+		return
+	}
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *compactor) run() {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-c.wake:
+			c.running.Store(true)
+			if err := c.im.CompactionCheck(); err != nil {
+				c.report(fmt.Errorf("db engine can not compact the tables: %v", err))
+			}
+			c.running.Store(false)
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// state reports whether a compaction check is running and whether another one
+// is waiting to run after it.
+func (c *compactor) state() (running, pending bool) {
+	return c.running.Load(), len(c.wake) > 0
+}
+
+// report hands a failed compaction to the OnCompactionError callback, or logs
+// it when none is set. The tables are left as they were, the next flush retries.
+func (c *compactor) report(err error) {
+	if c.onError != nil {
+		c.onError(err)
+		return
+	}
+	log.Println(err)
+}
+
+// close stops the compactor once the running check, if any, is done.
+func (c *compactor) close() {
+	close(c.stop)
+	c.wg.Wait()
+}
@@ -0,0 +1,43 @@
+package goldb
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+// FlateCompression compresses values with DEFLATE from the standard library.
+// Level is one of the compress/flate levels, zero picks the default one.
+// Other algorithms like snappy, zstd or lz4 plug in through the Compressor
+// interface, the engine does not ship with third-party codecs.
+type FlateCompression struct {
+	Level int
+}
+
+func (FlateCompression) Name() string { return "flate" }
+
+func (c FlateCompression) Compress(value []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+
+	buf := new(bytes.Buffer)
+	w, err := flate.NewWriter(buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (FlateCompression) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return io.ReadAll(r)
+}
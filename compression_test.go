@@ -0,0 +1,134 @@
+package goldb
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// compressible returns a value of key that compresses well.
+func compressible(key string) []byte {
+	return bytes.Repeat([]byte(key+";"), 50)
+}
+
+// incompressible returns a value of key that does not compress, stored as is.
+func incompressible(key string) []byte {
+	value := make([]byte, 200)
+	rand.New(rand.NewSource(int64(len(key)))).Read(value)
+	return append([]byte(key+";"), value...)
+}
+
+// checkValues checks every key reads its value back, and whether the index
+// records it compressed.
+func checkValues(t *testing.T, e *Engine, values map[string][]byte, compressed map[string]bool) {
+	t.Helper()
+	for key, want := range values {
+		value, err := e.Get(key)
+		if err != nil || !bytes.Equal(value, want) {
+			t.Fatalf("%q reads %q, %v", key, value, err)
+		}
+		node, err := e.indexManager.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node.Compressed != compressed[key] {
+			t.Fatalf("%q is stored compressed %v, want %v", key, node.Compressed, compressed[key])
+		}
+	}
+}
+
+func withFlate(c *EngineConfig) { c.Compression = FlateCompression{} }
+
+// TestCompressionRoundTrip writes values that compress and values that do not
+// through flushes, and checks they read back from the memtable, the tables, a
+// reopened database, the compacted level and the collected value file.
+func TestCompressionRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	e := openTestEngine(t, dir, withFlate, func(c *EngineConfig) {
+		c.MemtableSizeThreshold = 16
+	})
+
+	values, compressed := map[string][]byte{}, map[string]bool{}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%03d", i)
+		values[key], compressed[key] = compressible(key), i%3 != 0
+		if i%3 == 0 {
+			values[key] = incompressible(key)
+		}
+		if err := e.Set(key, values[key]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkValues(t, e, values, compressed)
+	size, err := e.storageManager.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := 0
+	for _, value := range values {
+		raw += len(value)
+	}
+	if size >= int64(raw) {
+		t.Fatalf("the value file holds %d bytes for %d bytes of values", size, raw)
+	}
+	e.Close()
+
+	e = openTestEngine(t, dir, withFlate)
+	defer e.Close()
+	checkValues(t, e, values, compressed)
+	if err := e.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, e, values, compressed)
+	if _, err := e.CollectValueGarbage(); err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, e, values, compressed)
+}
+
+// TestCompressedAndPlainTables writes tables without compression, then with it
+// over part of their keys, and checks both kinds of values read back, before
+// and after they are compacted together, and that the database does not open
+// without the compressor any more.
+func TestCompressedAndPlainTables(t *testing.T) {
+	dir := t.TempDir()
+	flush := func(e *Engine) { e.pipeline.exclusive(func() error { e.flush(); return nil }) }
+	values, compressed := map[string][]byte{}, map[string]bool{}
+	write := func(e *Engine, from, to int, isCompressed bool) {
+		t.Helper()
+		for i := from; i < to; i++ {
+			key := fmt.Sprintf("key-%03d", i)
+			values[key], compressed[key] = compressible(key+fmt.Sprint(isCompressed)), isCompressed
+			if err := e.Set(key, values[key]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		flush(e)
+	}
+
+	e := openTestEngine(t, dir)
+	write(e, 0, 40, false)
+	write(e, 40, 60, false)
+	checkValues(t, e, values, compressed)
+	e.Close()
+
+	e = openTestEngine(t, dir, withFlate)
+	write(e, 20, 50, true)
+	write(e, 60, 80, true)
+	checkValues(t, e, values, compressed)
+	if err := e.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, e, values, compressed)
+	e.Close()
+
+	config := *NewEngineConfig()
+	if e, err := New(dir, config); err == nil {
+		e.Close()
+		t.Fatal("the database with compressed values opens without a compressor")
+	}
+	e = openTestEngine(t, dir, withFlate)
+	defer e.Close()
+	checkValues(t, e, values, compressed)
+}
@@ -0,0 +1,52 @@
+package goldb
+
+import "github.com/hasssanezzz/goldb/internal/shared"
+
+// The configuration types live in an internal package, these aliases make them
+// usable from outside the module.
+type (
+	EngineConfig       = shared.EngineConfig
+	CompactionPicker   = shared.CompactionPicker
+	CompactionStrategy = shared.CompactionStrategy
+	CompactionStep     = shared.CompactionStep
+	PickerStrategy     = shared.PickerStrategy
+	LeveledStrategy    = shared.LeveledStrategy
+	SizeTieredStrategy = shared.SizeTieredStrategy
+	FIFOStrategy       = shared.FIFOStrategy
+	TableInfo          = shared.TableInfo
+	Codec              = shared.Codec
+	Collation          = shared.Collation
+	Compressor         = shared.Compressor
+	KeyProvider        = shared.KeyProvider
+	ValueSyncPolicy    = shared.ValueSyncPolicy
+	SyncMode           = shared.SyncMode
+	FlushFormat        = shared.FlushFormat
+	MemtableKind       = shared.MemtableKind
+	ArchiveDestination = shared.ArchiveDestination
+	MetricsReporter    = shared.MetricsReporter
+	CrashPoint         = shared.CrashPoint
+)
+
+const (
+	ValueSyncOnFlush = shared.ValueSyncOnFlush
+	ValueSyncNever   = shared.ValueSyncNever
+
+	SyncNever    = shared.SyncNever
+	SyncAlways   = shared.SyncAlways
+	SyncInterval = shared.SyncInterval
+
+	FlushSortedRun   = shared.FlushSortedRun
+	FlushPartitioned = shared.FlushPartitioned
+
+	MemtableAVL      = shared.MemtableAVL
+	MemtableSkiplist = shared.MemtableSkiplist
+
+	CrashAfterWALAppend  = shared.CrashAfterWALAppend
+	CrashAfterFlush      = shared.CrashAfterFlush
+	CrashAfterCompaction = shared.CrashAfterCompaction
+)
+
+// NewEngineConfig returns a configuration populated with the default values.
+func NewEngineConfig() *EngineConfig {
+	return shared.NewEngineConfig()
+}
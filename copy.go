@@ -0,0 +1,82 @@
+package goldb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/index_manager"
+	"github.com/hasssanezzz/goldb/internal/memtable"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// CopyRange duplicates every key starting with srcPrefix under dstPrefix, the
+// rest of the key staying the same, and returns the number of keys copied. Keys
+// already under dstPrefix are overwritten when the copy has the same name and
+// kept otherwise.
+//
+// Values are not read nor written again: the copies point at the values of the
+// originals in the value file and are written straight to a new SSTable. For
+// that the memtable is flushed first, and writes wait until the copy is done.
+func (e *Engine) CopyRange(srcPrefix, dstPrefix string) (int, error) {
+	if err := checkUserKey(dstPrefix); err != nil {
+		return 0, err
+	}
+	if srcPrefix == dstPrefix {
+		return 0, nil
+	}
+
+	copied := 0
+	err := e.pipeline.exclusive(func() error {
+		opts := index_manager.NewScanOptions()
+		opts.Start, opts.End = srcPrefix, shared.PrefixEnd(srcPrefix)
+		opts.KeysOnly = true
+
+		pairs, err := e.indexManager.Items(opts)
+		if err != nil {
+			return fmt.Errorf("db engine can not list prefix (%q): %v", srcPrefix, err)
+		}
+		if len(pairs) == 0 {
+			return nil
+		}
+
+		now := time.Now().UnixNano()
+		// the copies are a single write, numbered after every one before it
+		seq := e.pipeline.allocate(1)
+		copies := make([]memtable.KVPair, len(pairs))
+		for i, pair := range pairs {
+			key := dstPrefix + pair.Key[len(srcPrefix):]
+			if len([]byte(key)) > int(e.Config.KeySize) {
+				return &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
+			}
+			copies[i] = memtable.KVPair{Key: key, Value: pair.Value}
+			copies[i].Value.Timestamp = now
+			// the copies are a single write, newer than the pairs they copy
+			copies[i].Value.Sequence = seq
+		}
+
+		// the new table has to be newer than every write before it
+		if e.indexManager.MemtableSize() > 0 {
+			e.flush()
+		}
+		// the copies point into the value file without the WAL backing them
+		if err := e.syncValues(); err != nil {
+			return err
+		}
+		// recorded before the table is in place, the transactions keep what it supersedes
+		for _, pair := range copies {
+			e.txns.record(pair.Key, seq)
+		}
+		if err := e.indexManager.Ingest(copies); err != nil {
+			return err
+		}
+
+		e.clock.record(seq, earliestPair(copies))
+		e.publish(seq)
+		e.counters.keysWritten.Add(uint64(len(copies)))
+		invalidateReplicas(e.Config.Homepath)
+
+		copied = len(copies)
+		return nil
+	})
+	return copied, err
+}
@@ -0,0 +1,39 @@
+package goldb
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"runtime/pprof"
+)
+
+// profileLabel is the pprof label the goroutines of the engine are tagged with,
+// so CPU and goroutine profiles tell its background loops and compaction workers
+// apart from the application, e.g. `go tool pprof -tagfocus goldb=wal-stage`.
+const profileLabel = "goldb"
+
+// labeled runs fn with the calling goroutine labelled as doing task. The labels
+// are not restored but cleared afterwards, so it only starts the goroutines of
+// the engine: the flushes and compactions show up under the goroutine they run on.
+func labeled(task string, fn func()) {
+	pprof.Do(context.Background(), pprof.Labels(profileLabel, task), func(context.Context) {
+		fn()
+	})
+}
+
+// PublishExpvar publishes the stats of the engine under name with expvar, along
+// with its operation stats and last sequence number, so they show up at
+// /debug/vars next to the runtime ones. Names are published once per process.
+func (e *Engine) PublishExpvar(name string) error {
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("db engine can not publish the expvar %q: the name is taken", name)
+	}
+	expvar.Publish(name, expvar.Func(func() any {
+		return map[string]any{
+			"stats":    e.Stats(),
+			"ops":      e.OpStats(),
+			"sequence": e.LastSequence(),
+		}
+	}))
+	return nil
+}
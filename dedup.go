@@ -0,0 +1,66 @@
+package goldb
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hasssanezzz/goldb/internal/index_manager"
+	"github.com/hasssanezzz/goldb/internal/memtable"
+)
+
+// dedupIndex maps the hash of the stored values to their record, for
+// DedupValues.
+type dedupIndex struct {
+	mu      sync.Mutex
+	records map[[sha256.Size]byte]memtable.IndexNode
+	hits    atomic.Uint64 // Writes that reused a record.
+}
+
+func newDedupIndex() *dedupIndex {
+	return &dedupIndex{records: map[[sha256.Size]byte]memtable.IndexNode{}}
+}
+
+func (d *dedupIndex) get(sum [sha256.Size]byte) (memtable.IndexNode, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	indexNode, ok := d.records[sum]
+	return indexNode, ok
+}
+
+func (d *dedupIndex) put(sum [sha256.Size]byte, indexNode memtable.IndexNode) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	indexNode.Timestamp, indexNode.Deleted = 0, false
+	d.records[sum] = indexNode
+}
+
+// seedDedup indexes the values of the live keys, so values written before the
+// database was opened are reused too.
+func (e *Engine) seedDedup() error {
+	pairs, err := e.indexManager.Items(index_manager.NewScanOptions())
+	if err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		if pair.Value.Inline != nil {
+			continue
+		}
+		value, err := e.storageManager.ReadValue(pair.Value)
+		if err != nil {
+			return fmt.Errorf("db engine can not read key (%q) to deduplicate: %v", pair.Key, err)
+		}
+		e.dedup.put(sha256.Sum256(value), pair.Value)
+	}
+	return nil
+}
+
+// DedupHits returns the number of writes since the database was opened that
+// reused the record of an identical value, zero unless DedupValues is set.
+func (e *Engine) DedupHits() uint64 {
+	if e.dedup == nil {
+		return 0
+	}
+	return e.dedup.hits.Load()
+}
@@ -0,0 +1,35 @@
+package goldb
+
+import (
+	"fmt"
+
+	"github.com/hasssanezzz/goldb/internal/wal"
+)
+
+// EncryptionKeyID returns the id a key is recorded under in encrypted files.
+func EncryptionKeyID(key []byte) uint32 {
+	return wal.KeyID(key)
+}
+
+// RotateEncryptionKey makes new writes to the WAL use newKey. Entries already in
+// the WAL stay encrypted with the previous key until the next flush clears it,
+// keeping the prepared batches re-encrypted with newKey. Until then the previous
+// key must be passed in WALDecryptionKeys when the database is opened again;
+// EncryptionKeysInUse tells when it is no longer needed.
+func (e *Engine) RotateEncryptionKey(newKey []byte) error {
+	if n := len(newKey); n != 16 && n != 24 && n != 32 {
+		return fmt.Errorf("db engine can not rotate encryption key: key must be 16, 24 or 32 bytes long")
+	}
+	if !e.wal.Encrypted() {
+		return fmt.Errorf("db engine can not rotate encryption key: the WAL is not encrypted")
+	}
+	if e.Config.WALKeyProvider != nil {
+		return fmt.Errorf("db engine can not rotate encryption key: keys come from the key provider")
+	}
+	return e.wal.RotateKey(newKey)
+}
+
+// EncryptionKeysInUse returns the ids of the keys needed to read the WAL back.
+func (e *Engine) EncryptionKeysInUse() []uint32 {
+	return e.wal.KeysInUse()
+}
@@ -0,0 +1,121 @@
+package goldb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+var (
+	oldWALKey = bytes.Repeat([]byte{0x11}, 32)
+	newWALKey = bytes.Repeat([]byte{0x22}, 16)
+)
+
+// checkPairs checks every key reads its own name back as its value.
+func checkPairs(t *testing.T, e *Engine, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if value, err := e.Get(key); err != nil || string(value) != key {
+			t.Fatalf("%q reads %q, %v", key, value, err)
+		}
+	}
+}
+
+// TestRotateEncryptionKey rotates the WAL key between writes and checks the
+// writes before it replay with the old key passed in WALDecryptionKeys, and
+// that the old key is no longer needed once a flush cleared the WAL.
+func TestRotateEncryptionKey(t *testing.T) {
+	dir := t.TempDir()
+	withKeys := func(keys ...[]byte) func(*EngineConfig) {
+		return func(c *EngineConfig) {
+			c.WALEncryptionKey = keys[0]
+			c.WALDecryptionKeys = keys[1:]
+		}
+	}
+	e := openTestEngine(t, dir, withKeys(oldWALKey))
+	for _, key := range []string{"a", "b"} {
+		if err := e.Set(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.RotateEncryptionKey([]byte("short")); err == nil {
+		t.Fatal("the WAL key is rotated to a key of 5 bytes")
+	}
+	if err := e.RotateEncryptionKey(newWALKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Set("c", []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	both := []uint32{EncryptionKeyID(oldWALKey), EncryptionKeyID(newWALKey)}
+	if both[0] > both[1] {
+		both[0], both[1] = both[1], both[0]
+	}
+	if keys := e.EncryptionKeysInUse(); fmt.Sprint(keys) != fmt.Sprint(both) {
+		t.Fatalf("the keys in use are %v, want %v", keys, both)
+	}
+	e.Close()
+
+	config := *NewEngineConfig()
+	withKeys(newWALKey)(&config)
+	if e, err := New(dir, config); err == nil {
+		e.Close()
+		t.Fatal("the database opens without the key of the writes before the rotation")
+	}
+
+	e = openTestEngine(t, dir, withKeys(newWALKey, oldWALKey))
+	checkPairs(t, e, "a", "b", "c")
+	if keys := e.EncryptionKeysInUse(); fmt.Sprint(keys) != fmt.Sprint(both) {
+		t.Fatalf("the keys in use after a reopen are %v, want %v", keys, both)
+	}
+	e.pipeline.exclusive(func() error { e.flush(); return nil })
+	if err := e.Set("d", []byte("d")); err != nil {
+		t.Fatal(err)
+	}
+	if keys := e.EncryptionKeysInUse(); fmt.Sprint(keys) != fmt.Sprint([]uint32{EncryptionKeyID(newWALKey)}) {
+		t.Fatalf("the keys in use after the flush are %v, want the new key alone", keys)
+	}
+	e.Close()
+
+	e = openTestEngine(t, dir, withKeys(newWALKey))
+	defer e.Close()
+	checkPairs(t, e, "a", "b", "c", "d")
+}
+
+// fixedKeyProvider hands out the same data key, stored as is, enough for the
+// engine to take its keys from a provider.
+type fixedKeyProvider struct{}
+
+func (fixedKeyProvider) GenerateDataKey() ([]byte, []byte, error) {
+	return newWALKey, newWALKey, nil
+}
+
+func (fixedKeyProvider) GetKey(wrapped []byte) ([]byte, error) {
+	return wrapped, nil
+}
+
+// TestRotateEncryptionKeyWithKeyProvider checks the key of a WAL encrypted with
+// the data keys of a provider is not rotated by the engine, and the writes
+// replay with the provider.
+func TestRotateEncryptionKeyWithKeyProvider(t *testing.T) {
+	dir := t.TempDir()
+	withProvider := func(c *EngineConfig) { c.WALKeyProvider = fixedKeyProvider{} }
+	e := openTestEngine(t, dir, withProvider)
+	if err := e.Set("a", []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.RotateEncryptionKey(oldWALKey); err == nil {
+		t.Fatal("the key of a WAL encrypted with a key provider is rotated")
+	}
+	if err := e.Set("b", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if keys := e.EncryptionKeysInUse(); fmt.Sprint(keys) != fmt.Sprint([]uint32{EncryptionKeyID(newWALKey)}) {
+		t.Fatalf("the keys in use are %v, want the data key alone", keys)
+	}
+	e.Close()
+
+	e = openTestEngine(t, dir, withProvider)
+	defer e.Close()
+	checkPairs(t, e, "a", "b")
+}
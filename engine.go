@@ -1,35 +1,83 @@
 package goldb
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/hasssanezzz/goldb/internal/index_manager"
 	"github.com/hasssanezzz/goldb/internal/memtable"
 	"github.com/hasssanezzz/goldb/internal/shared"
 	"github.com/hasssanezzz/goldb/internal/storage_manager"
+	"github.com/hasssanezzz/goldb/internal/syncpoint"
 	"github.com/hasssanezzz/goldb/internal/wal"
 )
 
+// Engine is a key-value store, safe for concurrent use by multiple goroutines.
 type Engine struct {
 	Config         shared.EngineConfig
 	indexManager   *index_manager.IndexManager
 	storageManager *storage_manager.StorageManager
 	wal            *wal.WAL
+	writeMu        sync.RWMutex      // Serializes writers applying entries to the WAL and memtable, relaxed writes to a skiplist share it.
+	pipeline       *pipeline         // Commits writes in WAL and memtable stages.
+	coalescer      *coalescer        // Groups concurrent writes, nil unless WriteCoalesceWindow is set.
+	asyncQueue     *asyncQueue       // Batches the writes issued with SetAsync and DeleteAsync.
+	reads          *readLimiter      // Lets high priority reads go before low priority ones.
+	sequence       atomic.Uint64     // Sequence number of the latest write visible to readers.
+	prepared       map[string]*Batch // Prepared batches waiting for a decision, guarded by writeMu.
+	keyLocks       *keyLocks         // Per-key locks handed out by LockKey.
+	queueLocks     *keyLocks         // Serializes PopOldest per prefix.
+	txns           *txnTracker       // Writes made while transactions are running.
+	counters       *engineCounters   // Cumulative stats, see Stats.
+	dedup          *dedupIndex       // Records of the stored values by hash, nil unless DedupValues is set.
+	stats          *opStats          // Latency and throughput of the operations by tag.
+	walSyncer      *walSyncer        // Syncs the WAL in the background, nil unless SyncMode is SyncInterval.
+	values         *valueLock        // Held for reading from an index lookup until the value is read.
+	liveValueBytes int64             // Live bytes of the value file when last counted, guarded by writeMu.
+	clock          *sequenceClock    // Write times of the sequence numbers, see ExportSince.
+	archiver       *archiver         // Ships the closed WAL segments, nil unless Archive is set.
+	metrics        *metricsPusher    // Pushes the counters of Stats, nil unless Metrics is set.
+	compactor      *compactor        // Compacts the tables in the background after the flushes.
+	flusher        *flusher          // Writes the frozen memtable in the background.
+	flushMu        sync.Mutex        // Serializes freezing and flushing the frozen memtable.
+	frozenSegment  int               // First WAL segment written after the memtable was frozen, zero when none is, guarded by flushMu.
+	format         int               // Format version recorded when the database was created, see Features.
 }
 
 func New(homepath string, configs ...shared.EngineConfig) (*Engine, error) {
-	e := &Engine{}
+	e := &Engine{prepared: map[string]*Batch{}, keyLocks: newKeyLocks(), queueLocks: newKeyLocks(), txns: newTxnTracker(), counters: &engineCounters{}, stats: &opStats{ids: newOpIDs()}, values: &valueLock{}}
 
 	config := shared.DefaultConfig
 	if len(configs) > 0 {
 		config = configs[0]
 	}
 	config.Homepath = homepath
+	config.ResolveDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if err := checkCollations(&config, true); err != nil {
+		return nil, err
+	}
 	e.Config = config
+	e.reads = newReadLimiter(config.LowPriorityReadRate)
 
+	if err := index_manager.RecoverRelocation(homepath); err != nil {
+		return nil, fmt.Errorf("db engine can not recover the relocation of the values: %v", err)
+	}
+	if err := migrateFormat(&config); err != nil {
+		return nil, err
+	}
 	indexManager, err := index_manager.New(&config)
 	if err != nil {
 		return nil, err
@@ -39,74 +87,226 @@ func New(homepath string, configs ...shared.EngineConfig) (*Engine, error) {
 	if err != nil {
 		return nil, err
 	}
+	storageManager.SetCompressor(config.Compression)
+	if cache := indexManager.BlockCache(); cache != nil {
+		storageManager.SetCache(cache)
+	}
 
-	wal, err := wal.New(filepath.Join(homepath, "wal.log.bin"), config.KeySize)
+	var writeAheadLog *wal.WAL
+	walPath := filepath.Join(homepath, "wal.log.bin")
+	if config.WALKeyProvider != nil {
+		writeAheadLog, err = wal.NewWithProvider(walPath, config.KeySize, config.WALKeyProvider)
+	} else {
+		walKeys := append([][]byte{config.WALEncryptionKey}, config.WALDecryptionKeys...)
+		writeAheadLog, err = wal.New(walPath, config.KeySize, walKeys...)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if config.WALSegmentSize > 0 {
+		writeAheadLog.SetSegmentSize(config.WALSegmentSize)
+	}
+	writeAheadLog.SetSyncWrites(config.SyncMode == shared.SyncAlways)
+	if config.Archive != nil {
+		dir := filepath.Join(homepath, archiveDir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("db engine can not create the archive directory: %v", err)
+		}
+		writeAheadLog.SetArchiveDir(dir)
+	}
 
 	e.indexManager = indexManager
+	e.txns.lookup = indexManager.Lookup
 	e.storageManager = storageManager
-	e.wal = wal
+	e.wal = writeAheadLog
+
+	if config.DedupValues {
+		e.dedup = newDedupIndex()
+		if err := e.seedDedup(); err != nil {
+			return nil, err
+		}
+	}
+
+	replayed, err := e.setEntriesFromWAL()
+	if err != nil {
+		return nil, err
+	}
+	if err := e.loadStats(); err != nil {
+		return nil, err
+	}
+	if err := e.loadSequence(replayed); err != nil {
+		return nil, err
+	}
+
+	e.flusher = newFlusher(e)
+	e.pipeline = newPipeline(e)
+	e.asyncQueue = newAsyncQueue(e.pipeline)
+	e.compactor = newCompactor(e.indexManager, config.OnCompactionError)
+	e.compactor.trigger()
 
-	return e, e.setEntriesFromWAL()
+	if err := e.checkFormat(); err != nil {
+		e.Close()
+		return nil, err
+	}
+	if err := e.checkCompression(); err != nil {
+		e.Close()
+		return nil, err
+	}
+	if err := e.replayLegacyWAL(); err != nil {
+		e.Close()
+		return nil, err
+	}
+	if config.WriteCoalesceWindow > 0 {
+		e.coalescer = newCoalescer(e.pipeline, config.WriteCoalesceWindow)
+	}
+	if config.SyncMode == shared.SyncInterval {
+		e.walSyncer = newWALSyncer(e.wal, config.SyncInterval)
+	}
+	if config.Archive != nil {
+		e.archiver = newArchiver(config.Archive, filepath.Join(homepath, archiveDir), config.ArchiveInterval)
+	}
+	if config.Metrics != nil {
+		e.metrics = newMetricsPusher(e, config.Metrics, config.MetricsInterval)
+	}
+
+	return e, nil
 }
 
-func (e *Engine) setEntriesFromWAL() error {
-	entries, err := e.wal.ParseLogs()
+// setEntriesFromWAL replays the WAL into the memtable and returns the highest
+// sequence number of the replayed writes.
+func (e *Engine) setEntriesFromWAL() (uint64, error) {
+	entries, prepared, err := e.wal.ParseLogs()
 	if err != nil {
 		println("error parsing the logs")
-		return err
+		return 0, err
+	}
+
+	for _, batch := range prepared {
+		b := &Batch{engine: e, entries: batch.Entries, id: batch.ID}
+		for _, entry := range batch.Entries {
+			b.size += len(entry.Key) + len(entry.Value)
+		}
+		e.prepared[batch.ID] = b
 	}
 
+	replayed := uint64(0)
 	for _, entry := range entries {
-		if len(entry.Value) > 0 {
-			// TODO - make logging conditional
-			// log.Printf("[WAL:SET] %q %X\n", entry.Key, entry.Value)
-			if err := e.Set(entry.Key, entry.Value, true); err != nil {
-				return err
-			}
-		} else {
-			// TODO - make logging conditional
-			// log.Printf("[WAL:DEL] %q\n", entry.Key)
-			if err := e.Delete(entry.Key, true); err != nil {
-				return err
-			}
+		// TODO - make logging conditional
+		// log.Printf("[WAL] %q %X\n", entry.Key, entry.Value)
+		if err := e.apply(entry); err != nil {
+			return 0, err
 		}
+		replayed = max(replayed, entry.Sequence)
 	}
+	// the writes of the WAL were numbered after the checkpointed sequence number
+	e.sequence.Add(uint64(e.wal.Logged()))
 
-	return nil
+	return replayed, nil
 }
 
+// apply inserts a WAL entry into the memtable: a link to a stored value, a set,
+// or a delete when the value is empty.
+func (e *Engine) apply(entry wal.WALEntry) error {
+	if entry.Link != nil {
+		indexNode := *entry.Link
+		indexNode.Timestamp = entry.Timestamp
+		indexNode.Sequence = entry.Sequence
+		e.indexManager.Set(entry.Key, indexNode)
+		return nil
+	}
+	if len(entry.Value) > 0 {
+		return e.set(entry.Key, entry.Value, entry.Timestamp, entry.ExpiresAt, entry.Sequence)
+	}
+	return e.delete(entry.Key, entry.Timestamp, entry.Sequence)
+}
+
+// Scan returns the keys starting with pattern in ascending byte-wise order, or
+// in the order of their namespace collation. An empty pattern returns every key.
+// NewIterator walks large ranges with their values without listing them first.
 func (e *Engine) Scan(pattern string) ([]string, error) {
-	keys, err := e.indexManager.Keys()
+	keys, err := e.indexManager.Keys(pattern)
 	if err != nil {
 		return nil, err
 	}
 
-	// if not pattern exists, return all the keys
-	if len(pattern) == 0 {
-		return keys, nil
+	collateKeys(&e.Config, keys)
+	return keys, nil
+}
+
+// ScanPage returns up to limit keys starting with prefix, after the key the
+// cursor points at, and the cursor to pass to get the next page. The cursor is
+// empty once the last page is returned, an empty cursor starts from the first
+// key. Only the keys of the page are read, so large keyspaces can be listed
+// bit by bit. Pages are in ascending byte-wise order, namespace collations are
+// not applied across pages.
+func (e *Engine) ScanPage(prefix string, limit int, cursor string) ([]string, string, error) {
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("db engine can not scan pages of %d keys", limit)
 	}
 
-	results := []string{}
-	for _, key := range keys {
-		if strings.HasPrefix(key, pattern) {
-			results = append(results, key)
+	start := prefix
+	if cursor != "" {
+		last, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || !strings.HasPrefix(string(last), prefix) {
+			return nil, "", fmt.Errorf("db engine can not scan from invalid cursor %q", cursor)
 		}
+		// the smallest key after the last one of the previous page
+		start = string(last) + "\x00"
+	}
+
+	opts := index_manager.NewScanOptions()
+	opts.Start, opts.End = start, shared.PrefixEnd(prefix)
+	opts.KeysOnly = true
+	// one more key tells whether there is a next page
+	opts.Limit = limit + 1
+
+	pairs, err := e.indexManager.Items(opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(pairs) > limit {
+		pairs = pairs[:limit]
+		next = base64.RawURLEncoding.EncodeToString([]byte(pairs[limit-1].Key))
+	}
+
+	keys := make([]string, len(pairs))
+	for i, pair := range pairs {
+		keys[i] = pair.Key
 	}
+	return keys, next, nil
+}
 
+// ListPrefixes lists the level of the keyspace under prefix the way a directory
+// listing would: keys without delimiter after prefix are returned as they are,
+// and the keys sharing a part up to the next delimiter are collapsed into that
+// child prefix, which ends with the delimiter.
+//
+//	e.ListPrefixes("photos/", "/") // ["photos/2023/", "photos/2024/", "photos/cover.jpg"]
+func (e *Engine) ListPrefixes(prefix, delimiter string) ([]string, error) {
+	results, err := e.indexManager.ListPrefixes(prefix, delimiter)
+	if err != nil {
+		return nil, err
+	}
+	collateKeys(&e.Config, results)
 	return results, nil
 }
 
 func (e *Engine) Get(key string) ([]byte, error) {
+	return e.GetContext(context.Background(), key)
+}
+
+func (e *Engine) get(key string) ([]byte, error) {
 	// make sure key size is valid
 	if len([]byte(key)) > int(e.Config.KeySize) {
 		return nil, &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
 	}
 
+	e.values.mu.RLock()
 	indexNode, err := e.indexManager.Get(key)
 	if err != nil {
+		e.values.mu.RUnlock()
 		if _, ok := err.(*shared.ErrKeyNotFound); ok {
 			return nil, err
 		}
@@ -114,6 +314,7 @@ func (e *Engine) Get(key string) ([]byte, error) {
 	}
 
 	data, err := e.storageManager.ReadValue(indexNode)
+	e.values.mu.RUnlock()
 	if err != nil {
 		if e, ok := err.(*shared.ErrKeyNotFound); ok {
 			e.Key = key
@@ -122,78 +323,370 @@ func (e *Engine) Get(key string) ([]byte, error) {
 		return nil, fmt.Errorf("db engine can not read key (%q): %v", key, err)
 	}
 
-	return data, nil
+	return e.applyReadHook(key, indexNode, data)
 }
 
 func (e *Engine) Set(key string, value []byte, ignoreWAL ...bool) error {
+	if len(ignoreWAL) == 0 {
+		return e.SetContext(context.Background(), key, value)
+	}
+
+	if err := checkUserKey(key); err != nil {
+		return err
+	}
+
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+	return e.set(key, value, time.Now().UnixNano(), 0, 0)
+}
+
+// set applies a pair that is already in the WAL (or deliberately kept out of it)
+// to the storage and the memtable.
+// When would I ignore writing to the WAL? when I am setting KV pairs from the WAL
+// I don't want to rewrite the pairs coming from the WAL to the WAL again.
+// A non zero expiresAt is the time the key expires at, in unix nanoseconds, and a
+// non zero sequence the sequence number of the write.
+func (e *Engine) set(key string, value []byte, timestamp, expiresAt int64, sequence uint64) error {
 	// make sure key size is valid
 	if len([]byte(key)) > int(e.Config.KeySize) {
 		return &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
 	}
 
-	// first of all after validating the key size, write the pair to the WAL if not ingored.
-	if len(ignoreWAL) == 0 {
-		// when would I ignore writing to the WAL?
-		// when the I am setting KV pairs from the WAL I don't want to rewrite
-		// the pairs coming from the WAL to the WAL again.
-		if err := e.wal.Log(key, value); err != nil {
-			return err
-		}
+	// small values go to the tables along with their keys
+	if len(value) > 0 && len(value) <= e.Config.InlineValueSize {
+		e.indexManager.Set(key, memtable.IndexNode{Size: uint32(len(value)), Timestamp: timestamp, Inline: bytes.Clone(value), ExpiresAt: expiresAt, Sequence: sequence})
+		return nil
 	}
 
-	// periodic flush, after the memtable hits its threshold
-	if e.indexManager.Memtable.Size >= e.Config.MemtableSizeThreshold {
-		// TODO - add locks to avoid concurrency issues.
-		// NOTE - I temporary removed the `go` keyword
-		func() {
-			err := e.indexManager.Flush()
-			if err != nil {
-				log.Println("engine periodic flush error: ", err)
-			}
-
-			// if the flush was successful, clear the WAL
-			e.wal.Clear()
-		}()
-
-		err := e.indexManager.CompactionCheck()
-		if err != nil {
-			panic(err)
+	system := shared.IsSystemKey(key)
+	var sum [sha256.Size]byte
+	if e.dedup != nil && !system {
+		sum = sha256.Sum256(value)
+		if indexNode, ok := e.dedup.get(sum); ok {
+			e.dedup.hits.Add(1)
+			indexNode.Timestamp = timestamp
+			indexNode.ExpiresAt = expiresAt
+			indexNode.Sequence = sequence
+			e.indexManager.Set(key, indexNode)
+			return nil
 		}
 	}
 
-	offset, err := e.storageManager.WriteValue(value)
+	// the system keyspace stays readable whatever the compression, it records it
+	indexNode, err := e.storageManager.WriteValue(value, !system)
 	if err != nil {
 		return fmt.Errorf("db engine can not write (%q, %x): %v", key, value, err)
 	}
-	e.indexManager.Memtable.Set(key, memtable.IndexNode{
-		Offset: offset,
-		Size:   uint32(len(value)),
-	})
+	if e.dedup != nil && !system {
+		e.dedup.put(sum, indexNode)
+	}
+	indexNode.Timestamp = timestamp
+	indexNode.ExpiresAt = expiresAt
+	indexNode.Sequence = sequence
+	e.indexManager.Set(key, indexNode)
 	return nil
 }
 
+// flushIfFull freezes the memtable once it hits its threshold and wakes the
+// flusher to write it, so the write crossing the threshold does not wait for the
+// flush. It runs before new entries are logged and after the logged ones reached
+// the memtable, so the WAL segments dropped once the frozen memtable is flushed
+// never hold entries that did not make it into it.
+func (e *Engine) flushIfFull() {
+	// periodic flush, after the memtable hits its threshold
+	if e.indexManager.MemtableSize() < e.Config.MemtableSizeThreshold {
+		return
+	}
+	// the value file is only collected with nothing left in the memtables and
+	// the WAL, that flush is waited for
+	if e.valueGarbageDue() {
+		e.flush()
+		return
+	}
+
+	if err := e.freeze(); err != nil {
+		log.Println("engine periodic flush error: ", err)
+		return
+	}
+	e.flusher.trigger()
+	e.checkpoint()
+}
+
+// flush writes the memtable to a table and waits for it, clears the WAL and
+// wakes the compactor. The caller holds writeMu with every logged write applied.
+func (e *Engine) flush() {
+	if err := e.freeze(); err != nil {
+		log.Println("engine periodic flush error: ", err)
+		return
+	}
+	if err := e.flushFrozen(); err != nil {
+		log.Println("engine periodic flush error: ", err)
+		return
+	}
+
+	e.collectValueGarbageIfNeeded()
+	e.checkpoint()
+}
+
+// freeze swaps in a fresh memtable and WAL segment, the frozen memtable is left
+// for flushFrozen. A memtable frozen before is flushed first, this is where the
+// writes wait when the flusher falls behind. The caller holds writeMu with every
+// logged write applied.
+func (e *Engine) freeze() error {
+	e.flushMu.Lock()
+	defer e.flushMu.Unlock()
+
+	if err := e.flushFrozenLocked(); err != nil {
+		return err
+	}
+
+	// the writes from now on go to a new segment, which keeps the undecided
+	// prepared batches, the older ones go once the frozen memtable is flushed
+	segment, err := e.rotateWAL()
+	if err != nil {
+		return fmt.Errorf("db engine can not rotate the WAL: %v", err)
+	}
+	e.indexManager.Freeze()
+	e.frozenSegment = segment
+	syncpoint.Reach(syncpoint.MemtableFrozen)
+	return nil
+}
+
+// flushFrozen writes the frozen memtable, if any, to a table and drops the WAL
+// segments it covers. It does not need writeMu, the flusher runs it in the
+// background while writes go on.
+func (e *Engine) flushFrozen() error {
+	e.flushMu.Lock()
+	defer e.flushMu.Unlock()
+	return e.flushFrozenLocked()
+}
+
+func (e *Engine) flushFrozenLocked() error {
+	if e.frozenSegment == 0 {
+		return nil
+	}
+
+	// the table about to be written points into the value file
+	if err := e.syncValues(); err != nil {
+		return err
+	}
+	if err := e.indexManager.FlushFrozen(); err != nil {
+		return err
+	}
+	e.Config.ReachCrashPoint(shared.CrashAfterFlush)
+	syncpoint.Reach(syncpoint.FlushInstalled)
+
+	// the flushed table is durable by now, drop the WAL segments it covers
+	if err := e.wal.RemoveSegmentsBefore(e.frozenSegment); err != nil {
+		return fmt.Errorf("db engine can not clear the WAL: %v", err)
+	}
+	e.frozenSegment = 0
+
+	e.compactor.trigger()
+	invalidateReplicas(e.Config.Homepath)
+	return nil
+}
+
+// checkpoint saves the stats and the sequence number, the caller holds writeMu.
+func (e *Engine) checkpoint() {
+	if err := e.checkpointStats(); err != nil {
+		log.Println("engine can not checkpoint the stats: ", err)
+	}
+	if err := e.checkpointSequence(); err != nil {
+		log.Println("engine can not checkpoint the sequence number: ", err)
+	}
+}
+
+// syncValues syncs the value file unless the ValueSync policy opts out.
+func (e *Engine) syncValues() error {
+	if e.Config.ValueSync == shared.ValueSyncNever {
+		return nil
+	}
+	return e.storageManager.Sync()
+}
+
 func (e *Engine) Delete(key string, ignoreWAL ...bool) error {
+	if len(ignoreWAL) == 0 {
+		return e.DeleteContext(context.Background(), key)
+	}
+
+	if err := checkUserKey(key); err != nil {
+		return err
+	}
+
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+	return e.delete(key, time.Now().UnixNano(), 0)
+}
+
+// delete applies a deletion that is already in the WAL (or deliberately kept out of it)
+// to the memtable, numbered with sequence unless it is zero.
+func (e *Engine) delete(key string, timestamp int64, sequence uint64) error {
 	// make sure key size is valid
 	if len([]byte(key)) > int(e.Config.KeySize) {
 		return &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
 	}
 
-	// first of all after validating the key size
-	// write the pair (with empty value) to the WAL if not ingored.
-	if len(ignoreWAL) == 0 {
-		// when would I ignore writing to the WAL?
-		// when the I am setting KV pairs from the WAL I don't want to rewrite
-		// the pairs coming from the WAL to the WAL again.
-		if err := e.wal.Log(key, []byte{}); err != nil {
+	if e.Config.UndeleteWindow > 0 {
+		indexNode, err := e.indexManager.Get(key)
+		if err == nil {
+			e.indexManager.SoftDelete(key, indexNode, timestamp, sequence)
+			return nil
+		}
+		if _, ok := err.(*shared.ErrKeyNotFound); !ok {
 			return err
 		}
 	}
 
-	e.indexManager.Delete(key)
+	e.indexManager.Delete(key, timestamp, sequence)
+	return nil
+}
+
+// Undelete restores a key deleted less than UndeleteWindow ago, writing its
+// last value back. Returns ErrKeyNotFound if there is nothing to restore.
+func (e *Engine) Undelete(key string) error {
+	if len([]byte(key)) > int(e.Config.KeySize) {
+		return &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
+	}
+	if err := checkUserKey(key); err != nil {
+		return err
+	}
+
+	e.values.mu.RLock()
+	indexNode, found, err := e.indexManager.Lookup(key)
+	if err != nil {
+		e.values.mu.RUnlock()
+		return fmt.Errorf("db engine can not locate key (%q): %v", key, err)
+	}
+	if found && !indexNode.IsDeleted() {
+		e.values.mu.RUnlock()
+		return fmt.Errorf("db engine can not undelete key (%q): key is not deleted", key)
+	}
+	if !found || !indexNode.Deleted || time.Since(time.Unix(0, indexNode.Timestamp)) > e.Config.UndeleteWindow {
+		e.values.mu.RUnlock()
+		return &shared.ErrKeyNotFound{Key: key}
+	}
+
+	value, err := e.storageManager.ReadValue(indexNode)
+	e.values.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("db engine can not read key (%q): %v", key, err)
+	}
+	return e.Set(key, value)
+}
+
+// Warmup preloads the indexes of the tables holding keys under the given prefixes
+// into memory, so the first requests after opening the database do not pay for
+// cold disk reads. When loadValues is set the values are read once as well,
+// leaving them in the OS page cache.
+func (e *Engine) Warmup(prefixes []string, loadValues ...bool) error {
+	e.values.mu.RLock()
+	defer e.values.mu.RUnlock()
+
+	pairs, err := e.indexManager.Warmup(prefixes)
+	if err != nil {
+		return err
+	}
+
+	if len(loadValues) == 0 || !loadValues[0] {
+		return nil
+	}
+
+	for _, pair := range pairs {
+		if _, err := e.storageManager.ReadValue(pair.Value); err != nil {
+			return fmt.Errorf("db engine can not warm up key (%q): %v", pair.Key, err)
+		}
+	}
+
 	return nil
 }
 
+// EffectiveConfig returns the configuration the engine runs with, after the
+// defaults were filled in for the fields left unset and the options changed
+// with SetOption were applied.
+func (e *Engine) EffectiveConfig() shared.EngineConfig {
+	config := e.Config
+	config.CompactionWorkers = e.indexManager.CompactionWorkers()
+	config.CompactionRateLimit = e.indexManager.CompactionRateLimit()
+	config.LowPriorityReadRate = e.reads.rate.Load()
+	return config
+}
+
+// TombstoneRuns returns the number of times a scan skipped TombstoneRunThreshold
+// or more deleted keys in a row since the database was opened.
+func (e *Engine) TombstoneRuns() int64 {
+	return e.indexManager.TombstoneRuns()
+}
+
+// LastSequence returns the sequence number of the latest write visible to readers.
+// Sequence numbers keep growing across opens of the database.
+func (e *Engine) LastSequence() uint64 {
+	return e.sequence.Load()
+}
+
 func (e *Engine) Close() {
+	e.asyncQueue.close()
+	if e.coalescer != nil {
+		e.coalescer.close()
+	}
+	e.pipeline.close()
+	if e.walSyncer != nil {
+		e.walSyncer.close()
+	}
+	if e.archiver != nil {
+		e.archiver.close()
+	}
+	if e.metrics != nil {
+		e.metrics.close()
+	}
+	e.flusher.close()
+	if err := e.flushFrozen(); err != nil {
+		log.Println("engine periodic flush error: ", err)
+	}
+	e.compactor.close()
+
+	e.writeMu.Lock()
+	if err := e.checkpointStats(); err != nil {
+		log.Println("engine can not checkpoint the stats: ", err)
+	}
+	if err := e.checkpointSequence(); err != nil {
+		log.Println("engine can not checkpoint the sequence number: ", err)
+	}
+	e.writeMu.Unlock()
+
+	if e.Config.SyncMode != shared.SyncNever {
+		if err := e.wal.Sync(); err != nil {
+			log.Println("engine can not sync the WAL: ", err)
+		}
+	}
+
 	e.indexManager.Close()
 	e.storageManager.Close()
 }
+
+// Compact merges every table into a level dropping the deleted keys, the expired
+// ones and the overwritten values, rather than waiting for the compaction check
+// of the write path. The memtable is flushed first. Writes wait for it to
+// finish, it suits quiet hours.
+func (e *Engine) Compact() error {
+	return e.CompactRange("", "")
+}
+
+// CompactRange is Compact for the keys between start and end, both included, an
+// empty end leaving the range open. The tables overlapping the ones holding
+// those keys are merged as well, so the merge may cover more keys.
+func (e *Engine) CompactRange(start, end string) error {
+	if end != "" && end < start {
+		return fmt.Errorf("db engine can not compact the keys between %q and %q: the range is empty", start, end)
+	}
+	return e.pipeline.exclusive(func() error {
+		e.flush()
+		if err := e.indexManager.CompactRange(start, end); err != nil {
+			return fmt.Errorf("db engine can not compact: %v", err)
+		}
+		if err := e.checkpointStats(); err != nil {
+			log.Println("engine can not checkpoint the stats: ", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,202 @@
+package goldb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// TestConcurrentUse runs writers setting and deleting their own keys, readers
+// getting, scanning and iterating over all of them, and compactions and value
+// collections, while the small memtable keeps flushing. Run it with -race. Every
+// writer reads its own writes back as it goes, the readers check every value
+// they see belongs to its key, and the keys are checked against the writers once
+// they are done.
+func TestConcurrentUse(t *testing.T) {
+	e := openTestEngine(t, t.TempDir(), func(c *EngineConfig) {
+		c.MemtableSizeThreshold = 32
+	})
+	defer e.Close()
+
+	const writers, keys, writes = 4, 40, 300
+	key := func(w, k int) string { return fmt.Sprintf("w%d-%03d", w, k) }
+	value := func(key string, i int) string { return fmt.Sprintf("%s=%d", key, i) }
+
+	models := make([]map[string]string, writers)
+	errs := make(chan error, writers+3)
+	stop := make(chan struct{})
+	var wg, others sync.WaitGroup
+
+	for w := 0; w < writers; w++ {
+		models[w] = map[string]string{}
+		wg.Add(1)
+		go func(w int, model map[string]string) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				k := key(w, (i*7)%keys)
+				if i%5 == 4 {
+					if err := e.Delete(k); err != nil {
+						errs <- fmt.Errorf("delete %q: %v", k, err)
+						return
+					}
+					delete(model, k)
+				} else {
+					if err := e.Set(k, []byte(value(k, i))); err != nil {
+						errs <- fmt.Errorf("set %q: %v", k, err)
+						return
+					}
+					model[k] = value(k, i)
+				}
+
+				got, err := e.Get(k)
+				want, ok := model[k]
+				if _, missing := err.(*shared.ErrKeyNotFound); ok == missing || (ok && (err != nil || string(got) != want)) {
+					errs <- fmt.Errorf("writer %d reads %q as %q, %v, want %q", w, k, got, err, want)
+					return
+				}
+			}
+		}(w, models[w])
+	}
+
+	// the readers check what they see is consistent, the keys move under them
+	checkValue := func(key string, value []byte) error {
+		if !strings.HasPrefix(string(value), key+"=") {
+			return fmt.Errorf("%q holds %q, written for another key", key, value)
+		}
+		return nil
+	}
+	walk := func() error {
+		it, err := e.NewIterator()
+		if err != nil {
+			return fmt.Errorf("iterator: %v", err)
+		}
+		defer it.Close()
+		last := ""
+		for it.Next() {
+			if it.Key() <= last {
+				return fmt.Errorf("the iterator walks %q after %q", it.Key(), last)
+			}
+			last = it.Key()
+			got, err := it.Value()
+			if err == errValuesMoved {
+				continue
+			}
+			if err == nil {
+				err = checkValue(it.Key(), got)
+			}
+			if err != nil {
+				return fmt.Errorf("iterator at %q: %v", it.Key(), err)
+			}
+		}
+		return it.Err()
+	}
+	others.Add(3)
+	go func() {
+		defer others.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			k := key(i%writers, i%keys)
+			got, err := e.Get(k)
+			if _, missing := err.(*shared.ErrKeyNotFound); err != nil && !missing {
+				errs <- fmt.Errorf("get %q: %v", k, err)
+				return
+			}
+			if err == nil {
+				if err := checkValue(k, got); err != nil {
+					errs <- err
+					return
+				}
+			}
+
+			listed, err := e.Scan(fmt.Sprintf("w%d-", i%writers))
+			if err != nil {
+				errs <- fmt.Errorf("scan: %v", err)
+				return
+			}
+			if !sort.StringsAreSorted(listed) {
+				errs <- fmt.Errorf("scan lists %v out of order", listed)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer others.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := walk(); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		defer others.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			if err := e.Compact(); err != nil {
+				errs <- fmt.Errorf("compact: %v", err)
+				return
+			}
+			if _, err := e.CollectValueGarbage(); err != nil {
+				errs <- fmt.Errorf("collect value garbage: %v", err)
+				return
+			}
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stop)
+		others.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Minute):
+		t.Fatal("the goroutines did not finish within two minutes")
+	}
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	want := []string{}
+	for w, model := range models {
+		for k := 0; k < keys; k++ {
+			got, err := e.Get(key(w, k))
+			if value, ok := model[key(w, k)]; ok {
+				if err != nil || string(got) != value {
+					t.Fatalf("%q reads %q, %v, want %q", key(w, k), got, err, value)
+				}
+				want = append(want, key(w, k))
+			} else if _, missing := err.(*shared.ErrKeyNotFound); !missing {
+				t.Fatalf("deleted %q reads %q, %v", key(w, k), got, err)
+			}
+		}
+	}
+	listed, err := e.Scan("w")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(listed) != fmt.Sprint(want) {
+		t.Fatalf("scan lists %d keys, want %d", len(listed), len(want))
+	}
+}
@@ -0,0 +1,236 @@
+package goldb
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hasssanezzz/goldb/internal/parquet"
+)
+
+// ExportFormat selects the encoding used by Export.
+type ExportFormat string
+
+const (
+	ExportCSV     ExportFormat = "csv"
+	ExportJSONL   ExportFormat = "jsonl"
+	ExportParquet ExportFormat = "parquet"
+)
+
+// parquetRowGroupSize is the size of the values of a Parquet row group, the
+// rows of a group are held in memory until it is written.
+const parquetRowGroupSize = 64 << 20
+
+// ErrUnsupportedFormat is returned for formats the engine can not write.
+type ErrUnsupportedFormat struct{ Format ExportFormat }
+
+func (e *ErrUnsupportedFormat) Error() string {
+	return fmt.Sprintf("export format %q is not supported", e.Format)
+}
+
+// Export writes every live pair to w, one row per key in ascending order with
+// the columns key, value_base64, size and timestamp. CSV values are always
+// base64-encoded, the name of the column says so, as CSV can not carry
+// arbitrary bytes. JSON Lines rows are objects with the fields key, value, size
+// and timestamp, a value that is not valid UTF-8 going base64-encoded in
+// value_base64 instead of value. Parquet files hold the columns key, a UTF-8
+// string, value, a binary one, size and timestamp in microseconds, for DuckDB or
+// Spark to query.
+// The export reads the database as it was when it started, writes arriving
+// meanwhile are left out. A collection of the value garbage running meanwhile
+// moves the values it reads and fails it. Exports read with PriorityLow.
+func (e *Engine) Export(w io.Writer, format ExportFormat) error {
+	// exports are background work, interactive reads go first
+	it, err := e.NewIterator(LowPriority())
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	return export(w, it, format)
+}
+
+// Export writes every pair of the snapshot to w like Engine.Export does.
+func (s *SnapshotReader) Export(w io.Writer, format ExportFormat) error {
+	it, err := s.NewIterator()
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	return export(w, it, format)
+}
+
+func export(w io.Writer, it *Iterator, format ExportFormat) error {
+	switch format {
+	case ExportCSV:
+		return exportCSV(w, it)
+	case ExportJSONL:
+		return exportJSONL(w, it)
+	case ExportParquet:
+		return exportParquet(w, it)
+	default:
+		return &ErrUnsupportedFormat{Format: format}
+	}
+}
+
+// ExportSince writes to w the keys written after sequence number seq, as
+// returned by LastSequence, so a downstream system can pull the changes since
+// its last pull instead of full dumps. Rows carry the columns key,
+// value_base64, size, timestamp and op, the value base64-encoded like Export
+// does, op being "set" for a key holding a value and "delete" for a deleted
+// key, whose value is left empty. Only the latest state of every key
+// is written, not each write in between.
+//
+// The export holds every write after seq and may repeat a few writes around
+// it, applying it twice leaves the same state. Sequence numbers handed out
+// before the database was opened are not known, nor are deletes already
+// dropped by a compaction: a seq from before the open gets every live pair,
+// like a full export. Exports read with PriorityLow.
+func (e *Engine) ExportSince(seq uint64, w io.Writer) error {
+	it, err := e.NewIterator(LowPriority(), withDeleted())
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	// the clock is read once the iterator is taken, it covers every write it holds
+	since, known := e.clock.since(seq)
+	if !known {
+		since = math.MinInt64
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"key", "value_base64", "size", "timestamp", "op"}); err != nil {
+		return err
+	}
+
+	for it.Next() {
+		if it.pair.Value.Timestamp < since || (!known && it.deleted()) {
+			continue
+		}
+		row := []string{it.Key(), "", "0", it.Timestamp().UTC().Format(time.RFC3339Nano), "delete"}
+		if !it.deleted() {
+			value, err := it.Value()
+			if err != nil {
+				return fmt.Errorf("db engine can not export key (%q): %v", it.Key(), err)
+			}
+			row[1], row[2], row[4] = base64.StdEncoding.EncodeToString(value), strconv.Itoa(len(value)), "set"
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("db engine can not export: %v", err)
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func exportCSV(w io.Writer, it *Iterator) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"key", "value_base64", "size", "timestamp"}); err != nil {
+		return err
+	}
+
+	for it.Next() {
+		value, err := it.Value()
+		if err != nil {
+			return fmt.Errorf("db engine can not export key (%q): %v", it.Key(), err)
+		}
+		row := []string{
+			it.Key(),
+			base64.StdEncoding.EncodeToString(value),
+			strconv.Itoa(len(value)),
+			it.Timestamp().UTC().Format(time.RFC3339Nano),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("db engine can not export: %v", err)
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// jsonlRow is a row of a JSON Lines export.
+type jsonlRow struct {
+	Key         string `json:"key"`
+	Value       string `json:"value,omitempty"`
+	ValueBase64 string `json:"value_base64,omitempty"`
+	Size        int    `json:"size"`
+	Timestamp   string `json:"timestamp"`
+}
+
+func exportJSONL(w io.Writer, it *Iterator) error {
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+	encoder.SetEscapeHTML(false)
+
+	for it.Next() {
+		value, err := it.Value()
+		if err != nil {
+			return fmt.Errorf("db engine can not export key (%q): %v", it.Key(), err)
+		}
+		row := jsonlRow{
+			Key:       it.Key(),
+			Size:      len(value),
+			Timestamp: it.Timestamp().UTC().Format(time.RFC3339Nano),
+		}
+		// JSON strings can not carry arbitrary bytes
+		if utf8.Valid(value) {
+			row.Value = string(value)
+		} else {
+			row.ValueBase64 = base64.StdEncoding.EncodeToString(value)
+		}
+		if err := encoder.Encode(row); err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("db engine can not export: %v", err)
+	}
+
+	return buffered.Flush()
+}
+
+func exportParquet(w io.Writer, it *Iterator) error {
+	buffered := bufio.NewWriter(w)
+	writer, err := parquet.NewWriter(buffered, []parquet.Column{
+		{Name: "key", Type: parquet.ByteArray, Annotation: parquet.UTF8},
+		{Name: "value", Type: parquet.ByteArray, Annotation: parquet.None},
+		{Name: "size", Type: parquet.Int64, Annotation: parquet.None},
+		{Name: "timestamp", Type: parquet.Int64, Annotation: parquet.TimestampMicros},
+	}, parquetRowGroupSize)
+	if err != nil {
+		return err
+	}
+
+	for it.Next() {
+		value, err := it.Value()
+		if err != nil {
+			return fmt.Errorf("db engine can not export key (%q): %v", it.Key(), err)
+		}
+		if err := writer.Write(it.Key(), value, int64(len(value)), it.Timestamp().UnixMicro()); err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("db engine can not export: %v", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
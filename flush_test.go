@@ -0,0 +1,171 @@
+package goldb
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+	"github.com/hasssanezzz/goldb/internal/wal"
+)
+
+// copyDir copies the files under src to dst, standing for what a crash leaves
+// on disk at that point.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if entry.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// TestWALClearedAfterFlushIsInstalled crashes every flush once the memtable is
+// in a new table and before the WAL segments it covers are removed. At that
+// point the segments must still be there, and the database must come back
+// whole from the files on disk both with them and without them, which holds
+// only when the table was in place before the WAL is cleared.
+func TestWALClearedAfterFlushIsInstalled(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "wal.log.bin")
+	model := map[string]string{}
+
+	// the hook runs on the goroutine of the pipeline, it reports what it found
+	var (
+		mu       sync.Mutex
+		crashes  int
+		failures []error
+	)
+	check := func(crashed string) error {
+		reopened, err := New(crashed, *NewEngineConfig())
+		if err != nil {
+			return fmt.Errorf("can not reopen the database: %v", err)
+		}
+		defer reopened.Close()
+		for key, want := range model {
+			value, err := reopened.Get(key)
+			if err != nil || string(value) != want {
+				return fmt.Errorf("%q reads %q, %v, want %q", key, value, err, want)
+			}
+		}
+		keys, err := reopened.Scan("key-")
+		if err != nil {
+			return err
+		}
+		if len(keys) != len(model) {
+			return fmt.Errorf("%d keys are back, want %d", len(keys), len(model))
+		}
+		return nil
+	}
+	var e *Engine
+	crash := func() error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		covered := []string{}
+		for _, entry := range entries {
+			if n, ok := wal.SegmentNumber(source, entry.Name()); ok && n < e.frozenSegment {
+				covered = append(covered, entry.Name())
+			}
+		}
+		if len(covered) == 0 {
+			return fmt.Errorf("the WAL segments of the memtable were cleared before the table was installed")
+		}
+
+		// a crash right now replays the WAL over the table
+		crashed := filepath.Join(t.TempDir(), "with-wal")
+		if err := copyDir(dir, crashed); err != nil {
+			return err
+		}
+		if err := check(crashed); err != nil {
+			return fmt.Errorf("crashing with the WAL: %v", err)
+		}
+
+		// the table alone holds the writes of the segments about to go
+		cleared := filepath.Join(t.TempDir(), "cleared")
+		if err := copyDir(dir, cleared); err != nil {
+			return err
+		}
+		for _, name := range covered {
+			if err := os.Remove(filepath.Join(cleared, name)); err != nil {
+				return err
+			}
+		}
+		if err := check(cleared); err != nil {
+			return fmt.Errorf("crashing with the WAL cleared: %v", err)
+		}
+		return nil
+	}
+
+	e = openTestEngine(t, dir, func(c *EngineConfig) {
+		c.CrashHook = func(point shared.CrashPoint) {
+			if point != shared.CrashAfterFlush {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			crashes++
+			if err := crash(); err != nil {
+				failures = append(failures, fmt.Errorf("flush %d: %v", crashes, err))
+			}
+		}
+	})
+	defer e.Close()
+
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 30; i++ {
+			key := fmt.Sprintf("key-%02d", (i*7+round)%40)
+			var err error
+			if i%6 == 5 {
+				err = e.Delete(key)
+				delete(model, key)
+			} else {
+				value := fmt.Sprintf("round-%d-%d", round, i)
+				err = e.Set(key, []byte(value))
+				model[key] = value
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		// no write is in flight while the flush of the compaction crashes
+		if err := e.Compact(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, err := range failures {
+		t.Error(err)
+	}
+	if crashes != 3 {
+		t.Fatalf("%d flushes reached the crash point, want 3", crashes)
+	}
+}
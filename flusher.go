@@ -0,0 +1,52 @@
+package goldb
+
+import (
+	"log"
+	"sync"
+)
+
+// flusher writes the memtable frozen by flushIfFull in the background, so the
+// write crossing the memtable threshold does not wait for the flush.
+type flusher struct {
+	engine *Engine
+	wake   chan struct{}
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newFlusher(e *Engine) *flusher {
+	f := &flusher{engine: e, wake: make(chan struct{}, 1), stop: make(chan struct{})}
+	f.wg.Add(1)
+	go labeled("flusher", f.run)
+	return f
+}
+
+// trigger asks for the frozen memtable to be flushed without waiting for it.
+func (f *flusher) trigger() {
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (f *flusher) run() {
+	defer f.wg.Done()
+
+	for {
+		select {
+		case <-f.wake:
+			// a failed flush keeps the memtable frozen, the next freeze retries
+			if err := f.engine.flushFrozen(); err != nil {
+				log.Println("engine periodic flush error: ", err)
+			}
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// close stops the flusher once the running flush, if any, is done.
+func (f *flusher) close() {
+	close(f.stop)
+	f.wg.Wait()
+}
@@ -0,0 +1,159 @@
+package goldb
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// FS exposes the values of the database as a read-only fs.FS where keys are paths.
+// Keys are split on "/" to form directories, so "docs/readme.md" is the file
+// "readme.md" inside the directory "docs". Keys that are not valid fs paths
+// (for example ones starting with "/") can not be reached through the FS.
+func (e *Engine) FS() fs.FS {
+	return &engineFS{engine: e}
+}
+
+type engineFS struct {
+	engine *Engine
+}
+
+func (efs *engineFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) || shared.IsSystemKey(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if name != "." {
+		values := efs.engine.values
+		values.mu.RLock()
+		indexNode, err := efs.engine.indexManager.Get(name)
+		if err == nil {
+			data, err := efs.engine.storageManager.ReadValue(indexNode)
+			values.mu.RUnlock()
+			if err != nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+			}
+			info := &fileInfo{
+				name:    pathBase(name),
+				size:    int64(len(data)),
+				modTime: time.Unix(0, indexNode.Timestamp),
+			}
+			return &file{Reader: bytes.NewReader(data), info: info}, nil
+		}
+		values.mu.RUnlock()
+		if _, ok := err.(*shared.ErrKeyNotFound); !ok {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+
+	// not a key, maybe a directory
+	prefix := ""
+	if name != "." {
+		prefix = name + "/"
+	}
+
+	keys, err := efs.engine.Scan(prefix)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if len(keys) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	// collect the direct children of the directory
+	children := map[string]bool{} // child name -> is directory
+	for _, key := range keys {
+		rest := strings.TrimPrefix(key, prefix)
+		child, _, isDir := strings.Cut(rest, "/")
+		if child == "" {
+			continue
+		}
+		children[child] = children[child] || isDir
+	}
+
+	entries := []fs.DirEntry{}
+	for child, isDir := range children {
+		info := &fileInfo{name: child, isDir: isDir}
+		if !isDir {
+			if indexNode, err := efs.engine.indexManager.Get(prefix + child); err == nil {
+				info.size = int64(indexNode.Size)
+				for _, extent := range indexNode.Extents {
+					info.size += int64(extent.Size)
+				}
+				info.modTime = time.Unix(0, indexNode.Timestamp)
+			}
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return &dir{info: &fileInfo{name: pathBase(name), isDir: true}, entries: entries}, nil
+}
+
+func pathBase(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// file is a value opened through the FS.
+type file struct {
+	*bytes.Reader
+	info *fileInfo
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *file) Close() error               { return nil }
+
+// dir is a directory listing built from the keys sharing a prefix.
+type dir struct {
+	info    *fileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rest))
+	d.offset += n
+	return rest[:n], nil
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.isDir }
+func (fi *fileInfo) Sys() any           { return nil }
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	if fi.isDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
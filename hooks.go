@@ -0,0 +1,43 @@
+package goldb
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// transformValue runs the read hook of key, if any, on value.
+func transformValue(config *shared.EngineConfig, key string, value []byte) ([]byte, bool, error) {
+	hook, ok := config.ReadHookFor(key)
+	if !ok {
+		return value, false, nil
+	}
+
+	transformed, err := hook.Transform(key, value)
+	if err != nil {
+		return nil, false, fmt.Errorf("read hook of prefix %q failed on key (%q): %v", hook.Prefix, key, err)
+	}
+	return transformed, hook.Persist && !bytes.Equal(transformed, value), nil
+}
+
+// applyReadHook transforms a value read from indexNode, writing it back when the
+// hook persists its changes. The value is only written back if the key still
+// points at indexNode, so a newer write is not replaced by a migrated old value.
+func (e *Engine) applyReadHook(key string, indexNode memtable.IndexNode, value []byte) ([]byte, error) {
+	transformed, persist, err := transformValue(&e.Config, key, value)
+	if err != nil || !persist {
+		return transformed, err
+	}
+
+	current, found, err := e.indexManager.Lookup(key)
+	if err != nil || !found || !current.Equal(indexNode) {
+		return transformed, nil
+	}
+	if err := e.Set(key, transformed); err != nil {
+		log.Printf("db engine: failed to persist the transformed value of %q: %v\n", key, err)
+	}
+	return transformed, nil
+}
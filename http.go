@@ -0,0 +1,54 @@
+package goldb
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// ServeValue replies to the request with the value stored under key.
+// It sets an ETag derived from the value checksum and a Last-Modified header from
+// the write time, and answers conditional and Range requests by reading only the
+// requested parts of the value from disk.
+// If the key does not exist ErrKeyNotFound is returned and nothing is written,
+// leaving the caller free to pick the response.
+// A garbage collection of the value file waits for the responses being served.
+func (e *Engine) ServeValue(w http.ResponseWriter, r *http.Request, key string) error {
+	if len([]byte(key)) > int(e.Config.KeySize) {
+		return &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
+	}
+	if err := checkUserKey(key); err != nil {
+		return err
+	}
+
+	// the value must stay in place while it is streamed
+	e.values.mu.RLock()
+	defer e.values.mu.RUnlock()
+
+	indexNode, err := e.indexManager.Get(key)
+	if err != nil {
+		return err
+	}
+
+	reader, err := e.storageManager.ValueReader(indexNode)
+	if err != nil {
+		return fmt.Errorf("db engine can not read key (%q): %v", key, err)
+	}
+
+	// the checksum is computed by streaming the value, never holding it whole
+	checksum := crc32.NewIEEE()
+	if _, err := io.Copy(checksum, reader); err != nil {
+		return fmt.Errorf("db engine can not checksum key (%q): %v", key, err)
+	}
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	w.Header().Set("ETag", fmt.Sprintf(`"%08x"`, checksum.Sum32()))
+	http.ServeContent(w, r, key, time.Unix(0, indexNode.Timestamp), reader)
+	return nil
+}
@@ -0,0 +1,214 @@
+package goldb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// importBatchSize bounds the writes of a batch of Import, the batch limits of
+// the configuration may bound them further.
+const importBatchSize = 1000
+
+// ImportOptions tunes Import.
+type ImportOptions struct {
+	Bulk      bool // Write the pairs as tables with Ingest instead of going through the WAL, see Import.
+	ChunkSize int  // Pairs written per table in bulk mode, 100000 when zero.
+}
+
+// ImportReport describes what Import loaded.
+type ImportReport struct {
+	Keys   int // Pairs read and written, a key repeated in the input counting every time.
+	Tables int // Tables written in bulk mode.
+}
+
+// Import loads the pairs written by Export in format from r, the CSV and JSON
+// Lines ones, into the database: the key and value columns or fields are read,
+// the others are ignored. The pairs are written in batches of up to a thousand
+// writes through the WAL like Set, a key repeated in the input taking its last
+// value.
+//
+// In bulk mode the WAL and the memtable are bypassed: the pairs are gathered in
+// chunks of options.ChunkSize, every chunk written sorted as a table by Ingest,
+// which loads tens of millions of pairs far faster. Writes wait while a table
+// is put in place. A failed import leaves the pairs written before the failure
+// in the database.
+func (e *Engine) Import(r io.Reader, format ExportFormat, options ImportOptions) (ImportReport, error) {
+	var next func() (string, []byte, error)
+	switch format {
+	case ExportCSV:
+		rows, err := newCSVRows(r)
+		if err != nil {
+			return ImportReport{}, fmt.Errorf("db engine can not import: %v", err)
+		}
+		next = rows.next
+	case ExportJSONL:
+		next = newJSONLRows(r).next
+	default:
+		return ImportReport{}, &ErrUnsupportedFormat{Format: format}
+	}
+	if options.ChunkSize <= 0 {
+		options.ChunkSize = 100000
+	}
+
+	report := ImportReport{}
+	batch := e.NewBatch()
+	chunk := map[string][]byte{}
+	write := func() error {
+		if options.Bulk {
+			if err := e.Ingest(chunk); err != nil {
+				return err
+			}
+			report.Tables++
+			chunk = map[string][]byte{}
+			return nil
+		}
+		if err := batch.Commit(); err != nil {
+			return err
+		}
+		batch = e.NewBatch()
+		return nil
+	}
+	pending := func() int {
+		if options.Bulk {
+			return len(chunk)
+		}
+		return batch.Len()
+	}
+
+	for {
+		key, value, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("db engine can not import: %v", err)
+		}
+		// an empty value would read as a delete
+		if len(value) == 0 {
+			return report, fmt.Errorf("db engine can not import key (%q): the value is empty", key)
+		}
+
+		if options.Bulk {
+			if len(chunk) >= options.ChunkSize {
+				if err := write(); err != nil {
+					return report, err
+				}
+			}
+			chunk[key] = value
+		} else {
+			if batch.Len() >= importBatchSize || !batch.fits(key, value) {
+				if err := write(); err != nil {
+					return report, err
+				}
+			}
+			batch.Set(key, value)
+		}
+		report.Keys++
+	}
+	if pending() > 0 {
+		if err := write(); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// csvRows reads the pairs of a CSV export, whose first row names the columns.
+// The values of a value_base64 column are decoded, the ones of a value column
+// are taken as they are.
+type csvRows struct {
+	reader  *csv.Reader
+	key     int
+	value   int
+	encoded bool // The value column is value_base64.
+}
+
+func newCSVRows(r io.Reader) (*csvRows, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("can not read the header: %v", err)
+	}
+
+	rows := &csvRows{reader: reader, key: -1, value: -1}
+	for i, name := range header {
+		switch name {
+		case "key":
+			rows.key = i
+		case "value":
+			rows.value = i
+		case "value_base64":
+			rows.value, rows.encoded = i, true
+		}
+	}
+	if rows.key < 0 || rows.value < 0 {
+		return nil, fmt.Errorf("the header has no key or value column")
+	}
+	return rows, nil
+}
+
+func (r *csvRows) next() (string, []byte, error) {
+	row, err := r.reader.Read()
+	if err != nil {
+		return "", nil, err
+	}
+	if r.key >= len(row) || r.value >= len(row) {
+		line, _ := r.reader.FieldPos(0)
+		return "", nil, fmt.Errorf("line %d: the row has %d columns", line, len(row))
+	}
+	if !r.encoded {
+		return row[r.key], []byte(row[r.value]), nil
+	}
+	value, err := base64.StdEncoding.DecodeString(row[r.value])
+	if err != nil {
+		line, _ := r.reader.FieldPos(r.value)
+		return "", nil, fmt.Errorf("line %d: value_base64: %v", line, err)
+	}
+	return row[r.key], value, nil
+}
+
+// jsonlRows reads the pairs of a JSON Lines export, one object per line.
+type jsonlRows struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newJSONLRows(r io.Reader) *jsonlRows {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	return &jsonlRows{scanner: scanner}
+}
+
+func (r *jsonlRows) next() (string, []byte, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		row := jsonlRow{}
+		if err := json.Unmarshal(line, &row); err != nil {
+			return "", nil, fmt.Errorf("line %d: %v", r.line, err)
+		}
+		if row.ValueBase64 == "" {
+			return row.Key, []byte(row.Value), nil
+		}
+		value, err := base64.StdEncoding.DecodeString(row.ValueBase64)
+		if err != nil {
+			return "", nil, fmt.Errorf("line %d: value_base64: %v", r.line, err)
+		}
+		return row.Key, value, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return "", nil, err
+	}
+	return "", nil, io.EOF
+}
@@ -0,0 +1,100 @@
+package goldb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// Ingest writes pairs straight to a new SSTable instead of going through the
+// WAL and the memtable one write at a time, which suits seeding a database with
+// large amounts of data. Values are appended to the value file, or kept in the
+// table when they are small enough to be inline, and the keys are written as a
+// single sorted table shadowing every write before it: keys already in the
+// database take the ingested values. Empty values are refused, they would read
+// as deletes.
+//
+// The memtable is flushed first and writes wait until the table is in place.
+// Large loads are best ingested in chunks of a few hundred thousand pairs, each
+// chunk becomes a table that compactions merge with the others.
+func (e *Engine) Ingest(pairs map[string][]byte) error {
+	return e.IngestWithTTL(pairs, nil)
+}
+
+// IngestWithTTL ingests pairs like Ingest, the keys in ttls expiring once their
+// TTL has passed like SetWithTTL makes them. Keys of ttls missing from pairs are
+// refused.
+func (e *Engine) IngestWithTTL(pairs map[string][]byte, ttls map[string]time.Duration) error {
+	for key, ttl := range ttls {
+		if _, ok := pairs[key]; !ok {
+			return fmt.Errorf("db engine can not ingest key (%q) with a TTL: it has no value", key)
+		}
+		if ttl <= 0 {
+			return fmt.Errorf("db engine can not ingest key (%q) with a TTL of %v: it must be positive", key, ttl)
+		}
+	}
+	for key, value := range pairs {
+		if err := checkUserKey(key); err != nil {
+			return err
+		}
+		if len([]byte(key)) > int(e.Config.KeySize) {
+			return &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
+		}
+		if len(value) == 0 {
+			return fmt.Errorf("db engine can not ingest key (%q): the value is empty", key)
+		}
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	return e.pipeline.exclusive(func() error {
+		now := time.Now().UnixNano()
+		// the pairs are a single write, numbered after every one before it
+		seq := e.pipeline.allocate(1)
+		ingested := make([]memtable.KVPair, 0, len(pairs))
+		for key, value := range pairs {
+			indexNode := memtable.IndexNode{Size: uint32(len(value)), Inline: bytes.Clone(value)}
+			if len(value) > e.Config.InlineValueSize {
+				var err error
+				if indexNode, err = e.storageManager.WriteValue(value, true); err != nil {
+					return fmt.Errorf("db engine can not ingest key (%q): %v", key, err)
+				}
+			}
+			// the pairs are a single write, newer than every one before it
+			indexNode.Timestamp = now
+			indexNode.Sequence = seq
+			if ttl, ok := ttls[key]; ok {
+				indexNode.ExpiresAt = now + int64(ttl)
+			}
+			ingested = append(ingested, memtable.KVPair{Key: key, Value: indexNode})
+		}
+		sort.Slice(ingested, func(i, j int) bool { return ingested[i].Key < ingested[j].Key })
+
+		// the new table has to be newer than every write before it
+		if e.indexManager.MemtableSize() > 0 {
+			e.flush()
+		}
+		// the table points into the value file without the WAL backing it
+		if err := e.syncValues(); err != nil {
+			return err
+		}
+		// recorded before the table is in place, the transactions keep what it supersedes
+		for _, pair := range ingested {
+			e.txns.record(pair.Key, seq)
+		}
+		if err := e.indexManager.Ingest(ingested); err != nil {
+			return err
+		}
+
+		e.clock.record(seq, now)
+		e.publish(seq)
+		e.counters.keysWritten.Add(uint64(len(ingested)))
+		invalidateReplicas(e.Config.Homepath)
+		return nil
+	})
+}
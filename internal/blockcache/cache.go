@@ -0,0 +1,107 @@
+// Package blockcache keeps recently read blocks of the tables and values of the
+// value file in memory, within a budget of bytes, evicting the least recently
+// used ones first.
+package blockcache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// entryOverhead is roughly what an entry costs in memory besides its data.
+const entryOverhead = 64
+
+var lastOwner atomic.Uint64
+
+// NewOwner returns an id no other file got, files are only cached by their owner
+// id so a file replaced under the same name never serves the blocks of the old one.
+func NewOwner() uint64 {
+	return lastOwner.Add(1)
+}
+
+// Key identifies a block by the file it was read from and its position in it.
+type Key struct {
+	Owner  uint64
+	Offset int64
+}
+
+type entry struct {
+	key  Key
+	data []byte
+}
+
+// Cache is an LRU cache of blocks safe for concurrent use. The blocks it returns
+// are shared and must not be modified.
+type Cache struct {
+	mu      sync.Mutex
+	budget  int64
+	used    int64
+	order   *list.List // Most recently used first.
+	entries map[Key]*list.Element
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+}
+
+// New returns a cache holding up to budget bytes.
+func New(budget int64) *Cache {
+	return &Cache{budget: budget, order: list.New(), entries: map[Key]*list.Element{}}
+}
+
+// Get returns the block cached under key.
+func (c *Cache) Get(key Key) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	c.order.MoveToFront(element)
+	return element.Value.(*entry).data, true
+}
+
+// Add caches data under key, evicting the least recently used blocks to stay in
+// the budget. Blocks larger than the budget are not cached.
+func (c *Cache) Add(key Key, data []byte) {
+	cost := int64(len(data)) + entryOverhead
+	if cost > c.budget {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.used += int64(len(data)) - int64(len(element.Value.(*entry).data))
+		element.Value.(*entry).data = data
+		c.order.MoveToFront(element)
+	} else {
+		c.entries[key] = c.order.PushFront(&entry{key: key, data: data})
+		c.used += cost
+	}
+
+	for c.used > c.budget {
+		oldest := c.order.Back()
+		evicted := oldest.Value.(*entry)
+		c.order.Remove(oldest)
+		delete(c.entries, evicted.key)
+		c.used -= int64(len(evicted.data)) + entryOverhead
+	}
+}
+
+// Stats returns the number of lookups that found a block and that did not, and
+// the bytes the cache holds.
+func (c *Cache) Stats() (hits, misses uint64, used int64) {
+	c.mu.Lock()
+	used = c.used
+	c.mu.Unlock()
+	return c.hits.Load(), c.misses.Load(), used
+}
+
+// Budget returns the bytes the cache may hold.
+func (c *Cache) Budget() int64 {
+	return c.budget
+}
@@ -0,0 +1,81 @@
+package index_manager
+
+import (
+	"container/list"
+	"sync"
+)
+
+// absentKeys remembers the keys the last lookups found no entry for anywhere,
+// up to a number of keys, so looking them up again skips the tables. A key is
+// forgotten as soon as it is written.
+type absentKeys struct {
+	mu     sync.Mutex
+	limit  int
+	order  *list.List // Most recently missed first.
+	keys   map[string]*list.Element
+	writes uint64 // Number of calls to forget, see since.
+}
+
+func newAbsentKeys(limit int) *absentKeys {
+	return &absentKeys{limit: limit, order: list.New(), keys: map[string]*list.Element{}}
+}
+
+// contains reports whether key was found absent and not written since.
+func (a *absentKeys) contains(key string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	element, ok := a.keys[key]
+	if ok {
+		a.order.MoveToFront(element)
+	}
+	return ok
+}
+
+// since returns the number of writes so far, to hand to add.
+func (a *absentKeys) since() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.writes
+}
+
+// add remembers that key has no entry, unless a write came in since the lookup
+// started and got writes from since: with a concurrent memtable the write may
+// have added the key after the lookup passed the memtable.
+func (a *absentKeys) add(key string, writes uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.writes != writes {
+		return
+	}
+	if element, ok := a.keys[key]; ok {
+		a.order.MoveToFront(element)
+		return
+	}
+	a.keys[key] = a.order.PushFront(key)
+	if a.order.Len() > a.limit {
+		oldest := a.order.Back()
+		a.order.Remove(oldest)
+		delete(a.keys, oldest.Value.(string))
+	}
+}
+
+// forget drops key, which is being written.
+func (a *absentKeys) forget(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.writes++
+	if element, ok := a.keys[key]; ok {
+		a.order.Remove(element)
+		delete(a.keys, key)
+	}
+}
+
+// reset drops every key, when tables bring keys in without going through the
+// memtable.
+func (a *absentKeys) reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.writes++
+	a.order.Init()
+	clear(a.keys)
+}
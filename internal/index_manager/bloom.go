@@ -0,0 +1,162 @@
+package index_manager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"log"
+	"os"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+)
+
+// filterSuffix names the bloom filter file stored next to its table.
+const filterSuffix = ".bloom"
+
+// bitsPerKey sizes the filters for a false positive rate of about 1%.
+const bitsPerKey = 10
+
+// bloomFilter tells whether a table may hold a key, so lookups skip the tables
+// that definitely do not. Deleted keys are added too, their tombstones still
+// have to be found.
+type bloomFilter struct {
+	bits   []uint64
+	hashes uint32
+}
+
+func newBloomFilter(pairs []memtable.KVPair) *bloomFilter {
+	words := (len(pairs)*bitsPerKey + 63) / 64
+	f := &bloomFilter{bits: make([]uint64, max(words, 1)), hashes: 7}
+	for _, pair := range pairs {
+		f.add(pair.Key)
+	}
+	return f
+}
+
+// locations derives the bit positions of key by double hashing.
+func (f *bloomFilter) locations(key string, fn func(bit uint64) bool) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32
+	m := uint64(len(f.bits) * 64)
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		if !fn((h1 + i*h2) % m) {
+			return
+		}
+	}
+}
+
+func (f *bloomFilter) add(key string) {
+	f.locations(key, func(bit uint64) bool {
+		f.bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+}
+
+func (f *bloomFilter) mayContain(key string) bool {
+	found := true
+	f.locations(key, func(bit uint64) bool {
+		found = f.bits[bit/64]&(1<<(bit%64)) != 0
+		return found
+	})
+	return found
+}
+
+// writeFilter stores the filter of the table at path. The file is checksummed,
+// a damaged filter would hide keys the table holds.
+func writeFilter(path string, f *bloomFilter) error {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, f.hashes)
+	binary.Write(buf, binary.LittleEndian, uint32(len(f.bits)))
+	binary.Write(buf, binary.LittleEndian, f.bits)
+	binary.Write(buf, binary.LittleEndian, crc32.ChecksumIEEE(buf.Bytes()))
+
+	tmp := path + filterSuffix + tmpSuffix
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(buf.Bytes())
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+filterSuffix)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// readFilter loads the filter of the table at path.
+func readFilter(path string) (*bloomFilter, error) {
+	data, err := os.ReadFile(path + filterSuffix)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 {
+		return nil, fmt.Errorf("bloom filter %q is truncated", path+filterSuffix)
+	}
+
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, fmt.Errorf("bloom filter %q is corrupted", path+filterSuffix)
+	}
+
+	f := &bloomFilter{hashes: binary.LittleEndian.Uint32(body)}
+	words := binary.LittleEndian.Uint32(body[4:])
+	if f.hashes == 0 || words == 0 || len(body) != 8+int(words)*8 {
+		return nil, fmt.Errorf("bloom filter %q is corrupted", path+filterSuffix)
+	}
+	f.bits = make([]uint64, words)
+	binary.Read(bytes.NewReader(body[8:]), binary.LittleEndian, f.bits)
+	return f, nil
+}
+
+// buildFilter writes the filter of a table about to be written at path. Tables
+// work without their filter, so failures are only logged.
+func (im *IndexManager) buildFilter(path string, pairs []memtable.KVPair) *bloomFilter {
+	f := newBloomFilter(pairs)
+	if err := writeFilter(path, f); err != nil {
+		log.Printf("index manager: failed to write the bloom filter of %q: %v\n", path, err)
+		return nil
+	}
+	return f
+}
+
+// mayContain reports whether the table may hold key. The filter is loaded on the
+// first call, a table without a usable filter may hold any key.
+func (s *SSTable) mayContain(key string) bool {
+	s.filterOnce.Do(func() {
+		f, err := readFilter(s.metadata.Path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("index manager: failed to read the bloom filter of table %d: %v\n", s.metadata.Serial, err)
+			}
+			return
+		}
+		s.filter = f
+	})
+	return s.filter == nil || s.filter.mayContain(key)
+}
+
+// setFilter gives a table just written the filter built along with it.
+func (s *SSTable) setFilter(f *bloomFilter) {
+	if f != nil {
+		s.filterOnce.Do(func() { s.filter = f })
+	}
+}
+
+// removeFilter deletes the filter file of a removed table.
+func removeFilter(path string) {
+	if err := os.Remove(path + filterSuffix); err != nil && !os.IsNotExist(err) {
+		log.Printf("index manager: failed to remove the bloom filter of %q: %v\n", path, err)
+	}
+}
@@ -0,0 +1,159 @@
+package index_manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// testPairs returns n sorted pairs holding their value inline, key-0000 on.
+func testPairs(n int) []memtable.KVPair {
+	pairs := make([]memtable.KVPair, n)
+	for i := range pairs {
+		value := []byte(fmt.Sprintf("value-%04d", i))
+		pairs[i] = memtable.KVPair{
+			Key:   fmt.Sprintf("key-%04d", i),
+			Value: memtable.IndexNode{Size: uint32(len(value)), Inline: value, Timestamp: 1},
+		}
+	}
+	return pairs
+}
+
+// falsePositives counts the keys of absent the filter may hold.
+func falsePositives(f *bloomFilter, absent int) int {
+	positives := 0
+	for i := 0; i < absent; i++ {
+		if f.mayContain(fmt.Sprintf("absent-%04d", i)) {
+			positives++
+		}
+	}
+	return positives
+}
+
+// TestBloomFilterHasNoFalseNegatives builds filters of a few sizes and checks
+// they hold every key added, and few of the others.
+func TestBloomFilterHasNoFalseNegatives(t *testing.T) {
+	for _, n := range []int{0, 1, 7, 1000, 20000} {
+		pairs := testPairs(n)
+		f := newBloomFilter(pairs)
+		for _, pair := range pairs {
+			if !f.mayContain(pair.Key) {
+				t.Fatalf("the filter of %d keys misses %q", n, pair.Key)
+			}
+		}
+		// about 1%, the filters of a handful of keys are too small to tell
+		if positives := falsePositives(f, 10000); n >= 1000 && positives > 300 {
+			t.Fatalf("the filter of %d keys may hold %d of 10000 other keys", n, positives)
+		}
+	}
+}
+
+// openTestManager opens the index manager of a database in dir.
+func openTestManager(t *testing.T, dir string) *IndexManager {
+	t.Helper()
+	config := *shared.NewEngineConfig()
+	config.Homepath = dir
+	im, err := New(&config)
+	if err != nil {
+		t.Fatalf("can not open the index manager: %v", err)
+	}
+	return im
+}
+
+// checkLookups checks every pair reads back from the tables.
+func checkLookups(t *testing.T, im *IndexManager, pairs []memtable.KVPair) {
+	t.Helper()
+	for _, pair := range pairs {
+		node, err := im.Get(pair.Key)
+		if err != nil || string(node.Inline) != string(pair.Value.Inline) {
+			t.Fatalf("%q reads %q, %v", pair.Key, node.Inline, err)
+		}
+	}
+	if _, err := im.Get("absent"); err == nil {
+		t.Fatal("a key never written is found")
+	}
+}
+
+// TestBloomFilterPersistsWithItsTable writes a table and checks its filter is
+// stored next to it, read back when the table is reopened, and that the table
+// still answers lookups once the filter is lost or damaged, or after the table
+// is merged into a level, which gets a filter of its own.
+func TestBloomFilterPersistsWithItsTable(t *testing.T) {
+	dir := t.TempDir()
+	pairs := testPairs(2000)
+	im := openTestManager(t, dir)
+	if err := im.Ingest(pairs); err != nil {
+		t.Fatal(err)
+	}
+	path := im.sstables[0].metadata.Path
+	written, err := readFilter(path)
+	if err != nil {
+		t.Fatalf("the filter of the table is not stored: %v", err)
+	}
+	if !slices.Equal(written.bits, newBloomFilter(pairs).bits) {
+		t.Fatal("the stored filter differs from the one of the pairs")
+	}
+	im.Close()
+
+	im = openTestManager(t, dir)
+	checkLookups(t, im, pairs)
+	table := im.sstables[0]
+	if table.filter == nil || !slices.Equal(table.filter.bits, written.bits) || table.filter.hashes != written.hashes {
+		t.Fatal("the reopened table does not read its filter back")
+	}
+	if positives := falsePositives(table.filter, 10000); positives > 300 {
+		t.Fatalf("the reloaded filter may hold %d of 10000 other keys", positives)
+	}
+	im.Close()
+
+	// without a usable filter the table is searched for every key
+	for _, damage := range []struct {
+		name  string
+		apply func() error
+	}{
+		{"damaged", func() error {
+			data, err := os.ReadFile(path + filterSuffix)
+			if err != nil {
+				return err
+			}
+			data[10] ^= 0xff
+			return os.WriteFile(path+filterSuffix, data, 0644)
+		}},
+		{"missing", func() error { return os.Remove(path + filterSuffix) }},
+	} {
+		if err := damage.apply(); err != nil {
+			t.Fatal(err)
+		}
+		im = openTestManager(t, dir)
+		checkLookups(t, im, pairs)
+		if im.sstables[0].filter != nil {
+			t.Fatalf("the table reads its %s filter", damage.name)
+		}
+		im.Close()
+	}
+
+	im = openTestManager(t, dir)
+	defer im.Close()
+	if err := im.CompactRange("", ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(im.sstables) != 0 || len(im.levels) != 1 {
+		t.Fatalf("the compaction left %d tables and %d levels, want a level", len(im.sstables), len(im.levels))
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("the merged table is left behind: %v", err)
+	}
+	level := im.levels[0].metadata.Path
+	if f, err := readFilter(level); err != nil || !slices.Equal(f.bits, newBloomFilter(pairs).bits) {
+		t.Fatalf("the level is not stored with the filter of its pairs: %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*"+filterSuffix)); len(matches) != 1 {
+		t.Fatalf("the filters left are %v, want the one of the level", matches)
+	}
+	checkLookups(t, im, pairs)
+}
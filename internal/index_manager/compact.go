@@ -0,0 +1,63 @@
+package index_manager
+
+import (
+	"fmt"
+	"log"
+)
+
+// CompactRange merges the tables holding keys between start and end, both
+// included, into a level without deleted or expired keys, whatever the
+// compaction picker says. An empty end leaves the range open, so ("", "") merges
+// every table. The tables overlapping the merged ones are merged along with
+// them, the level must not shadow or be shadowed by a table left out, so the
+// range only narrows the merge when the tables split the keys between them.
+func (im *IndexManager) CompactRange(start, end string) error {
+	im.compactMu.Lock()
+	defer im.compactMu.Unlock()
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if err := im.dropExpiredTables(); err != nil {
+		return err
+	}
+
+	tables := im.tables()
+	merged := map[*SSTable]struct{}{}
+	for _, table := range tables {
+		if table.metadata.MaxKey >= start && (end == "" || table.metadata.MinKey <= end) {
+			merged[table] = struct{}{}
+		}
+	}
+	for added := true; added; {
+		added = false
+		for _, candidate := range tables {
+			if _, ok := merged[candidate]; ok {
+				continue
+			}
+			for table := range merged {
+				if keysOverlap(candidate, table) {
+					merged[candidate] = struct{}{}
+					added = true
+					break
+				}
+			}
+		}
+	}
+
+	// the merge reads the tables from newest to oldest
+	picked := []*SSTable{}
+	for _, table := range tables {
+		if _, ok := merged[table]; ok {
+			picked = append(picked, table)
+		}
+	}
+	if len(picked) == 0 {
+		return nil
+	}
+
+	log.Printf("index manager: compacting %d tables holding the keys between %q and %q\n", len(picked), start, end)
+	if err := im.createLevel(picked, false); err != nil {
+		return fmt.Errorf("index manager can not compact the keys between %q and %q: %v", start, end, err)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package index_manager
+
+import (
+	"os"
+	"sync/atomic"
+)
+
+// Counters sums up the work of the index manager since it was created.
+type Counters struct {
+	Flushes        uint64 // Memtables flushed to SSTables.
+	Compactions    uint64 // Tables merged into levels.
+	ReclaimedBytes uint64 // Disk space freed by compactions and expired tables.
+}
+
+type counters struct {
+	flushes     atomic.Uint64
+	compactions atomic.Uint64
+	reclaimed   atomic.Uint64
+}
+
+// Counters returns the work done since the index manager was created.
+func (im *IndexManager) Counters() Counters {
+	return Counters{
+		Flushes:        im.counters.flushes.Load(),
+		Compactions:    im.counters.compactions.Load(),
+		ReclaimedBytes: im.counters.reclaimed.Load(),
+	}
+}
+
+// reclaim accounts the space freed by replacing tables with written bytes.
+func (im *IndexManager) reclaim(tables []*SSTable, written uint64) {
+	removed := uint64(0)
+	for _, table := range tables {
+		removed += table.fileSize()
+	}
+	if removed > written {
+		im.counters.reclaimed.Add(removed - written)
+	}
+}
+
+// fileSize returns the size of the table file. The inline values are only
+// counted when the file can be looked at.
+func (s *SSTable) fileSize() uint64 {
+	if info, err := os.Stat(s.metadata.Path); err == nil {
+		return uint64(info.Size())
+	}
+	return uint64(s.config.GetMetadataSize()) + uint64(s.metadata.Size)*uint64(s.config.GetKVPairSize())
+}
@@ -0,0 +1,164 @@
+package index_manager
+
+import (
+	"fmt"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+)
+
+// Cursor walks the pairs Items lists one at a time, in either direction, so a
+// scan never holds more than the memtable in memory. It merges the memtable as
+// it was and the tables there were when it was opened. A compaction removing
+// some of them meanwhile leaves their files open until the cursor is closed.
+type Cursor struct {
+	im     *IndexManager
+	opts   ScanOptions
+	merge  *mergeIterator
+	tables []*SSTable // Tables merged by the cursor, acquired until Close.
+	run    tombstoneRun
+
+	reverse    bool   // The merge walks backwards.
+	done       bool   // The merge went past the end of the range in its direction.
+	positioned bool   // A pair was returned since the cursor was positioned.
+	key        string // Key of the pair returned last.
+}
+
+// NewCursor returns a cursor positioned before the first pair matching opts.
+// Limit is left to the caller. The cursor has to be closed.
+func (im *IndexManager) NewCursor(opts ScanOptions) (*Cursor, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	merge, err := im.mergeIterator(opts)
+	if err != nil {
+		return nil, fmt.Errorf("index manager can not merge tables: %v", err)
+	}
+
+	c := &Cursor{im: im, opts: opts, merge: merge}
+	for _, source := range merge.sources {
+		source, ok := source.(*tableSource)
+		if !ok {
+			continue
+		}
+		if err := source.table.acquire(); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("index manager can not merge tables: %v", err)
+		}
+		c.tables = append(c.tables, source.table)
+	}
+	return c, nil
+}
+
+// Next returns the pair after the one returned last, the first one of the
+// range when the cursor went past its start. It returns false once the range is
+// exhausted.
+func (c *Cursor) Next() (memtable.KVPair, bool, error) {
+	if c.reverse {
+		// the smallest key greater than the one returned last
+		from := c.opts.Start
+		if c.positioned {
+			from = c.key + "\x00"
+		}
+		if err := c.Seek(from); err != nil {
+			return memtable.KVPair{}, false, err
+		}
+	}
+	return c.walk(func(key string) bool {
+		return c.opts.End != "" && key >= c.opts.End
+	})
+}
+
+// Prev returns the pair before the one returned last, the last one of the range
+// when the cursor went past its end. It returns false once the range is exhausted.
+func (c *Cursor) Prev() (memtable.KVPair, bool, error) {
+	if !c.reverse {
+		var err error
+		if c.positioned {
+			err = c.SeekBefore(c.key)
+		} else {
+			err = c.Last()
+		}
+		if err != nil {
+			return memtable.KVPair{}, false, err
+		}
+	}
+	return c.walk(func(key string) bool {
+		return key < c.opts.Start
+	})
+}
+
+// walk returns the next visible pair of the merge, until past reports the end of the range.
+func (c *Cursor) walk(past func(key string) bool) (memtable.KVPair, bool, error) {
+	for !c.done {
+		pair, ok, err := c.merge.next()
+		if err != nil {
+			return memtable.KVPair{}, false, fmt.Errorf("index manager can not merge tables: %v", err)
+		}
+		if !ok || past(pair.Key) {
+			c.done = true
+			break
+		}
+		if c.im.visible(pair, c.opts, &c.run) {
+			c.positioned, c.key = true, pair.Key
+			return pair, true, nil
+		}
+	}
+	c.positioned = false
+	return memtable.KVPair{}, false, nil
+}
+
+// Seek positions the cursor before the first pair not less than key, the
+// start of the range when key is before it.
+func (c *Cursor) Seek(key string) error {
+	c.position(false)
+	if err := c.merge.seek(max(key, c.opts.Start)); err != nil {
+		return fmt.Errorf("index manager can not merge tables: %v", err)
+	}
+	return nil
+}
+
+// SeekBefore positions the cursor after the last pair less than key, Prev
+// returns it. The end of the range stands for key when key is past it.
+func (c *Cursor) SeekBefore(key string) error {
+	if c.opts.End != "" && key > c.opts.End {
+		key = c.opts.End
+	}
+	c.position(true)
+	if err := c.merge.seekBefore(key); err != nil {
+		return fmt.Errorf("index manager can not merge tables: %v", err)
+	}
+	return nil
+}
+
+// Last positions the cursor after the last pair of the range, Prev returns it.
+func (c *Cursor) Last() error {
+	if c.opts.End != "" {
+		return c.SeekBefore(c.opts.End)
+	}
+	c.position(true)
+	if err := c.merge.last(); err != nil {
+		return fmt.Errorf("index manager can not merge tables: %v", err)
+	}
+	return nil
+}
+
+// position resets the cursor before it moves the merge.
+func (c *Cursor) position(reverse bool) {
+	c.endRun()
+	c.reverse, c.done, c.positioned = reverse, false, false
+}
+
+// endRun records the run of deleted keys the cursor went through in a row.
+func (c *Cursor) endRun() {
+	c.im.endTombstoneRun(c.run, c.opts.Stats)
+	c.run = tombstoneRun{}
+}
+
+// Close releases the tables of the cursor.
+func (c *Cursor) Close() {
+	c.endRun()
+	for _, table := range c.tables {
+		table.release()
+	}
+	c.tables = nil
+}
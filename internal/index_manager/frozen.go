@@ -0,0 +1,86 @@
+package index_manager
+
+import (
+	"log"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+	"github.com/hasssanezzz/goldb/internal/syncpoint"
+)
+
+// frozenMemtable is a memtable that stopped taking writes and waits to be
+// written to disk. Lookups and scans read it after the memtable, so its keys
+// stay visible until its tables are installed.
+type frozenMemtable struct {
+	table  memtable.Memtable
+	runs   [][]memtable.KVPair // Pairs of every SSTable to write, see flushRuns.
+	serial int                 // Serial of the first of them, the others follow.
+}
+
+// Freeze swaps in a fresh memtable and keeps the current one aside for
+// FlushFrozen, reserving the serials of its tables so the ones written in the
+// meantime, by Ingest, do not take them. An empty memtable is not kept. The
+// memtable frozen before must be flushed first.
+func (im *IndexManager) Freeze() {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if im.Memtable.Len() == 0 {
+		return
+	}
+
+	runs := [][]memtable.KVPair{}
+	for _, run := range im.flushRuns(im.Memtable.Items()) {
+		if len(run) > 0 {
+			runs = append(runs, run)
+		}
+	}
+	im.frozen = &frozenMemtable{table: im.Memtable, runs: runs, serial: im.currSerial}
+	im.currSerial += len(runs)
+	im.Memtable = newMemtable(im.config)
+}
+
+// FlushFrozen writes the frozen memtable, if any, to disk as a new SSTable, or
+// one per partition when flushes are partitioned. The tables are written without
+// holding the lock, lookups and writes to the memtable go on meanwhile, and
+// installed at once along with dropping the frozen memtable.
+// Returns an error if an SSTable cannot be created or written, the memtable
+// stays frozen then.
+func (im *IndexManager) FlushFrozen() error {
+	im.mu.RLock()
+	frozen := im.frozen
+	im.mu.RUnlock()
+	if frozen == nil {
+		return nil
+	}
+
+	tables := []*SSTable{}
+	for i, pairs := range frozen.runs {
+		table, err := im.writeSSTable(pairs, frozen.serial+i)
+		if err != nil {
+			for _, table := range tables {
+				table.Close()
+			}
+			return err
+		}
+		tables = append(tables, table)
+	}
+	syncpoint.Reach(syncpoint.FlushWritten)
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.sstables = append(im.sstables, tables...)
+	im.sortTablesBySerial()
+	im.saveManifest()
+	im.pinIndexes()
+	im.frozen = nil
+	im.counters.flushes.Add(1)
+
+	if len(tables) == 1 {
+		log.Printf("index manager: flushed the memtable successfully, created new table %d", frozen.serial)
+	} else {
+		log.Printf("index manager: flushed the memtable successfully, created %d partitioned tables", len(tables))
+	}
+
+	return nil
+}
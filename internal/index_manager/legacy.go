@@ -0,0 +1,118 @@
+package index_manager
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// legacyTableSize returns the size of a table of format version 0 holding n
+// pairs, written before the tables kept the write times: a header of
+// "<is level><serial><pair count><min key><max key>" followed by the pairs as
+// "<key><value offset><value size>". Tables of the current format are always
+// larger, their header alone carries the time bounds on top.
+func legacyTableSize(n uint32, keySize uint32) int64 {
+	header := 1 + 2*shared.UintSize + 2*int64(keySize)
+	return header + int64(n)*(int64(keySize)+2*shared.UintSize)
+}
+
+// isLegacyTable reports whether the table at path was written by format version 0.
+func isLegacyTable(path string, keySize uint32) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	header := make([]byte, 1+2*shared.UintSize)
+	if _, err := io.ReadFull(file, header); err != nil {
+		// too short to be a table of any format, left to the parser
+		return false, nil
+	}
+	n := binary.LittleEndian.Uint32(header[1+shared.UintSize:])
+	return info.Size() == legacyTableSize(n, keySize), nil
+}
+
+// UpgradeLegacyTables rewrites the tables of format version 0 in the directory
+// of config in the current format and returns how many it rewrote. Their pairs
+// take the time the table was written at as their write time. Every table is
+// replaced through a rename, a crash midway leaves the remaining ones to the
+// next call.
+func UpgradeLegacyTables(config *shared.EngineConfig) (int, error) {
+	files, err := os.ReadDir(config.Homepath)
+	if err != nil {
+		return 0, err
+	}
+
+	im := &IndexManager{config: config}
+	upgraded := 0
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasSuffix(name, filterSuffix) || strings.HasSuffix(name, tmpSuffix) {
+			continue
+		}
+		if !strings.HasPrefix(name, config.SSTableNamePrefix) && !strings.HasPrefix(name, config.LevelFileNamePrefix) {
+			continue
+		}
+		path := filepath.Join(config.Homepath, name)
+		legacy, err := isLegacyTable(path, config.KeySize)
+		if err != nil {
+			return upgraded, fmt.Errorf("index manager can not read table %q: %v", name, err)
+		}
+		if !legacy {
+			continue
+		}
+		if err := im.upgradeLegacyTable(path); err != nil {
+			return upgraded, fmt.Errorf("index manager can not upgrade table %q: %v", name, err)
+		}
+		upgraded++
+	}
+	return upgraded, nil
+}
+
+// upgradeLegacyTable rewrites the table of format version 0 at path.
+func (im *IndexManager) upgradeLegacyTable(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	keySize := int(im.config.KeySize)
+
+	metadata := TableMetadata{Path: path, IsLevel: data[0] != 0x00}
+	metadata.Serial = binary.LittleEndian.Uint32(data[1:])
+	metadata.Size = binary.LittleEndian.Uint32(data[1+shared.UintSize:])
+	offset := 1 + 2*shared.UintSize
+	metadata.MinKey = shared.TrimPaddedKey(string(data[offset : offset+keySize]))
+	metadata.MaxKey = shared.TrimPaddedKey(string(data[offset+keySize : offset+2*keySize]))
+	offset += 2 * keySize
+
+	timestamp := info.ModTime().UnixNano()
+	pairs := make([]memtable.KVPair, metadata.Size)
+	for i := range pairs {
+		pairs[i].Key = shared.TrimPaddedKey(string(data[offset : offset+keySize]))
+		pairs[i].Value = memtable.IndexNode{
+			Offset:    binary.LittleEndian.Uint32(data[offset+keySize:]),
+			Size:      binary.LittleEndian.Uint32(data[offset+keySize+shared.UintSize:]),
+			Timestamp: timestamp,
+		}
+		offset += keySize + 2*shared.UintSize
+	}
+	metadata.MinTime, metadata.MaxTime = timestamp, timestamp
+
+	im.buildFilter(path, pairs)
+	return im.writeTable(path, pairs, &metadata, false)
+}
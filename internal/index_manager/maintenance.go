@@ -0,0 +1,36 @@
+package index_manager
+
+import (
+	"log"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// maintenance runs the heavy maintenance when it is allowed at this time: the
+// trash is purged, the ranges dense with deleted keys are compacted, and when maintenance windows are configured all the levels
+// are merged into one, dropping the deleted keys for good.
+func (im *IndexManager) maintenance() error {
+	if !im.config.MaintenanceAllowed(time.Now()) {
+		return nil
+	}
+
+	if err := im.PurgeTrash(); err != nil {
+		return err
+	}
+	if err := im.compactTombstoneRuns(); err != nil {
+		return err
+	}
+
+	if len(im.config.MaintenanceWindows) == 0 || len(im.levels) < 2 {
+		return nil
+	}
+
+	if im.config.FlushFormat == shared.FlushPartitioned {
+		return im.mergePartitionLevels()
+	}
+
+	log.Printf("index manager: merging %d levels in the maintenance window\n", len(im.levels))
+	levels := append([]*SSTable{}, im.levels...)
+	return im.createLevel(levels, false)
+}
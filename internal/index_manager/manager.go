@@ -11,24 +11,41 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/hasssanezzz/goldb/internal/blockcache"
 	"github.com/hasssanezzz/goldb/internal/memtable"
 	"github.com/hasssanezzz/goldb/internal/shared"
+	"github.com/hasssanezzz/goldb/internal/syncpoint"
 )
 
 // IndexManager handles the indexing of keys across the memtable, SSTables, and levels.
 // It ensures that keys are efficiently located and manages the compaction process.
+// It is safe for concurrent use: lookups and scans share a read lock, while writes
+// to the memtable, flushes and compactions take it exclusively. Writes to a
+// concurrent memtable share the read lock too.
 type IndexManager struct {
-	Memtable   *memtable.Table // In-memory AVL tree for temporary storage.
+	Memtable   memtable.Memtable // In-memory table of the latest writes, see MemtableKind.
+	frozen     *frozenMemtable   // Memtable being flushed in the background, nil when there is none.
+	mu         sync.RWMutex      // Guards the memtable and the lists of tables.
+	compactMu  sync.Mutex        // Serializes the compactions and the relocations, taken before mu.
 	config     *shared.EngineConfig
 	currSerial int        // Current serial number for SSTables.
 	lvlSerial  int        // Current serial number for levels.
 	sstables   []*SSTable // List of SSTables on disk.
 	levels     []*SSTable // List of levels (merged SSTables).
+	compaction compactionOptions
+	hints      tombstoneHints
+	counters   counters
+	queues     queueWatermarks
+	blockCache *blockcache.Cache // Shared with the value file, nil when BlockCacheSize is zero.
+	absent     *absentKeys       // Keys last found missing, nil when NegativeCacheSize is zero.
 }
 
 // New initializes a new IndexManager with the given homepath.
@@ -37,10 +54,18 @@ type IndexManager struct {
 func New(config *shared.EngineConfig) (*IndexManager, error) {
 	im := &IndexManager{
 		config:     config,
-		Memtable:   memtable.New(),
+		Memtable:   newMemtable(config),
 		currSerial: 1, // starting from one to reserve number zero
 		lvlSerial:  1, // level 0 for SSTables only
 	}
+	if config.BlockCacheSize > 0 {
+		im.blockCache = blockcache.New(config.BlockCacheSize)
+	}
+	if config.NegativeCacheSize > 0 {
+		im.absent = newAbsentKeys(config.NegativeCacheSize)
+	}
+	im.compaction.workers.Store(int32(config.CompactionWorkers))
+	im.compaction.rateLimit.Store(config.CompactionRateLimit)
 
 	if err := im.ParseHomeDir(); err != nil {
 		return nil, err
@@ -55,10 +80,31 @@ func (im *IndexManager) ParseHomeDir() error {
 		return err
 	}
 
+	// the tables the manifest knows about are opened on their first access
+	manifest := im.readManifest()
+	fromManifest := 0
+
 	for _, file := range files {
 		name := file.Name()
+		if im.removeTmpFile(name) {
+			continue
+		}
+
+		if strings.HasSuffix(name, filterSuffix) {
+			continue
+		}
 
 		if strings.HasPrefix(name, im.config.SSTableNamePrefix) || strings.HasPrefix(name, im.config.LevelFileNamePrefix) {
+			if entry, ok := manifest[name]; ok {
+				if info, err := file.Info(); err == nil && im.openFromManifest(entry, info) {
+					fromManifest++
+					continue
+				}
+			}
+
+			if legacy, err := isLegacyTable(filepath.Join(im.config.Homepath, name), im.config.KeySize); err == nil && legacy {
+				return fmt.Errorf("index manager can not read table %q: it was written by format version 0, opening the database with New migrates it", name)
+			}
 			err := im.readTable(name)
 			if err != nil {
 				log.Printf("index manager: failed to parse file %q: %v\n", name, err)
@@ -66,6 +112,12 @@ func (im *IndexManager) ParseHomeDir() error {
 		}
 	}
 
+	im.sortTablesBySerial()
+	im.pinIndexes()
+	if fromManifest > 0 {
+		log.Printf("index manager: opened %d tables from the manifest\n", fromManifest)
+	}
+
 	return nil
 }
 
@@ -73,140 +125,428 @@ func (im *IndexManager) ParseHomeDir() error {
 // It searches the memtable, SSTables, and levels in order of recency.
 // Returns ErrKeyNotFound if the key does not exist.
 func (im *IndexManager) Get(key string) (memtable.IndexNode, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	indexNode, found, err := im.lookup(key)
+	if err != nil {
+		return memtable.IndexNode{}, err
+	}
+	if !found || indexNode.IsDeleted() || im.expired(key, indexNode) {
+		return memtable.IndexNode{}, &shared.ErrKeyNotFound{Key: key}
+	}
+	return indexNode, nil
+}
+
+// BlockCache returns the cache of the table blocks, which the value file shares,
+// or nil when it is disabled.
+func (im *IndexManager) BlockCache() *blockcache.Cache {
+	return im.blockCache
+}
+
+// Lookup returns the most recent entry for the given key, deleted ones included.
+// It searches the memtable, SSTables, and levels in order of recency.
+// The boolean is false when no entry for the key exists at all.
+func (im *IndexManager) Lookup(key string) (memtable.IndexNode, bool, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	return im.lookup(key)
+}
 
-	// 1. search in the memtable
+func (im *IndexManager) lookup(key string) (memtable.IndexNode, bool, error) {
+	// the writes are counted before the memtable is searched, so a key written
+	// after it was passed is not remembered as missing
+	writes := uint64(0)
+	if im.absent != nil {
+		writes = im.absent.since()
+	}
+
+	// 1. search in the memtable, then in the one being flushed
 	if im.Memtable.Contains(key) {
-		indexNode := im.Memtable.Get(key)
-		if indexNode.Size == 0 {
-			return memtable.IndexNode{}, &shared.ErrKeyNotFound{Key: key}
-		}
-		return indexNode, nil
+		return im.Memtable.Get(key), true, nil
+	}
+	if im.frozen != nil && im.frozen.table.Contains(key) {
+		return im.frozen.table.Get(key), true, nil
+	}
+	if im.absent == nil {
+		return im.lookupTables(key)
 	}
 
-	// 2. search in the SSTables
-	for _, table := range im.sstables {
-		if table.metadata.MinKey > key || table.metadata.MaxKey < key {
+	if im.absent.contains(key) {
+		return memtable.IndexNode{}, false, nil
+	}
+	node, found, err := im.lookupTables(key)
+	if !found && err == nil {
+		im.absent.add(key, writes)
+	}
+	return node, found, err
+}
+
+// lookupTables searches the SSTables, then the levels.
+func (im *IndexManager) lookupTables(key string) (memtable.IndexNode, bool, error) {
+	for _, table := range im.tables() {
+		if table.metadata.MinKey > key || table.metadata.MaxKey < key || !table.mayContain(key) {
 			continue
 		}
 
 		result, err := table.BSearch(key)
 		if err != nil {
 			if _, ok := err.(*shared.ErrKeyRemoved); ok {
-				return memtable.IndexNode{}, &shared.ErrKeyNotFound{Key: key}
+				return result, true, nil
 			}
 			if _, ok := err.(*shared.ErrKeyNotFound); !ok {
-				return memtable.IndexNode{}, fmt.Errorf("index manager can not read key %q from sstable %d: %v", key, table.metadata.Serial, err)
+				return memtable.IndexNode{}, false, fmt.Errorf("index manager can not read key %q from sstable %d: %v", key, table.metadata.Serial, err)
 			}
 			continue
 		}
 
-		return result, nil
+		return result, true, nil
 	}
 
-	// 3. search in the levels
-	for _, table := range im.levels {
-		if table.metadata.MinKey > key || table.metadata.MaxKey < key {
-			continue
-		}
+	return memtable.IndexNode{}, false, nil
+}
 
-		result, err := table.BSearch(key)
-		if err != nil {
-			if _, ok := err.(*shared.ErrKeyRemoved); ok {
-				return memtable.IndexNode{}, &shared.ErrKeyNotFound{Key: key}
-			}
-			if _, ok := err.(*shared.ErrKeyNotFound); !ok {
-				return memtable.IndexNode{}, fmt.Errorf("index manager can not read key %q from sstable %d: %v", key, table.metadata.Serial, err)
-			}
-			continue
-		}
+// newMemtable returns an empty memtable of the configured kind.
+func newMemtable(config *shared.EngineConfig) memtable.Memtable {
+	if config.MemtableKind == shared.MemtableSkiplist {
+		return memtable.NewSkiplist()
+	}
+	return memtable.New()
+}
 
-		return result, nil
+// Set points the key at its value in the memtable. A concurrent memtable only
+// needs the read lock, which keeps it from being frozen in the middle.
+func (im *IndexManager) Set(key string, indexNode memtable.IndexNode) {
+	if im.config.MemtableKind == shared.MemtableSkiplist {
+		im.mu.RLock()
+		defer im.mu.RUnlock()
+	} else {
+		im.mu.Lock()
+		defer im.mu.Unlock()
 	}
+	im.Memtable.Set(key, indexNode)
+	im.queues.lower(key)
+	// forgotten once in the memtable, a lookup in between must not remember it again
+	if im.absent != nil {
+		im.absent.forget(key)
+	}
+}
 
-	return memtable.IndexNode{}, &shared.ErrKeyNotFound{Key: key}
+// MemtableSize returns the number of keys in the memtable.
+func (im *IndexManager) MemtableSize() uint32 {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	return im.Memtable.Len()
 }
 
 // Delete marks the given key as deleted in the memtable.
 // The key will be removed during the next flush or compaction.
-func (im *IndexManager) Delete(key string) {
-	im.Memtable.Set(key, memtable.IndexNode{})
+func (im *IndexManager) Delete(key string, timestamp int64, sequence uint64) {
+	im.Set(key, memtable.IndexNode{Timestamp: timestamp, Sequence: sequence})
+}
+
+// SoftDelete marks the given key as deleted while keeping a pointer to its value,
+// so it can be restored until the undelete window passes.
+func (im *IndexManager) SoftDelete(key string, indexNode memtable.IndexNode, timestamp int64, sequence uint64) {
+	im.Set(key, memtable.IndexNode{
+		Offset:     indexNode.Offset,
+		Size:       indexNode.Size,
+		Timestamp:  timestamp,
+		Deleted:    true,
+		Compressed: indexNode.Compressed,
+		Inline:     indexNode.Inline,
+		ExpiresAt:  indexNode.ExpiresAt,
+		Sequence:   sequence,
+		Extents:    indexNode.Extents,
+	})
+}
+
+// Ingest writes pairs pointing at values already in the value file as a new
+// SSTable, which shadows every table before it. The pairs must be sorted by key
+// and none of them in the memtable, whose older entries would shadow them.
+func (im *IndexManager) Ingest(pairs []memtable.KVPair) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for _, pair := range pairs {
+		if im.Memtable.Contains(pair.Key) || im.frozen != nil && im.frozen.table.Contains(pair.Key) {
+			return fmt.Errorf("index manager can not ingest key %q, the memtable holds it", pair.Key)
+		}
+	}
+	if err := im.addSSTable(pairs); err != nil {
+		return err
+	}
+
+	log.Printf("index manager: ingested %d pairs as table %d", len(pairs), im.currSerial-1)
+
+	return nil
 }
 
-// Flush writes the contents of the memtable to disk as a new SSTable.
-// It resets the memtable and updates the list of SSTables.
-// Returns an error if the SSTable cannot be created or written.
-func (im *IndexManager) Flush() error {
-	path := filepath.Join(im.config.Homepath, fmt.Sprintf(im.config.SSTableNamePrefix+"%d", im.currSerial))
-	file, err := os.Create(path)
+// addSSTable writes the sorted pairs as the newest SSTable.
+func (im *IndexManager) addSSTable(pairs []memtable.KVPair) error {
+	newSSTable, err := im.writeSSTable(pairs, im.currSerial)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	pairs := im.Memtable.Items()
+	im.sstables = append(im.sstables, newSSTable)
+	if im.absent != nil {
+		im.absent.reset()
+	}
+	im.sortTablesBySerial()
+	im.currSerial++
+	im.saveManifest()
+	im.pinIndexes()
+
+	return nil
+}
+
+// writeSSTable writes the sorted pairs as the SSTable numbered serial and opens it.
+func (im *IndexManager) writeSSTable(pairs []memtable.KVPair, serial int) (*SSTable, error) {
+	path := filepath.Join(im.config.Homepath, fmt.Sprintf(im.config.SSTableNamePrefix+"%d", serial))
+	minTime, maxTime := timeBounds(pairs)
 	metadata := TableMetadata{
 		Path:    path,
 		IsLevel: false,
 		Size:    uint32(len(pairs)),
-		Serial:  uint32(im.currSerial),
+		Serial:  uint32(serial),
 		MinKey:  pairs[0].Key,
 		MaxKey:  pairs[len(pairs)-1].Key,
+		MinTime: minTime,
+		MaxTime: maxTime,
 	}
 
-	err = im.serializePairs(file, pairs, &metadata)
+	filter := im.buildFilter(path, pairs)
+	err := im.writeTable(path, pairs, &metadata, false)
 	if err != nil {
-		return fmt.Errorf("index manager can not flush sstable %d: %v", im.currSerial, err)
+		removeFilter(path)
+		return nil, fmt.Errorf("index manager can not write sstable %d: %v", serial, err)
+	}
+
+	table, err := NewSSTable(metadata, im.config, im.blockCache)
+	if err != nil {
+		return nil, err
+	}
+	table.setFilter(filter)
+	return table, nil
+}
+
+// SplitKeys returns up to shards-1 ascending keys that split the keyspace into
+// ranges of similar size. They are read at evenly spaced positions of the largest
+// table, the one that best represents the distribution of the keys.
+func (im *IndexManager) SplitKeys(shards int) ([]string, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	var largest *SSTable
+	for _, table := range im.tables() {
+		if largest == nil || table.metadata.Size > largest.metadata.Size {
+			largest = table
+		}
 	}
+	if largest == nil || shards < 2 {
+		return nil, nil
+	}
+
+	splits := []string{}
+	size := int(largest.metadata.Size)
+	for i := 1; i < shards; i++ {
+		pair, err := largest.nthKey(i * size / shards)
+		if err != nil {
+			return nil, fmt.Errorf("index manager can not read split key of table %d: %v", largest.metadata.Serial, err)
+		}
+		// tiny tables yield the same key more than once
+		if pair.Key == "" || (len(splits) > 0 && splits[len(splits)-1] == pair.Key) {
+			continue
+		}
+		splits = append(splits, pair.Key)
+	}
+	return splits, nil
+}
 
-	// reset the memtable after successfully serializing it
-	im.Memtable = memtable.New()
+// Keys returns a list of the live keys starting with prefix in ascending order.
+// It includes keys from the memtable, SSTables, and levels. Every table is
+// seeked to the prefix and the merge stops at the first key past it, so only
+// the matching part of the keyspace is read.
+// Returns an error if any SSTable or level cannot be read.
+func (im *IndexManager) Keys(prefix string) ([]string, error) {
+	opts := NewScanOptions()
+	opts.Start, opts.End = prefix, shared.PrefixEnd(prefix)
+	opts.KeysOnly = true
 
-	newSSTable, err := NewSSTable(metadata, im.config)
+	pairs, err := im.Items(opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	im.sstables = append(im.sstables, newSSTable)
-	im.sortTablesBySerial()
-	im.currSerial++
+	results := make([]string, len(pairs))
+	for i, pair := range pairs {
+		results[i] = pair.Key
+	}
 
-	log.Printf("index manager: flushed the memtable successfully, created new table %d", im.currSerial-1)
+	return results, nil
+}
 
-	return nil
+// ScanOptions narrows down which entries Items returns.
+type ScanOptions struct {
+	From int64 // Lower bound of the write time window in unix nanoseconds.
+	To   int64 // Upper bound of the write time window in unix nanoseconds.
+
+	CreatedFrom int64 // Lower bound of the creation time of the tables read, in unix nanoseconds.
+	CreatedTo   int64 // Upper bound of the creation time of the tables read, in unix nanoseconds.
+
+	Start string // First key of the range, inclusive.
+	End   string // Key the range stops at, exclusive, empty for no upper bound.
+
+	KeysOnly    bool // The caller only needs keys, values are never read.
+	LowPriority bool // The reads of the scan yield to the high priority ones.
+	System      bool // Include the system keyspace, left out by default.
+	Deleted     bool // Include the deleted keys, for callers following the changes.
+	Limit       int  // Stop after this many entries, zero for no limit.
+
+	Stats *ScanStats // Collects the work done by the scan when set.
 }
 
-// Keys returns a list of all keys in the database.
-// It includes keys from the memtable, SSTables, and levels.
-// Returns an error if any SSTable or level cannot be read.
-func (im *IndexManager) Keys() ([]string, error) {
-	// TODO get keys from levels
-	final := map[string]struct{}{}
+// ScanStats counts the work done by a scan.
+type ScanStats struct {
+	KeysScanned       int   // Keys the merge went through, deleted and filtered out ones included.
+	TombstonesSkipped int   // Deleted keys left out.
+	TablesRead        int   // SSTables and levels merged by the scan.
+	EntriesRead       int   // Index entries read from disk rather than from a loaded index.
+	BytesRead         int64 // Size of the index entries read from disk.
+
+	LongestTombstoneRun int    // Most deleted keys skipped in a row.
+	TombstoneRunStart   string // First key of the longest run of deleted keys.
+	TombstoneRunEnd     string // Last key of the longest run of deleted keys.
+}
 
-	for _, table := range im.sstables {
-		keys, err := table.Keys()
-		if err != nil {
-			return nil, err
+// inRange reports whether key falls within [Start, End).
+func (opts ScanOptions) inRange(key string) bool {
+	return key >= opts.Start && (opts.End == "" || key < opts.End)
+}
+
+// NewScanOptions returns options that match every entry.
+func NewScanOptions() ScanOptions {
+	return ScanOptions{From: math.MinInt64, To: math.MaxInt64, CreatedFrom: math.MinInt64, CreatedTo: math.MaxInt64}
+}
+
+// mergeIterator merges the memtable and the tables that may hold entries matching
+// opts, positioned at opts.Start.
+func (im *IndexManager) mergeIterator(opts ScanOptions) (*mergeIterator, error) {
+	// memtable first, then sstables and levels from newest to oldest
+	sources := []pairSource{}
+	// the memtable is the table being created right now
+	if now := time.Now().UnixNano(); now >= opts.CreatedFrom && now <= opts.CreatedTo {
+		sources = append(sources, &sliceSource{pairs: im.Memtable.Items()})
+		if im.frozen != nil {
+			sources = append(sources, &sliceSource{pairs: im.frozen.table.Items()})
 		}
-		for _, key := range keys {
-			final[key] = struct{}{}
+	}
+	for _, table := range im.tables() {
+		if !table.Overlaps(opts.From, opts.To) {
+			continue
+		}
+		if table.metadata.CreatedAt < opts.CreatedFrom || table.metadata.CreatedAt > opts.CreatedTo {
+			continue
+		}
+		if table.metadata.MaxKey < opts.Start || (opts.End != "" && table.metadata.MinKey >= opts.End) {
+			continue
+		}
+		sources = append(sources, &tableSource{table: table, stats: opts.Stats})
+		if opts.Stats != nil {
+			opts.Stats.TablesRead++
 		}
 	}
 
-	memtablePairs := im.Memtable.Items()
-	for _, pair := range memtablePairs {
-		final[pair.Key] = struct{}{}
+	it, err := newMergeIterator(sources)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Start != "" {
+		if err := it.seek(opts.Start); err != nil {
+			return nil, err
+		}
+	}
+	return it, nil
+}
+
+// Items returns the live key-value pairs of the database sorted by key.
+// The memtable and the tables are merged through a heap, when the same key exists
+// in several places the most recent entry wins, and deleted keys are left out.
+// Tables whose time range does not overlap the window in opts are skipped entirely.
+func (im *IndexManager) Items(opts ScanOptions) ([]memtable.KVPair, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	it, err := im.mergeIterator(opts)
+	if err != nil {
+		return nil, fmt.Errorf("index manager can not merge tables: %v", err)
 	}
 
-	results := []string{}
-	for key := range final {
-		results = append(results, key)
+	results := []memtable.KVPair{}
+	run := tombstoneRun{}
+	defer func() { im.endTombstoneRun(run, opts.Stats) }()
+
+	for {
+		pair, ok, err := it.next()
+		if err != nil {
+			return nil, fmt.Errorf("index manager can not merge tables: %v", err)
+		}
+		if !ok {
+			break
+		}
+
+		if opts.End != "" && pair.Key >= opts.End {
+			break
+		}
+		if !im.visible(pair, opts, &run) {
+			continue
+		}
+		results = append(results, pair)
+		if opts.Limit > 0 && len(results) == opts.Limit {
+			break
+		}
 	}
 
 	return results, nil
 }
 
+// visible reports whether a pair the merge yielded is listed by a scan with
+// opts, counting it in the scan stats and in the run of deleted keys.
+func (im *IndexManager) visible(pair memtable.KVPair, opts ScanOptions, run *tombstoneRun) bool {
+	if opts.Stats != nil {
+		opts.Stats.KeysScanned++
+	}
+	// deleted keys still shadow older entries
+	if pair.Value.IsDeleted() {
+		if opts.Stats != nil {
+			opts.Stats.TombstonesSkipped++
+		}
+		run.add(pair.Key)
+		if !opts.Deleted {
+			return false
+		}
+	} else {
+		im.endTombstoneRun(*run, opts.Stats)
+		*run = tombstoneRun{}
+	}
+
+	if !opts.inRange(pair.Key) || (!opts.System && shared.IsSystemKey(pair.Key)) {
+		return false
+	}
+	if pair.Value.Timestamp < opts.From || pair.Value.Timestamp > opts.To {
+		return false
+	}
+	return !im.expired(pair.Key, pair.Value)
+}
+
 // Close closes all open SSTables and levels.
 func (im *IndexManager) Close() error {
+	im.compactMu.Lock()
+	defer im.compactMu.Unlock()
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
 	for _, table := range im.sstables {
 		if err := table.Close(); err != nil {
 			return err
@@ -222,127 +562,227 @@ func (im *IndexManager) Close() error {
 	return nil
 }
 
-// CompactionCheck checks if the number of SSTables exceeds the threshold.
+// CompactionCheck drops tables that aged out of their retention window and checks
+// if the number of SSTables exceeds the threshold.
 // If so, it triggers compaction to merge SSTables into a single level.
+// The heavy maintenance runs afterwards, when it is allowed at this time.
 // Returns an error if compaction fails.
 func (im *IndexManager) CompactionCheck() error {
-	if len(im.sstables) <= int(im.config.CompactionThreshold) {
-		return nil
+	im.compactMu.Lock()
+	defer im.compactMu.Unlock()
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if err := im.dropExpiredTables(); err != nil {
+		return err
+	}
+	if err := im.compactSSTables(); err != nil {
+		return err
+	}
+	return im.maintenance()
+}
+
+// compactSSTables compacts the tables the way the compaction strategy decides.
+func (im *IndexManager) compactSSTables() error {
+	if im.config.FlushFormat == shared.FlushPartitioned {
+		return im.compactPartitions()
 	}
 
-	return im.createLevel()
+	return im.runStrategy(im.config.GetCompactionStrategy())
 }
 
 func (im *IndexManager) readTable(filename string) error {
 
 	// 1. create a new sstable
 	fullPath := filepath.Join(im.config.Homepath, filename)
-	table, err := NewSSTable(TableMetadata{Path: fullPath}, im.config)
+	table, err := NewSSTable(TableMetadata{Path: fullPath}, im.config, im.blockCache)
 	if err != nil {
 		return fmt.Errorf("index manager can not parse table %q: %v", filename, err)
 	}
 
-	// 2. add the table to the list
+	// 2. add the table to the list, ParseHomeDir sorts them once all are read
+	im.addTable(table)
+
+	// 3. do some logging
+	log.Printf("index manager: read %s %d with %d pairs\n", filename, table.metadata.Serial, table.metadata.Size)
+
+	return nil
+}
+
+// addTable adds a table read from disk to the list of SSTables or levels.
+func (im *IndexManager) addTable(table *SSTable) {
 	if table.metadata.IsLevel {
 		im.levels = append(im.levels, table)
-		im.lvlSerial = max(im.lvlSerial, int(table.metadata.Serial))
+		im.lvlSerial = max(im.lvlSerial, int(table.metadata.Serial)+1)
 	} else {
 		im.sstables = append(im.sstables, table)
-		im.currSerial = max(im.currSerial, int(table.metadata.Serial))
+		im.currSerial = max(im.currSerial, int(table.metadata.Serial)+1)
 	}
+}
 
-	// 3. sort the tables
-	im.sortTablesBySerial()
+// createLevel merges the given tables into a single level and deletes the original tables.
+// Deleted keys are kept when keepDeleted is set, for they still shadow older tables.
+// Returns an error if the level cannot be created or written.
+func (im *IndexManager) createLevel(tables []*SSTable, keepDeleted bool) error {
+	return im.createLevelAt(tables, keepDeleted, 0)
+}
 
-	// 4. do some logging
-	log.Printf("index manager: read %s %d with %d pairs\n", filename, table.metadata.Serial, table.metadata.Size)
+// createLevelAt is createLevel for a level of the leveled compaction at depth,
+// a depth of zero writes a plain level.
+//
+// The caller holds compactMu and mu. mu is released while the tables are read
+// and the level is written, so lookups and flushes go on in the meantime: a
+// flush only adds SSTables newer than every merged table, which keeps both the
+// merge and keepDeleted right, and compactMu keeps the merged tables in place.
+func (im *IndexManager) createLevelAt(tables []*SSTable, keepDeleted bool, depth int) error {
+	im.mu.Unlock()
+	level, err := im.mergeLevel(tables, keepDeleted, depth)
+	if err == nil {
+		syncpoint.Reach(syncpoint.CompactionMerged)
+	}
+	im.mu.Lock()
+	if err != nil {
+		return err
+	}
+	im.config.ReachCrashPoint(shared.CrashAfterCompaction)
+
+	written := uint64(0)
+	if level != nil {
+		im.lvlSerial++
+		im.levels = append(im.levels, level)
+		written = level.fileSize()
+	}
+	im.reclaim(tables, written)
+	im.removeSSTables(tables)
+	im.counters.compactions.Add(1)
+	syncpoint.Reach(syncpoint.CompactionInstalled)
 
 	return nil
 }
 
-// createLevel merges all SSTables into a single level and deletes the original SSTables.
-// Returns an error if the level cannot be created or written.
-func (im *IndexManager) createLevel() error {
-	path := filepath.Join(im.config.Homepath, fmt.Sprintf(im.config.LevelFileNamePrefix+"%d", im.lvlSerial))
-	file, err := os.Create(path)
-	if err != nil {
-		return err
+// mergeLevel merges the tables into a new level file and returns it, or nil when
+// nothing survived the merge and the tables can simply go away.
+func (im *IndexManager) mergeLevel(tables []*SSTable, keepDeleted bool, depth int) (*SSTable, error) {
+	if err := im.loadIndexes(tables); err != nil {
+		return nil, fmt.Errorf("compaction failed to read pairs: %v", err)
 	}
-	defer file.Close()
 
-	allPairs, err := im.getAllUniquePairs()
+	allPairs, err := im.getAllUniquePairs(tables, keepDeleted)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if len(allPairs) == 0 {
+		return nil, nil
 	}
 
+	path := filepath.Join(im.config.Homepath, fmt.Sprintf(im.config.LevelFileNamePrefix+"%d", im.lvlSerial))
+
+	minTime, maxTime := timeBounds(allPairs)
 	metadata := TableMetadata{
 		Path:    path,
 		IsLevel: true,
+		Depth:   depth,
 		Size:    uint32(len(allPairs)),
 		Serial:  uint32(im.lvlSerial),
 		MinKey:  allPairs[0].Key,
 		MaxKey:  allPairs[len(allPairs)-1].Key,
+		MinTime: minTime,
+		MaxTime: maxTime,
 	}
 
-	err = im.serializePairs(file, allPairs, &metadata)
+	filter := im.buildFilter(path, allPairs)
+	err = im.writeTable(path, allPairs, &metadata, true)
 	if err != nil {
-		return fmt.Errorf("index manager can not flush sstable %d: %v", im.currSerial, err)
+		removeFilter(path)
+		return nil, fmt.Errorf("index manager can not create level %d: %v", im.lvlSerial, err)
 	}
 
 	// create a new level
-	level, err := NewSSTable(metadata, im.config)
+	level, err := NewSSTable(metadata, im.config, im.blockCache)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	level.setFilter(filter)
 
-	im.lvlSerial++
-	im.levels = append(im.levels, level)
+	return level, nil
+}
 
-	// delete all sstables (danger)
-	for _, table := range im.sstables {
+// removeSSTables closes the given SSTables or levels, deletes their files and drops them from the lists.
+func (im *IndexManager) removeSSTables(tables []*SSTable) {
+	removed := map[*SSTable]struct{}{}
+
+	// delete the sstables (danger)
+	for _, table := range tables {
+		removed[table] = struct{}{}
 		table.Close() // TODO handle closing errors
 		err := os.Remove(table.metadata.Path)
 		if err != nil {
 			log.Printf("failed to remove sstable %d: %v", table.metadata.Serial, err)
 			continue
 		}
+		removeFilter(table.metadata.Path)
 	}
 
-	im.sstables = []*SSTable{}
-	im.sortTablesBySerial()
+	keep := func(tables []*SSTable) []*SSTable {
+		kept := []*SSTable{}
+		for _, table := range tables {
+			if _, ok := removed[table]; !ok {
+				kept = append(kept, table)
+			}
+		}
+		return kept
+	}
 
-	return nil
+	im.sstables = keep(im.sstables)
+	im.levels = keep(im.levels)
+	im.sortTablesBySerial()
+	im.saveManifest()
+	im.pinIndexes()
 }
 
-// getAllUniquePairs retrieves all unique key-value pairs from SSTables.
-// It removes duplicates and deleted keys.
+// getAllUniquePairs retrieves all unique key-value pairs from the given SSTables,
+// which must be ordered from newest to oldest.
+// It removes duplicates, and deleted keys unless keepDeleted is set.
 // Returns an error if any SSTable cannot be read.
-func (im *IndexManager) getAllUniquePairs() ([]memtable.KVPair, error) {
-	mp := map[string]*memtable.KVPair{}
-	for _, table := range im.sstables {
-		pairs, err := table.KVPairs()
+func (im *IndexManager) getAllUniquePairs(tables []*SSTable, keepDeleted bool) ([]memtable.KVPair, error) {
+	sources := make([]pairSource, len(tables))
+	for i, table := range tables {
+		sources[i] = &tableSource{table: table, uncached: true}
+	}
+
+	it, err := newMergeIterator(sources)
+	if err != nil {
+		return nil, fmt.Errorf("compaction failed to read pairs: %v", err)
+	}
+
+	pairs := []memtable.KVPair{}
+	for {
+		pair, ok, err := it.next()
 		if err != nil {
-			return nil, fmt.Errorf("compaction failed to read pairs of table %d: %v", table.metadata.Serial, err)
+			return nil, fmt.Errorf("compaction failed to read pairs: %v", err)
 		}
-		for _, pair := range pairs {
-			// TODO urgent - check deleted keys
-			if pair.Value.Size == 0 {
-				continue
-			}
-			if _, ok := mp[pair.Key]; ok {
-				continue
-			}
-			mp[pair.Key] = &pair
+		if !ok {
+			break
 		}
-	}
 
-	pairs := make([]memtable.KVPair, len(mp))
-	i := 0
-	for _, pair := range mp {
-		pairs[i] = *pair
-	}
+		// soft deleted keys become plain deleted ones once they can not be restored
+		if pair.Value.Deleted && time.Since(time.Unix(0, pair.Value.Timestamp)) > im.config.UndeleteWindow {
+			pair.Value = memtable.IndexNode{Timestamp: pair.Value.Timestamp}
+		}
+
+		// a key past its TTL may shadow an older entry, it stays as a tombstone
+		if pair.Value.Size > 0 && pair.Value.Expired() {
+			pair.Value = memtable.IndexNode{Timestamp: pair.Value.Timestamp}
+		}
 
-	sort.Sort(memtable.KVPairSlice(pairs))
+		if pair.Value.Size == 0 && !keepDeleted {
+			continue
+		}
+		if im.config.Expired(pair.Key, pair.Value.Timestamp) {
+			continue
+		}
+		pairs = append(pairs, pair)
+	}
 
 	return pairs, nil
 }
@@ -350,11 +790,14 @@ func (im *IndexManager) getAllUniquePairs() ([]memtable.KVPair, error) {
 // serializePairs writes key-value pairs to disk in the SSTable format.
 // Returns an error if the pairs cannot be written.
 func (im *IndexManager) serializePairs(w io.Writer, pairs []memtable.KVPair, metadata *TableMetadata) error {
-	// isLevel
+	// isLevel, the depth of the levels of the leveled compaction
 	byteToWrite := byte(0x00)
 	if metadata.IsLevel {
 		byteToWrite = byte(0xFF)
 	}
+	if metadata.Depth > 0 {
+		byteToWrite = byte(metadata.Depth)
+	}
 	err := binary.Write(w, binary.LittleEndian, byteToWrite)
 	if err != nil {
 		return err
@@ -386,8 +829,18 @@ func (im *IndexManager) serializePairs(w io.Writer, pairs []memtable.KVPair, met
 	if err != nil {
 		return err
 	}
+	// write min and max timestamps
+	err = binary.Write(w, binary.LittleEndian, metadata.MinTime)
+	if err != nil {
+		return err
+	}
+	err = binary.Write(w, binary.LittleEndian, metadata.MaxTime)
+	if err != nil {
+		return err
+	}
 
-	// write pairs
+	// write pairs, the expiries, the sequence numbers, the extents and the inline values follow them
+	trailing := []byte{}
 	for _, pair := range pairs {
 		keyAsBytes, err := shared.KeyToBytes(pair.Key, im.config.KeySize)
 		if err != nil {
@@ -397,7 +850,36 @@ func (im *IndexManager) serializePairs(w io.Writer, pairs []memtable.KVPair, met
 		if err != nil {
 			return err
 		}
-		err = binary.Write(w, binary.LittleEndian, pair.Value.Offset)
+		offset := pair.Value.Offset
+		extents := pair.Value.Inline == nil && len(pair.Value.Extents) > 0
+		if pair.Value.ExpiresAt != 0 || pair.Value.Sequence != 0 || pair.Value.Inline != nil || extents {
+			offset = uint32(len(trailing))
+		}
+		if pair.Value.ExpiresAt != 0 {
+			trailing = binary.LittleEndian.AppendUint64(trailing, uint64(pair.Value.ExpiresAt))
+		}
+		if pair.Value.Sequence != 0 {
+			trailing = binary.LittleEndian.AppendUint64(trailing, pair.Value.Sequence)
+		}
+		if (pair.Value.ExpiresAt != 0 || pair.Value.Sequence != 0 || extents) && pair.Value.Inline == nil {
+			trailing = binary.LittleEndian.AppendUint32(trailing, pair.Value.Offset)
+		}
+		if extents {
+			trailing = binary.LittleEndian.AppendUint32(trailing, uint32(len(pair.Value.Extents)))
+			for _, extent := range pair.Value.Extents {
+				trailing = binary.LittleEndian.AppendUint32(trailing, extent.Offset)
+				trailing = binary.LittleEndian.AppendUint32(trailing, extent.Size)
+				compressed := byte(0)
+				if extent.Compressed {
+					compressed = 1
+				}
+				trailing = append(trailing, compressed)
+			}
+		}
+		if pair.Value.Inline != nil {
+			trailing = append(trailing, pair.Value.Inline...)
+		}
+		err = binary.Write(w, binary.LittleEndian, offset)
 		if err != nil {
 			return err
 		}
@@ -405,18 +887,94 @@ func (im *IndexManager) serializePairs(w io.Writer, pairs []memtable.KVPair, met
 		if err != nil {
 			return err
 		}
+		err = binary.Write(w, binary.LittleEndian, pair.Value.Timestamp)
+		if err != nil {
+			return err
+		}
+		flags := byte(0)
+		if pair.Value.Deleted {
+			flags |= flagDeleted
+		}
+		if pair.Value.Compressed {
+			flags |= flagCompressed
+		}
+		if pair.Value.Inline != nil {
+			flags |= flagInline
+		}
+		if pair.Value.ExpiresAt != 0 {
+			flags |= flagExpires
+		}
+		if pair.Value.Sequence != 0 {
+			flags |= flagSequence
+		}
+		if extents {
+			flags |= flagExtents
+		}
+		err = binary.Write(w, binary.LittleEndian, flags)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write(trailing); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-// sortTablesBySerial sorts the list of SSTables and levels by their serial numbers in descending order.
+// tables returns the sstables followed by the levels, newest first.
+func (im *IndexManager) tables() []*SSTable {
+	tables := make([]*SSTable, 0, len(im.sstables)+len(im.levels))
+	tables = append(tables, im.sstables...)
+	return append(tables, im.levels...)
+}
+
+// OpenTableFiles opens the files of the current tables and of their bloom
+// filters. The open files keep reading the tables as they are now, even when a
+// compaction removes them or a relocation rewrites them meanwhile. The caller
+// closes them.
+func (im *IndexManager) OpenTableFiles() ([]*os.File, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	files := []*os.File{}
+	for _, table := range im.tables() {
+		for _, path := range []string{table.metadata.Path, table.metadata.Path + filterSuffix} {
+			file, err := os.Open(path)
+			if os.IsNotExist(err) && path != table.metadata.Path {
+				// a table without its filter is searched without one
+				continue
+			}
+			if err != nil {
+				for _, file := range files {
+					file.Close()
+				}
+				return nil, fmt.Errorf("index manager can not open table %d: %v", table.metadata.Serial, err)
+			}
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// timeBounds returns the oldest and newest timestamps among pairs.
+func timeBounds(pairs []memtable.KVPair) (int64, int64) {
+	minTime, maxTime := int64(math.MaxInt64), int64(math.MinInt64)
+	for _, pair := range pairs {
+		minTime = min(minTime, pair.Value.Timestamp)
+		maxTime = max(maxTime, pair.Value.Timestamp)
+	}
+	return minTime, maxTime
+}
+
+// sortTablesBySerial sorts the list of SSTables and levels by their serial numbers in descending order,
+// the levels with a depth going first, see searchedBefore.
 func (im *IndexManager) sortTablesBySerial() {
 	sort.Slice(im.sstables, func(i, j int) bool {
 		return im.sstables[i].metadata.Serial > im.sstables[j].metadata.Serial
 	})
 
 	sort.Slice(im.levels, func(i, j int) bool {
-		return im.levels[i].metadata.Serial > im.levels[j].metadata.Serial
+		return searchedBefore(im.levels[i].metadata, im.levels[j].metadata)
 	})
 }
@@ -0,0 +1,122 @@
+package index_manager
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// ManifestFile lists the tables with their metadata, so opening the database
+// does not have to read the header of every table.
+const ManifestFile = "manifest.json"
+
+// manifestEntry describes a table as it was when the manifest was written. The
+// file size and modification time tell whether the table changed since.
+type manifestEntry struct {
+	Name     string
+	FileSize int64
+	ModTime  int64
+	IsLevel  bool
+	Depth    int
+	Serial   uint32
+	Size     uint32
+	MinKey   string
+	MaxKey   string
+	MinTime  int64
+	MaxTime  int64
+}
+
+// readManifest returns the entries of the manifest by table name. A missing or
+// unreadable manifest yields no entries, the tables are read the slow way then.
+func (im *IndexManager) readManifest() map[string]manifestEntry {
+	entries := map[string]manifestEntry{}
+
+	data, err := os.ReadFile(filepath.Join(im.config.Homepath, ManifestFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("index manager: failed to read the manifest: %v\n", err)
+		}
+		return entries
+	}
+
+	stored := []manifestEntry{}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		log.Printf("index manager: failed to parse the manifest: %v\n", err)
+		return entries
+	}
+
+	for _, entry := range stored {
+		entries[entry.Name] = entry
+	}
+	return entries
+}
+
+// openFromManifest adds the table described by the manifest entry without
+// opening its file, reporting false if the file no longer matches the entry.
+func (im *IndexManager) openFromManifest(entry manifestEntry, info os.FileInfo) bool {
+	if info.Size() != entry.FileSize || info.ModTime().UnixNano() != entry.ModTime {
+		return false
+	}
+
+	table := newLazySSTable(TableMetadata{
+		Path:    filepath.Join(im.config.Homepath, entry.Name),
+		IsLevel: entry.IsLevel,
+		Depth:   entry.Depth,
+		Serial:  entry.Serial,
+		Size:    entry.Size,
+		MinKey:  entry.MinKey,
+		MaxKey:  entry.MaxKey,
+		MinTime: entry.MinTime,
+		MaxTime: entry.MaxTime,
+
+		CreatedAt: entry.ModTime,
+	}, im.config, im.blockCache)
+	im.addTable(table)
+	return true
+}
+
+// saveManifest records the current tables in the manifest. It only speeds up
+// the next open, so failures are logged and otherwise ignored.
+func (im *IndexManager) saveManifest() {
+	entries := []manifestEntry{}
+	for _, table := range im.tables() {
+		info, err := os.Stat(table.metadata.Path)
+		if err != nil {
+			log.Printf("index manager: failed to save the manifest: %v\n", err)
+			return
+		}
+
+		metadata := table.metadata
+		entries = append(entries, manifestEntry{
+			Name:     filepath.Base(metadata.Path),
+			FileSize: info.Size(),
+			ModTime:  info.ModTime().UnixNano(),
+			IsLevel:  metadata.IsLevel,
+			Depth:    metadata.Depth,
+			Serial:   metadata.Serial,
+			Size:     metadata.Size,
+			MinKey:   metadata.MinKey,
+			MaxKey:   metadata.MaxKey,
+			MinTime:  metadata.MinTime,
+			MaxTime:  metadata.MaxTime,
+		})
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		log.Printf("index manager: failed to save the manifest: %v\n", err)
+		return
+	}
+
+	path := filepath.Join(im.config.Homepath, ManifestFile)
+	tmp := path + tmpSuffix
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("index manager: failed to save the manifest: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("index manager: failed to save the manifest: %v\n", err)
+		os.Remove(tmp)
+	}
+}
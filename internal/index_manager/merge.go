@@ -0,0 +1,239 @@
+package index_manager
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+)
+
+// pairSource yields pairs in ascending key order through next, and in
+// descending order through prev.
+type pairSource interface {
+	next() (memtable.KVPair, bool, error)
+	prev() (memtable.KVPair, bool, error)
+	// seek positions the source between the last pair less than key and the
+	// first one not less than it, next yields the latter and prev the former.
+	seek(key string) error
+	last() error // Positions the source after its last pair.
+}
+
+// sliceSource walks pairs that are already in memory, like the memtable items.
+type sliceSource struct {
+	pairs []memtable.KVPair
+	pos   int
+}
+
+func (s *sliceSource) next() (memtable.KVPair, bool, error) {
+	if s.pos >= len(s.pairs) {
+		return memtable.KVPair{}, false, nil
+	}
+	s.pos++
+	return s.pairs[s.pos-1], true, nil
+}
+
+func (s *sliceSource) prev() (memtable.KVPair, bool, error) {
+	if s.pos <= 0 {
+		return memtable.KVPair{}, false, nil
+	}
+	s.pos--
+	return s.pairs[s.pos], true, nil
+}
+
+func (s *sliceSource) seek(key string) error {
+	s.pos = sort.Search(len(s.pairs), func(i int) bool {
+		return s.pairs[i].Key >= key
+	})
+	return nil
+}
+
+func (s *sliceSource) last() error {
+	s.pos = len(s.pairs)
+	return nil
+}
+
+// tableSource walks the pairs of a table one at a time.
+type tableSource struct {
+	table    *SSTable
+	pos      int
+	stats    *ScanStats // Counts the entries read from disk when set.
+	uncached bool       // Skips the block cache, for compactions reading every pair once.
+}
+
+func (s *tableSource) next() (memtable.KVPair, bool, error) {
+	if s.pos >= int(s.table.metadata.Size) {
+		return memtable.KVPair{}, false, nil
+	}
+	pair, err := s.read(s.pos)
+	if err != nil {
+		return memtable.KVPair{}, false, err
+	}
+	s.pos++
+	return pair, true, nil
+}
+
+func (s *tableSource) prev() (memtable.KVPair, bool, error) {
+	if s.pos <= 0 {
+		return memtable.KVPair{}, false, nil
+	}
+	pair, err := s.read(s.pos - 1)
+	if err != nil {
+		return memtable.KVPair{}, false, err
+	}
+	s.pos--
+	return pair, true, nil
+}
+
+// seek binary searches the table for key.
+func (s *tableSource) seek(key string) error {
+	low, high := 0, int(s.table.metadata.Size)
+	for low < high {
+		mid := int(uint(low+high) >> 1)
+		pair, err := s.read(mid)
+		if err != nil {
+			return err
+		}
+		if pair.Key < key {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	s.pos = low
+	return nil
+}
+
+func (s *tableSource) last() error {
+	s.pos = int(s.table.metadata.Size)
+	return nil
+}
+
+func (s *tableSource) read(n int) (memtable.KVPair, error) {
+	if s.stats != nil && s.table.index.Load() == nil {
+		s.stats.EntriesRead++
+		s.stats.BytesRead += int64(s.table.config.GetKVPairSize())
+	}
+	return s.table.readPair(n, !s.uncached)
+}
+
+type mergeItem struct {
+	pair   memtable.KVPair
+	rank   int // Position of the source, lower is more recent.
+	source pairSource
+}
+
+type mergeHeap struct {
+	items   []mergeItem
+	reverse bool // The greatest key comes first, for walking backwards.
+}
+
+func (h *mergeHeap) Len() int { return len(h.items) }
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if a.pair.Key != b.pair.Key {
+		return (a.pair.Key < b.pair.Key) != h.reverse
+	}
+	// the later write wins, the most recent source when either is not numbered
+	if a, b := a.pair.Value.Sequence, b.pair.Value.Sequence; a != 0 && b != 0 && a != b {
+		return a > b
+	}
+	return a.rank < b.rank
+}
+func (h *mergeHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *mergeHeap) Push(x any)    { h.items = append(h.items, x.(mergeItem)) }
+func (h *mergeHeap) Pop() any {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
+
+// mergeIterator walks several sorted sources at once and yields every key once,
+// in ascending order, or in descending order once positioned by seekBefore or
+// last. When sources share a key the entry with the higher sequence number
+// wins, or the one of the most recent source when they are not both numbered.
+// Deleted keys are yielded too, it is up to the caller to skip them.
+type mergeIterator struct {
+	sources []pairSource
+	h       mergeHeap
+	lastKey string
+	started bool
+}
+
+// newMergeIterator takes the sources ordered from the most to the least recent.
+func newMergeIterator(sources []pairSource) (*mergeIterator, error) {
+	it := &mergeIterator{sources: sources}
+	for rank, source := range sources {
+		if err := it.push(source, rank); err != nil {
+			return nil, err
+		}
+	}
+	return it, nil
+}
+
+// seek moves every source to key, the next pair yielded is the first one not less than key.
+func (it *mergeIterator) seek(key string) error {
+	return it.position(false, func(source pairSource) error { return source.seek(key) })
+}
+
+// seekBefore moves every source to key and turns the merge backwards, the next
+// pair yielded is the last one less than key.
+func (it *mergeIterator) seekBefore(key string) error {
+	return it.position(true, func(source pairSource) error { return source.seek(key) })
+}
+
+// last moves every source past its end and turns the merge backwards, the next
+// pair yielded is the last one.
+func (it *mergeIterator) last() error {
+	return it.position(true, pairSource.last)
+}
+
+// position moves every source with move and refills the heap in the direction reverse sets.
+func (it *mergeIterator) position(reverse bool, move func(pairSource) error) error {
+	it.h.items = it.h.items[:0]
+	it.h.reverse = reverse
+	it.started = false
+	for rank, source := range it.sources {
+		if err := move(source); err != nil {
+			return err
+		}
+		if err := it.push(source, rank); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (it *mergeIterator) push(source pairSource, rank int) error {
+	step := source.next
+	if it.h.reverse {
+		step = source.prev
+	}
+	pair, ok, err := step()
+	if err != nil {
+		return err
+	}
+	if ok {
+		heap.Push(&it.h, mergeItem{pair: pair, rank: rank, source: source})
+	}
+	return nil
+}
+
+// next yields the following pair in the direction the merge walks.
+func (it *mergeIterator) next() (memtable.KVPair, bool, error) {
+	for it.h.Len() > 0 {
+		item := heap.Pop(&it.h).(mergeItem)
+		if err := it.push(item.source, item.rank); err != nil {
+			return memtable.KVPair{}, false, err
+		}
+
+		// older entries of a key that was already yielded
+		if it.started && item.pair.Key == it.lastKey {
+			continue
+		}
+
+		it.started = true
+		it.lastKey = item.pair.Key
+		return item.pair, true, nil
+	}
+	return memtable.KVPair{}, false, nil
+}
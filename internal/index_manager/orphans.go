@@ -0,0 +1,71 @@
+package index_manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// OrphanFile is a file in the homepath the engine does not use.
+type OrphanFile struct {
+	Name      string
+	Size      int64
+	Reason    string
+	Removable bool // Left behind by the engine, so removing it is safe.
+}
+
+// OrphanFiles lists the files in the homepath that are neither an open table,
+// the filter of one, nor one of the engine files: temporary files of interrupted
+// writes, table files that could not be read, filters of missing tables, and
+// files the engine knows nothing about. All but the unknown files are removable,
+// those may belong to somebody else. The caller makes sure no WAL rewrite runs
+// meanwhile, its temporary file would look orphaned.
+func (im *IndexManager) OrphanFiles() ([]OrphanFile, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	files, err := os.ReadDir(im.config.Homepath)
+	if err != nil {
+		return nil, fmt.Errorf("index manager can not list the home directory: %v", err)
+	}
+
+	open := map[string]struct{}{}
+	for _, table := range im.tables() {
+		open[filepath.Base(table.metadata.Path)] = struct{}{}
+	}
+
+	orphans := []OrphanFile{}
+	for _, file := range files {
+		name := file.Name()
+		if _, ok := open[name]; ok || shared.IsEngineFile(name) || (name == trashDir && file.IsDir()) {
+			continue
+		}
+
+		orphan := OrphanFile{Name: name}
+		switch {
+		case strings.HasSuffix(name, filterSuffix):
+			if _, ok := open[strings.TrimSuffix(name, filterSuffix)]; ok {
+				continue
+			}
+			orphan.Reason = "bloom filter of a missing table"
+			orphan.Removable = !file.IsDir()
+		case strings.HasSuffix(name, tmpSuffix):
+			orphan.Reason = "left behind by an interrupted write"
+			orphan.Removable = true
+		case strings.HasPrefix(name, im.config.SSTableNamePrefix) || strings.HasPrefix(name, im.config.LevelFileNamePrefix):
+			orphan.Reason = "table that could not be read"
+			orphan.Removable = !file.IsDir()
+		default:
+			orphan.Reason = "unknown file"
+		}
+		if info, err := file.Info(); err == nil {
+			orphan.Size = info.Size()
+		}
+		orphans = append(orphans, orphan)
+	}
+
+	return orphans, nil
+}
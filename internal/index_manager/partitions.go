@@ -0,0 +1,203 @@
+package index_manager
+
+import (
+	"log"
+	"sort"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// Partitioned flushes write the keys of every partition to their own SSTable, so
+// the tables of a partition only overlap the tables of the same partition and
+// can be compacted apart. Tables written before partitioning was turned on, or
+// holding the keys without a separator, may span several partitions; they are
+// merged along with whatever they overlap, so newer entries keep shadowing older
+// ones whatever the layout.
+
+// flushRuns splits the sorted pairs of a flush into the runs written as tables.
+func (im *IndexManager) flushRuns(pairs []memtable.KVPair) [][]memtable.KVPair {
+	if im.config.FlushFormat != shared.FlushPartitioned {
+		return [][]memtable.KVPair{pairs}
+	}
+
+	runs := map[string][]memtable.KVPair{}
+	partitions := []string{}
+	for _, pair := range pairs {
+		partition := im.config.KeyPartition(pair.Key)
+		if _, ok := runs[partition]; !ok {
+			partitions = append(partitions, partition)
+		}
+		runs[partition] = append(runs[partition], pair)
+	}
+
+	sort.Strings(partitions)
+	result := make([][]memtable.KVPair, len(partitions))
+	for i, partition := range partitions {
+		result[i] = runs[partition]
+	}
+	return result
+}
+
+// tablePartition returns the partition of the keys of table, and false when they
+// span several partitions.
+func (im *IndexManager) tablePartition(table *SSTable) (string, bool) {
+	partition := im.config.KeyPartition(table.metadata.MinKey)
+	return partition, partition == im.config.KeyPartition(table.metadata.MaxKey)
+}
+
+// compactPartitions runs the compaction picker over the SSTables of every
+// partition on its own and merges the picked ones into a level per partition.
+func (im *IndexManager) compactPartitions() error {
+	groups := map[string][]*SSTable{}
+	for _, table := range im.sstables {
+		partition, ok := im.tablePartition(table)
+		if !ok {
+			// a table spanning partitions is compacted along with the ones it overlaps
+			continue
+		}
+		groups[partition] = append(groups[partition], table)
+	}
+
+	partitions := make([]string, 0, len(groups))
+	for partition := range groups {
+		partitions = append(partitions, partition)
+	}
+	sort.Strings(partitions)
+
+	for _, partition := range partitions {
+		group := groups[partition]
+		if !im.hasTables(group) {
+			// merged by the compaction of a partition it overlaps
+			continue
+		}
+
+		infos := make([]shared.TableInfo, len(group))
+		for i, table := range group {
+			infos[i] = table.Info()
+		}
+		picked := im.config.GetCompactionPicker().Pick(infos, im.config)
+		if len(picked) == 0 {
+			continue
+		}
+
+		newest := picked[0].Serial
+		for _, info := range picked {
+			newest = max(newest, info.Serial)
+		}
+		tables := []*SSTable{}
+		for _, table := range group {
+			if table.metadata.Serial <= newest {
+				tables = append(tables, table)
+			}
+		}
+
+		tables = im.olderOverlapping(tables)
+		log.Printf("index manager: compacting %d tables of partition %q\n", len(tables), partition)
+		if err := im.createLevel(tables, len(im.levels) > 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasTables reports whether all the tables are still SSTables.
+func (im *IndexManager) hasTables(tables []*SSTable) bool {
+	current := map[*SSTable]struct{}{}
+	for _, table := range im.sstables {
+		current[table] = struct{}{}
+	}
+	for _, table := range tables {
+		if _, ok := current[table]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// olderOverlapping adds to the SSTables about to be merged every SSTable that is
+// older than one of them and overlaps its keys. Left out, such a table would
+// shadow the newer entries once they are moved to a level.
+func (im *IndexManager) olderOverlapping(tables []*SSTable) []*SSTable {
+	merged := map[*SSTable]struct{}{}
+	for _, table := range tables {
+		merged[table] = struct{}{}
+	}
+
+	for added := true; added; {
+		added = false
+		for _, table := range im.sstables {
+			if _, ok := merged[table]; ok {
+				continue
+			}
+			for _, other := range tables {
+				if table.metadata.Serial < other.metadata.Serial && keysOverlap(table, other) {
+					merged[table] = struct{}{}
+					tables = append(tables, table)
+					added = true
+					break
+				}
+			}
+		}
+	}
+
+	// createLevel expects the tables from newest to oldest
+	sort.Slice(tables, func(i, j int) bool { return tables[i].metadata.Serial > tables[j].metadata.Serial })
+	return tables
+}
+
+// mergePartitionLevels merges the levels of every partition into one, along
+// with the levels overlapping them. Merging only part of the levels into the
+// newest one is safe once no other level holds their keys, so deleted keys are
+// dropped for good as well.
+func (im *IndexManager) mergePartitionLevels() error {
+	for {
+		var levels []*SSTable
+		for _, level := range im.levels {
+			levels = im.overlappingLevels(level)
+			if len(levels) > 1 {
+				break
+			}
+		}
+		if len(levels) < 2 {
+			return nil
+		}
+
+		log.Printf("index manager: merging %d levels of partition %q in the maintenance window\n", len(levels), im.config.KeyPartition(levels[0].metadata.MinKey))
+		if err := im.createLevel(levels, false); err != nil {
+			return err
+		}
+	}
+}
+
+// overlappingLevels returns level along with every level that overlaps it, or
+// overlaps one of those, from newest to oldest.
+func (im *IndexManager) overlappingLevels(level *SSTable) []*SSTable {
+	levels := []*SSTable{level}
+	merged := map[*SSTable]struct{}{level: {}}
+
+	for added := true; added; {
+		added = false
+		for _, candidate := range im.levels {
+			if _, ok := merged[candidate]; ok {
+				continue
+			}
+			for _, other := range levels {
+				if keysOverlap(candidate, other) {
+					merged[candidate] = struct{}{}
+					levels = append(levels, candidate)
+					added = true
+					break
+				}
+			}
+		}
+	}
+
+	sort.Slice(levels, func(i, j int) bool { return levels[i].metadata.Serial > levels[j].metadata.Serial })
+	return levels
+}
+
+// keysOverlap reports whether the key ranges of two tables overlap.
+func keysOverlap(a, b *SSTable) bool {
+	return a.metadata.MinKey <= b.metadata.MaxKey && b.metadata.MinKey <= a.metadata.MaxKey
+}
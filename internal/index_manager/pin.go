@@ -0,0 +1,38 @@
+package index_manager
+
+import (
+	"log"
+)
+
+// pinIndexes loads the indexes of the tables pinned by PinLevels or PinPrefixes
+// into memory. Pinned indexes stay loaded until their table is compacted away,
+// so scans over the rest of the data do not push them out of the page cache.
+func (im *IndexManager) pinIndexes() {
+	for _, table := range im.tables() {
+		if !im.pinned(table) {
+			continue
+		}
+		if err := table.LoadIndex(); err != nil {
+			log.Printf("index manager: failed to pin the index of table %d: %v\n", table.metadata.Serial, err)
+		}
+	}
+}
+
+// pinned reports whether the index of the table must stay in memory.
+func (im *IndexManager) pinned(table *SSTable) bool {
+	if !table.metadata.IsLevel && im.config.PinLevels > 0 {
+		return true
+	}
+	for i, level := range im.levels {
+		if level == table && i < im.config.PinLevels-1 {
+			return true
+		}
+	}
+
+	for _, prefix := range im.config.PinPrefixes {
+		if table.OverlapsPrefix(prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package index_manager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// ListPrefixes returns the keys directly under prefix and the child prefixes up to
+// the next delimiter, sorted and without duplicates. Child prefixes keep the
+// delimiter at their end. Once a child prefix is found the merge seeks past it,
+// so the keys under it are never walked one by one.
+func (im *IndexManager) ListPrefixes(prefix, delimiter string) ([]string, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	opts := NewScanOptions()
+	opts.Start, opts.End = prefix, shared.PrefixEnd(prefix)
+
+	it, err := im.mergeIterator(opts)
+	if err != nil {
+		return nil, fmt.Errorf("index manager can not merge tables: %v", err)
+	}
+
+	results := []string{}
+	for {
+		pair, ok, err := it.next()
+		if err != nil {
+			return nil, fmt.Errorf("index manager can not merge tables: %v", err)
+		}
+		if !ok || !strings.HasPrefix(pair.Key, prefix) {
+			break
+		}
+		if pair.Value.IsDeleted() || im.expired(pair.Key, pair.Value) || shared.IsSystemKey(pair.Key) {
+			continue
+		}
+
+		rest := pair.Key[len(prefix):]
+		i := strings.Index(rest, delimiter)
+		if delimiter == "" || i < 0 {
+			results = append(results, pair.Key)
+			continue
+		}
+
+		child := prefix + rest[:i+len(delimiter)]
+		results = append(results, child)
+
+		next := shared.PrefixEnd(child)
+		if next == "" {
+			break
+		}
+		if err := it.seek(next); err != nil {
+			return nil, fmt.Errorf("index manager can not merge tables: %v", err)
+		}
+	}
+
+	return results, nil
+}
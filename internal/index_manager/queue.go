@@ -0,0 +1,99 @@
+package index_manager
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// queueWatermarks remembers, per prefix consumed oldest first, the key below
+// which every key of the prefix is known to be deleted. Queues are consumed by
+// reading the first key and deleting it, so without the watermark each read
+// would walk over all the deleted keys again.
+type queueWatermarks struct {
+	mu     sync.Mutex
+	marks  map[string]string // prefix -> low watermark
+	writes uint64            // Number of writes seen by lower.
+}
+
+// get returns the watermark of prefix, along with the number of writes so far
+// to hand to set.
+func (q *queueWatermarks) get(prefix string) (string, bool, uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	mark, ok := q.marks[prefix]
+	return mark, ok, q.writes
+}
+
+// set moves the watermark of prefix to mark, unless a write came in since get
+// returned writes: a concurrent memtable takes writes alongside the read that
+// found mark, and one of them may be below it.
+func (q *queueWatermarks) set(prefix, mark string, writes uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.writes != writes {
+		return
+	}
+	if q.marks == nil {
+		q.marks = map[string]string{}
+	}
+	q.marks[prefix] = mark
+}
+
+// lower moves the watermarks above key back to it, a key written below a
+// watermark must be found by the next read.
+func (q *queueWatermarks) lower(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.writes++
+	for prefix, mark := range q.marks {
+		if key < mark && strings.HasPrefix(key, prefix) {
+			q.marks[prefix] = key
+		}
+	}
+}
+
+// First returns the live pair with the smallest key starting with prefix. The
+// search starts at the low watermark of the prefix, which is then moved up to
+// the pair found, so consuming a queue oldest first does not rescan the keys
+// deleted by earlier reads.
+func (im *IndexManager) First(prefix string) (memtable.KVPair, bool, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	opts := NewScanOptions()
+	opts.Start, opts.End = prefix, shared.PrefixEnd(prefix)
+	mark, ok, writes := im.queues.get(prefix)
+	if ok {
+		opts.Start = mark
+	}
+
+	it, err := im.mergeIterator(opts)
+	if err != nil {
+		return memtable.KVPair{}, false, fmt.Errorf("index manager can not merge tables: %v", err)
+	}
+
+	for {
+		pair, ok, err := it.next()
+		if err != nil {
+			return memtable.KVPair{}, false, fmt.Errorf("index manager can not merge tables: %v", err)
+		}
+		if !ok || (opts.End != "" && pair.Key >= opts.End) {
+			break
+		}
+		if pair.Value.IsDeleted() || im.expired(pair.Key, pair.Value) || shared.IsSystemKey(pair.Key) {
+			continue
+		}
+
+		im.queues.set(prefix, pair.Key, writes)
+		return pair, true, nil
+	}
+
+	if opts.End != "" {
+		im.queues.set(prefix, opts.End, writes)
+	}
+	return memtable.KVPair{}, false, nil
+}
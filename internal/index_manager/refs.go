@@ -0,0 +1,60 @@
+package index_manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+)
+
+// ValueRefs counts the keys pointing at every value record, by offset in the
+// value file. Several keys share a record once linked or copied with a range
+// copy, so a record may only be reclaimed when no key refers to it anymore.
+// Keys deleted softly and the dropped prefixes in the trash still refer to their
+// values, they can be restored.
+func (im *IndexManager) ValueRefs() (map[uint32]int, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	refs := map[uint32]int{}
+	count := func(pair memtable.KVPair) {
+		if pair.Value.Size == 0 || pair.Value.Inline != nil || im.expired(pair.Key, pair.Value) {
+			return
+		}
+		for _, record := range pair.Value.Records() {
+			refs[record.Offset]++
+		}
+	}
+
+	it, err := im.mergeIterator(NewScanOptions())
+	if err != nil {
+		return nil, fmt.Errorf("index manager can not merge tables: %v", err)
+	}
+	for {
+		pair, ok, err := it.next()
+		if err != nil {
+			return nil, fmt.Errorf("index manager can not merge tables: %v", err)
+		}
+		if !ok {
+			break
+		}
+		count(pair)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(im.config.Homepath, trashDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("index manager can not list the trash: %v", err)
+	}
+	for _, entry := range entries {
+		pairs, err := im.ReadTrash(entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("index manager can not read trash %q: %v", entry.Name(), err)
+		}
+		for _, pair := range pairs {
+			count(pair)
+		}
+	}
+
+	return refs, nil
+}
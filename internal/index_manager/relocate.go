@@ -0,0 +1,336 @@
+package index_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+)
+
+// relocationFile lists the files a value relocation swaps in. Once it is written
+// the swap is rolled forward, by RecoverRelocation after a crash.
+const relocationFile = "relocation.json"
+
+// relocationSuffix marks the files staged by a relocation, removed on open when
+// the relocation never got to write its relocation file.
+const relocationSuffix = ".gc" + tmpSuffix
+
+// relocation is a file staged by a relocation and the file it replaces.
+type relocation struct {
+	From string
+	To   string
+}
+
+// LiveValues returns the value records the index still refers to, sorted by
+// offset and each listed once: the values of the newest entry of every key, the
+// ones of keys deleted softly that can still be restored, and the ones of the
+// prefixes dropped to the trash. Values only older entries refer to, and the
+// values of expired keys, are garbage.
+func (im *IndexManager) LiveValues() ([]memtable.IndexNode, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	live := map[uint32]memtable.IndexNode{}
+	it, err := im.mergeIterator(NewScanOptions())
+	if err != nil {
+		return nil, fmt.Errorf("index manager can not merge tables: %v", err)
+	}
+	for {
+		pair, ok, err := it.next()
+		if err != nil {
+			return nil, fmt.Errorf("index manager can not merge tables: %v", err)
+		}
+		if !ok {
+			break
+		}
+		if im.holdsValue(pair) {
+			for _, record := range pair.Value.Records() {
+				live[record.Offset] = record
+			}
+		}
+	}
+
+	err = im.eachTrash(func(table *SSTable) error {
+		pairs, err := table.KVPairs()
+		if err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			for _, record := range pair.Value.Records() {
+				live[record.Offset] = record
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]memtable.IndexNode, 0, len(live))
+	for _, node := range live {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Offset < nodes[j].Offset })
+	return nodes, nil
+}
+
+// holdsValue reports whether the newest entry of a key still needs its value.
+func (im *IndexManager) holdsValue(pair memtable.KVPair) bool {
+	node := pair.Value
+	if node.Size == 0 || node.Inline != nil || im.expired(pair.Key, node) {
+		return false
+	}
+	return !node.Deleted || time.Since(time.Unix(0, node.Timestamp)) <= im.config.UndeleteWindow
+}
+
+// eachTrash calls fn with every table of the trash.
+func (im *IndexManager) eachTrash(fn func(table *SSTable) error) error {
+	entries, err := os.ReadDir(filepath.Join(im.config.Homepath, trashDir))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("index manager can not list the trash: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), tmpSuffix) {
+			continue
+		}
+		table, err := im.openTrash(entry.Name())
+		if err != nil {
+			return fmt.Errorf("index manager can not read trash %q: %v", entry.Name(), err)
+		}
+		err = fn(table)
+		table.Close()
+		if err != nil {
+			return fmt.Errorf("index manager can not read trash %q: %v", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// RelocateValues points the memtable and every table, the trash included, at
+// the new offsets of the values moved by a garbage collection of the value file,
+// given by their old offsets. Entries whose value did not move lost it to the
+// collection and become plain deletes. The tables are rewritten to staged files,
+// then swapped in along with the extra staged files given, like the new value
+// file, all at once: a crash in the middle leaves either the old files or,
+// through RecoverRelocation, the new ones. The staged files are removed when
+// the relocation fails before the swap.
+func (im *IndexManager) RelocateValues(moved map[uint32]uint32, extra map[string]string) error {
+	im.compactMu.Lock()
+	defer im.compactMu.Unlock()
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if im.frozen != nil {
+		return fmt.Errorf("index manager can not relocate the values while a memtable is being flushed")
+	}
+
+	relocate := func(pairs []memtable.KVPair) {
+		for i := range pairs {
+			node := &pairs[i].Value
+			if node.Size == 0 || node.Inline != nil {
+				continue
+			}
+			if !node.Relocate(moved) {
+				*node = memtable.IndexNode{Timestamp: node.Timestamp}
+			}
+		}
+	}
+
+	// the extra files are removed along with the staged tables if this fails
+	relocations := []relocation{}
+	for from, to := range extra {
+		relocations = append(relocations, relocation{From: from, To: to})
+	}
+	stage := func(table *SSTable) error {
+		pairs, err := table.KVPairs()
+		if err != nil {
+			return err
+		}
+		relocate(pairs)
+		staged, err := im.stageTable(table, pairs)
+		if err != nil {
+			return err
+		}
+		relocations = append(relocations, relocation{From: staged, To: table.metadata.Path})
+		return nil
+	}
+
+	for _, table := range im.tables() {
+		if err := stage(table); err != nil {
+			im.removeStaged(relocations)
+			return fmt.Errorf("index manager can not relocate the values of table %d: %v", table.metadata.Serial, err)
+		}
+	}
+	if err := im.eachTrash(stage); err != nil {
+		im.removeStaged(relocations)
+		return err
+	}
+
+	if err := im.writeRelocation(relocations); err != nil {
+		im.removeStaged(relocations)
+		return fmt.Errorf("index manager can not record the relocation: %v", err)
+	}
+
+	// the relocation is committed, what follows is redone on open if interrupted
+	items := im.Memtable.Items()
+	relocate(items)
+	for _, pair := range items {
+		im.Memtable.Set(pair.Key, pair.Value)
+	}
+	for _, table := range im.tables() {
+		table.Close()
+	}
+	if err := applyRelocation(im.config.Homepath); err != nil {
+		return fmt.Errorf("index manager can not apply the relocation: %v", err)
+	}
+	im.reopenTables()
+
+	log.Printf("index manager: relocated the values of %d tables\n", len(relocations)-len(extra))
+	return nil
+}
+
+// stageTable writes the pairs as the staged replacement of table. The staged
+// file keeps the modification time of the table, which stands for its
+// creation time.
+func (im *IndexManager) stageTable(table *SSTable, pairs []memtable.KVPair) (string, error) {
+	staged := table.metadata.Path + relocationSuffix
+	file, err := os.Create(staged)
+	if err != nil {
+		return "", err
+	}
+
+	checksum := crc32.NewIEEE()
+	metadata := table.metadata
+	err = im.serializePairs(io.MultiWriter(file, checksum), pairs, &metadata)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = im.verifyTable(staged, checksum.Sum32())
+	}
+	if err == nil {
+		var info os.FileInfo
+		if info, err = os.Stat(table.metadata.Path); err == nil {
+			err = os.Chtimes(staged, info.ModTime(), info.ModTime())
+		}
+	}
+	if err != nil {
+		os.Remove(staged)
+		return "", err
+	}
+	return staged, nil
+}
+
+func (im *IndexManager) removeStaged(relocations []relocation) {
+	for _, r := range relocations {
+		if err := os.Remove(r.From); err != nil && !os.IsNotExist(err) {
+			log.Printf("index manager: failed to remove %q: %v\n", r.From, err)
+		}
+	}
+}
+
+// writeRelocation durably records the relocations, committing them.
+func (im *IndexManager) writeRelocation(relocations []relocation) error {
+	data, err := json.Marshal(relocations)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(im.config.Homepath, relocationFile)
+	tmp := path + tmpSuffix
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	file, err := os.Open(tmp)
+	if err == nil {
+		err = file.Sync()
+		file.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(im.config.Homepath)
+}
+
+// reopenTables replaces every table with a fresh handle on its file, after the
+// file was swapped. The tables are opened lazily, their metadata is unchanged.
+func (im *IndexManager) reopenTables() {
+	reopen := func(tables []*SSTable) {
+		for i, table := range tables {
+			tables[i] = newLazySSTable(table.metadata, im.config, im.blockCache)
+		}
+	}
+	reopen(im.sstables)
+	reopen(im.levels)
+	im.saveManifest()
+	im.pinIndexes()
+}
+
+// RecoverRelocation finishes the relocation a crash interrupted in the database
+// at homepath, or removes the files it staged when it was not committed yet. It
+// has to run before the tables and the value file are opened.
+func RecoverRelocation(homepath string) error {
+	if _, err := os.Stat(filepath.Join(homepath, relocationFile)); err == nil {
+		log.Printf("index manager: finishing an interrupted relocation of the values\n")
+		return applyRelocation(homepath)
+	}
+
+	for _, dir := range []string{homepath, filepath.Join(homepath, trashDir)} {
+		staged, _ := filepath.Glob(filepath.Join(dir, "*"+relocationSuffix))
+		for _, path := range staged {
+			log.Printf("index manager: removing %q left behind by an interrupted relocation\n", path)
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyRelocation renames the staged files of the recorded relocation over the
+// ones they replace and drops the record. Files already renamed are skipped, so
+// it can be run again after a crash.
+func applyRelocation(homepath string) error {
+	path := filepath.Join(homepath, relocationFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	relocations := []relocation{}
+	if err := json.Unmarshal(data, &relocations); err != nil {
+		return fmt.Errorf("can not parse %q: %v", path, err)
+	}
+
+	dirs := map[string]struct{}{}
+	for _, r := range relocations {
+		if err := os.Rename(r.From, r.To); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		dirs[filepath.Dir(r.To)] = struct{}{}
+	}
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return syncDir(homepath)
+}
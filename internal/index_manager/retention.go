@@ -0,0 +1,81 @@
+package index_manager
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+)
+
+// expired reports whether the entry for key fell out of its retention window or
+// outlived its TTL.
+func (im *IndexManager) expired(key string, node memtable.IndexNode) bool {
+	return node.Expired() || im.config.Expired(key, node.Timestamp)
+}
+
+// dropExpiredTables deletes SSTables and levels whose entries all fell out of
+// their retention window. This is a FIFO drop: whole files are removed without
+// being read, so log-style data ages out without rewriting anything.
+func (im *IndexManager) dropExpiredTables() error {
+	if len(im.config.Retention) == 0 {
+		return nil
+	}
+
+	sstables, levels := len(im.sstables), len(im.levels)
+	im.sstables = im.dropExpired(im.sstables)
+	im.levels = im.dropExpired(im.levels)
+	if len(im.sstables) != sstables || len(im.levels) != levels {
+		im.saveManifest()
+		im.pinIndexes()
+	}
+
+	return nil
+}
+
+func (im *IndexManager) dropExpired(tables []*SSTable) []*SSTable {
+	kept := []*SSTable{}
+	for _, table := range tables {
+		if !im.tableExpired(table) {
+			kept = append(kept, table)
+			continue
+		}
+
+		table.Close() // TODO handle closing errors
+		if err := os.Remove(table.metadata.Path); err != nil {
+			log.Printf("index manager: failed to drop expired table %d: %v", table.metadata.Serial, err)
+			kept = append(kept, table)
+			continue
+		}
+		removeFilter(table.metadata.Path)
+		im.reclaim([]*SSTable{table}, 0)
+		log.Printf("index manager: dropped expired table %d", table.metadata.Serial)
+	}
+	return kept
+}
+
+// tableExpired reports whether every key the table can hold is governed by a
+// retention policy and even the newest entry is older than the most permissive
+// of those policies.
+func (im *IndexManager) tableExpired(table *SSTable) bool {
+	minKey, maxKey := table.metadata.MinKey, table.metadata.MaxKey
+
+	covered := false
+	var maxAge time.Duration
+	for _, policy := range im.config.Retention {
+		// every key between minKey and maxKey shares this prefix
+		if strings.HasPrefix(minKey, policy.Prefix) && strings.HasPrefix(maxKey, policy.Prefix) {
+			covered = true
+			maxAge = max(maxAge, policy.MaxAge)
+			continue
+		}
+
+		// a more specific policy may govern some of the keys in the table
+		if policy.Prefix <= maxKey && policy.Prefix+"\xff" >= minKey {
+			maxAge = max(maxAge, policy.MaxAge)
+		}
+	}
+
+	return covered && time.Since(time.Unix(0, table.metadata.MaxTime)) > maxAge
+}
@@ -0,0 +1,32 @@
+package index_manager
+
+import "github.com/hasssanezzz/goldb/internal/shared"
+
+// Shape is a picture of the memtables and the tables at one point.
+type Shape struct {
+	MemtableKeys   uint32
+	FrozenKeys     uint32                // Keys of the memtable waiting to be flushed, zero when none is.
+	SSTables       []shared.TableInfo    // Newest first.
+	Levels         []shared.TableInfo    // In the order they are searched.
+	NextCompaction shared.CompactionStep // What the strategy runs next, always empty for partitioned flushes.
+}
+
+// Shape returns the current memtables and tables, and the compaction step the
+// strategy would pick next.
+func (im *IndexManager) Shape() Shape {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	shape := Shape{
+		MemtableKeys: im.Memtable.Len(),
+		SSTables:     tableInfos(im.sstables),
+		Levels:       tableInfos(im.levels),
+	}
+	if im.frozen != nil {
+		shape.FrozenKeys = im.frozen.table.Len()
+	}
+	if im.config.FlushFormat != shared.FlushPartitioned {
+		shape.NextCompaction = im.config.GetCompactionStrategy().Next(shape.SSTables, shape.Levels, im.config)
+	}
+	return shape
+}
@@ -1,33 +1,67 @@
 package index_manager
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
-	"io"
 	"os"
+	"sync"
+	"sync/atomic"
 
+	"github.com/hasssanezzz/goldb/internal/blockcache"
 	"github.com/hasssanezzz/goldb/internal/memtable"
 	"github.com/hasssanezzz/goldb/internal/shared"
 )
 
+// Bits of the flags byte stored with every pair.
+const (
+	flagDeleted    byte = 1 << 0
+	flagCompressed byte = 1 << 1
+	flagInline     byte = 1 << 2 // The value follows the pairs, offset is relative to the first one.
+	flagExpires    byte = 1 << 3 // The expiry follows the pairs, before the value offset or the inline value.
+	flagSequence   byte = 1 << 4 // The sequence number follows the pairs, after the expiry.
+	flagExtents    byte = 1 << 5 // The extents appended to the value follow the pairs, after the value offset.
+)
+
+// extentSize is the size of an extent kept after the pairs.
+const extentSize = shared.UintSize*2 + 1
+
+// blockSize is the size of the runs of pairs read and cached at once when the
+// block cache is enabled.
+const blockSize = 4096
+
 type TableMetadata struct {
 	Path    string
 	IsLevel bool
+	Depth   int // Level of the leveled compaction the level belongs to, from 1, zero for the other levels.
 	Serial  uint32
 	Size    uint32
 	MinKey  string
 	MaxKey  string
+	MinTime int64 // Oldest entry timestamp in unix nanoseconds.
+	MaxTime int64 // Newest entry timestamp in unix nanoseconds.
+
+	CreatedAt int64 // Time the table file was written in unix nanoseconds, tables are never modified.
 }
 
 type SSTable struct {
 	metadata TableMetadata
 	config   *shared.EngineConfig
-	file     io.ReadSeekCloser
+	file     *os.File
+	index    atomic.Pointer[[]memtable.KVPair] // In-memory copy of the pairs, set by LoadIndex.
+	openMu   sync.Mutex                        // Guards opening the file of a lazy table.
+	readers  int                               // Cursors reading the table, guarded by openMu.
+	retired  bool                              // Closed while cursors read it, the last one closes the file.
+
+	filter     *bloomFilter // Keys the table may hold, nil if the table has no usable filter.
+	filterOnce sync.Once    // Loads the filter on the first lookup.
+
+	cache      *blockcache.Cache // Blocks read from the table, nil when the block cache is disabled.
+	cacheOwner uint64            // Id of the table in the cache, the file never changes under it.
 }
 
-func NewSSTable(metadata TableMetadata, config *shared.EngineConfig) (*SSTable, error) {
-	table := &SSTable{config: config}
-	table.metadata = metadata
+func NewSSTable(metadata TableMetadata, config *shared.EngineConfig, cache *blockcache.Cache) (*SSTable, error) {
+	table := newLazySSTable(metadata, config, cache)
 
 	if err := table.open(); err != nil {
 		return nil, err
@@ -36,13 +70,40 @@ func NewSSTable(metadata TableMetadata, config *shared.EngineConfig) (*SSTable,
 	return table, nil
 }
 
+// newLazySSTable returns a table whose metadata is already known, its file is
+// only opened on the first access.
+func newLazySSTable(metadata TableMetadata, config *shared.EngineConfig, cache *blockcache.Cache) *SSTable {
+	return &SSTable{config: config, metadata: metadata, cache: cache, cacheOwner: blockcache.NewOwner()}
+}
+
 func (s *SSTable) open() error {
+	if err := s.ensureOpen(); err != nil {
+		return err
+	}
+	s.ParseMetadata()
+
+	info, err := s.file.Stat()
+	if err != nil {
+		return fmt.Errorf("can not stat sstable %q: %v", s.metadata.Path, err)
+	}
+	s.metadata.CreatedAt = info.ModTime().UnixNano()
+	return nil
+}
+
+// ensureOpen opens the file of a lazy table.
+func (s *SSTable) ensureOpen() error {
+	s.openMu.Lock()
+	defer s.openMu.Unlock()
+
+	if s.file != nil {
+		return nil
+	}
+
 	file, err := os.Open(s.metadata.Path)
 	if err != nil {
 		return fmt.Errorf("can not open sstable %q: %v", s.metadata.Path, err)
 	}
 	s.file = file
-	s.ParseMetadata()
 	return nil
 }
 
@@ -56,7 +117,10 @@ func (s *SSTable) ParseMetadata() error {
 	if err != nil {
 		return fmt.Errorf("can not read metadata from sstable %q: %v", s.metadata.Path, err)
 	}
-	s.metadata.IsLevel = isLevelBuffer[0] == 0xFF
+	s.metadata.IsLevel = isLevelBuffer[0] != 0x00
+	if s.metadata.IsLevel && isLevelBuffer[0] != 0xFF {
+		s.metadata.Depth = int(isLevelBuffer[0])
+	}
 
 	// read serial
 	_, err = s.file.Read(uintBuffer)
@@ -86,14 +150,50 @@ func (s *SSTable) ParseMetadata() error {
 	}
 	s.metadata.MaxKey = shared.TrimPaddedKey(string(keyBuffer))
 
+	// read min and max timestamps
+	timeBuffer := make([]byte, shared.Uint64Size)
+	_, err = s.file.Read(timeBuffer)
+	if err != nil {
+		return fmt.Errorf("can not read metadata from sstable %q: %v", s.metadata.Path, err)
+	}
+	s.metadata.MinTime = int64(binary.LittleEndian.Uint64(timeBuffer))
+
+	_, err = s.file.Read(timeBuffer)
+	if err != nil {
+		return fmt.Errorf("can not read metadata from sstable %q: %v", s.metadata.Path, err)
+	}
+	s.metadata.MaxTime = int64(binary.LittleEndian.Uint64(timeBuffer))
+
 	return nil
 }
 
+// Info returns the public description of the table.
+func (s *SSTable) Info() shared.TableInfo {
+	return shared.TableInfo{
+		Serial:   s.metadata.Serial,
+		IsLevel:  s.metadata.IsLevel,
+		Depth:    s.metadata.Depth,
+		Size:     s.metadata.Size,
+		FileSize: int64(s.fileSize()),
+		MinKey:   s.metadata.MinKey,
+		MaxKey:   s.metadata.MaxKey,
+		MinTime:  s.metadata.MinTime,
+		MaxTime:  s.metadata.MaxTime,
+
+		CreatedAt: s.metadata.CreatedAt,
+	}
+}
+
+// Overlaps reports whether the table may hold entries written within [from, to].
+func (s *SSTable) Overlaps(from, to int64) bool {
+	return s.metadata.MaxTime >= from && s.metadata.MinTime <= to
+}
+
 func (s *SSTable) Keys() ([]string, error) {
 	results := []string{}
 
 	for i := 0; i < int(s.metadata.Size); i++ {
-		pair, err := s.nthKey(i)
+		pair, err := s.readPair(i, false)
 		if err != nil {
 			return nil, fmt.Errorf("sstable seq scan can not read %dth key: %v", i, err)
 		}
@@ -107,7 +207,7 @@ func (s *SSTable) KVPairs() ([]memtable.KVPair, error) {
 	results := []memtable.KVPair{}
 
 	for i := 0; i < int(s.metadata.Size); i++ {
-		pair, err := s.nthKey(i)
+		pair, err := s.readPair(i, false)
 		if err != nil {
 			return nil, fmt.Errorf("sstable seq scan can not read %dth key: %v", i, err)
 		}
@@ -131,8 +231,8 @@ func (s *SSTable) BSearch(key string) (memtable.IndexNode, error) {
 		} else if pair.Key > key {
 			right = mid - 1
 		} else {
-			if pair.Value.Size == 0 {
-				return memtable.IndexNode{}, &shared.ErrKeyRemoved{Key: key}
+			if pair.Value.IsDeleted() {
+				return pair.Value, &shared.ErrKeyRemoved{Key: key}
 			} else {
 				return pair.Value, nil
 			}
@@ -142,43 +242,213 @@ func (s *SSTable) BSearch(key string) (memtable.IndexNode, error) {
 	return memtable.IndexNode{}, &shared.ErrKeyNotFound{Key: key}
 }
 
+// LoadIndex reads all the pairs of the table into memory so later lookups
+// are served without touching the disk.
+func (s *SSTable) LoadIndex() error {
+	if s.index.Load() != nil {
+		return nil
+	}
+
+	pairs, err := s.KVPairs()
+	if err != nil {
+		return err
+	}
+	s.index.Store(&pairs)
+	return nil
+}
+
+// OverlapsPrefix reports whether the table may hold keys starting with prefix.
+func (s *SSTable) OverlapsPrefix(prefix string) bool {
+	return prefix <= s.metadata.MaxKey && prefix+"\xff" >= s.metadata.MinKey
+}
+
+// Close closes the file of the table. When cursors still read it, the file is
+// closed once the last one releases it, its deleted file stays readable until then.
 func (s *SSTable) Close() error {
+	s.openMu.Lock()
+	defer s.openMu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	if s.readers > 0 {
+		s.retired = true
+		return nil
+	}
 	return s.file.Close()
 }
 
-func (s *SSTable) nthKey(n int) (memtable.KVPair, error) {
-	position := int64(int(s.config.GetMetadataSize()) + n*int(s.config.GetKVPairSize()))
-	_, err := s.file.Seek(position, io.SeekStart)
-	if err != nil {
-		return memtable.KVPair{}, fmt.Errorf("sstable %q can not seek position %d: %v", s.metadata.Path, position, err)
+// acquire opens the file of the table and keeps it open for a cursor until release.
+func (s *SSTable) acquire() error {
+	if err := s.ensureOpen(); err != nil {
+		return err
 	}
+	s.openMu.Lock()
+	defer s.openMu.Unlock()
+	s.readers++
+	return nil
+}
 
-	keyBuffer := make([]byte, s.config.KeySize)
-	numberBuffer := make([]byte, shared.UintSize)
+// release lets go of the table acquired by a cursor.
+func (s *SSTable) release() {
+	s.openMu.Lock()
+	defer s.openMu.Unlock()
 
-	// read key string
-	_, err = s.file.Read(keyBuffer)
-	if err != nil {
-		return memtable.KVPair{}, err
+	s.readers--
+	if s.readers == 0 && s.retired {
+		s.file.Close()
 	}
+}
 
-	_, err = s.file.Read(numberBuffer)
-	if err != nil {
+// nthKey reads the nth pair of the table. It reads at an absolute offset without
+// moving the file position, so concurrent lookups do not step on each other.
+func (s *SSTable) nthKey(n int) (memtable.KVPair, error) {
+	return s.readPair(n, true)
+}
+
+// readPair reads the nth pair of the table, through the block cache when cached
+// is set. Reads of the whole table skip the cache so they do not push the
+// blocks of the lookups out of it.
+func (s *SSTable) readPair(n int, cached bool) (memtable.KVPair, error) {
+	if index := s.index.Load(); index != nil {
+		return (*index)[n], nil
+	}
+	if err := s.ensureOpen(); err != nil {
 		return memtable.KVPair{}, err
 	}
-	offset := binary.LittleEndian.Uint32(numberBuffer)
 
-	_, err = s.file.Read(numberBuffer)
+	keySize := int(s.config.KeySize)
+	buffer, err := s.pairBytes(n, cached)
 	if err != nil {
 		return memtable.KVPair{}, err
 	}
-	size := binary.LittleEndian.Uint32(numberBuffer)
 
-	return memtable.KVPair{
-		Key: shared.TrimPaddedKey(string(keyBuffer)),
+	// "<key><offset><size><timestamp><flags>"
+	numbers := buffer[keySize:]
+	flags := numbers[shared.UintSize*2+shared.Uint64Size]
+	pair := memtable.KVPair{
+		Key: shared.TrimPaddedKey(string(buffer[:keySize])),
 		Value: memtable.IndexNode{
-			Offset: offset,
-			Size:   size,
+			Offset:     binary.LittleEndian.Uint32(numbers),
+			Size:       binary.LittleEndian.Uint32(numbers[shared.UintSize:]),
+			Timestamp:  int64(binary.LittleEndian.Uint64(numbers[shared.UintSize*2:])),
+			Deleted:    flags&flagDeleted != 0,
+			Compressed: flags&flagCompressed != 0,
 		},
-	}, nil
+	}
+	if flags&(flagInline|flagExpires|flagSequence|flagExtents) != 0 {
+		if err := s.readTrailing(&pair.Value, flags, cached); err != nil {
+			return memtable.KVPair{}, err
+		}
+	}
+	return pair, nil
+}
+
+// pairBytes returns the bytes of the nth pair. Through the block cache the whole
+// block of pairs around it is read, and kept for the next lookups.
+func (s *SSTable) pairBytes(n int, cached bool) ([]byte, error) {
+	pairSize := int(s.config.GetKVPairSize())
+	first, count := n, 1
+	if cached && s.cache != nil {
+		perBlock := max(blockSize/pairSize, 1)
+		first = n - n%perBlock
+		count = min(perBlock, int(s.metadata.Size)-first)
+	}
+
+	position := int64(int(s.config.GetMetadataSize()) + first*pairSize)
+	block, err := s.readAt(count*pairSize, position, cached)
+	if err != nil {
+		return nil, fmt.Errorf("sstable %q can not read position %d: %v", s.metadata.Path, position, err)
+	}
+	offset := (n - first) * pairSize
+	return block[offset : offset+pairSize], nil
+}
+
+// readAt reads size bytes at position, from the block cache when cached is set
+// and it holds them. The bytes may be shared with the cache and must not be
+// modified.
+func (s *SSTable) readAt(size int, position int64, cached bool) ([]byte, error) {
+	cached = cached && s.cache != nil
+	key := blockcache.Key{Owner: s.cacheOwner, Offset: position}
+	if cached {
+		if data, ok := s.cache.Get(key); ok && len(data) == size {
+			return data, nil
+		}
+	}
+
+	data := make([]byte, size)
+	if _, err := s.file.ReadAt(data, position); err != nil {
+		return nil, err
+	}
+	if cached {
+		s.cache.Add(key, data)
+	}
+	return data, nil
+}
+
+// readTrailing reads what the table keeps for the node after the pairs, the node
+// points at it relative to the end of the pairs: "[<expiry>][<sequence>]"
+// followed by "<offset>[<count><extent>...]" for a value in the value file, an
+// extent being "<offset><size><compressed>", or the value when it is inline.
+func (s *SSTable) readTrailing(node *memtable.IndexNode, flags byte, cached bool) error {
+	position := int64(s.config.GetMetadataSize()) + int64(s.metadata.Size)*int64(s.config.GetKVPairSize()) + int64(node.Offset)
+	node.Offset = 0
+	if flags&(flagExpires|flagSequence|flagExtents) != 0 {
+		size := 0
+		if flags&flagExpires != 0 {
+			size += shared.Uint64Size
+		}
+		if flags&flagSequence != 0 {
+			size += shared.Uint64Size
+		}
+		if flags&flagInline == 0 {
+			size += shared.UintSize
+		}
+		if flags&flagExtents != 0 {
+			size += shared.UintSize
+		}
+		buffer, err := s.readAt(size, position, cached)
+		if err != nil {
+			return fmt.Errorf("sstable %q can not read the trailing fields at %d: %v", s.metadata.Path, position, err)
+		}
+		position += int64(len(buffer))
+		if flags&flagExpires != 0 {
+			node.ExpiresAt = int64(binary.LittleEndian.Uint64(buffer))
+			buffer = buffer[shared.Uint64Size:]
+		}
+		if flags&flagSequence != 0 {
+			node.Sequence = binary.LittleEndian.Uint64(buffer)
+			buffer = buffer[shared.Uint64Size:]
+		}
+		if flags&flagInline == 0 {
+			node.Offset = binary.LittleEndian.Uint32(buffer)
+			buffer = buffer[shared.UintSize:]
+		}
+		if flags&flagExtents != 0 {
+			count := int(binary.LittleEndian.Uint32(buffer))
+			buffer, err := s.readAt(count*extentSize, position, cached)
+			if err != nil {
+				return fmt.Errorf("sstable %q can not read the extents at %d: %v", s.metadata.Path, position, err)
+			}
+			position += int64(len(buffer))
+			node.Extents = make([]memtable.Extent, count)
+			for i := range node.Extents {
+				extent := buffer[i*extentSize:]
+				node.Extents[i] = memtable.Extent{
+					Offset:     binary.LittleEndian.Uint32(extent),
+					Size:       binary.LittleEndian.Uint32(extent[shared.UintSize:]),
+					Compressed: extent[shared.UintSize*2] == 1,
+				}
+			}
+		}
+	}
+	if flags&flagInline != 0 {
+		value, err := s.readAt(int(node.Size), position, cached)
+		if err != nil {
+			return fmt.Errorf("sstable %q can not read the inline value at %d: %v", s.metadata.Path, position, err)
+		}
+		// the value leaves the table, it must not share the cached bytes
+		node.Inline = bytes.Clone(value)
+	}
+	return nil
 }
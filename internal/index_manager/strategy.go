@@ -0,0 +1,123 @@
+package index_manager
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// maxStrategySteps bounds the steps run by a compaction check, in case a
+// strategy never runs out of work.
+const maxStrategySteps = 64
+
+// runStrategy runs the steps of the compaction strategy until it has nothing
+// left to do.
+func (im *IndexManager) runStrategy(strategy shared.CompactionStrategy) error {
+	for range maxStrategySteps {
+		step := strategy.Next(tableInfos(im.sstables), tableInfos(im.levels), im.config)
+		if step.Empty() {
+			return nil
+		}
+		if err := im.runStep(step); err != nil {
+			return fmt.Errorf("index manager can not run a compaction step of %T: %v", strategy, err)
+		}
+	}
+	log.Printf("index manager: compaction strategy %T still has work after %d steps\n", strategy, maxStrategySteps)
+	return nil
+}
+
+// runStep drops the tables of the step, then merges its tables into a level
+// once it made sure the level goes where they were searched.
+func (im *IndexManager) runStep(step shared.CompactionStep) error {
+	if len(step.Drop) > 0 {
+		dropped, err := im.resolveTables(step.Drop)
+		if err != nil {
+			return err
+		}
+		for _, table := range dropped {
+			log.Printf("index manager: compaction strategy dropped table %d\n", table.metadata.Serial)
+		}
+		im.reclaim(dropped, 0)
+		im.removeSSTables(dropped)
+	}
+	if len(step.Merge) == 0 {
+		return nil
+	}
+
+	merged, err := im.resolveTables(step.Merge)
+	if err != nil {
+		return err
+	}
+	if step.Depth < 0 || step.Depth > 254 {
+		return fmt.Errorf("depth %d is out of range", step.Depth)
+	}
+
+	tables := im.tables()
+	start := 0
+	for start < len(tables) && tables[start] != merged[0] {
+		start++
+	}
+	end := start + len(merged)
+	for i, table := range merged {
+		if start+i >= len(tables) || tables[start+i] != table {
+			return fmt.Errorf("the tables to merge do not follow each other from newest to oldest")
+		}
+	}
+
+	level := TableMetadata{IsLevel: true, Depth: step.Depth, Serial: uint32(im.lvlSerial)}
+	for _, table := range tables[:start] {
+		if !searchedBefore(table.metadata, level) {
+			return fmt.Errorf("the level would be searched before table %d, which is newer", table.metadata.Serial)
+		}
+	}
+	for _, table := range tables[end:] {
+		if !searchedBefore(level, table.metadata) {
+			return fmt.Errorf("the level would be searched after table %d, which is older", table.metadata.Serial)
+		}
+	}
+
+	// deleted keys must keep shadowing the older tables left out of the merge
+	return im.createLevelAt(merged, end < len(tables), step.Depth)
+}
+
+// resolveTables returns the tables the infos describe.
+func (im *IndexManager) resolveTables(infos []shared.TableInfo) ([]*SSTable, error) {
+	resolved := make([]*SSTable, 0, len(infos))
+	for _, info := range infos {
+		found := false
+		for _, table := range im.tables() {
+			if table.metadata.IsLevel == info.IsLevel && table.metadata.Serial == info.Serial {
+				resolved = append(resolved, table)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("table %d does not exist", info.Serial)
+		}
+	}
+	return resolved, nil
+}
+
+func tableInfos(tables []*SSTable) []shared.TableInfo {
+	infos := make([]shared.TableInfo, len(tables))
+	for i, table := range tables {
+		infos[i] = table.Info()
+	}
+	return infos
+}
+
+// searchedBefore reports whether the table a is searched before the table b:
+// the SSTables, newest first, then the levels with a depth, by depth, then the
+// plain levels, newest first. A deeper level is written later than the one
+// above it yet holds older keys.
+func searchedBefore(a, b TableMetadata) bool {
+	if a.IsLevel != b.IsLevel {
+		return !a.IsLevel
+	}
+	if a.Depth != b.Depth {
+		return a.Depth != 0 && (b.Depth == 0 || a.Depth < b.Depth)
+	}
+	return a.Serial > b.Serial
+}
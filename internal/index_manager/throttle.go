@@ -0,0 +1,105 @@
+package index_manager
+
+import (
+	"context"
+	"io"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// compactionOptions are the compaction settings that can be changed while the
+// database is running.
+type compactionOptions struct {
+	workers   atomic.Int32 // Number of tables read in parallel.
+	rateLimit atomic.Int64 // Bytes per second written, zero is unlimited.
+}
+
+// SetCompactionWorkers changes the number of tables a compaction reads in parallel.
+// It applies from the next compaction on.
+func (im *IndexManager) SetCompactionWorkers(workers int) {
+	im.compaction.workers.Store(int32(max(workers, 1)))
+}
+
+// SetCompactionRateLimit changes how many bytes per second a compaction may write,
+// zero removes the limit. It applies right away, even to a running compaction.
+func (im *IndexManager) SetCompactionRateLimit(bytesPerSecond int64) {
+	im.compaction.rateLimit.Store(max(bytesPerSecond, 0))
+}
+
+func (im *IndexManager) CompactionWorkers() int {
+	return int(im.compaction.workers.Load())
+}
+
+func (im *IndexManager) CompactionRateLimit() int64 {
+	return im.compaction.rateLimit.Load()
+}
+
+// loadIndexes reads the pairs of the tables into memory using the configured
+// number of workers, so the merge that follows does not wait on the disk.
+func (im *IndexManager) loadIndexes(tables []*SSTable) error {
+	jobs := make(chan *SSTable)
+	errs := make(chan error, len(tables))
+
+	wg := sync.WaitGroup{}
+	for range min(im.CompactionWorkers(), len(tables)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// tagged like the goroutines of the engine, see its profileLabel
+			pprof.Do(context.Background(), pprof.Labels("goldb", "compaction"), func(context.Context) {
+				for table := range jobs {
+					if err := table.LoadIndex(); err != nil {
+						errs <- err
+					}
+				}
+			})
+		}()
+	}
+
+	for _, table := range tables {
+		jobs <- table
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
+
+// throttle wraps w so writes stay under the compaction rate limit.
+func (im *IndexManager) throttle(w io.Writer) io.Writer {
+	return &throttledWriter{w: w, limit: &im.compaction.rateLimit, start: time.Now(), current: im.CompactionRateLimit()}
+}
+
+// throttledWriter sleeps whenever the bytes written so far are ahead of the
+// limit. The limit is read on every write so changing it takes effect at once.
+type throttledWriter struct {
+	w       io.Writer
+	limit   *atomic.Int64
+	current int64 // Limit the window starting at start was measured against.
+	start   time.Time
+	written int64
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	n, err := tw.w.Write(p)
+	tw.written += int64(n)
+
+	// start over when the limit changes so the bytes written under the old
+	// limit are not paid for under the new one
+	limit := tw.limit.Load()
+	if limit != tw.current {
+		tw.current, tw.start, tw.written = limit, time.Now(), int64(n)
+	}
+	if limit <= 0 {
+		return n, err
+	}
+
+	expected := time.Duration(float64(tw.written) / float64(limit) * float64(time.Second))
+	if ahead := expected - time.Since(tw.start); ahead > 0 {
+		time.Sleep(ahead)
+	}
+	return n, err
+}
@@ -0,0 +1,108 @@
+package index_manager
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// tombstoneRun tracks deleted keys a scan skipped in a row.
+type tombstoneRun struct {
+	count      int
+	start, end string
+}
+
+// add extends the run with key, scans walking backwards add the keys in descending order.
+func (r *tombstoneRun) add(key string) {
+	if r.count == 0 {
+		r.start, r.end = key, key
+	}
+	r.start, r.end = min(r.start, key), max(r.end, key)
+	r.count++
+}
+
+// tombstoneHints collects the runs of deleted keys long enough to slow scans down.
+type tombstoneHints struct {
+	runs atomic.Int64 // Number of runs reported since the database was opened.
+
+	mu         sync.Mutex
+	pending    bool // A range waits to be compacted.
+	start, end string
+}
+
+// endTombstoneRun records a finished run in the scan stats, and reports it when
+// it reaches TombstoneRunThreshold.
+func (im *IndexManager) endTombstoneRun(run tombstoneRun, stats *ScanStats) {
+	if run.count == 0 {
+		return
+	}
+
+	if stats != nil && run.count > stats.LongestTombstoneRun {
+		stats.LongestTombstoneRun = run.count
+		stats.TombstoneRunStart = run.start
+		stats.TombstoneRunEnd = run.end
+	}
+
+	threshold := im.config.TombstoneRunThreshold
+	if threshold == 0 || run.count < threshold {
+		return
+	}
+
+	im.hints.runs.Add(1)
+	log.Printf("index manager: scan skipped %d deleted keys between %q and %q, compacting the range drops them\n", run.count, run.start, run.end)
+
+	if !im.config.CompactTombstoneRuns {
+		return
+	}
+
+	im.hints.mu.Lock()
+	defer im.hints.mu.Unlock()
+	if !im.hints.pending {
+		im.hints.pending, im.hints.start, im.hints.end = true, run.start, run.end
+		return
+	}
+	im.hints.start = min(im.hints.start, run.start)
+	im.hints.end = max(im.hints.end, run.end)
+}
+
+// TombstoneRuns returns the number of runs of deleted keys reported by scans
+// since the database was opened.
+func (im *IndexManager) TombstoneRuns() int64 {
+	return im.hints.runs.Load()
+}
+
+// compactTombstoneRuns compacts the range reported by the tombstone hints. The
+// level written must not shadow newer tables, so every SSTable as old as the
+// newest one holding keys of the range is merged along with all the levels, and
+// as the oldest tables take part the deleted keys can be dropped.
+func (im *IndexManager) compactTombstoneRuns() error {
+	im.hints.mu.Lock()
+	pending, start, end := im.hints.pending, im.hints.start, im.hints.end
+	im.hints.pending = false
+	im.hints.mu.Unlock()
+
+	if !pending {
+		return nil
+	}
+
+	newest := uint32(0)
+	for _, table := range im.sstables {
+		if table.metadata.MinKey <= end && table.metadata.MaxKey >= start {
+			newest = max(newest, table.metadata.Serial)
+		}
+	}
+
+	tables := []*SSTable{}
+	for _, table := range im.sstables {
+		if table.metadata.Serial <= newest {
+			tables = append(tables, table)
+		}
+	}
+	tables = append(tables, im.levels...)
+	if len(tables) == 0 {
+		return nil
+	}
+
+	log.Printf("index manager: compacting %d tables to drop the deleted keys between %q and %q\n", len(tables), start, end)
+	return im.createLevel(tables, false)
+}
@@ -0,0 +1,154 @@
+package index_manager
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+)
+
+// trashDir is the directory under the homepath holding dropped prefixes.
+const trashDir = "trash"
+
+// TrashInfo describes a dropped prefix kept in the trash.
+type TrashInfo struct {
+	ID        string
+	Prefix    string
+	DroppedAt time.Time
+	Size      uint32 // Number of pairs.
+}
+
+// WriteTrash stores the pairs of a dropped prefix in the trash as a table file
+// named after the drop time and the hex encoded prefix.
+// The values stay in the value file, only their index is kept.
+func (im *IndexManager) WriteTrash(prefix string, pairs []memtable.KVPair, droppedAt time.Time) (string, error) {
+	dir := filepath.Join(im.config.Homepath, trashDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("index manager can not create trash directory: %v", err)
+	}
+
+	id := fmt.Sprintf("%d-%s", droppedAt.UnixNano(), hex.EncodeToString([]byte(prefix)))
+
+	minTime, maxTime := timeBounds(pairs)
+	metadata := TableMetadata{
+		Size:    uint32(len(pairs)),
+		MinKey:  pairs[0].Key,
+		MaxKey:  pairs[len(pairs)-1].Key,
+		MinTime: minTime,
+		MaxTime: maxTime,
+	}
+	if err := im.writeTable(filepath.Join(dir, id), pairs, &metadata, false); err != nil {
+		return "", fmt.Errorf("index manager can not write trash %q: %v", id, err)
+	}
+
+	return id, nil
+}
+
+// ListTrash returns the dropped prefixes in the trash, oldest first.
+func (im *IndexManager) ListTrash() ([]TrashInfo, error) {
+	entries, err := os.ReadDir(filepath.Join(im.config.Homepath, trashDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []TrashInfo{}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), tmpSuffix) {
+			continue
+		}
+		info, err := im.trashInfo(entry.Name())
+		if err != nil {
+			log.Printf("index manager: failed to parse trash %q: %v\n", entry.Name(), err)
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].DroppedAt.Before(infos[j].DroppedAt)
+	})
+	return infos, nil
+}
+
+func (im *IndexManager) trashInfo(id string) (TrashInfo, error) {
+	nanos, encodedPrefix, ok := strings.Cut(id, "-")
+	if !ok {
+		return TrashInfo{}, fmt.Errorf("malformed trash name")
+	}
+	droppedAt, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return TrashInfo{}, err
+	}
+	prefix, err := hex.DecodeString(encodedPrefix)
+	if err != nil {
+		return TrashInfo{}, err
+	}
+
+	table, err := im.openTrash(id)
+	if err != nil {
+		return TrashInfo{}, err
+	}
+	defer table.Close()
+
+	return TrashInfo{
+		ID:        id,
+		Prefix:    string(prefix),
+		DroppedAt: time.Unix(0, droppedAt),
+		Size:      table.metadata.Size,
+	}, nil
+}
+
+func (im *IndexManager) openTrash(id string) (*SSTable, error) {
+	if strings.ContainsAny(id, `/\`) {
+		return nil, fmt.Errorf("malformed trash id %q", id)
+	}
+	return NewSSTable(TableMetadata{Path: filepath.Join(im.config.Homepath, trashDir, id)}, im.config, im.blockCache)
+}
+
+// ReadTrash returns the pairs of a dropped prefix.
+func (im *IndexManager) ReadTrash(id string) ([]memtable.KVPair, error) {
+	table, err := im.openTrash(id)
+	if err != nil {
+		return nil, err
+	}
+	defer table.Close()
+	return table.KVPairs()
+}
+
+// RemoveTrash deletes a dropped prefix from the trash for good.
+func (im *IndexManager) RemoveTrash(id string) error {
+	if strings.ContainsAny(id, `/\`) {
+		return fmt.Errorf("malformed trash id %q", id)
+	}
+	return os.Remove(filepath.Join(im.config.Homepath, trashDir, id))
+}
+
+// PurgeTrash deletes the dropped prefixes that outlived TrashRetention.
+func (im *IndexManager) PurgeTrash() error {
+	infos, err := im.ListTrash()
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		if time.Since(info.DroppedAt) <= im.config.TrashRetention {
+			continue
+		}
+		if err := im.RemoveTrash(info.ID); err != nil {
+			log.Printf("index manager: failed to purge trash %q: %v\n", info.ID, err)
+			continue
+		}
+		log.Printf("index manager: purged dropped prefix %q from the trash\n", info.Prefix)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package index_manager
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// verifyTable reads a freshly written and synced table back from disk, comparing
+// its checksum with the one of the bytes written. It does nothing unless
+// VerifyWrites is set.
+func (im *IndexManager) verifyTable(path string, expected uint32) error {
+	if !im.config.VerifyWrites {
+		return nil
+	}
+
+	actual, err := fileChecksum(path)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return &shared.ErrChecksumMismatch{Path: path, Expected: expected, Actual: actual}
+	}
+	return nil
+}
+
+func fileChecksum(path string) (uint32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	checksum := crc32.NewIEEE()
+	if _, err := io.Copy(checksum, file); err != nil {
+		return 0, err
+	}
+	return checksum.Sum32(), nil
+}
+
+// VerifyTables checks every table of the home directory and returns how many
+// there are: a table file the manager could not open, one cut short, or keys out
+// of order or outside the key range of their table are reported as errors.
+func (im *IndexManager) VerifyTables() (int, error) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	tables := im.tables()
+	opened := map[string]bool{}
+	for _, table := range tables {
+		opened[filepath.Base(table.metadata.Path)] = true
+	}
+	entries, err := os.ReadDir(im.config.Homepath)
+	if err != nil {
+		return 0, fmt.Errorf("index manager can not list the tables: %v", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, im.config.SSTableNamePrefix) && !strings.HasPrefix(name, im.config.LevelFileNamePrefix) {
+			continue
+		}
+		if strings.HasSuffix(name, filterSuffix) || strings.HasSuffix(name, tmpSuffix) || opened[name] {
+			continue
+		}
+		return 0, fmt.Errorf("index manager can not open table %q", name)
+	}
+
+	for _, table := range tables {
+		if err := table.verify(); err != nil {
+			return 0, fmt.Errorf("index manager found table %q damaged: %v", table.metadata.Path, err)
+		}
+	}
+	return len(tables), nil
+}
+
+// verify reads every pair of the table, inline values included.
+func (s *SSTable) verify() error {
+	if err := s.ensureOpen(); err != nil {
+		return err
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	if expected := int64(s.config.GetMetadataSize()) + int64(s.metadata.Size)*int64(s.config.GetKVPairSize()); info.Size() < expected {
+		return fmt.Errorf("the file is %d bytes long, its %d pairs take %d", info.Size(), s.metadata.Size, expected)
+	}
+
+	pairs, err := s.KVPairs()
+	if err != nil {
+		return err
+	}
+	for i, pair := range pairs {
+		if i > 0 && pair.Key <= pairs[i-1].Key {
+			return fmt.Errorf("key %q of pair %d is out of order", pair.Key, i)
+		}
+		if pair.Key < s.metadata.MinKey || pair.Key > s.metadata.MaxKey {
+			return fmt.Errorf("key %q of pair %d is outside the range of the table", pair.Key, i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package index_manager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+)
+
+// Warmup loads the index of every table that may hold keys starting with one of
+// the prefixes into memory. An empty list of prefixes warms up every table.
+// Returns the live pairs under the prefixes so the caller can warm up values too.
+func (im *IndexManager) Warmup(prefixes []string) ([]memtable.KVPair, error) {
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+
+	if err := im.loadPrefixIndexes(prefixes); err != nil {
+		return nil, err
+	}
+
+	pairs, err := im.Items(NewScanOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	results := []memtable.KVPair{}
+	for _, pair := range pairs {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(pair.Key, prefix) {
+				results = append(results, pair)
+				break
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// loadPrefixIndexes loads the indexes of the tables that may hold keys under the
+// prefixes. The indexes are swapped in under the write lock, as readers use them
+// without locking the tables.
+func (im *IndexManager) loadPrefixIndexes(prefixes []string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for _, table := range im.tables() {
+		for _, prefix := range prefixes {
+			if !table.OverlapsPrefix(prefix) {
+				continue
+			}
+			if err := table.LoadIndex(); err != nil {
+				return fmt.Errorf("index manager can not warm up table %d: %v", table.metadata.Serial, err)
+			}
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package index_manager
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+)
+
+// tmpSuffix marks a table that is still being written.
+const tmpSuffix = ".tmp"
+
+// writeTable writes the pairs as a table at path. The table is written to a
+// temporary file first, synced and verified, then renamed into place and the
+// directory synced. Once it returns, the table survives a crash, and a crash
+// before that never leaves a partial table under a table name, so the WAL or the
+// tables the new one is built from can only be removed afterwards.
+// Compactions pass throttled to stay under the compaction rate limit.
+func (im *IndexManager) writeTable(path string, pairs []memtable.KVPair, metadata *TableMetadata, throttled bool) error {
+	tmp := path + tmpSuffix
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = file
+	if throttled {
+		w = im.throttle(file)
+	}
+	checksum := crc32.NewIEEE()
+
+	err = im.serializePairs(io.MultiWriter(w, checksum), pairs, metadata)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = im.verifyTable(tmp, checksum.Sum32())
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+			log.Printf("index manager: failed to remove %q: %v\n", tmp, err)
+		}
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes the entries created or renamed in dir durable.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("can not sync directory %q: %v", dir, err)
+	}
+	return nil
+}
+
+// removeTmpFile deletes a table left half written by a crash, reporting whether
+// name was one.
+func (im *IndexManager) removeTmpFile(name string) bool {
+	if !strings.HasSuffix(name, tmpSuffix) {
+		return false
+	}
+	if !strings.HasPrefix(name, im.config.SSTableNamePrefix) && !strings.HasPrefix(name, im.config.LevelFileNamePrefix) {
+		return false
+	}
+
+	log.Printf("index manager: removing %q left behind by an interrupted write\n", name)
+	if err := os.Remove(filepath.Join(im.config.Homepath, name)); err != nil {
+		log.Printf("index manager: failed to remove %q: %v\n", name, err)
+	}
+	return true
+}
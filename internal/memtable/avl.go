@@ -1,5 +1,11 @@
 package memtable
 
+import (
+	"bytes"
+	"slices"
+	"time"
+)
+
 type treeNode struct {
 	key    string
 	value  IndexNode
@@ -25,8 +31,85 @@ func (a KVPairSlice) Less(i, j int) bool { return a[i].Key < a[j].Key }
 func (a KVPairSlice) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 
 type IndexNode struct {
-	Offset uint32
-	Size   uint32
+	Offset     uint32
+	Size       uint32
+	Timestamp  int64    // Write time in unix nanoseconds.
+	Deleted    bool     // Soft deleted, the value is kept until the undelete window passes.
+	Compressed bool     // The value is stored compressed, Size is its compressed size.
+	Inline     []byte   // The value itself when it is kept in the tables rather than the value file.
+	ExpiresAt  int64    // Time the key expires at in unix nanoseconds, zero if it never does.
+	Sequence   uint64   // Sequence number of the write, zero for the writes made before they were recorded.
+	Extents    []Extent // Runs appended to the value with Append, read after the first one in order.
+}
+
+// Extent is a run of bytes appended to a value, stored as a record of its own in
+// the value file.
+type Extent struct {
+	Offset     uint32
+	Size       uint32
+	Compressed bool // The run is stored compressed, Size is its compressed size.
+}
+
+// Records returns the records of the value file the node points at: its own
+// followed by the ones of its extents. A value kept inline or a delete has none.
+func (n IndexNode) Records() []IndexNode {
+	if n.Size == 0 || n.Inline != nil {
+		return nil
+	}
+	records := []IndexNode{{Offset: n.Offset, Size: n.Size, Compressed: n.Compressed}}
+	for _, extent := range n.Extents {
+		records = append(records, IndexNode{Offset: extent.Offset, Size: extent.Size, Compressed: extent.Compressed})
+	}
+	return records
+}
+
+// Relocate points the node and its extents at the new offsets of their records,
+// given by their old offsets, and reports whether all of them moved.
+func (n *IndexNode) Relocate(moved map[uint32]uint32) bool {
+	offset, ok := moved[n.Offset]
+	if !ok {
+		return false
+	}
+	extents := make([]Extent, len(n.Extents))
+	for i, extent := range n.Extents {
+		if extent.Offset, ok = moved[extent.Offset]; !ok {
+			return false
+		}
+		extents[i] = extent
+	}
+	n.Offset = offset
+	if len(extents) > 0 {
+		n.Extents = extents
+	}
+	return true
+}
+
+// IsDeleted reports whether the node marks its key as deleted, softly or not.
+func (n IndexNode) IsDeleted() bool {
+	return n.Size == 0 || n.Deleted
+}
+
+// Equal reports whether both nodes point at the same value written at the same time.
+func (n IndexNode) Equal(other IndexNode) bool {
+	return n.Offset == other.Offset && n.Size == other.Size && n.Timestamp == other.Timestamp &&
+		n.Deleted == other.Deleted && n.Compressed == other.Compressed && bytes.Equal(n.Inline, other.Inline) &&
+		n.ExpiresAt == other.ExpiresAt && n.Sequence == other.Sequence && slices.Equal(n.Extents, other.Extents)
+}
+
+// Expired reports whether the TTL the key was written with ran out.
+func (n IndexNode) Expired() bool {
+	return n.ExpiresAt != 0 && time.Now().UnixNano() >= n.ExpiresAt
+}
+
+// Memtable holds the latest entry of the keys written since the last flush.
+// Table is an AVL tree that must be guarded by its owner, Skiplist takes
+// inserts alongside lookups and other inserts.
+type Memtable interface {
+	Set(key string, value IndexNode)
+	Get(key string) IndexNode
+	Contains(key string) bool
+	Len() uint32
+	Items() []KVPair
 }
 
 func New() *Table {
@@ -134,13 +217,13 @@ func (t *Table) insert(node *treeNode, key string, value IndexNode) *treeNode {
 	return t.balance(node, key)
 }
 
-func (t *Table) get(node *treeNode, key string) IndexNode {
+func (t *Table) get(node *treeNode, key string) (IndexNode, bool) {
 	if node == nil {
-		return IndexNode{}
+		return IndexNode{}, false
 	}
 
 	if node.key == key {
-		return node.value
+		return node.value, true
 	} else if node.key > key {
 		return t.get(node.left, key)
 	} else {
@@ -167,11 +250,15 @@ func (t *Table) Set(key string, value IndexNode) {
 }
 
 func (t *Table) Get(key string) IndexNode {
-	return t.get(t.root, key)
+	value, _ := t.get(t.root, key)
+	return value
 }
 
+// Contains reports whether the table holds an entry for the key,
+// deleted keys included since their entries shadow older tables.
 func (t *Table) Contains(key string) bool {
-	return t.get(t.root, key).Size != 0
+	_, ok := t.get(t.root, key)
+	return ok
 }
 
 func (t *Table) Items() []KVPair {
@@ -179,3 +266,8 @@ func (t *Table) Items() []KVPair {
 	t.inOrder(t.root, &r)
 	return r
 }
+
+// Len returns the number of keys in the table.
+func (t *Table) Len() uint32 {
+	return t.Size
+}
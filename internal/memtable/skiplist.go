@@ -0,0 +1,155 @@
+package memtable
+
+import (
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+// skiplistMaxHeight bounds the levels of the skiplist, enough for millions of
+// keys with a branching factor of four.
+const skiplistMaxHeight = 16
+
+type skipNode struct {
+	key   string
+	value atomic.Pointer[IndexNode]
+	next  []atomic.Pointer[skipNode] // One link per level the node is on.
+}
+
+// Skiplist is a memtable safe for concurrent use without a lock: inserts link
+// their node with compare-and-swap and lookups and iterations walk the links
+// while inserts go on. Keys are never removed, a delete is an entry like any
+// other. Items walks the bottom level, which is already in key order.
+//
+// Concurrent inserts of a key may land in any order, the entry with the highest
+// sequence number is kept whatever the order; entries that are not numbered
+// replace the one before them.
+type Skiplist struct {
+	head   *skipNode
+	height atomic.Int32
+	size   atomic.Uint32
+}
+
+func NewSkiplist() *Skiplist {
+	s := &Skiplist{head: &skipNode{next: make([]atomic.Pointer[skipNode], skiplistMaxHeight)}}
+	s.height.Store(1)
+	return s
+}
+
+func randomHeight() int {
+	height := 1
+	for height < skiplistMaxHeight && rand.Uint32()&3 == 0 {
+		height++
+	}
+	return height
+}
+
+// findSplice returns the last node before key at level, starting the walk at
+// before, and the node following it, which holds key when the key is in the list.
+func (s *Skiplist) findSplice(key string, before *skipNode, level int) (*skipNode, *skipNode) {
+	for {
+		next := before.next[level].Load()
+		if next == nil || next.key >= key {
+			return before, next
+		}
+		before = next
+	}
+}
+
+// also works as "put"
+func (s *Skiplist) Set(key string, value IndexNode) {
+	var prev, next [skiplistMaxHeight]*skipNode
+
+	listHeight := int(s.height.Load())
+	before := s.head
+	for level := listHeight - 1; level >= 0; level-- {
+		prev[level], next[level] = s.findSplice(key, before, level)
+		if next[level] != nil && next[level].key == key {
+			next[level].store(value)
+			return
+		}
+		before = prev[level]
+	}
+
+	// the levels above the height the splices were found at start from the head,
+	// whether this insert raises the height or a concurrent one did meanwhile
+	height := randomHeight()
+	for level := listHeight; level < height; level++ {
+		prev[level], next[level] = s.head, nil
+	}
+	for current := listHeight; current < height; current = int(s.height.Load()) {
+		if s.height.CompareAndSwap(int32(current), int32(height)) {
+			break
+		}
+	}
+
+	node := &skipNode{key: key, next: make([]atomic.Pointer[skipNode], height)}
+	node.value.Store(&value)
+	for level := 0; level < height; level++ {
+		for {
+			node.next[level].Store(next[level])
+			if prev[level].next[level].CompareAndSwap(next[level], node) {
+				break
+			}
+			// another insert changed the splice, find it again from where it was
+			prev[level], next[level] = s.findSplice(key, prev[level], level)
+			if level == 0 && next[level] != nil && next[level].key == key {
+				next[level].store(value)
+				return
+			}
+		}
+	}
+	s.size.Add(1)
+}
+
+// store replaces the entry of the node unless it holds a newer one.
+func (n *skipNode) store(value IndexNode) {
+	for {
+		old := n.value.Load()
+		if value.Sequence != 0 && value.Sequence < old.Sequence {
+			return
+		}
+		if n.value.CompareAndSwap(old, &value) {
+			return
+		}
+	}
+}
+
+func (s *Skiplist) find(key string) *skipNode {
+	before := s.head
+	for level := int(s.height.Load()) - 1; level >= 0; level-- {
+		var next *skipNode
+		before, next = s.findSplice(key, before, level)
+		if next != nil && next.key == key {
+			return next
+		}
+	}
+	return nil
+}
+
+func (s *Skiplist) Get(key string) IndexNode {
+	if node := s.find(key); node != nil {
+		return *node.value.Load()
+	}
+	return IndexNode{}
+}
+
+// Contains reports whether the skiplist holds an entry for the key,
+// deleted keys included since their entries shadow older tables.
+func (s *Skiplist) Contains(key string) bool {
+	return s.find(key) != nil
+}
+
+// Len returns the number of keys in the skiplist.
+func (s *Skiplist) Len() uint32 {
+	return s.size.Load()
+}
+
+// Items returns the pairs in key order. Keys inserted during the walk may or
+// may not show up.
+func (s *Skiplist) Items() []KVPair {
+	r := make([]KVPair, 0, s.size.Load())
+	for node := s.head.next[0].Load(); node != nil; node = node.next[0].Load() {
+		r = append(r, KVPair{node.key, *node.value.Load()})
+	}
+	return r
+}
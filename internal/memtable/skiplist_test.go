@@ -0,0 +1,93 @@
+package memtable
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// TestSkiplistConcurrentSet inserts keys from several goroutines at once, some
+// of them shared, and checks every key is found once, in order, holding the
+// entry with the highest sequence number.
+func TestSkiplistConcurrentSet(t *testing.T) {
+	const writers, keys = 8, 2000
+	for round := 0; round < 20; round++ {
+		s := NewSkiplist()
+		var wg sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < keys; i++ {
+					s.Set(fmt.Sprintf("own-%d-%05d", w, i), IndexNode{Size: uint32(i), Sequence: uint64(i + 1)})
+					// every writer writes the shared keys, writer w with sequence numbers ending in w
+					if i%10 == 0 {
+						s.Set(fmt.Sprintf("shared-%05d", i), IndexNode{Size: uint32(w), Sequence: uint64(i*writers + w + 1)})
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+
+		items := s.Items()
+		if want := writers*keys + keys/10; len(items) != want || int(s.Len()) != want {
+			t.Fatalf("round %d: %d items and Len %d, want %d", round, len(items), s.Len(), want)
+		}
+		if !sort.SliceIsSorted(items, func(i, j int) bool { return items[i].Key < items[j].Key }) {
+			t.Fatalf("round %d: the items are not in key order", round)
+		}
+		for i := 0; i < keys; i += 10 {
+			node := s.Get(fmt.Sprintf("shared-%05d", i))
+			if node.Size != writers-1 || node.Sequence != uint64(i*writers+writers) {
+				t.Fatalf("round %d: shared key %d holds the write of writer %d, sequence %d, want the highest one", round, i, node.Size, node.Sequence)
+			}
+		}
+		for w := 0; w < writers; w++ {
+			if !s.Contains(fmt.Sprintf("own-%d-%05d", w, keys-1)) {
+				t.Fatalf("round %d: the last key of writer %d is missing", round, w)
+			}
+		}
+	}
+}
+
+// TestSkiplistUnnumberedSet checks entries without a sequence number replace
+// the one before them, like the writes of the system keyspace.
+func TestSkiplistUnnumberedSet(t *testing.T) {
+	s := NewSkiplist()
+	s.Set("key", IndexNode{Size: 1, Sequence: 5})
+	s.Set("key", IndexNode{Size: 2, Sequence: 3})
+	if node := s.Get("key"); node.Size != 1 {
+		t.Fatalf("an older write replaced a newer one: %+v", node)
+	}
+	s.Set("key", IndexNode{Size: 3})
+	if node := s.Get("key"); node.Size != 3 {
+		t.Fatalf("a write without a sequence number was dropped: %+v", node)
+	}
+}
+
+// TestSkiplistConcurrentGrowth inserts into fresh skiplists from many goroutines,
+// so inserts raise the height of the list while others are linking their nodes.
+func TestSkiplistConcurrentGrowth(t *testing.T) {
+	const writers, keys = 32, 64
+	for round := 0; round < 100; round++ {
+		s := NewSkiplist()
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				<-start
+				for i := 0; i < keys; i++ {
+					s.Set(fmt.Sprintf("%03d-%02d", i, w), IndexNode{Sequence: 1})
+				}
+			}(w)
+		}
+		close(start)
+		wg.Wait()
+		if s.Len() != writers*keys || len(s.Items()) != writers*keys {
+			t.Fatalf("round %d: Len %d and %d items, want %d", round, s.Len(), len(s.Items()), writers*keys)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package parquet
+
+import "encoding/binary"
+
+// Types of the Thrift compact protocol, which encodes the page headers and the
+// footer of a Parquet file.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes Thrift structs with the compact protocol. Fields are
+// written in increasing id order, every struct is closed with end.
+type thriftWriter struct {
+	buf  []byte
+	last []int16 // Id of the last field written, one per open struct.
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{last: []int16{0}}
+}
+
+// field writes the header of field id of type typ, as a delta from the last
+// field of the struct when it fits.
+func (t *thriftWriter) field(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.buf = binary.AppendVarint(t.buf, int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.buf = binary.AppendVarint(t.buf, int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.buf = binary.AppendVarint(t.buf, v)
+}
+
+func (t *thriftWriter) binary(id int16, b []byte) {
+	t.field(id, thriftBinary)
+	t.bytes(b)
+}
+
+// bytes writes a binary value without a field header, as list elements are.
+func (t *thriftWriter) bytes(b []byte) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(b)))
+	t.buf = append(t.buf, b...)
+}
+
+// list writes the header of a list field of n elements of type typ, the
+// elements follow without field headers.
+func (t *thriftWriter) list(id int16, typ byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|typ)
+		return
+	}
+	t.buf = append(t.buf, 0xf0|typ)
+	t.buf = binary.AppendUvarint(t.buf, uint64(n))
+}
+
+// listI32 writes a list of i32 values, the encoding of a list of enums.
+func (t *thriftWriter) listI32(id int16, values ...int32) {
+	t.list(id, thriftI32, len(values))
+	for _, v := range values {
+		t.buf = binary.AppendVarint(t.buf, int64(v))
+	}
+}
+
+// structField opens the struct held by field id.
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.begin()
+}
+
+// begin opens a struct, which has its own field ids. List elements open their
+// structs with it, without a field header.
+func (t *thriftWriter) begin() {
+	t.last = append(t.last, 0)
+}
+
+// end closes the struct opened last.
+func (t *thriftWriter) end() {
+	t.buf = append(t.buf, 0)
+	t.last = t.last[:len(t.last)-1]
+}
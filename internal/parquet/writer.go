@@ -0,0 +1,239 @@
+// Package parquet writes Parquet files with flat schemas of required columns,
+// enough for exports to be read by DuckDB, Spark or pandas without extract
+// code. Values are PLAIN encoded and left uncompressed, every column chunk is a
+// single data page.
+package parquet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// magic opens and closes every Parquet file.
+const magic = "PAR1"
+
+// Type is the physical type of a column.
+type Type int32
+
+const (
+	Int64     Type = 2
+	ByteArray Type = 6
+)
+
+// Annotation tells readers how to read the values of a column, the converted
+// type of the Parquet format.
+type Annotation int32
+
+const (
+	None            Annotation = -1
+	UTF8            Annotation = 0
+	TimestampMicros Annotation = 10
+)
+
+// Column describes a column of the file.
+type Column struct {
+	Name       string
+	Type       Type
+	Annotation Annotation
+}
+
+// Encodings, page types and codecs of the Parquet format the writer uses.
+const (
+	encodingPlain      = 0
+	encodingRLE        = 3
+	pageData           = 0
+	codecUncompressed  = 0
+	repetitionRequired = 0
+)
+
+// Writer writes rows to a Parquet file. The rows are kept in memory until
+// their values take rowGroupSize bytes, then written as a row group. Close
+// writes the last row group and the footer, the file is not valid before.
+type Writer struct {
+	w            io.Writer
+	offset       int64
+	columns      []Column
+	rowGroupSize int
+
+	values    [][]byte // PLAIN encoded values of the current row group, one per column.
+	rows      int      // Rows of the current row group.
+	total     int64    // Rows written so far.
+	rowGroups []rowGroup
+}
+
+// rowGroup is what the footer holds of a written row group.
+type rowGroup struct {
+	rows    int
+	size    int64
+	offsets []int64 // Offset of the page of every column.
+	sizes   []int64 // Size of the page of every column, its header included.
+}
+
+// NewWriter starts a Parquet file with the columns on w, writing a row group
+// every rowGroupSize bytes of values.
+func NewWriter(w io.Writer, columns []Column, rowGroupSize int) (*Writer, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("parquet writer needs at least one column")
+	}
+	for _, column := range columns {
+		if column.Type != Int64 && column.Type != ByteArray {
+			return nil, fmt.Errorf("parquet writer can not write column %q of type %d", column.Name, column.Type)
+		}
+	}
+	pw := &Writer{w: w, columns: columns, rowGroupSize: rowGroupSize, values: make([][]byte, len(columns))}
+	if err := pw.write([]byte(magic)); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// Write appends a row, holding a []byte or a string for every ByteArray column
+// and an int64 for every Int64 column, in the order of the columns.
+func (pw *Writer) Write(row ...any) error {
+	if len(row) != len(pw.columns) {
+		return fmt.Errorf("parquet writer got a row of %d values for %d columns", len(row), len(pw.columns))
+	}
+	// checked first, a row is written whole or not at all
+	for i, column := range pw.columns {
+		switch row[i].(type) {
+		case []byte, string:
+			if column.Type != ByteArray {
+				return fmt.Errorf("parquet writer got %T for integer column %q", row[i], column.Name)
+			}
+		case int64:
+			if column.Type != Int64 {
+				return fmt.Errorf("parquet writer got an integer for column %q", column.Name)
+			}
+		default:
+			return fmt.Errorf("parquet writer can not write %T to column %q", row[i], column.Name)
+		}
+	}
+	for i := range pw.columns {
+		switch v := row[i].(type) {
+		case []byte:
+			pw.values[i] = binary.LittleEndian.AppendUint32(pw.values[i], uint32(len(v)))
+			pw.values[i] = append(pw.values[i], v...)
+		case string:
+			pw.values[i] = binary.LittleEndian.AppendUint32(pw.values[i], uint32(len(v)))
+			pw.values[i] = append(pw.values[i], v...)
+		case int64:
+			pw.values[i] = binary.LittleEndian.AppendUint64(pw.values[i], uint64(v))
+		}
+	}
+	pw.rows++
+
+	size := 0
+	for _, values := range pw.values {
+		size += len(values)
+	}
+	if size >= pw.rowGroupSize {
+		return pw.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a row group, a data page per column.
+func (pw *Writer) flush() error {
+	if pw.rows == 0 {
+		return nil
+	}
+
+	group := rowGroup{rows: pw.rows}
+	for i, values := range pw.values {
+		header := newThriftWriter()
+		header.i32(1, pageData)
+		header.i32(2, int32(len(values)))
+		header.i32(3, int32(len(values)))
+		header.structField(5)
+		header.i32(1, int32(pw.rows))
+		header.i32(2, encodingPlain)
+		header.i32(3, encodingRLE)
+		header.i32(4, encodingRLE)
+		header.end()
+		header.end()
+
+		group.offsets = append(group.offsets, pw.offset)
+		if err := pw.write(header.buf); err != nil {
+			return err
+		}
+		if err := pw.write(values); err != nil {
+			return err
+		}
+		size := int64(len(header.buf) + len(values))
+		group.sizes = append(group.sizes, size)
+		group.size += size
+		pw.values[i] = values[:0]
+	}
+
+	pw.rowGroups = append(pw.rowGroups, group)
+	pw.total += int64(pw.rows)
+	pw.rows = 0
+	return nil
+}
+
+// Close writes the rows left and the footer. It does not close the underlying writer.
+func (pw *Writer) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+
+	footer := newThriftWriter()
+	footer.i32(1, 1)
+	footer.list(2, thriftStruct, len(pw.columns)+1)
+	footer.begin()
+	footer.binary(4, []byte("schema"))
+	footer.i32(5, int32(len(pw.columns)))
+	footer.end()
+	for _, column := range pw.columns {
+		footer.begin()
+		footer.i32(1, int32(column.Type))
+		footer.i32(3, repetitionRequired)
+		footer.binary(4, []byte(column.Name))
+		if column.Annotation != None {
+			footer.i32(6, int32(column.Annotation))
+		}
+		footer.end()
+	}
+	footer.i64(3, pw.total)
+	footer.list(4, thriftStruct, len(pw.rowGroups))
+	for _, group := range pw.rowGroups {
+		footer.begin()
+		footer.list(1, thriftStruct, len(pw.columns))
+		for i, column := range pw.columns {
+			footer.begin()
+			footer.i64(2, group.offsets[i])
+			footer.structField(3)
+			footer.i32(1, int32(column.Type))
+			footer.listI32(2, encodingPlain, encodingRLE)
+			footer.list(3, thriftBinary, 1)
+			footer.bytes([]byte(column.Name))
+			footer.i32(4, codecUncompressed)
+			footer.i64(5, int64(group.rows))
+			footer.i64(6, group.sizes[i])
+			footer.i64(7, group.sizes[i])
+			footer.i64(9, group.offsets[i])
+			footer.end()
+			footer.end()
+		}
+		footer.i64(2, group.size)
+		footer.i64(3, int64(group.rows))
+		footer.end()
+	}
+	footer.binary(6, []byte("goldb"))
+	footer.end()
+
+	if err := pw.write(footer.buf); err != nil {
+		return err
+	}
+	if err := pw.write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer.buf)))); err != nil {
+		return err
+	}
+	return pw.write([]byte(magic))
+}
+
+func (pw *Writer) write(b []byte) error {
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	return err
+}
@@ -0,0 +1,175 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// thriftReader decodes Thrift compact structs into maps from field ids to
+// int64, []byte, []any or nested map values, to read the files back.
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+func (t *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(t.buf[t.pos:])
+	if n <= 0 {
+		panic("bad varint")
+	}
+	t.pos += n
+	return v
+}
+
+func (t *thriftReader) varint() int64 {
+	v, n := binary.Varint(t.buf[t.pos:])
+	if n <= 0 {
+		panic("bad varint")
+	}
+	t.pos += n
+	return v
+}
+
+func (t *thriftReader) value(typ byte) any {
+	switch typ {
+	case thriftI32, thriftI64:
+		return t.varint()
+	case thriftBinary:
+		n := int(t.uvarint())
+		t.pos += n
+		return t.buf[t.pos-n : t.pos]
+	case thriftList:
+		header := t.buf[t.pos]
+		t.pos++
+		n, elem := int(header>>4), header&0x0f
+		if n == 15 {
+			n = int(t.uvarint())
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = t.value(elem)
+		}
+		return list
+	case thriftStruct:
+		fields := map[int16]any{}
+		last := int16(0)
+		for {
+			header := t.buf[t.pos]
+			t.pos++
+			if header == 0 {
+				return fields
+			}
+			id := last + int16(header>>4)
+			if header>>4 == 0 {
+				id = int16(t.varint())
+			}
+			fields[id] = t.value(header & 0x0f)
+			last = id
+		}
+	}
+	panic(fmt.Sprintf("unexpected type %d", typ))
+}
+
+// readFile returns the footer of a Parquet file and the values of every column,
+// decoded from the pages the footer points to.
+func readFile(t *testing.T, file []byte) (map[int16]any, [][]any) {
+	t.Helper()
+	if !bytes.HasPrefix(file, []byte(magic)) || !bytes.HasSuffix(file, []byte(magic)) {
+		t.Fatalf("the file does not start and end with %q", magic)
+	}
+	size := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	footer := (&thriftReader{buf: file[len(file)-8-size : len(file)-8]}).value(thriftStruct).(map[int16]any)
+
+	schema := footer[2].([]any)
+	columns := make([][]any, len(schema)-1)
+	for _, group := range footer[4].([]any) {
+		chunks := group.(map[int16]any)[1].([]any)
+		for i, chunk := range chunks {
+			meta := chunk.(map[int16]any)[3].(map[int16]any)
+			reader := &thriftReader{buf: file, pos: int(meta[9].(int64))}
+			header := reader.value(thriftStruct).(map[int16]any)
+			data := file[reader.pos : reader.pos+int(header[3].(int64))]
+			count := int(header[5].(map[int16]any)[1].(int64))
+			if count != int(meta[5].(int64)) {
+				t.Fatalf("the page of column %d holds %d values, its chunk %d", i, count, meta[5])
+			}
+			for v := 0; v < count; v++ {
+				switch Type(meta[1].(int64)) {
+				case Int64:
+					columns[i] = append(columns[i], int64(binary.LittleEndian.Uint64(data)))
+					data = data[8:]
+				case ByteArray:
+					n := binary.LittleEndian.Uint32(data)
+					columns[i] = append(columns[i], string(data[4:4+n]))
+					data = data[4+n:]
+				}
+			}
+			if len(data) != 0 {
+				t.Fatalf("the page of column %d has %d bytes left", i, len(data))
+			}
+		}
+	}
+	return footer, columns
+}
+
+// TestWriterRoundTrip writes rows over several row groups and reads them back
+// through the footer, along with the schema.
+func TestWriterRoundTrip(t *testing.T) {
+	file := &bytes.Buffer{}
+	w, err := NewWriter(file, []Column{
+		{Name: "key", Type: ByteArray, Annotation: UTF8},
+		{Name: "value", Type: ByteArray, Annotation: None},
+		{Name: "size", Type: Int64, Annotation: None},
+	}, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const rows = 100
+	for i := 0; i < rows; i++ {
+		value := bytes.Repeat([]byte{byte(i), 0xff}, i%7)
+		if err := w.Write(fmt.Sprintf("key-%03d", i), value, int64(-i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Write("key", []byte("value")); err == nil {
+		t.Fatal("a row missing a column is written")
+	}
+	if err := w.Write("key", []byte("value"), "size"); err == nil {
+		t.Fatal("a string is written to an integer column")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	footer, columns := readFile(t, file.Bytes())
+	if footer[3].(int64) != rows {
+		t.Fatalf("the footer counts %d rows, want %d", footer[3], rows)
+	}
+	if groups := len(footer[4].([]any)); groups < 2 {
+		t.Fatalf("the rows are written in %d row groups, want several", groups)
+	}
+	schema := footer[2].([]any)
+	if root := schema[0].(map[int16]any); root[5].(int64) != 3 {
+		t.Fatalf("the schema root has %d children, want 3", root[5])
+	}
+	for i, want := range []struct {
+		name       string
+		typ        Type
+		annotation Annotation
+	}{{"key", ByteArray, UTF8}, {"value", ByteArray, None}, {"size", Int64, None}} {
+		element := schema[i+1].(map[int16]any)
+		annotation, ok := element[6]
+		if string(element[4].([]byte)) != want.name || Type(element[1].(int64)) != want.typ || ok != (want.annotation != None) || (ok && Annotation(annotation.(int64)) != want.annotation) {
+			t.Fatalf("column %d is described as %v, want %+v", i, element, want)
+		}
+	}
+
+	for i := 0; i < rows; i++ {
+		value := bytes.Repeat([]byte{byte(i), 0xff}, i%7)
+		if columns[0][i] != fmt.Sprintf("key-%03d", i) || columns[1][i] != string(value) || columns[2][i] != int64(-i) {
+			t.Fatalf("row %d reads %v, %q, %v", i, columns[0][i], columns[1][i], columns[2][i])
+		}
+	}
+}
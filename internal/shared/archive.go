@@ -0,0 +1,12 @@
+package shared
+
+import "io"
+
+// ArchiveDestination stores the files shipped by the archiver, like a directory
+// on another disk or a bucket of an object store such as S3.
+type ArchiveDestination interface {
+	// Put stores content under name, replacing a previous copy.
+	Put(name string, content io.Reader) error
+	// Open reads back the copy stored under name, to verify it.
+	Open(name string) (io.ReadCloser, error)
+}
@@ -0,0 +1,10 @@
+package shared
+
+// Codec marshals Go values stored with SetStruct and read with GetStruct.
+type Codec interface {
+	// Name identifies the codec in stored values, so data written with one codec
+	// stays readable after the configured codec changes.
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
@@ -0,0 +1,31 @@
+package shared
+
+import "strings"
+
+// Collation orders the keys of a namespace when they are listed.
+type Collation interface {
+	// Name identifies the collation in the database directory, so a namespace
+	// can not be reopened with a different order than it was created with.
+	Name() string
+	// Compare returns a negative number when a sorts before b, a positive one
+	// when it sorts after, and zero when they are equal under the collation.
+	// The keys are passed without the namespace prefix. Keys that are equal
+	// under the collation are listed in byte-wise order.
+	Compare(a, b string) int
+}
+
+// NamespaceCollation applies Collation to the keys starting with Prefix.
+type NamespaceCollation struct {
+	Prefix    string
+	Collation Collation
+}
+
+// CollationFor returns the namespace key belongs to, if it has a collation.
+func (ec *EngineConfig) CollationFor(key string) (NamespaceCollation, bool) {
+	for _, namespace := range ec.Collations {
+		if strings.HasPrefix(key, namespace.Prefix) {
+			return namespace, true
+		}
+	}
+	return NamespaceCollation{}, false
+}
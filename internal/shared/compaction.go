@@ -0,0 +1,35 @@
+package shared
+
+// TableInfo describes an SSTable on disk.
+type TableInfo struct {
+	Serial   uint32
+	IsLevel  bool
+	Depth    int    // Level of the leveled compaction, zero for the SSTables and the other levels.
+	Size     uint32 // Number of pairs in the table.
+	FileSize int64  // Size of the table file in bytes.
+	MinKey   string
+	MaxKey   string
+	MinTime  int64 // Oldest entry timestamp in unix nanoseconds.
+	MaxTime  int64 // Newest entry timestamp in unix nanoseconds.
+
+	CreatedAt int64 // Time the table was written in unix nanoseconds.
+}
+
+// CompactionPicker selects which SSTables get merged into a new level.
+type CompactionPicker interface {
+	// Pick receives the SSTables ordered from newest to oldest and returns the
+	// ones to compact. Returning none skips compaction. Every SSTable older than
+	// the newest picked table is merged too, so newer data keeps shadowing older data.
+	Pick(tables []TableInfo, config *EngineConfig) []TableInfo
+}
+
+// ThresholdPicker compacts all the SSTables once their count exceeds
+// EngineConfig.CompactionThreshold.
+type ThresholdPicker struct{}
+
+func (ThresholdPicker) Pick(tables []TableInfo, config *EngineConfig) []TableInfo {
+	if len(tables) <= int(config.CompactionThreshold) {
+		return nil
+	}
+	return tables
+}
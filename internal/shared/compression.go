@@ -0,0 +1,10 @@
+package shared
+
+// Compressor compresses the values written to the value file.
+type Compressor interface {
+	// Name identifies the compressor in the database, values compressed by one
+	// can only be read back by a compressor of the same name.
+	Name() string
+	Compress(value []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
@@ -1,24 +1,149 @@
 package shared
 
+import (
+	"strings"
+	"time"
+)
+
 var DefaultConfig = EngineConfig{
 	KeySize:               256,
 	MemtableSizeThreshold: 1000,
 	SSTableNamePrefix:     "sst_",
 	LevelFileNamePrefix:   "lvl_",
 	CompactionThreshold:   10,
+	CompactionWorkers:     1,
 }
 
 // EngineConfig defines the configuration parameters for the Goldb database engine.
 // It allows customization of key sizes, memtable thresholds, file naming conventions, and compaction behavior.
 type EngineConfig struct {
-	KeySize               uint32 // Maximum size of a key in bytes.
-	MemtableSizeThreshold uint32 // Maximum number of key-value pairs the memtable can hold before flushing to disk.
-	SSTableNamePrefix     string // Prefix for SSTable file names.
-	LevelFileNamePrefix   string // Prefix for level file names.
-	CompactionThreshold   uint32 // Number of SSTables that if exceeded will trigger compaction.
+	KeySize               uint32               // Maximum size of a key in bytes.
+	MemtableSizeThreshold uint32               // Maximum number of key-value pairs the memtable can hold before flushing to disk.
+	MemtableKind          MemtableKind         // Structure of the memtable, an AVL tree by default.
+	SSTableNamePrefix     string               // Prefix for SSTable file names.
+	LevelFileNamePrefix   string               // Prefix for level file names.
+	CompactionThreshold   uint32               // Number of SSTables that if exceeded will trigger compaction.
+	Retention             []RetentionPolicy    // Age limits for keys, enforced by reads and compaction.
+	Collations            []NamespaceCollation // Key order of namespaces in listings, byte-wise for the other keys.
+	ReadHooks             []ReadHook           // Transformations applied to the values of a namespace when they are read.
+	CompactionPicker      CompactionPicker     // Selects the SSTables to compact, ThresholdPicker when nil.
+	CompactionStrategy    CompactionStrategy   // Decides how the tables are compacted after a flush, PickerStrategy when nil. Partitioned flushes compact every partition with the picker.
+	CompactionWorkers     int                  // Number of tables read in parallel by a compaction, can be changed with SetOption.
+	CompactionRateLimit   int64                // Bytes per second a compaction may write, zero is unlimited, can be changed with SetOption.
+	OnCompactionError     func(err error)      // Called with the errors of the background compactions, which are logged when nil.
+	LowPriorityReadRate   int64                // Bytes per second low priority reads may read, zero is unlimited, can be changed with SetOption.
+	VerifyWrites          bool                 // Re-read and checksum every flushed or compacted table before using it.
+	PinLevels             int                  // Number of newest levels whose indexes stay in memory, the SSTables count as the first level.
+	PinPrefixes           []string             // Namespaces whose tables keep their indexes in memory.
+	BlockCacheSize        int64                // Bytes of recently read table blocks and values kept in memory, zero disables the cache.
+	NegativeCacheSize     int                  // Number of keys last found missing remembered so looking them up again skips the tables, zero disables the cache.
+	ValueSync             ValueSyncPolicy      // When the value file is synced to disk, before every flush by default.
+	MaintenanceWindows    []MaintenanceWindow  // When heavy maintenance may run, at any time when empty.
+	WriteCoalesceWindow   time.Duration        // How long writes wait to be grouped with others, zero disables coalescing.
+	RelaxedWrites         bool                 // Acknowledge writes once in the memtable, appending them to the WAL in the background.
+	StructCodec           Codec                // Encoding used by SetStruct, JSON when nil.
+	Compression           Compressor           // Compresses the values in the value file, nil stores them as they are.
+	DedupValues           bool                 // Store identical values once, keys written with them share the record.
+	SlowOpThreshold       time.Duration        // Operations taking longer are logged along with their tag, zero disables the slow log.
+	MaxBatchEntries       int                  // Maximum number of operations in a batch, zero is unlimited.
+	MaxBatchBytes         int                  // Maximum size of the keys and values in a batch, zero is unlimited.
+	UndeleteWindow        time.Duration        // How long deleted keys can be restored with Undelete, zero deletes right away.
+	TombstoneRunThreshold int                  // Runs of deleted keys a scan must skip to log a hint, zero disables the hints.
+	CompactTombstoneRuns  bool                 // Compact the ranges reported by tombstone hints in the next maintenance.
+	TrashRetention        time.Duration        // How long prefixes dropped with DropPrefix stay restorable, zero skips the trash.
+	WALEncryptionKey      []byte               // AES key the WAL is encrypted with, 16, 24 or 32 bytes, nil keeps it in plain text.
+	WALDecryptionKeys     [][]byte             // Previous WAL keys, only used to read entries written before a key rotation.
+	WALSegmentSize        int64                // Size at which the WAL moves on to a new segment file, 64 MiB when zero.
+	SyncMode              SyncMode             // When the WAL is synced to disk, left to the OS by default.
+	SyncInterval          time.Duration        // How often the WAL is synced in SyncInterval mode, 100ms when zero.
+	FlushFormat           FlushFormat          // Whether a flush writes one SSTable or one per key partition.
+	PartitionSeparator    string               // Keys are partitioned by their prefix up to the first separator, "/" when empty.
+	ValueGCThreshold      float64              // Ratio of the value file size to its live values that triggers a garbage collection, zero disables it.
+	WALKeyProvider        KeyProvider          // Supplies a wrapped data key per WAL, in place of WALEncryptionKey.
+	InlineValueSize       int                  // Values up to this size are kept in the tables rather than the value file, zero keeps them all in the value file.
+	Archive               ArchiveDestination   // Where the closed WAL segments are shipped to, nil disables archiving.
+	ArchiveInterval       time.Duration        // How often the archiver ships the pending files, 10s when zero.
+	Metrics               MetricsReporter      // Where the counters and the timings of the operations are pushed to, nil disables pushing.
+	MetricsInterval       time.Duration        // How often the counters are pushed, 10s when zero.
+	CrashHook             func(CrashPoint)     // Called at the crash points, only set by failure injection tests.
 	Homepath              string
 }
 
+// ReadHook transforms the values of the keys starting with Prefix as they are
+// read, for example to migrate values written with an old schema lazily. When
+// Persist is set a transformed value is written back, so it is only migrated once.
+// When several hooks match a key the one with the longest prefix wins.
+type ReadHook struct {
+	Prefix    string
+	Transform func(key string, value []byte) ([]byte, error)
+	Persist   bool
+}
+
+// ValueSyncPolicy decides when the value file is synced to disk.
+//
+// Entries replayed from the WAL carry their values, so only the tables point
+// into the value file. Syncing it before a table is installed and the WAL
+// truncated makes sure a table never points at value bytes lost in a crash.
+type ValueSyncPolicy int
+
+const (
+	ValueSyncOnFlush ValueSyncPolicy = iota // Sync once before each flush, batching the writes since the last one.
+	ValueSyncNever                          // Leave syncing to the OS, a crash may lose flushed values.
+)
+
+// SyncMode decides when the WAL is synced to disk. A write survives a crash of
+// the process as soon as it is logged, but only survives a power loss once the
+// WAL holding it is synced.
+type SyncMode int
+
+const (
+	SyncNever    SyncMode = iota // Leave syncing to the OS, a power loss may lose recent writes.
+	SyncAlways                   // Sync every WAL write before the write returns.
+	SyncInterval                 // Sync in the background every SyncInterval, a power loss may lose the writes of the last interval.
+)
+
+// FlushFormat decides how a flush lays the memtable out in SSTables.
+type FlushFormat int
+
+const (
+	FlushSortedRun   FlushFormat = iota // Every flush writes one SSTable holding all its keys.
+	FlushPartitioned                    // Every flush writes one SSTable per partition, which are compacted apart.
+)
+
+// MemtableKind decides the structure of the memtable.
+type MemtableKind int
+
+const (
+	MemtableAVL      MemtableKind = iota // An AVL tree, writes to the memtable block lookups and other writes.
+	MemtableSkiplist                     // A lock-free skiplist, writes go on alongside lookups and other writes.
+)
+
+// CrashPoint names a step of the engine after which a failure injection test,
+// like the chaos command, may crash the process to check what recovery makes of
+// the files left behind.
+type CrashPoint string
+
+const (
+	CrashAfterWALAppend  CrashPoint = "wal-append" // A batch is in the WAL but not in the memtable yet.
+	CrashAfterFlush      CrashPoint = "flush"      // The memtable is in a new SSTable but the WAL is not cleared yet.
+	CrashAfterCompaction CrashPoint = "compaction" // The merged level is written but the merged tables are not removed yet.
+)
+
+// ReachCrashPoint calls the CrashHook, if any, with point.
+func (ec *EngineConfig) ReachCrashPoint(point CrashPoint) {
+	if ec.CrashHook != nil {
+		ec.CrashHook(point)
+	}
+}
+
+// RetentionPolicy ages out keys starting with Prefix once they are older than MaxAge.
+// An empty prefix applies to every key; when several policies match a key the one
+// with the longest prefix wins.
+type RetentionPolicy struct {
+	Prefix string
+	MaxAge time.Duration
+}
+
 func NewEngineConfig() *EngineConfig {
 	return &EngineConfig{
 		KeySize:               DefaultConfig.KeySize,
@@ -26,6 +151,7 @@ func NewEngineConfig() *EngineConfig {
 		SSTableNamePrefix:     DefaultConfig.SSTableNamePrefix,
 		LevelFileNamePrefix:   DefaultConfig.LevelFileNamePrefix,
 		CompactionThreshold:   DefaultConfig.CompactionThreshold,
+		CompactionWorkers:     DefaultConfig.CompactionWorkers,
 	}
 }
 
@@ -54,18 +180,359 @@ func (ec *EngineConfig) WithLevelFileNamePrefix(value string) *EngineConfig {
 	return ec
 }
 
+func (ec *EngineConfig) WithRetention(prefix string, maxAge time.Duration) *EngineConfig {
+	ec.Retention = append(ec.Retention, RetentionPolicy{Prefix: prefix, MaxAge: maxAge})
+	return ec
+}
+
+// WithCollation makes the keys starting with prefix be listed in the order of
+// collation. Namespaces must not be nested in one another.
+func (ec *EngineConfig) WithCollation(prefix string, collation Collation) *EngineConfig {
+	ec.Collations = append(ec.Collations, NamespaceCollation{Prefix: prefix, Collation: collation})
+	return ec
+}
+
+func (ec *EngineConfig) WithReadHook(prefix string, transform func(key string, value []byte) ([]byte, error), persist bool) *EngineConfig {
+	ec.ReadHooks = append(ec.ReadHooks, ReadHook{Prefix: prefix, Transform: transform, Persist: persist})
+	return ec
+}
+
+func (ec *EngineConfig) WithCompactionPicker(picker CompactionPicker) *EngineConfig {
+	ec.CompactionPicker = picker
+	return ec
+}
+
+func (ec *EngineConfig) WithCompactionWorkers(value int) *EngineConfig {
+	ec.CompactionWorkers = value
+	return ec
+}
+
+func (ec *EngineConfig) WithCompactionRateLimit(bytesPerSecond int64) *EngineConfig {
+	ec.CompactionRateLimit = bytesPerSecond
+	return ec
+}
+
+// WithVerifyWrites makes every flush and compaction read the table it wrote back
+// from disk and compare its checksum with the bytes that were written. A table
+// that does not match is removed instead of replacing the data it was built from,
+// so bad hardware is caught before a corrupt file becomes part of the database.
+func (ec *EngineConfig) WithVerifyWrites(value bool) *EngineConfig {
+	ec.VerifyWrites = value
+	return ec
+}
+
+// WithMaintenanceWindow confines the heavy maintenance to the given days (every
+// day when none is given) between start and end, offsets from local midnight.
+// Heavy maintenance purges the trash and, only when windows are configured,
+// merges all the levels into one. The compaction of SSTables into a level
+// keeps running at any time.
+func (ec *EngineConfig) WithMaintenanceWindow(start, end time.Duration, days ...time.Weekday) *EngineConfig {
+	ec.MaintenanceWindows = append(ec.MaintenanceWindows, MaintenanceWindow{Days: days, Start: start, End: end})
+	return ec
+}
+
+func (ec *EngineConfig) WithValueSync(policy ValueSyncPolicy) *EngineConfig {
+	ec.ValueSync = policy
+	return ec
+}
+
+// WithSyncMode sets when the WAL is synced to disk, trading write latency for
+// durability. Coalescing writes with WithWriteCoalesceWindow makes SyncAlways
+// sync once per group of writes.
+func (ec *EngineConfig) WithSyncMode(mode SyncMode) *EngineConfig {
+	ec.SyncMode = mode
+	return ec
+}
+
+// WithSyncInterval syncs the WAL in the background every interval.
+func (ec *EngineConfig) WithSyncInterval(interval time.Duration) *EngineConfig {
+	ec.SyncMode = SyncInterval
+	ec.SyncInterval = interval
+	return ec
+}
+
+func (ec *EngineConfig) WithWriteCoalesceWindow(value time.Duration) *EngineConfig {
+	ec.WriteCoalesceWindow = value
+	return ec
+}
+
+// WithRelaxedWrites makes writes visible and acknowledged as soon as they reach the
+// memtable, while the WAL append happens in the background. Writes acknowledged
+// but not yet logged are lost if the process crashes, so this only suits jobs
+// that checkpoint externally and can replay their input.
+func (ec *EngineConfig) WithRelaxedWrites(value bool) *EngineConfig {
+	ec.RelaxedWrites = value
+	return ec
+}
+
+func (ec *EngineConfig) WithSlowOpThreshold(value time.Duration) *EngineConfig {
+	ec.SlowOpThreshold = value
+	return ec
+}
+
+func (ec *EngineConfig) WithStructCodec(codec Codec) *EngineConfig {
+	ec.StructCodec = codec
+	return ec
+}
+
+func (ec *EngineConfig) WithMaxBatchEntries(value int) *EngineConfig {
+	ec.MaxBatchEntries = value
+	return ec
+}
+
+func (ec *EngineConfig) WithMaxBatchBytes(value int) *EngineConfig {
+	ec.MaxBatchBytes = value
+	return ec
+}
+
+func (ec *EngineConfig) WithUndeleteWindow(value time.Duration) *EngineConfig {
+	ec.UndeleteWindow = value
+	return ec
+}
+
+// WithCompression compresses the values written to the value file. A value is
+// only stored compressed when that makes it smaller.
+func (ec *EngineConfig) WithCompression(compressor Compressor) *EngineConfig {
+	ec.Compression = compressor
+	return ec
+}
+
+// WithDedupValues hashes the values as they are written and points a key at the
+// stored record of an identical value instead of storing it again. Opening the
+// database reads every live value once to index it.
+func (ec *EngineConfig) WithDedupValues(value bool) *EngineConfig {
+	ec.DedupValues = value
+	return ec
+}
+
+// WithWALSegmentSize rotates the WAL to a new segment file once the current one
+// grows past size bytes. Segments are deleted once the memtable they cover is
+// flushed, so smaller segments free their space sooner.
+func (ec *EngineConfig) WithWALSegmentSize(size int64) *EngineConfig {
+	ec.WALSegmentSize = size
+	return ec
+}
+
+// WithMemtableKind sets the structure of the memtable. MemtableSkiplist lets
+// reads and scans run while writes are applied, which helps read-heavy
+// workloads with a steady stream of writes.
+func (ec *EngineConfig) WithMemtableKind(kind MemtableKind) *EngineConfig {
+	ec.MemtableKind = kind
+	return ec
+}
+
+// WithBlockCacheSize keeps up to size bytes of the table blocks and the values
+// read last in memory, so the hot keys are served without reading the disk.
+func (ec *EngineConfig) WithBlockCacheSize(size int64) *EngineConfig {
+	ec.BlockCacheSize = size
+	return ec
+}
+
+// WithNegativeCacheSize remembers the last count keys found missing, so
+// repeated lookups of keys that do not exist skip the tables. A key is forgotten
+// as soon as it is written.
+func (ec *EngineConfig) WithNegativeCacheSize(count int) *EngineConfig {
+	ec.NegativeCacheSize = count
+	return ec
+}
+
+// WithPartitionedFlush makes flushes write one SSTable per partition of the keys,
+// their prefix up to and including the first separator, and compact the tables
+// of every partition on their own. Workloads whose tenants write disjoint
+// prefixes then never merge the tables of one tenant with another's. Keys
+// without the separator share one partition.
+func (ec *EngineConfig) WithPartitionedFlush(separator string) *EngineConfig {
+	ec.FlushFormat = FlushPartitioned
+	ec.PartitionSeparator = separator
+	return ec
+}
+
+// WithValueGCThreshold collects the garbage of the value file after a flush once
+// it grows ratio times larger than the values still in use, like 2 to let at
+// most half of it go to waste. The collection rewrites the live values to a new
+// file and writes wait while it runs.
+func (ec *EngineConfig) WithValueGCThreshold(ratio float64) *EngineConfig {
+	ec.ValueGCThreshold = ratio
+	return ec
+}
+
+// WithInlineValueSize keeps the values of up to size bytes in the tables next to
+// their keys, so reading them takes no lookup in the value file. Larger values
+// stay in the value file, which keeps the tables small for compactions to merge.
+// Inline values are not compressed.
+func (ec *EngineConfig) WithInlineValueSize(size int) *EngineConfig {
+	ec.InlineValueSize = size
+	return ec
+}
+
+// WithPinLevels keeps the indexes of the SSTables and of the newest n-1 levels in
+// memory, so lookups in the recent data never read their keys from disk.
+func (ec *EngineConfig) WithPinLevels(n int) *EngineConfig {
+	ec.PinLevels = n
+	return ec
+}
+
+// WithPinPrefix keeps the indexes of the tables holding keys under prefix in memory.
+func (ec *EngineConfig) WithPinPrefix(prefix string) *EngineConfig {
+	ec.PinPrefixes = append(ec.PinPrefixes, prefix)
+	return ec
+}
+
+// WithTombstoneRunThreshold logs a hint whenever a scan skips at least n deleted
+// keys in a row, which is what makes scans over delete-heavy queues slow.
+func (ec *EngineConfig) WithTombstoneRunThreshold(n int) *EngineConfig {
+	ec.TombstoneRunThreshold = n
+	return ec
+}
+
+// WithCompactTombstoneRuns compacts the ranges reported by tombstone hints in the
+// next maintenance, which drops their deleted keys for good.
+func (ec *EngineConfig) WithCompactTombstoneRuns(value bool) *EngineConfig {
+	ec.CompactTombstoneRuns = value
+	return ec
+}
+
+// WithLowPriorityReadRate caps the bytes per second read by low priority reads,
+// like exports, so they leave the disk to the interactive ones.
+func (ec *EngineConfig) WithLowPriorityReadRate(bytesPerSecond int64) *EngineConfig {
+	ec.LowPriorityReadRate = bytesPerSecond
+	return ec
+}
+
+func (ec *EngineConfig) WithTrashRetention(value time.Duration) *EngineConfig {
+	ec.TrashRetention = value
+	return ec
+}
+
+// WithWALEncryptionKey encrypts the WAL, which holds the most recent writes, with
+// AES-GCM under key. The WAL must be empty (the database closed after a flush)
+// when the key is set, changed or removed, or the pending entries can not be read.
+func (ec *EngineConfig) WithWALEncryptionKey(key []byte) *EngineConfig {
+	ec.WALEncryptionKey = key
+	return ec
+}
+
+// WithWALDecryptionKeys adds keys the WAL was encrypted with before the last
+// rotation. They are needed until the entries written under them were flushed.
+func (ec *EngineConfig) WithWALDecryptionKeys(keys ...[]byte) *EngineConfig {
+	ec.WALDecryptionKeys = append(ec.WALDecryptionKeys, keys...)
+	return ec
+}
+
+// WithWALKeyProvider encrypts the WAL with data keys from provider, so the keys
+// can come from a KMS rather than the configuration. A new data key is used every
+// time the WAL is cleared by a flush.
+func (ec *EngineConfig) WithWALKeyProvider(provider KeyProvider) *EngineConfig {
+	ec.WALKeyProvider = provider
+	return ec
+}
+
+// WithArchive ships every closed WAL segment to destination in the background,
+// checking every interval for the files waiting to be shipped.
+func (ec *EngineConfig) WithArchive(destination ArchiveDestination, interval time.Duration) *EngineConfig {
+	ec.Archive = destination
+	ec.ArchiveInterval = interval
+	return ec
+}
+
+// WithMetrics pushes the timings of the operations to reporter as they happen,
+// and the counters of Stats every interval.
+func (ec *EngineConfig) WithMetrics(reporter MetricsReporter, interval time.Duration) *EngineConfig {
+	ec.Metrics = reporter
+	ec.MetricsInterval = interval
+	return ec
+}
+
+// WithCompactionStrategy sets the strategy deciding how the tables are compacted.
+func (ec *EngineConfig) WithCompactionStrategy(strategy CompactionStrategy) *EngineConfig {
+	ec.CompactionStrategy = strategy
+	return ec
+}
+
+// WithLeveledCompaction compacts with a LeveledStrategy of count levels, the
+// first one holding up to baseSize bytes and every other one fanout times more
+// than the one above it. Zero values pick the defaults.
+func (ec *EngineConfig) WithLeveledCompaction(baseSize int64, fanout, count int) *EngineConfig {
+	return ec.WithCompactionStrategy(LeveledStrategy{BaseSize: baseSize, Fanout: fanout, Levels: count})
+}
+
+// GetCompactionStrategy returns the configured strategy, falling back to PickerStrategy.
+func (ec *EngineConfig) GetCompactionStrategy() CompactionStrategy {
+	if ec.CompactionStrategy == nil {
+		return PickerStrategy{}
+	}
+	return ec.CompactionStrategy
+}
+
+// GetCompactionPicker returns the configured picker, falling back to ThresholdPicker.
+func (ec *EngineConfig) GetCompactionPicker() CompactionPicker {
+	if ec.CompactionPicker == nil {
+		return ThresholdPicker{}
+	}
+	return ec.CompactionPicker
+}
+
+// KeyPartition returns the partition of key for partitioned flushes: its prefix
+// up to and including the first PartitionSeparator, empty when it has none.
+func (ec *EngineConfig) KeyPartition(key string) string {
+	separator := ec.PartitionSeparator
+	if separator == "" {
+		separator = "/"
+	}
+	i := strings.Index(key, separator)
+	if i < 0 {
+		return ""
+	}
+	return key[:i+len(separator)]
+}
+
+// ReadHookFor returns the hook that transforms the values of key, if any.
+func (ec *EngineConfig) ReadHookFor(key string) (ReadHook, bool) {
+	var match ReadHook
+	found := false
+	for _, hook := range ec.ReadHooks {
+		if strings.HasPrefix(key, hook.Prefix) && (!found || len(hook.Prefix) > len(match.Prefix)) {
+			match, found = hook, true
+		}
+	}
+	return match, found
+}
+
+// RetentionFor returns the policy that governs key, if any.
+func (ec *EngineConfig) RetentionFor(key string) (RetentionPolicy, bool) {
+	var match RetentionPolicy
+	found := false
+	for _, policy := range ec.Retention {
+		if strings.HasPrefix(key, policy.Prefix) && (!found || len(policy.Prefix) > len(match.Prefix)) {
+			match, found = policy, true
+		}
+	}
+	return match, found
+}
+
+// Expired reports whether an entry for key written at timestamp (unix nanoseconds)
+// fell out of its retention window.
+func (ec *EngineConfig) Expired(key string, timestamp int64) bool {
+	policy, ok := ec.RetentionFor(key)
+	if !ok {
+		return false
+	}
+	return time.Since(time.Unix(0, timestamp)) > policy.MaxAge
+}
+
 // GetMetadataSize calculates the size of the metadata section in an SSTable.
-// The metadata includes the serial number, pair count, min key, and max key.
+// The metadata includes the level flag, serial number, pair count, min key, max key,
+// min timestamp, and max timestamp.
 // Returns the total size in bytes.
 func (ec *EngineConfig) GetMetadataSize() uint32 {
-	return ec.KeySize*2 + UintSize*2
+	return 1 + UintSize*2 + ec.KeySize*2 + Uint64Size*2
 }
 
 // GetKVPairSize calculates the size of a key-value pair in an SSTable.
-// Each pair consists of a key, an offset, and a size.
+// Each pair consists of a key, an offset, a size, a timestamp, and a flags byte.
 // Returns the total size in bytes.
 func (ec *EngineConfig) GetKVPairSize() uint32 {
-	return ec.KeySize + UintSize*2 // "<key><offset><size>"
+	return ec.KeySize + UintSize*2 + Uint64Size + 1 // "<key><offset><size><timestamp><flags>"
 }
 
 // GetSSTableExpectedSize calculates the expected size of an SSTable based on the configuration.
@@ -0,0 +1,13 @@
+package shared
+
+// KeyProvider supplies data keys for envelope encryption, typically backed by an
+// external KMS such as AWS KMS or Vault so no key is kept in plain configuration.
+// Every encrypted file gets its own data key, and only the wrapped form of the
+// key, encrypted under the master key of the provider, is stored with the file.
+type KeyProvider interface {
+	// GenerateDataKey returns a fresh AES data key of 16, 24 or 32 bytes along
+	// with its wrapped form.
+	GenerateDataKey() (key []byte, wrapped []byte, err error)
+	// GetKey unwraps a data key returned by GenerateDataKey.
+	GetKey(wrapped []byte) ([]byte, error)
+}
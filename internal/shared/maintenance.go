@@ -0,0 +1,51 @@
+package shared
+
+import "time"
+
+// MaintenanceWindow is a recurring period of the day, in local time, during which
+// heavy maintenance may run. End before Start makes the window span midnight.
+type MaintenanceWindow struct {
+	Days  []time.Weekday // Days the window starts on, every day when empty.
+	Start time.Duration  // Offset from midnight the window opens at.
+	End   time.Duration  // Offset from midnight the window closes at.
+}
+
+// Contains reports whether t falls within the window.
+func (mw MaintenanceWindow) Contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+
+	if mw.Start <= mw.End {
+		return mw.startsOn(t.Weekday()) && offset >= mw.Start && offset < mw.End
+	}
+
+	// the window spans midnight, it opened either today or yesterday
+	yesterday := (t.Weekday() + 6) % 7
+	return (mw.startsOn(t.Weekday()) && offset >= mw.Start) || (mw.startsOn(yesterday) && offset < mw.End)
+}
+
+func (mw MaintenanceWindow) startsOn(day time.Weekday) bool {
+	if len(mw.Days) == 0 {
+		return true
+	}
+	for _, d := range mw.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// MaintenanceAllowed reports whether heavy maintenance may run at t, which is
+// always the case when no window is configured.
+func (ec *EngineConfig) MaintenanceAllowed(t time.Time) bool {
+	if len(ec.MaintenanceWindows) == 0 {
+		return true
+	}
+	for _, window := range ec.MaintenanceWindows {
+		if window.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,13 @@
+package shared
+
+import "time"
+
+// MetricsReporter receives the metrics pushed by the engine, like the client of
+// a StatsD or Datadog agent. Timings are reported from the operations
+// themselves, so the reporter must not block.
+type MetricsReporter interface {
+	// Count adds delta to the counter name.
+	Count(name string, delta int64, tags []string)
+	// Timing records one duration of the timer name.
+	Timing(name string, duration time.Duration, tags []string)
+}
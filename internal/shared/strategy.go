@@ -0,0 +1,211 @@
+package shared
+
+import "math"
+
+// CompactionStep is a compaction chosen by a CompactionStrategy.
+type CompactionStep struct {
+	// Merge lists the tables merged into a new level. They must follow each
+	// other in the order the tables are searched, from newest to oldest.
+	Merge []TableInfo
+	// Depth is the depth of the new level, from 1, for the strategies keeping
+	// their levels at fixed depths, zero for a plain level.
+	Depth int
+	// Drop lists the tables deleted without being merged, their keys are lost.
+	Drop []TableInfo
+}
+
+// Empty reports whether the step has nothing to do.
+func (s CompactionStep) Empty() bool {
+	return len(s.Merge) == 0 && len(s.Drop) == 0
+}
+
+// CompactionStrategy decides how the tables are compacted once a flush added
+// a new SSTable. Tables are searched from the SSTables, newest first, through
+// the levels with a depth, by depth, to the plain levels, newest first. A level
+// written by a step goes where its depth puts it, ahead of the plain levels
+// when it is plain, so the step must merge the tables it is meant to replace
+// there: the engine refuses the steps that would let older keys shadow newer ones.
+type CompactionStrategy interface {
+	// Next receives the SSTables and the levels in the order they are searched
+	// and returns the next step, or an empty one when there is nothing left to
+	// do. It is called again once the step is done.
+	Next(sstables, levels []TableInfo, config *EngineConfig) CompactionStep
+}
+
+// PickerStrategy merges the SSTables picked by the CompactionPicker, along with
+// every older SSTable, into a new plain level. It is the default strategy.
+type PickerStrategy struct{}
+
+func (PickerStrategy) Next(sstables, levels []TableInfo, config *EngineConfig) CompactionStep {
+	merge := pickedRun(sstables, config)
+	if len(merge) == 0 {
+		return CompactionStep{}
+	}
+	return CompactionStep{Merge: append(merge, levelsWithDepth(levels)...)}
+}
+
+// LeveledStrategy merges the picked SSTables into the first of a fixed number
+// of levels, and every level grown past its size into the one below it, so a
+// lookup reads a single table per level. Zero fields pick the defaults.
+type LeveledStrategy struct {
+	BaseSize int64 // Size in bytes the first level may reach, 10 MiB when zero.
+	Fanout   int   // How many times larger than the one above it a level may grow, 10 when zero.
+	Levels   int   // Number of levels, the last one grows without bound, 7 when zero.
+}
+
+func (s LeveledStrategy) Next(sstables, levels []TableInfo, config *EngineConfig) CompactionStep {
+	if merge := pickedRun(sstables, config); len(merge) > 0 {
+		return CompactionStep{Merge: append(merge, levelsAt(levels, 1)...), Depth: 1}
+	}
+
+	for depth := 1; depth < s.levelCount(); depth++ {
+		at := levelsAt(levels, depth)
+		size := int64(0)
+		for _, level := range at {
+			size += level.FileSize
+		}
+		if size > s.MaxSize(depth) {
+			return CompactionStep{Merge: append(at, levelsAt(levels, depth+1)...), Depth: depth + 1}
+		}
+	}
+	return CompactionStep{}
+}
+
+func (s LeveledStrategy) levelCount() int {
+	if s.Levels == 0 {
+		return 7
+	}
+	return s.Levels
+}
+
+// MaxSize returns the size in bytes the level at depth may reach before it is
+// merged into the next one.
+func (s LeveledStrategy) MaxSize(depth int) int64 {
+	size, fanout := s.BaseSize, int64(s.Fanout)
+	if size == 0 {
+		size = 10 << 20
+	}
+	if fanout == 0 {
+		fanout = 10
+	}
+	for i := 1; i < depth && size <= math.MaxInt64/fanout; i++ {
+		size *= fanout
+	}
+	return size
+}
+
+// SizeTieredStrategy merges the picked SSTables into a new plain level like
+// PickerStrategy, then merges the newest plain levels once enough of them have
+// about the same size, so every level is a few times larger than the newer
+// ones. Writes are cheaper than with LeveledStrategy, lookups read more tables.
+// Zero fields pick the defaults.
+type SizeTieredStrategy struct {
+	MinMerge int     // Number of levels of about the same size that get merged, 4 when zero.
+	Ratio    float64 // How many times larger than the smallest one a level of the same tier may be, 2 when zero.
+}
+
+func (s SizeTieredStrategy) Next(sstables, levels []TableInfo, config *EngineConfig) CompactionStep {
+	if step := (PickerStrategy{}).Next(sstables, levels, config); !step.Empty() {
+		return step
+	}
+
+	minMerge, ratio := s.MinMerge, s.Ratio
+	if minMerge == 0 {
+		minMerge = 4
+	}
+	if ratio == 0 {
+		ratio = 2
+	}
+
+	tier := []TableInfo{}
+	smallest, largest := int64(0), int64(0)
+	for _, level := range levels {
+		if level.Depth > 0 {
+			continue
+		}
+		if len(tier) == 0 {
+			smallest, largest = level.FileSize, level.FileSize
+		} else {
+			smallest, largest = min(smallest, level.FileSize), max(largest, level.FileSize)
+		}
+		if float64(largest) > float64(smallest)*ratio {
+			break
+		}
+		tier = append(tier, level)
+	}
+	if len(tier) < minMerge {
+		return CompactionStep{}
+	}
+	return CompactionStep{Merge: tier}
+}
+
+// FIFOStrategy never merges tables, it drops the oldest ones once the tables
+// take more than MaxSize bytes, the newest table aside. Along with a Retention
+// policy it suits time series, whose tables age out whole. Lookups read every
+// table holding the key, the SSTables are never compacted.
+type FIFOStrategy struct {
+	MaxSize int64 // Size in bytes the tables may take, zero leaves the drops to the retention policies.
+}
+
+func (s FIFOStrategy) Next(sstables, levels []TableInfo, config *EngineConfig) CompactionStep {
+	if s.MaxSize == 0 {
+		return CompactionStep{}
+	}
+
+	tables := append(append([]TableInfo{}, sstables...), levels...)
+	total := int64(0)
+	for _, table := range tables {
+		total += table.FileSize
+	}
+	drop := []TableInfo{}
+	for i := len(tables) - 1; i > 0 && total > s.MaxSize; i-- {
+		drop = append(drop, tables[i])
+		total -= tables[i].FileSize
+	}
+	return CompactionStep{Drop: drop}
+}
+
+// pickedRun returns the SSTables picked by the CompactionPicker along with every
+// older SSTable, so newer data keeps shadowing older data.
+func pickedRun(sstables []TableInfo, config *EngineConfig) []TableInfo {
+	picked := config.GetCompactionPicker().Pick(sstables, config)
+	if len(picked) == 0 {
+		return nil
+	}
+
+	newest := picked[0].Serial
+	for _, info := range picked {
+		newest = max(newest, info.Serial)
+	}
+	run := []TableInfo{}
+	for _, table := range sstables {
+		if table.Serial <= newest {
+			run = append(run, table)
+		}
+	}
+	return run
+}
+
+// levelsWithDepth returns the levels with a depth, which are newer than the
+// plain ones: a step writing a plain level must merge them.
+func levelsWithDepth(levels []TableInfo) []TableInfo {
+	run := []TableInfo{}
+	for _, level := range levels {
+		if level.Depth > 0 {
+			run = append(run, level)
+		}
+	}
+	return run
+}
+
+// levelsAt returns the levels at depth. There is a single one but after a crash
+// in the middle of a merge, which leaves the merged one behind.
+func levelsAt(levels []TableInfo, depth int) []TableInfo {
+	at := []TableInfo{}
+	for _, level := range levels {
+		if level.Depth == depth {
+			at = append(at, level)
+		}
+	}
+	return at
+}
@@ -6,6 +6,8 @@ import (
 
 const UintSize = 4
 
+const Uint64Size = 8
+
 type ErrKeyTooLong struct {
 	Key     string
 	KeySize uint32
@@ -26,3 +28,33 @@ type ErrKeyRemoved struct{ Key string }
 func (e *ErrKeyRemoved) Error() string {
 	return fmt.Sprintf("key %q is deleted", e.Key)
 }
+
+type ErrInvalidConfig struct {
+	Field  string
+	Reason string
+}
+
+func (e *ErrInvalidConfig) Error() string {
+	return fmt.Sprintf("invalid config %s: %s", e.Field, e.Reason)
+}
+
+type ErrChecksumMismatch struct {
+	Path     string
+	Expected uint32
+	Actual   uint32
+}
+
+func (e *ErrChecksumMismatch) Error() string {
+	return fmt.Sprintf("file %q has checksum %08x, expected %08x", e.Path, e.Actual, e.Expected)
+}
+
+type ErrBatchTooLarge struct {
+	Entries    int
+	Bytes      int
+	MaxEntries int
+	MaxBytes   int
+}
+
+func (e *ErrBatchTooLarge) Error() string {
+	return fmt.Sprintf("batch of %d entries and %d bytes exceeds the limit of %d entries and %d bytes", e.Entries, e.Bytes, e.MaxEntries, e.MaxBytes)
+}
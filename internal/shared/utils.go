@@ -14,6 +14,28 @@ func KeyToBytes(key string, keySize uint32) ([]byte, error) {
 	return results, nil
 }
 
+// SystemPrefix starts the keys the engine keeps its own metadata under. Users
+// can not read or write them, and listings leave them out.
+const SystemPrefix = "\x00sys/"
+
+// IsSystemKey reports whether key belongs to the system keyspace.
+func IsSystemKey(key string) bool {
+	return strings.HasPrefix(key, SystemPrefix)
+}
+
+// PrefixEnd returns the first key after every key starting with prefix,
+// empty when there is none.
+func PrefixEnd(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
+
 // TrimPaddedKey removes the null bytes from the end of a string.
 func TrimPaddedKey(key string) string {
 	return strings.TrimRight(key, "\x00")
@@ -0,0 +1,262 @@
+package shared
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// reservedFileNames are the files the engine keeps next to the tables.
+var reservedFileNames = []string{"data.bin", "wal.log.bin", "collations.json", "manifest.json", "archive"}
+
+// IsEngineFile reports whether name is one of the files the engine keeps next to the tables.
+func IsEngineFile(name string) bool {
+	for _, reserved := range reservedFileNames {
+		if name == reserved {
+			return true
+		}
+	}
+	return isWALSegment(name)
+}
+
+// isWALSegment reports whether name is a numbered segment of the WAL.
+func isWALSegment(name string) bool {
+	number, ok := strings.CutPrefix(name, "wal.log.bin.")
+	if !ok || number == "" {
+		return false
+	}
+	for _, c := range number {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolveDefaults fills the fields left at their zero value with DefaultConfig.
+func (ec *EngineConfig) ResolveDefaults() {
+	if ec.KeySize == 0 {
+		ec.KeySize = DefaultConfig.KeySize
+	}
+	if ec.MemtableSizeThreshold == 0 {
+		ec.MemtableSizeThreshold = DefaultConfig.MemtableSizeThreshold
+	}
+	if ec.SSTableNamePrefix == "" {
+		ec.SSTableNamePrefix = DefaultConfig.SSTableNamePrefix
+	}
+	if ec.LevelFileNamePrefix == "" {
+		ec.LevelFileNamePrefix = DefaultConfig.LevelFileNamePrefix
+	}
+	if ec.CompactionThreshold == 0 {
+		ec.CompactionThreshold = DefaultConfig.CompactionThreshold
+	}
+	if ec.CompactionWorkers == 0 {
+		ec.CompactionWorkers = DefaultConfig.CompactionWorkers
+	}
+}
+
+// Validate checks the configuration for nonsensical or conflicting values.
+// Every problem found is reported as an ErrInvalidConfig, joined in one error.
+func (ec *EngineConfig) Validate() error {
+	errs := []error{}
+	invalid := func(field, reason string) {
+		errs = append(errs, &ErrInvalidConfig{Field: field, Reason: reason})
+	}
+
+	if info, err := os.Stat(ec.Homepath); err != nil {
+		invalid("Homepath", err.Error())
+	} else if !info.IsDir() {
+		invalid("Homepath", "not a directory")
+	}
+
+	if ec.KeySize == 0 {
+		invalid("KeySize", "must be greater than zero")
+	}
+	if ec.MemtableSizeThreshold == 0 {
+		invalid("MemtableSizeThreshold", "must be greater than zero")
+	}
+
+	prefixes := [][2]string{
+		{"SSTableNamePrefix", ec.SSTableNamePrefix},
+		{"LevelFileNamePrefix", ec.LevelFileNamePrefix},
+	}
+	for _, p := range prefixes {
+		field, prefix := p[0], p[1]
+		if prefix == "" {
+			invalid(field, "must not be empty")
+			continue
+		}
+		if strings.ContainsAny(prefix, `/\`) {
+			invalid(field, "must not contain path separators")
+		}
+		for _, name := range reservedFileNames {
+			if strings.HasPrefix(name, prefix) {
+				invalid(field, "matches the engine file "+name)
+			}
+		}
+	}
+	sst, lvl := ec.SSTableNamePrefix, ec.LevelFileNamePrefix
+	if sst != "" && lvl != "" && (strings.HasPrefix(sst, lvl) || strings.HasPrefix(lvl, sst)) {
+		invalid("LevelFileNamePrefix", "SSTable and level file names would be indistinguishable")
+	}
+
+	seen := map[string]struct{}{}
+	for _, policy := range ec.Retention {
+		if policy.MaxAge <= 0 {
+			invalid("Retention", "max age of prefix "+policy.Prefix+" must be positive")
+		}
+		if _, ok := seen[policy.Prefix]; ok {
+			invalid("Retention", "prefix "+policy.Prefix+" has more than one policy")
+		}
+		seen[policy.Prefix] = struct{}{}
+	}
+
+	for i, namespace := range ec.Collations {
+		if namespace.Collation == nil {
+			invalid("Collations", "namespace "+namespace.Prefix+" has no collation")
+		}
+		for _, other := range ec.Collations[:i] {
+			if strings.HasPrefix(namespace.Prefix, other.Prefix) || strings.HasPrefix(other.Prefix, namespace.Prefix) {
+				invalid("Collations", "namespaces "+other.Prefix+" and "+namespace.Prefix+" are nested")
+			}
+		}
+	}
+
+	if n := len(ec.WALEncryptionKey); n != 0 && n != 16 && n != 24 && n != 32 {
+		invalid("WALEncryptionKey", "must be 16, 24 or 32 bytes long")
+	}
+	for _, key := range ec.WALDecryptionKeys {
+		if n := len(key); n != 16 && n != 24 && n != 32 {
+			invalid("WALDecryptionKeys", "must be 16, 24 or 32 bytes long")
+		}
+	}
+	if len(ec.WALDecryptionKeys) > 0 && len(ec.WALEncryptionKey) == 0 {
+		invalid("WALDecryptionKeys", "need WALEncryptionKey to be set")
+	}
+	if ec.WALKeyProvider != nil && len(ec.WALEncryptionKey) > 0 {
+		invalid("WALKeyProvider", "can not be used along with WALEncryptionKey")
+	}
+
+	hooks := map[string]struct{}{}
+	for _, hook := range ec.ReadHooks {
+		if hook.Transform == nil {
+			invalid("ReadHooks", "hook of prefix "+hook.Prefix+" has no transform")
+		}
+		if _, ok := hooks[hook.Prefix]; ok {
+			invalid("ReadHooks", "prefix "+hook.Prefix+" has more than one hook")
+		}
+		hooks[hook.Prefix] = struct{}{}
+	}
+
+	for _, window := range ec.MaintenanceWindows {
+		if window.Start < 0 || window.Start >= 24*time.Hour || window.End < 0 || window.End > 24*time.Hour {
+			invalid("MaintenanceWindows", "start and end must be offsets within a day")
+		}
+		if window.Start == window.End {
+			invalid("MaintenanceWindows", "window is empty")
+		}
+	}
+
+	if ec.ValueSync != ValueSyncOnFlush && ec.ValueSync != ValueSyncNever {
+		invalid("ValueSync", "unknown policy")
+	}
+
+	if ec.CompactionWorkers < 0 {
+		invalid("CompactionWorkers", "must be greater than zero")
+	}
+	if ec.CompactionRateLimit < 0 {
+		invalid("CompactionRateLimit", "must not be negative")
+	}
+	if ec.LowPriorityReadRate < 0 {
+		invalid("LowPriorityReadRate", "must not be negative")
+	}
+	if ec.SyncMode < SyncNever || ec.SyncMode > SyncInterval {
+		invalid("SyncMode", "unknown mode")
+	}
+	if ec.SyncInterval < 0 {
+		invalid("SyncInterval", "must not be negative")
+	}
+	if ec.MemtableKind != MemtableAVL && ec.MemtableKind != MemtableSkiplist {
+		invalid("MemtableKind", "unknown kind")
+	}
+	if ec.FlushFormat != FlushSortedRun && ec.FlushFormat != FlushPartitioned {
+		invalid("FlushFormat", "unknown format")
+	}
+	if ec.InlineValueSize < 0 {
+		invalid("InlineValueSize", "must not be negative")
+	}
+	if ec.BlockCacheSize < 0 {
+		invalid("BlockCacheSize", "must not be negative")
+	}
+	if ec.NegativeCacheSize < 0 {
+		invalid("NegativeCacheSize", "must not be negative")
+	}
+	if ec.ArchiveInterval < 0 {
+		invalid("ArchiveInterval", "must not be negative")
+	}
+	switch strategy := ec.CompactionStrategy.(type) {
+	case LeveledStrategy:
+		if strategy.BaseSize < 0 {
+			invalid("CompactionStrategy", "the base size must not be negative")
+		}
+		if strategy.Fanout < 0 || strategy.Fanout == 1 {
+			invalid("CompactionStrategy", "the fanout must be at least 2, or zero for the default")
+		}
+		if strategy.Levels < 0 || strategy.Levels > 254 {
+			invalid("CompactionStrategy", "the number of levels must be between 1 and 254, or zero for the default")
+		}
+	case SizeTieredStrategy:
+		if strategy.MinMerge < 0 || strategy.MinMerge == 1 {
+			invalid("CompactionStrategy", "the levels merged must be at least 2, or zero for the default")
+		}
+		if strategy.Ratio != 0 && strategy.Ratio < 1 {
+			invalid("CompactionStrategy", "the size ratio must be at least 1, or zero for the default")
+		}
+	case FIFOStrategy:
+		if strategy.MaxSize < 0 {
+			invalid("CompactionStrategy", "the maximum size must not be negative")
+		}
+	}
+	if ec.MetricsInterval < 0 {
+		invalid("MetricsInterval", "must not be negative")
+	}
+	if ec.ValueGCThreshold != 0 && ec.ValueGCThreshold <= 1 {
+		invalid("ValueGCThreshold", "must be greater than 1, or zero to disable it")
+	}
+	if ec.WALSegmentSize < 0 {
+		invalid("WALSegmentSize", "must not be negative")
+	}
+	if ec.MaxBatchEntries < 0 {
+		invalid("MaxBatchEntries", "must not be negative")
+	}
+	if ec.MaxBatchBytes < 0 {
+		invalid("MaxBatchBytes", "must not be negative")
+	}
+	if ec.SlowOpThreshold < 0 {
+		invalid("SlowOpThreshold", "must not be negative")
+	}
+	if ec.UndeleteWindow < 0 {
+		invalid("UndeleteWindow", "must not be negative")
+	}
+	if ec.TombstoneRunThreshold < 0 {
+		invalid("TombstoneRunThreshold", "must not be negative")
+	}
+	if ec.CompactTombstoneRuns && ec.TombstoneRunThreshold == 0 {
+		invalid("CompactTombstoneRuns", "needs a TombstoneRunThreshold to detect the runs")
+	}
+	if ec.PinLevels < 0 {
+		invalid("PinLevels", "must not be negative")
+	}
+	if ec.TrashRetention < 0 {
+		invalid("TrashRetention", "must not be negative")
+	}
+	if ec.WriteCoalesceWindow < 0 {
+		invalid("WriteCoalesceWindow", "must not be negative")
+	}
+	if ec.WriteCoalesceWindow > 0 && ec.RelaxedWrites {
+		invalid("RelaxedWrites", "relaxed writes skip the coalescer, unset WriteCoalesceWindow")
+	}
+
+	return errors.Join(errs...)
+}
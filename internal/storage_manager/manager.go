@@ -1,18 +1,25 @@
 package storage_manager
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"sync"
 
+	"github.com/hasssanezzz/goldb/internal/blockcache"
 	"github.com/hasssanezzz/goldb/internal/memtable"
 	"github.com/hasssanezzz/goldb/internal/shared"
 )
 
 type StorageManager struct {
-	writer   shared.WriteSeekCloser
-	reader   io.ReadSeekCloser
-	filename string
+	mu         sync.Mutex // Serializes appends to the value file.
+	writer     shared.WriteSeekCloser
+	reader     io.ReadSeekCloser
+	filename   string
+	compressor shared.Compressor // Compresses the values, nil stores them as they are.
+	cache      *blockcache.Cache // Values read last, decompressed, nil when the cache is disabled.
+	cacheOwner uint64            // Id of the value file in the cache, a new one after Reopen.
 }
 
 func New(filename string) (*StorageManager, error) {
@@ -20,6 +27,15 @@ func New(filename string) (*StorageManager, error) {
 	return sm, sm.Open()
 }
 
+// NewReadOnly opens an existing value file for reading only, WriteValue fails.
+func NewReadOnly(filename string) (*StorageManager, error) {
+	rfile, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("storage manager can not open file for reading %q: %v", filename, err)
+	}
+	return &StorageManager{filename: filename, reader: rfile}, nil
+}
+
 func (s *StorageManager) Open() error {
 	wfile, err := os.OpenFile(s.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -34,41 +50,220 @@ func (s *StorageManager) Open() error {
 	return nil
 }
 
-func (s *StorageManager) WriteValue(value []byte) (uint32, error) {
+// SetCompressor makes WriteValue compress the values it is asked to, and
+// ReadValue decompress the values stored compressed.
+func (s *StorageManager) SetCompressor(compressor shared.Compressor) {
+	s.compressor = compressor
+}
+
+// SetCache makes ReadValue keep the values it reads in cache.
+func (s *StorageManager) SetCache(cache *blockcache.Cache) {
+	s.cache = cache
+	s.cacheOwner = blockcache.NewOwner()
+}
+
+// WriteValue appends a value and returns the index node pointing at it, without
+// its timestamp. When compress is set and a compressor is configured, the value
+// is stored compressed if that makes it smaller.
+func (s *StorageManager) WriteValue(value []byte, compress bool) (memtable.IndexNode, error) {
+	if s.writer == nil {
+		return memtable.IndexNode{}, fmt.Errorf("storage manager %q is read-only", s.filename)
+	}
+
+	stored, compressed := value, false
+	if compress && s.compressor != nil {
+		data, err := s.compressor.Compress(value)
+		if err != nil {
+			return memtable.IndexNode{}, fmt.Errorf("storage manager can not compress value with %s: %v", s.compressor.Name(), err)
+		}
+		if len(data) < len(value) {
+			stored, compressed = data, true
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	offset, err := s.writer.Seek(0, io.SeekEnd)
 	if err != nil {
-		return 0, fmt.Errorf("storage manager can not seek to end: %v", err)
+		return memtable.IndexNode{}, fmt.Errorf("storage manager can not seek to end: %v", err)
 	}
 
-	_, err = s.writer.Write(value)
+	_, err = s.writer.Write(stored)
 	if err != nil {
-		return 0, fmt.Errorf("storage manager can not write value %q: %v", value, err)
+		return memtable.IndexNode{}, fmt.Errorf("storage manager can not write value %q: %v", value, err)
 	}
-	return uint32(offset), err
+	return memtable.IndexNode{Offset: uint32(offset), Size: uint32(len(stored)), Compressed: compressed}, nil
 }
 
+// ReadValue reads a whole value, decompressing it when it is stored compressed,
+// followed by the extents appended to it. It reads at absolute offsets without
+// moving the file position, so concurrent reads do not step on each other.
 func (s *StorageManager) ReadValue(indexNode memtable.IndexNode) ([]byte, error) {
-	if indexNode.Size == 0 {
-		return nil, &shared.ErrKeyNotFound{}
+	value, err := s.readRecord(indexNode)
+	if err != nil || indexNode.Inline != nil {
+		return value, err
 	}
+	for _, extent := range indexNode.Extents {
+		run, err := s.readRecord(memtable.IndexNode{Offset: extent.Offset, Size: extent.Size, Compressed: extent.Compressed})
+		if err != nil {
+			return nil, err
+		}
+		value = append(value, run...)
+	}
+	return value, nil
+}
 
-	_, err := s.reader.Seek(int64(indexNode.Offset), io.SeekStart)
+// readRecord reads the record of the value file the node points at, through
+// the cache when there is one.
+func (s *StorageManager) readRecord(indexNode memtable.IndexNode) ([]byte, error) {
+	reader, err := s.storedReader(indexNode)
 	if err != nil {
-		return []byte{}, fmt.Errorf("storage manager can not read (%d, %d): %v", indexNode.Offset, indexNode.Size, err)
+		return nil, err
+	}
+
+	// inline values come from the tables, which cache them already
+	cached := s.cache != nil && indexNode.Inline == nil
+	key := blockcache.Key{Owner: s.cacheOwner, Offset: int64(indexNode.Offset)}
+	if cached {
+		if value, ok := s.cache.Get(key); ok {
+			return bytes.Clone(value), nil
+		}
+	}
+	value, err := s.readStored(reader, indexNode)
+	if cached && err == nil {
+		s.cache.Add(key, bytes.Clone(value))
 	}
+	return value, err
+}
+
+// readStored reads a value as it is stored and decompresses it.
+func (s *StorageManager) readStored(reader io.Reader, indexNode memtable.IndexNode) ([]byte, error) {
 	buf := make([]byte, indexNode.Size)
-	_, err = s.reader.Read(buf)
+	_, err := io.ReadFull(reader, buf)
+	if err != nil {
+		return nil, fmt.Errorf("storage manager can not read (%d, %d): %v", indexNode.Offset, indexNode.Size, err)
+	}
+	if !indexNode.Compressed {
+		return buf, nil
+	}
+
+	if s.compressor == nil {
+		return nil, fmt.Errorf("storage manager can not read (%d, %d): the value is compressed and no compressor is configured", indexNode.Offset, indexNode.Size)
+	}
+	value, err := s.compressor.Decompress(buf)
+	if err != nil {
+		return nil, fmt.Errorf("storage manager can not decompress (%d, %d) with %s: %v", indexNode.Offset, indexNode.Size, s.compressor.Name(), err)
+	}
+	return value, nil
+}
+
+// ValueReader returns a reader over the bytes of a single value, allowing parts of
+// the value to be read without loading all of it into memory. A compressed value,
+// or one appended to, is read in memory first.
+func (s *StorageManager) ValueReader(indexNode memtable.IndexNode) (*io.SectionReader, error) {
+	if !indexNode.Compressed && len(indexNode.Extents) == 0 {
+		return s.storedReader(indexNode)
+	}
+
+	value, err := s.ReadValue(indexNode)
 	if err != nil {
 		return nil, err
 	}
-	return buf, nil
+	return io.NewSectionReader(bytes.NewReader(value), 0, int64(len(value))), nil
 }
 
-func (s *StorageManager) Close() error {
-	err := s.writer.Close()
+// storedReader returns a reader over the bytes of a value as they are stored.
+func (s *StorageManager) storedReader(indexNode memtable.IndexNode) (*io.SectionReader, error) {
+	if indexNode.Size == 0 {
+		return nil, &shared.ErrKeyNotFound{}
+	}
+	if indexNode.Inline != nil {
+		return io.NewSectionReader(bytes.NewReader(indexNode.Inline), 0, int64(len(indexNode.Inline))), nil
+	}
+
+	readerAt, ok := s.reader.(io.ReaderAt)
+	if !ok {
+		return nil, fmt.Errorf("storage manager reader does not support partial reads")
+	}
+	return io.NewSectionReader(readerAt, int64(indexNode.Offset), int64(indexNode.Size)), nil
+}
+
+// Sync flushes the values written so far to stable storage.
+func (s *StorageManager) Sync() error {
+	syncer, ok := s.writer.(interface{ Sync() error })
+	if !ok {
+		return nil
+	}
+	if err := syncer.Sync(); err != nil {
+		return fmt.Errorf("storage manager can not sync %q: %v", s.filename, err)
+	}
+	return nil
+}
+
+// Size returns the size of the value file.
+func (s *StorageManager) Size() (int64, error) {
+	info, err := os.Stat(s.filename)
 	if err != nil {
+		return 0, fmt.Errorf("storage manager can not stat %q: %v", s.filename, err)
+	}
+	return info.Size(), nil
+}
+
+// CopyTo writes the given values to a new value file at path, back to back in
+// the order given and as they are stored, and syncs it. It returns the new
+// offsets of the values by their old offsets.
+func (s *StorageManager) CopyTo(path string, nodes []memtable.IndexNode) (map[uint32]uint32, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("storage manager can not create %q: %v", path, err)
+	}
+
+	moved := make(map[uint32]uint32, len(nodes))
+	offset := int64(0)
+	for _, node := range nodes {
+		reader, err := s.storedReader(node)
+		if err == nil {
+			_, err = io.Copy(file, reader)
+		}
+		if err != nil {
+			file.Close()
+			os.Remove(path)
+			return nil, fmt.Errorf("storage manager can not copy (%d, %d): %v", node.Offset, node.Size, err)
+		}
+		moved[node.Offset] = uint32(offset)
+		offset += int64(node.Size)
+	}
+
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("storage manager can not write %q: %v", path, err)
+	}
+	return moved, nil
+}
+
+// Reopen closes the value file and opens it again, after it was replaced.
+func (s *StorageManager) Reopen() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.Close(); err != nil {
 		return err
 	}
-	err = s.reader.Close()
-	return err
+	// the values moved, the ones cached are left to age out
+	s.cacheOwner = blockcache.NewOwner()
+	return s.Open()
+}
+
+func (s *StorageManager) Close() error {
+	if s.writer != nil {
+		if err := s.writer.Close(); err != nil {
+			return err
+		}
+	}
+	return s.reader.Close()
 }
@@ -0,0 +1,82 @@
+//go:build goldb_syncpoints
+
+package syncpoint
+
+import "sync"
+
+// Enabled reports whether the synchronization points are compiled in.
+const Enabled = true
+
+var (
+	mu     sync.Mutex
+	hook   func(Point)
+	pauses = map[Point][]*Pause{}
+)
+
+// Pause stops the next goroutine reaching a point until it is released.
+type Pause struct {
+	point   Point
+	reached chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+// Reach runs the hook, if any, and stops the goroutine when a pause waits for it
+// at point.
+func Reach(point Point) {
+	mu.Lock()
+	h := hook
+	var pause *Pause
+	if queued := pauses[point]; len(queued) > 0 {
+		pause, pauses[point] = queued[0], queued[1:]
+	}
+	mu.Unlock()
+
+	if h != nil {
+		h(point)
+	}
+	if pause != nil {
+		close(pause.reached)
+		<-pause.release
+	}
+}
+
+// SetHook makes every goroutine reaching a point call hook with it, nil removes
+// the hook. The hook is process-wide.
+func SetHook(h func(Point)) {
+	mu.Lock()
+	defer mu.Unlock()
+	hook = h
+}
+
+// PauseAt stops the next goroutine reaching point until the pause is released.
+// Pauses at the same point are taken in the order they were set.
+func PauseAt(point Point) *Pause {
+	pause := &Pause{point: point, reached: make(chan struct{}), release: make(chan struct{})}
+	mu.Lock()
+	defer mu.Unlock()
+	pauses[point] = append(pauses[point], pause)
+	return pause
+}
+
+// Reached is closed once a goroutine stopped at the point.
+func (p *Pause) Reached() <-chan struct{} {
+	return p.reached
+}
+
+// Release lets the stopped goroutine go on, or drops the pause when no goroutine
+// reached the point yet. Releasing twice does nothing.
+func (p *Pause) Release() {
+	p.once.Do(func() {
+		mu.Lock()
+		queued := pauses[p.point]
+		for i, pause := range queued {
+			if pause == p {
+				pauses[p.point] = append(queued[:i:i], queued[i+1:]...)
+				break
+			}
+		}
+		mu.Unlock()
+		close(p.release)
+	})
+}
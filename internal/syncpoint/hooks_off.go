@@ -0,0 +1,9 @@
+//go:build !goldb_syncpoints
+
+package syncpoint
+
+// Enabled reports whether the synchronization points are compiled in.
+const Enabled = false
+
+// Reach does nothing, the points are only compiled in with the goldb_syncpoints tag.
+func Reach(Point) {}
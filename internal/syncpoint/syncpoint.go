@@ -0,0 +1,18 @@
+// Package syncpoint marks the points of the engine where goroutines hand work
+// over to each other, so interleaving tests can stop a goroutine at one of them
+// and run others meanwhile. The points cost nothing unless the engine is built
+// with the goldb_syncpoints tag, see hooks.go.
+package syncpoint
+
+// Point names a synchronization point, along with the locks held when it is reached.
+type Point string
+
+const (
+	WALAppended         Point = "wal-appended"         // A batch is in the WAL but not in the memtable, on the WAL stage.
+	MemtableApplied     Point = "memtable-applied"     // A batch is in the memtable but its sequence number is not published, writeMu held.
+	MemtableFrozen      Point = "memtable-frozen"      // A fresh memtable took over, the frozen one is not being flushed yet, writeMu held.
+	FlushWritten        Point = "flush-written"        // The tables of the frozen memtable are written but not installed, no index lock held.
+	FlushInstalled      Point = "flush-installed"      // The tables of the frozen memtable are installed, the WAL segments they cover are not dropped yet.
+	CompactionMerged    Point = "compaction-merged"    // A compaction wrote its level but did not install it, no index lock held.
+	CompactionInstalled Point = "compaction-installed" // A compaction installed its level and removed the merged tables, the index lock held.
+)
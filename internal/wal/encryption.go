@@ -0,0 +1,249 @@
+package wal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// KeyID identifies an encryption key without revealing it. It is stored with
+// every encrypted frame so the frame can be opened after the key was rotated.
+// Id zero never names a key, it marks the frames holding a wrapped data key.
+func KeyID(key []byte) uint32 {
+	sum := sha256.Sum256(key)
+	return max(binary.LittleEndian.Uint32(sum[:]), 1)
+}
+
+// dataKeyFrame is the key id of the frames holding a wrapped data key.
+const dataKeyFrame = 0
+
+// keyring holds the keys the WAL can read and the one it writes with.
+type keyring struct {
+	mu       sync.Mutex
+	current  uint32
+	keys     map[uint32]cipher.AEAD
+	used     map[uint32]struct{} // Keys of the frames in the log.
+	rotated  map[uint32]struct{} // Keys of the frames since the last rotate, nil when no older segment is pending removal.
+	provider shared.KeyProvider  // Hands out a data key per log, nil with static keys.
+}
+
+// newKeyring writes with the first key and reads with all of them.
+func newKeyring(keys [][]byte) (*keyring, error) {
+	kr := &keyring{keys: map[uint32]cipher.AEAD{}, used: map[uint32]struct{}{}}
+	for _, key := range keys {
+		if _, err := kr.add(key); err != nil {
+			return nil, err
+		}
+	}
+	kr.current = KeyID(keys[0])
+	return kr, nil
+}
+
+func (kr *keyring) add(key []byte) (uint32, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return 0, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return 0, err
+	}
+
+	id := KeyID(key)
+	kr.keys[id] = aead
+	return id, nil
+}
+
+// newDataKey asks the provider for a fresh data key to write with, and returns
+// the frame recording its wrapped form: "<zero><wrapped length><wrapped key>".
+func (kr *keyring) newDataKey() ([]byte, error) {
+	key, wrapped, err := kr.provider.GenerateDataKey()
+	if err != nil {
+		return nil, fmt.Errorf("key provider can not generate a data key: %v", err)
+	}
+
+	id, err := kr.add(key)
+	if err != nil {
+		return nil, err
+	}
+	kr.current = id
+
+	frame := binary.LittleEndian.AppendUint32(nil, dataKeyFrame)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(wrapped)))
+	return append(frame, wrapped...), nil
+}
+
+// rotate starts tracking the keys of the frames written to a new segment. With
+// a provider the next write starts the segment with a new data key, so it can
+// be read once the older segments are gone.
+func (kr *keyring) rotate() {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.rotated = map[uint32]struct{}{}
+	if kr.provider != nil {
+		kr.current = 0
+	}
+}
+
+// forgetRotated forgets the keys of the frames of the segments written before
+// the last rotate, once they are deleted.
+func (kr *keyring) forgetRotated() {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if kr.rotated != nil {
+		kr.used, kr.rotated = kr.rotated, nil
+	}
+}
+
+// seal encrypts the records of a single write into a frame laid out as
+// "<key id><sealed length><nonce><sealed records>", preceded by the frame of a
+// new data key when the provider has not handed one out for this log yet.
+func (kr *keyring) seal(records []byte) ([]byte, error) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	frame := []byte{}
+	if kr.current == 0 {
+		dataKey, err := kr.newDataKey()
+		if err != nil {
+			return nil, err
+		}
+		frame = dataKey
+	}
+
+	aead := kr.keys[kr.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := aead.Seal(nil, nonce, records, nil)
+	frame = binary.LittleEndian.AppendUint32(frame, kr.current)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(len(sealed)))
+	frame = append(frame, nonce...)
+	kr.used[kr.current] = struct{}{}
+	if kr.rotated != nil {
+		kr.rotated[kr.current] = struct{}{}
+	}
+	return append(frame, sealed...), nil
+}
+
+// open decrypts the frames read from the log back into plain records.
+// A frame cut short by a torn write at the tail is treated as the end of the log.
+func (kr *keyring) open(r io.Reader) (*bytes.Buffer, error) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	plain := bytes.NewBuffer(nil)
+	header := make([]byte, shared.UintSize*2)
+
+	for {
+		_, err := io.ReadFull(r, header)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return plain, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		id := binary.LittleEndian.Uint32(header)
+		if id == dataKeyFrame {
+			if err := kr.unwrap(r, binary.LittleEndian.Uint32(header[shared.UintSize:])); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		aead, ok := kr.keys[id]
+		if !ok {
+			return nil, fmt.Errorf("frame was encrypted with unknown key %08x", id)
+		}
+
+		frame := make([]byte, aead.NonceSize()+int(binary.LittleEndian.Uint32(header[shared.UintSize:])))
+		_, err = io.ReadFull(r, frame)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return plain, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		nonce, sealed := frame[:aead.NonceSize()], frame[aead.NonceSize():]
+		records, err := aead.Open(nil, nonce, sealed, nil)
+		if err != nil {
+			return nil, fmt.Errorf("frame does not authenticate under key %08x: %v", id, err)
+		}
+		kr.used[id] = struct{}{}
+		plain.Write(records)
+	}
+}
+
+// unwrap reads a wrapped data key and adds it to the keys the log can be read with.
+func (kr *keyring) unwrap(r io.Reader, length uint32) error {
+	if kr.provider == nil {
+		return fmt.Errorf("log holds wrapped data keys but no key provider is set")
+	}
+
+	wrapped := make([]byte, length)
+	if _, err := io.ReadFull(r, wrapped); err != nil {
+		return err
+	}
+	key, err := kr.provider.GetKey(wrapped)
+	if err != nil {
+		return fmt.Errorf("key provider can not unwrap a data key: %v", err)
+	}
+	_, err = kr.add(key)
+	return err
+}
+
+// Encrypted reports whether the WAL is encrypted.
+func (w *WAL) Encrypted() bool {
+	return w.keys != nil
+}
+
+// RotateKey makes the WAL write with key from now on. The frames written with
+// the previous keys stay readable, and are gone once the WAL is cleared by the
+// next flush.
+func (w *WAL) RotateKey(key []byte) error {
+	if w.keys == nil {
+		return fmt.Errorf("WAL %q is not encrypted", w.source)
+	}
+	if w.keys.provider != nil {
+		return fmt.Errorf("WAL %q takes its keys from a key provider, rotate the master key there", w.source)
+	}
+
+	w.keys.mu.Lock()
+	defer w.keys.mu.Unlock()
+	id, err := w.keys.add(key)
+	if err != nil {
+		return fmt.Errorf("WAL %q can not rotate key: %v", w.source, err)
+	}
+	w.keys.current = id
+	return nil
+}
+
+// KeysInUse returns the ids of the keys the frames in the WAL were encrypted with,
+// the keys that must still be provided to read it back.
+func (w *WAL) KeysInUse() []uint32 {
+	if w.keys == nil {
+		return nil
+	}
+
+	w.keys.mu.Lock()
+	defer w.keys.mu.Unlock()
+	ids := []uint32{}
+	for id := range w.keys.used {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -0,0 +1,268 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var (
+	testKey  = bytes.Repeat([]byte{0x11}, 32)
+	otherKey = bytes.Repeat([]byte{0x22}, 32)
+)
+
+// testKeyring returns a keyring writing with the first of keys.
+func testKeyring(t *testing.T, keys ...[]byte) *keyring {
+	t.Helper()
+	kr, err := newKeyring(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+// sealAll seals every write into a frame and returns the frames one after the other.
+func sealAll(t *testing.T, kr *keyring, writes ...string) []byte {
+	t.Helper()
+	frames := []byte{}
+	for _, write := range writes {
+		frame, err := kr.seal([]byte(write))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if write != "" && bytes.Contains(frame, []byte(write)) {
+			t.Fatalf("the frame of %q holds it in the clear", write)
+		}
+		frames = append(frames, frame...)
+	}
+	return frames
+}
+
+// TestSealOpenRoundTrip seals writes and opens them back, with the key they
+// were sealed with among others.
+func TestSealOpenRoundTrip(t *testing.T) {
+	writes := []string{"first", "", strings.Repeat("a longer write ", 100)}
+	frames := sealAll(t, testKeyring(t, testKey), writes...)
+
+	for _, kr := range []*keyring{testKeyring(t, testKey), testKeyring(t, otherKey, testKey)} {
+		plain, err := kr.open(bytes.NewReader(frames))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plain.String() != strings.Join(writes, "") {
+			t.Fatalf("the frames open as %q", plain)
+		}
+		if ids := kr.used; len(ids) != 1 {
+			t.Fatalf("the frames are recorded as sealed with %d keys", len(ids))
+		}
+	}
+}
+
+// TestOpenWithWrongKey opens frames with a keyring lacking their key, and a
+// WAL written with a key with another one.
+func TestOpenWithWrongKey(t *testing.T) {
+	frames := sealAll(t, testKeyring(t, testKey), "write")
+	if plain, err := testKeyring(t, otherKey).open(bytes.NewReader(frames)); err == nil {
+		t.Fatalf("the frames open as %q without their key", plain)
+	}
+
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, testKey)
+	logKeys(t, w, 0, 3)
+	w.Close()
+	data, err := os.ReadFile(w.segmentPath(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("value-00")) {
+		t.Fatal("the WAL holds the values in the clear")
+	}
+
+	w = openTestWAL(t, dir, otherKey)
+	defer w.Close()
+	if keys, err := replayedKeys(w); err == nil {
+		t.Fatalf("the WAL replays %v with another key", keys)
+	}
+}
+
+// TestTamperedFrameFails flips a bit of every part of a frame past its
+// header, and checks the GCM tag catches it.
+func TestTamperedFrameFails(t *testing.T) {
+	kr := testKeyring(t, testKey)
+	frames := sealAll(t, kr, "a write worth protecting")
+	nonce := kr.keys[kr.current].NonceSize()
+
+	for name, offset := range map[string]int{
+		"nonce":      8,
+		"ciphertext": 8 + nonce,
+		"tag":        len(frames) - 1,
+	} {
+		tampered := bytes.Clone(frames)
+		tampered[offset] ^= 0x01
+		plain, err := testKeyring(t, testKey).open(bytes.NewReader(tampered))
+		if err == nil {
+			t.Fatalf("the frame with its %s tampered with opens as %q", name, plain)
+		}
+		if !strings.Contains(err.Error(), "does not authenticate") {
+			t.Fatalf("the frame with its %s tampered with fails with %v", name, err)
+		}
+	}
+}
+
+// TestRotateKey rotates the key between writes and checks the segments written
+// with the old key replay with it among the keys, and that KeysInUse drops it
+// once its segments are removed.
+func TestRotateKey(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir, testKey)
+	logKeys(t, w, 0, 3)
+	if err := w.RotateKey(otherKey); err != nil {
+		t.Fatal(err)
+	}
+	logKeys(t, w, 3, 6)
+	both := fmt.Sprint(sortedIDs(KeyID(testKey), KeyID(otherKey)))
+	if keys := fmt.Sprint(w.KeysInUse()); keys != both {
+		t.Fatalf("the keys in use are %v, want %v", keys, both)
+	}
+	w.Close()
+
+	w = openTestWAL(t, dir, otherKey)
+	if keys, err := replayedKeys(w); err == nil {
+		t.Fatalf("the WAL replays %v without the old key", keys)
+	}
+	w.Close()
+	w = openTestWAL(t, dir, otherKey, testKey)
+	checkKeys(t, w, 0, 6)
+	if keys := fmt.Sprint(w.KeysInUse()); keys != both {
+		t.Fatalf("the keys in use after a reopen are %v, want %v", keys, both)
+	}
+
+	// the old segments stay until the writes in them are flushed
+	next, err := w.Rotate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logKeys(t, w, 6, 8)
+	if keys := fmt.Sprint(w.KeysInUse()); keys != both {
+		t.Fatalf("the keys in use with the old segments left are %v, want %v", keys, both)
+	}
+	if err := w.RemoveSegmentsBefore(next); err != nil {
+		t.Fatal(err)
+	}
+	if keys := fmt.Sprint(w.KeysInUse()); keys != fmt.Sprint([]uint32{KeyID(otherKey)}) {
+		t.Fatalf("the keys in use once the old segments are removed are %v, want [%d]", keys, KeyID(otherKey))
+	}
+	w.Close()
+
+	w = openTestWAL(t, dir, otherKey)
+	defer w.Close()
+	checkKeys(t, w, 6, 8)
+}
+
+func sortedIDs(ids ...uint32) []uint32 {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// testProvider hands out data keys wrapped by XOR with its master key.
+type testProvider struct {
+	master    []byte
+	generated [][]byte
+}
+
+func (p *testProvider) wrap(key []byte) []byte {
+	wrapped := bytes.Clone(key)
+	for i := range wrapped {
+		wrapped[i] ^= p.master[i%len(p.master)]
+	}
+	return wrapped
+}
+
+func (p *testProvider) GenerateDataKey() ([]byte, []byte, error) {
+	key := bytes.Repeat([]byte{byte(len(p.generated) + 1)}, 32)
+	p.generated = append(p.generated, key)
+	return key, p.wrap(key), nil
+}
+
+func (p *testProvider) GetKey(wrapped []byte) ([]byte, error) {
+	if len(wrapped) != 32 {
+		return nil, fmt.Errorf("wrapped key of %d bytes", len(wrapped))
+	}
+	return p.wrap(wrapped), nil
+}
+
+// TestDataKeyFrames writes with data keys of a provider and checks the log
+// opens with a frame of key id zero holding the wrapped data key, never the
+// key itself, which the log replays with; and that a new segment starts with
+// a new data key, the old one dropped from KeysInUse once its segments go.
+func TestDataKeyFrames(t *testing.T) {
+	dir := t.TempDir()
+	provider := &testProvider{master: bytes.Repeat([]byte{0x5a}, 32)}
+	openWithProvider := func() *WAL {
+		t.Helper()
+		w, err := NewWithProvider(filepath.Join(dir, "wal.log.bin"), 256, provider)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	w := openWithProvider()
+	logKeys(t, w, 0, 3)
+	w.Close()
+	if len(provider.generated) != 1 {
+		t.Fatalf("three writes asked for %d data keys, want one", len(provider.generated))
+	}
+	dataKey := provider.generated[0]
+
+	data, err := os.ReadFile(w.segmentPath(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, dataKey) {
+		t.Fatal("the log holds the data key in the clear")
+	}
+	first := data[frameOffsets(t, data)[0]+8:]
+	if id, length := binary.LittleEndian.Uint32(first), binary.LittleEndian.Uint32(first[4:]); id != dataKeyFrame || length != 32 {
+		t.Fatalf("the log starts with a frame of key id %d and length %d, want a data key frame", id, length)
+	}
+	if wrapped := first[8:40]; !bytes.Equal(wrapped, provider.wrap(dataKey)) {
+		t.Fatalf("the data key frame holds %x, want the wrapped data key", wrapped)
+	}
+	if id := binary.LittleEndian.Uint32(first[40:]); id != KeyID(dataKey) {
+		t.Fatalf("the first write is sealed with key %d, want the data key", id)
+	}
+
+	w = openTestWAL(t, dir, testKey)
+	if keys, err := replayedKeys(w); err == nil {
+		t.Fatalf("the WAL replays %v without the key provider", keys)
+	}
+	w.Close()
+
+	w = openWithProvider()
+	defer w.Close()
+	checkKeys(t, w, 0, 3)
+	if err := w.RotateKey(testKey); err == nil {
+		t.Fatal("the key of a WAL encrypted with a key provider is rotated")
+	}
+
+	next, err := w.Rotate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logKeys(t, w, 3, 5)
+	if len(provider.generated) != 2 {
+		t.Fatalf("the new segment was written with %d data keys in all, want a new one", len(provider.generated))
+	}
+	if err := w.RemoveSegmentsBefore(next); err != nil {
+		t.Fatal(err)
+	}
+	if keys := fmt.Sprint(w.KeysInUse()); keys != fmt.Sprint([]uint32{KeyID(provider.generated[1])}) {
+		t.Fatalf("the keys in use once the old segments are removed are %v, want the new data key alone", keys)
+	}
+	checkKeys(t, w, 3, 5)
+}
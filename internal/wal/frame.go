@@ -0,0 +1,171 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// fileHeader starts every log written with framed writes. Logs without it were
+// written by format version 0 and are migrated when the database is opened, see
+// ReadLegacy.
+var fileHeader = []byte("\xffWAL\x01")
+
+// frame wraps the bytes of a single write as "<length><crc32><bytes>", so a
+// write torn by a crash is told apart from a complete one.
+func frame(data []byte) []byte {
+	framed := binary.LittleEndian.AppendUint32(nil, uint32(len(data)))
+	framed = binary.LittleEndian.AppendUint32(framed, crc32.ChecksumIEEE(data))
+	return append(framed, data...)
+}
+
+// unframe returns the bytes of the complete writes in a framed log, and the
+// length of the log up to the end of the last one. A frame running past the end
+// of the log, or the last frame failing its checksum, is a torn write left by a
+// crash and ends the log. A damaged frame followed by others is an error, a
+// frame whose length was damaged runs past the end of the log as well but the
+// writes after it are still found.
+func unframe(data []byte) (*bytes.Buffer, int, error) {
+	writes := bytes.NewBuffer(nil)
+	offset := len(fileHeader)
+	headerSize := shared.UintSize * 2
+
+	for offset < len(data) {
+		if len(data)-offset < headerSize {
+			break
+		}
+		length := int(binary.LittleEndian.Uint32(data[offset:]))
+		sum := binary.LittleEndian.Uint32(data[offset+shared.UintSize:])
+		end := offset + headerSize + length
+		if end > len(data) || end < offset {
+			if framedAfter(data, offset) {
+				return nil, 0, fmt.Errorf("write at offset %d runs past the end of the log, which holds writes after it", offset)
+			}
+			break
+		}
+
+		payload := data[offset+headerSize : end]
+		if crc32.ChecksumIEEE(payload) != sum {
+			if end == len(data) {
+				break
+			}
+			return nil, 0, fmt.Errorf("write at offset %d fails its checksum", offset)
+		}
+		writes.Write(payload)
+		offset = end
+	}
+
+	return writes, offset, nil
+}
+
+// framedAfter reports whether a complete frame passing its checksum starts after
+// offset, which tells a damaged frame in the middle of a log from the write torn
+// at its tail: nothing is written after a torn write.
+func framedAfter(data []byte, offset int) bool {
+	headerSize := shared.UintSize * 2
+	for start := offset + 1; start+headerSize < len(data); start++ {
+		length := int(binary.LittleEndian.Uint32(data[start:]))
+		end := start + headerSize + length
+		if length == 0 || end > len(data) || end < start {
+			continue
+		}
+		if crc32.ChecksumIEEE(data[start+headerSize:end]) == binary.LittleEndian.Uint32(data[start+shared.UintSize:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeHeader starts an empty log with the file header.
+func (w *WAL) writeHeader() error {
+	info, err := w.writer.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > 0 {
+		return nil
+	}
+	_, err = w.writer.Write(fileHeader)
+	return err
+}
+
+// readWrites returns the records of the log files, decrypted when the log is
+// encrypted. The torn write a crash may leave at the tail of the newest file is
+// cut off, so new writes are not appended after garbage. A file header torn
+// the same way is completed, the file holds no write yet. Older files were
+// synced before the next one was started, a torn write in them is an error.
+func (w *WAL) readWrites(paths []string) (*bytes.Buffer, error) {
+	buf := bytes.NewBuffer(nil)
+	for i, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("can not be read: %v", err)
+		}
+		if len(data) == 0 {
+			continue
+		}
+
+		torn := len(data) < len(fileHeader) && bytes.HasPrefix(fileHeader, data)
+		if !torn && !bytes.HasPrefix(data, fileHeader) {
+			return nil, fmt.Errorf("segment %q was written by format version 0, opening the database with New migrates it", path)
+		}
+		writes, length := bytes.NewBuffer(nil), 0
+		if !torn {
+			if writes, length, err = unframe(data); err != nil {
+				return nil, fmt.Errorf("segment %q %v", path, err)
+			}
+			torn = length < len(data)
+		}
+
+		switch {
+		case !torn:
+		case i < len(paths)-1:
+			return nil, fmt.Errorf("segment %q has a torn write at offset %d and is followed by others", path, length)
+		case w.inspecting:
+		case len(data) < len(fileHeader):
+			log.Printf("WAL %q: completing the file header torn by a crash\n", path)
+			if err := completeHeader(path, len(data)); err != nil {
+				return nil, fmt.Errorf("can not complete the torn file header: %v", err)
+			}
+		default:
+			log.Printf("WAL %q: dropping %d bytes of a torn write at the tail\n", path, len(data)-length)
+			if err := os.Truncate(path, int64(length)); err != nil {
+				return nil, fmt.Errorf("can not truncate the torn write: %v", err)
+			}
+		}
+		buf.Write(writes.Bytes())
+	}
+
+	if w.keys != nil {
+		opened, err := w.keys.open(buf)
+		if err != nil {
+			return nil, fmt.Errorf("can not be decrypted: %v", err)
+		}
+		buf = opened
+	}
+	return buf, nil
+}
+
+// completeHeader appends to the file at path the part of the file header a
+// crash left out, it holds the first written bytes of it. The segment may be
+// open for appending already, a new write has to follow a whole header.
+func completeHeader(path string, written int) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(fileHeader[written:]); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,200 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// openTestWAL opens the WAL of a database in dir.
+func openTestWAL(t *testing.T, dir string, encryptionKeys ...[]byte) *WAL {
+	t.Helper()
+	w, err := New(filepath.Join(dir, "wal.log.bin"), 256, encryptionKeys...)
+	if err != nil {
+		t.Fatalf("can not open the WAL: %v", err)
+	}
+	return w
+}
+
+// logKeys logs the keys from key-from to key-to, a write each.
+func logKeys(t *testing.T, w *WAL, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := w.Log(fmt.Sprintf("key-%02d", i), []byte(fmt.Sprintf("value-%02d", i)), int64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// replayedKeys returns the keys the WAL replays, sorted, or the error parsing it.
+func replayedKeys(w *WAL) ([]string, error) {
+	entries, _, err := w.ParseLogs()
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Key, "key-") || string(entry.Value) != "value-"+entry.Key[len("key-"):] {
+			return nil, fmt.Errorf("%q replays as %q", entry.Key, entry.Value)
+		}
+		keys = append(keys, entry.Key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// checkKeys checks the WAL replays the keys from key-from to key-to.
+func checkKeys(t *testing.T, w *WAL, from, to int) {
+	t.Helper()
+	keys, err := replayedKeys(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{}
+	for i := from; i < to; i++ {
+		want = append(want, fmt.Sprintf("key-%02d", i))
+	}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Fatalf("the WAL replays %v, want %v", keys, want)
+	}
+}
+
+// frameOffsets returns the offsets of the frames of a segment file.
+func frameOffsets(t *testing.T, data []byte) []int {
+	t.Helper()
+	offsets := []int{}
+	for offset := len(fileHeader); offset < len(data); {
+		offsets = append(offsets, offset)
+		offset += 8 + int(binary.LittleEndian.Uint32(data[offset:]))
+	}
+	return offsets
+}
+
+// TestTornTail appends part of a write to the log, as a crash in the middle of
+// it leaves, and checks the log replays the writes before it, drops it and
+// takes new writes after them.
+func TestTornTail(t *testing.T) {
+	for _, cut := range []int{3, 8, 12} {
+		t.Run(fmt.Sprintf("%d bytes", cut), func(t *testing.T) {
+			dir := t.TempDir()
+			w := openTestWAL(t, dir)
+			logKeys(t, w, 0, 5)
+			w.Close()
+
+			path := w.segmentPath(1)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			torn := frame([]byte("a write the crash cut short"))[:cut]
+			if err := os.WriteFile(path, append(data, torn...), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			w = openTestWAL(t, dir)
+			defer w.Close()
+			checkKeys(t, w, 0, 5)
+			if info, err := os.Stat(path); err != nil || info.Size() != int64(len(data)) {
+				t.Fatalf("the torn write is not cut off the segment: %v", err)
+			}
+			logKeys(t, w, 5, 7)
+			checkKeys(t, w, 0, 7)
+		})
+	}
+}
+
+// TestDamagedWriteInTheMiddle damages the checksum or the length of a write
+// followed by others, and checks the log refuses to replay rather than
+// dropping the writes after it.
+func TestDamagedWriteInTheMiddle(t *testing.T) {
+	for name, damage := range map[string]func(data []byte, offset int){
+		"checksum": func(data []byte, offset int) { data[offset+4] ^= 0xff },
+		"length":   func(data []byte, offset int) { binary.LittleEndian.PutUint32(data[offset:], 1<<20) },
+		"payload":  func(data []byte, offset int) { data[offset+9] ^= 0xff },
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			w := openTestWAL(t, dir)
+			logKeys(t, w, 0, 5)
+			w.Close()
+
+			path := w.segmentPath(1)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			damage(data, frameOffsets(t, data)[2])
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			w = openTestWAL(t, dir)
+			defer w.Close()
+			if keys, err := replayedKeys(w); err == nil {
+				t.Fatalf("the WAL with a damaged %s replays %v", name, keys)
+			}
+			if after, err := os.ReadFile(path); err != nil || len(after) != len(data) {
+				t.Fatalf("the damaged segment was cut: %v", err)
+			}
+		})
+	}
+}
+
+// TestTornWriteBeforeNewerSegment leaves a torn write at the end of a segment
+// followed by a newer one, which a crash can not do, and checks the log refuses
+// to replay.
+func TestTornWriteBeforeNewerSegment(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir)
+	w.SetSegmentSize(64)
+	logKeys(t, w, 0, 5)
+	w.Close()
+	if w.segment < 2 {
+		t.Fatalf("the writes went to %d segments, want several", w.segment)
+	}
+
+	path := w.segmentPath(1)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(data, frame([]byte("torn"))[:10]...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w = openTestWAL(t, dir)
+	defer w.Close()
+	if keys, err := replayedKeys(w); err == nil {
+		t.Fatalf("the WAL replays %v over a torn write followed by a newer segment", keys)
+	}
+}
+
+// TestTornFileHeader leaves the first bytes of the file header in a new
+// segment, as a crash right after it was created does, and checks the log
+// replays the older segments and takes new writes.
+func TestTornFileHeader(t *testing.T) {
+	dir := t.TempDir()
+	w := openTestWAL(t, dir)
+	logKeys(t, w, 0, 3)
+	w.Close()
+
+	path := w.segmentPath(2)
+	if err := os.WriteFile(path, fileHeader[:2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w = openTestWAL(t, dir)
+	checkKeys(t, w, 0, 3)
+	logKeys(t, w, 3, 5)
+	checkKeys(t, w, 0, 5)
+	w.Close()
+
+	// the new writes follow a whole header
+	w = openTestWAL(t, dir)
+	defer w.Close()
+	checkKeys(t, w, 0, 5)
+}
@@ -0,0 +1,52 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// ReadLegacy reads the log at path when it was written by format version 0,
+// before the records carried their write time and were framed: its records are
+// laid out as "<key><value length><value>", a delete having an empty value. It
+// returns the last write of every key in the order of the log, and false when
+// the file is missing, empty or in the current format. A record cut short by a
+// crash at the tail is dropped.
+func ReadLegacy(path string, keySize uint32) ([]WALEntry, bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("WAL %q can not be read: %v", path, err)
+	}
+	if len(data) == 0 || bytes.HasPrefix(data, fileHeader) {
+		return nil, false, nil
+	}
+
+	order := []string{}
+	last := map[string][]byte{}
+	headerSize := int(keySize) + shared.UintSize
+	for offset := 0; len(data)-offset >= headerSize; {
+		key := shared.TrimPaddedKey(string(data[offset : offset+int(keySize)]))
+		length := int(binary.LittleEndian.Uint32(data[offset+int(keySize):]))
+		end := offset + headerSize + length
+		if end > len(data) || end < offset {
+			break
+		}
+		if _, ok := last[key]; !ok {
+			order = append(order, key)
+		}
+		last[key] = data[offset+headerSize : end]
+		offset = end
+	}
+
+	entries := make([]WALEntry, len(order))
+	for i, key := range order {
+		entries[i] = WALEntry{Key: key, Value: last[key]}
+	}
+	return entries, true, nil
+}
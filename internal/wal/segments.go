@@ -0,0 +1,178 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultSegmentSize is the size a segment grows to before the WAL moves on to
+// the next one.
+const DefaultSegmentSize = 64 << 20
+
+// The WAL is a series of numbered segment files named after its source, like
+// "wal.log.bin.000001". Writes go to the newest segment, which is rotated once it
+// grows past the segment size, and by Rotate when the memtable is frozen.
+// Segments are only deleted by Rewrite and RemoveSegmentsBefore, once the
+// memtable they cover is flushed. A single file at the source itself is a log
+// written before segments, it is read as the oldest segment.
+
+// SetSegmentSize sets the size at which the WAL moves on to a new segment.
+func (w *WAL) SetSegmentSize(size int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.segmentSize = size
+}
+
+// SegmentNumber returns the number of the segment named name of the WAL at
+// source, and false when name is not one of its segments.
+func SegmentNumber(source, name string) (int, bool) {
+	number, ok := strings.CutPrefix(filepath.Base(name), filepath.Base(source)+".")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+func (w *WAL) segmentPath(n int) string {
+	return fmt.Sprintf("%s.%06d", w.source, n)
+}
+
+// segmentNumbers returns the numbers of the segments on disk in ascending order.
+func (w *WAL) segmentNumbers() ([]int, error) {
+	entries, err := os.ReadDir(filepath.Dir(w.source))
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := []int{}
+	for _, entry := range entries {
+		if n, ok := SegmentNumber(w.source, entry.Name()); ok {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+// Segments returns the paths of the files holding the log, oldest first.
+func (w *WAL) Segments() ([]string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.segmentFiles()
+}
+
+func (w *WAL) segmentFiles() ([]string, error) {
+	numbers, err := w.segmentNumbers()
+	if err != nil {
+		return nil, fmt.Errorf("WAL %q can not list its segments: %v", w.source, err)
+	}
+
+	paths := []string{}
+	if _, err := os.Stat(w.source); err == nil {
+		paths = append(paths, w.source)
+	}
+	for _, n := range numbers {
+		paths = append(paths, w.segmentPath(n))
+	}
+	return paths, nil
+}
+
+// openSegment opens segment n for appending, starting it with the file header
+// when it is new.
+func (w *WAL) openSegment(n int) error {
+	path := w.segmentPath(n)
+	wfile, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("WAL %q can not open segment %q: %v", w.source, path, err)
+	}
+	w.writer = wfile
+	w.segment = n
+	if err := w.writeHeader(); err != nil {
+		return fmt.Errorf("WAL %q can not write its header: %v", w.source, err)
+	}
+
+	info, err := wfile.Stat()
+	if err != nil {
+		return fmt.Errorf("WAL %q can not stat segment %q: %v", w.source, path, err)
+	}
+	w.size = info.Size()
+	return nil
+}
+
+// rotateIfFull moves on to a new segment when a write of n bytes would grow the
+// current one past the segment size. A segment always takes at least one write.
+func (w *WAL) rotateIfFull(n int) error {
+	if w.segmentSize <= 0 || w.size <= int64(len(fileHeader)) || w.size+int64(n) <= w.segmentSize {
+		return nil
+	}
+	if err := w.writer.Sync(); err != nil {
+		return fmt.Errorf("WAL %q can not sync segment %d: %v", w.source, w.segment, err)
+	}
+	w.writer.Close()
+	if err := w.archiveSegment(w.segmentPath(w.segment)); err != nil {
+		return err
+	}
+	if err := w.openSegment(w.segment + 1); err != nil {
+		return err
+	}
+	// the new segment must not vanish with a crash once it is synced
+	if err := syncDir(filepath.Dir(w.source)); err != nil {
+		return fmt.Errorf("WAL %q can not sync its directory: %v", w.source, err)
+	}
+	return nil
+}
+
+// removeSegmentsBefore deletes the segments older than n, and the log written
+// before segments.
+func (w *WAL) removeSegmentsBefore(n int) error {
+	numbers, err := w.segmentNumbers()
+	if err != nil {
+		return err
+	}
+	for _, number := range numbers {
+		if number >= n {
+			continue
+		}
+		if err := os.Remove(w.segmentPath(number)); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(w.source); err == nil {
+		if err := w.archiveSegment(w.source); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(w.source); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SetArchiveDir makes the WAL link every segment it closes into dir, where the
+// archiver picks them up. The link keeps the segment around once the WAL
+// deletes it.
+func (w *WAL) SetArchiveDir(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.archiveDir = dir
+}
+
+// archiveSegment links the closed segment at path into the archive directory.
+func (w *WAL) archiveSegment(path string) error {
+	if w.archiveDir == "" {
+		return nil
+	}
+	err := os.Link(path, filepath.Join(w.archiveDir, filepath.Base(path)))
+	if err != nil && !os.IsExist(err) {
+		return fmt.Errorf("WAL %q can not archive %q: %v", w.source, path, err)
+	}
+	return nil
+}
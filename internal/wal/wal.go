@@ -2,123 +2,638 @@ package wal
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
+	"github.com/hasssanezzz/goldb/internal/memtable"
 	"github.com/hasssanezzz/goldb/internal/shared"
 )
 
 type WALEntry struct {
-	Key   string
-	Value []byte
+	Key       string
+	Value     []byte
+	Timestamp int64
+	Link      *memtable.IndexNode // Points the key at a value already in the value file, in place of Value.
+	ExpiresAt int64               // Time the key expires at in unix nanoseconds, zero if it never does. A Link carries its own.
+	Sequence  uint64              // Sequence number of the write, zero when it is not numbered.
 }
 
 type WAL struct {
-	keySize uint32
-	source  string
-	writer  *os.File
+	keySize     uint32
+	source      string
+	mu          sync.Mutex // Guards writer, replaced on rotations and by Rewrite.
+	writer      *os.File
+	segment     int      // Number of the segment written to.
+	size        int64    // Size of the segment written to.
+	segmentSize int64    // Size at which a new segment is started.
+	syncWrites  bool     // Sync the segment after every write.
+	keys        *keyring // Seals every write when the WAL is encrypted, nil otherwise.
+	archiveDir  string   // Directory the closed segments are linked into, empty unless archiving.
+	logged      int      // Writes read by the last ParseLogs, overwritten ones included.
+	inspecting  bool     // Read only by Inspect, the files are left as they are.
 }
 
-func New(source string, keySize uint32) (*WAL, error) {
-	w := &WAL{source: source, keySize: keySize}
+// New opens the WAL at source. When encryption keys are given every write is
+// sealed with AES-GCM under the first one, the others are only used to read
+// entries written before a key rotation. Keys must be 16, 24 or 32 bytes long.
+func New(source string, keySize uint32, encryptionKeys ...[]byte) (*WAL, error) {
+	w := &WAL{source: source, keySize: keySize, segmentSize: DefaultSegmentSize}
+	if len(encryptionKeys) > 0 && len(encryptionKeys[0]) > 0 {
+		keys, err := newKeyring(encryptionKeys)
+		if err != nil {
+			return nil, fmt.Errorf("WAL %q can not set up encryption: %v", source, err)
+		}
+		w.keys = keys
+	}
 	return w, w.Open()
 }
 
+// NewWithProvider opens the WAL at source with envelope encryption: the first
+// write after the log is opened or cleared asks provider for a data key the
+// following writes are sealed with, and only the wrapped form of the key is stored.
+func NewWithProvider(source string, keySize uint32, provider shared.KeyProvider) (*WAL, error) {
+	w := &WAL{
+		source:      source,
+		keySize:     keySize,
+		segmentSize: DefaultSegmentSize,
+		keys:        &keyring{keys: map[uint32]cipher.AEAD{}, used: map[uint32]struct{}{}, provider: provider},
+	}
+	return w, w.Open()
+}
+
+// Open opens the newest segment for appending, or the first one of a new log.
 func (w *WAL) Open() error {
-	wfile, err := os.OpenFile(w.source, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	numbers, err := w.segmentNumbers()
 	if err != nil {
-		return fmt.Errorf("WAL %q can not open file: %v", w.source, err)
+		return fmt.Errorf("WAL %q can not list its segments: %v", w.source, err)
 	}
-	w.writer = wfile
-	return nil
+	n := 1
+	if len(numbers) > 0 {
+		n = numbers[len(numbers)-1]
+	}
+	return w.openSegment(n)
+}
+
+// Record kinds, stored in the first byte of every record.
+const (
+	recordEntry    byte = iota // A set, or a delete when the value is empty.
+	recordPrepare              // A prepared batch, the value holds its encoded entries.
+	recordCommit               // Commits the prepared batch named by the key.
+	recordRollback             // Rolls back the prepared batch named by the key.
+	recordLink                 // Points the key at a value in the value file, the value holds its location.
+	recordExpiring             // A set with a TTL, the value is preceded by the expiry.
+	recordSequence             // Numbers the entries following it from the sequence number it holds, zero stops numbering.
+)
+
+// PreparedBatch is a batch that went through the prepare phase of a two-phase
+// commit without being committed or rolled back yet.
+type PreparedBatch struct {
+	ID      string
+	Entries []WALEntry
+}
+
+func (w *WAL) Log(key string, value []byte, timestamp int64) error {
+	return w.LogBatch([]WALEntry{{Key: key, Value: value, Timestamp: timestamp}})
+}
+
+// LogBatch appends all the entries to the log with a single write.
+func (w *WAL) LogBatch(entries []WALEntry) error {
+	bytesToWrite, err := w.encodeAll(entries)
+	if err != nil {
+		return err
+	}
+	return w.write(bytesToWrite)
+}
+
+// LogPrepare records a prepared batch. Its entries are only replayed once a
+// commit record for the same id follows.
+func (w *WAL) LogPrepare(id string, entries []WALEntry) error {
+	bytesToWrite, err := w.encodePrepare(id, entries)
+	if err != nil {
+		return err
+	}
+	return w.write(bytesToWrite)
 }
 
-func (w *WAL) Log(key string, value []byte) error {
-	bytesToWrite, err := shared.KeyToBytes(key, w.keySize)
+func (w *WAL) encodePrepare(id string, entries []WALEntry) ([]byte, error) {
+	payload, err := w.encodeAll(entries)
+	if err != nil {
+		return nil, err
+	}
+	return w.encode(recordPrepare, WALEntry{Key: id, Value: payload, Timestamp: time.Now().UnixNano()})
+}
+
+// LogDecision records whether the prepared batch with the given id was committed
+// or rolled back. The entries of a committed batch are numbered from sequence,
+// or not at all when it is zero.
+func (w *WAL) LogDecision(id string, commit bool, sequence uint64) error {
+	kind := recordRollback
+	if commit {
+		kind = recordCommit
+	}
+
+	record, err := w.encode(kind, WALEntry{Key: id, Timestamp: time.Now().UnixNano()})
 	if err != nil {
 		return err
 	}
+	return w.write(append(encodeSequence(sequence), record...))
+}
+
+func (w *WAL) write(bytesToWrite []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	bytesToWrite, err := w.seal(bytesToWrite)
+	if err != nil {
+		return err
+	}
+
+	framed := frame(bytesToWrite)
+	if err := w.rotateIfFull(len(framed)); err != nil {
+		return err
+	}
+	n, err := w.writer.Write(framed)
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("WAL %q can not write log: %v", w.source, err)
+	}
+	if w.syncWrites {
+		if err := w.writer.Sync(); err != nil {
+			return fmt.Errorf("WAL %q can not sync log: %v", w.source, err)
+		}
+	}
+
+	return nil
+}
+
+// SetSyncWrites makes every write sync the log before returning, so an
+// acknowledged write survives a power loss.
+func (w *WAL) SetSyncWrites(value bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.syncWrites = value
+}
+
+// Sync flushes the writes made so far to disk.
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.writer.Sync(); err != nil {
+		return fmt.Errorf("WAL %q can not sync log: %v", w.source, err)
+	}
+	return nil
+}
+
+// seal encrypts the records of a write when the WAL is encrypted.
+func (w *WAL) seal(records []byte) ([]byte, error) {
+	if w.keys == nil {
+		return records, nil
+	}
+	sealed, err := w.keys.seal(records)
+	if err != nil {
+		return nil, fmt.Errorf("WAL %q can not encrypt log: %v", w.source, err)
+	}
+	return sealed, nil
+}
+
+func (w *WAL) encodeAll(entries []WALEntry) ([]byte, error) {
+	bytesToWrite := []byte{}
+	next := uint64(0)
+	for i, entry := range entries {
+		// every run of entries numbered one after the other starts with the
+		// number of its first one, a run of entries that are not numbered with zero
+		if i == 0 || entry.Sequence != next {
+			bytesToWrite = append(bytesToWrite, encodeSequence(entry.Sequence)...)
+		}
+		next = 0
+		if entry.Sequence != 0 {
+			next = entry.Sequence + 1
+		}
+
+		kind := recordEntry
+		if entry.Link != nil {
+			kind, entry = recordLink, WALEntry{Key: entry.Key, Value: encodeLink(*entry.Link), Timestamp: entry.Timestamp}
+		} else if entry.ExpiresAt != 0 && len(entry.Value) > 0 {
+			value := binary.LittleEndian.AppendUint64(nil, uint64(entry.ExpiresAt))
+			kind, entry = recordExpiring, WALEntry{Key: entry.Key, Value: append(value, entry.Value...), Timestamp: entry.Timestamp}
+		}
+		record, err := w.encode(kind, entry)
+		if err != nil {
+			return nil, err
+		}
+		bytesToWrite = append(bytesToWrite, record...)
+	}
+	return bytesToWrite, nil
+}
+
+// encode serializes a record as "<kind><key><timestamp><value length><value>".
+func (w *WAL) encode(kind byte, entry WALEntry) ([]byte, error) {
+	keyBytes, err := shared.KeyToBytes(entry.Key, w.keySize)
+	if err != nil {
+		return nil, err
+	}
+	bytesToWrite := append([]byte{kind}, keyBytes...)
+
+	timestampBuff := make([]byte, shared.Uint64Size)
+	binary.LittleEndian.PutUint64(timestampBuff, uint64(entry.Timestamp))
+	bytesToWrite = append(bytesToWrite, timestampBuff...)
 
 	valueLengthBuff := make([]byte, 4)
-	valueLength := uint32(len(value))
+	valueLength := uint32(len(entry.Value))
 	binary.LittleEndian.PutUint32(valueLengthBuff, valueLength)
 	bytesToWrite = append(bytesToWrite, valueLengthBuff...)
 
 	// if len(value) == 0 then this is a delete operation
 	// if not, this is a set/put operation
-	if len(value) > 0 {
-		bytesToWrite = append(bytesToWrite, value...)
+	if len(entry.Value) > 0 {
+		bytesToWrite = append(bytesToWrite, entry.Value...)
 	}
 
-	_, err = w.writer.Write(bytesToWrite)
+	return bytesToWrite, nil
+}
+
+// encodeSequence serializes a sequence record as "<kind><sequence>".
+func encodeSequence(sequence uint64) []byte {
+	return binary.LittleEndian.AppendUint64([]byte{recordSequence}, sequence)
+}
+
+// decode reads the next record, returning io.EOF once the log is exhausted.
+// A record cut short by a torn write at the tail is treated as the end of the log.
+// The number of a sequence record is returned in the Sequence of the entry.
+func (w *WAL) decode(r io.Reader) (byte, WALEntry, error) {
+	kind := []byte{0}
+	if _, err := io.ReadFull(r, kind); err != nil {
+		return 0, WALEntry{}, err
+	}
+
+	size := int(w.keySize) + shared.Uint64Size + shared.UintSize
+	if kind[0] == recordSequence {
+		size = shared.Uint64Size
+	}
+	header := make([]byte, size)
+	_, err := io.ReadFull(r, header)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return 0, WALEntry{}, io.EOF
+	}
 	if err != nil {
-		return fmt.Errorf("WAL %q can not write log: %v", w.source, err)
+		return 0, WALEntry{}, err
+	}
+	if kind[0] == recordSequence {
+		return recordSequence, WALEntry{Sequence: binary.LittleEndian.Uint64(header)}, nil
 	}
 
-	return nil
+	keyBytes, header := header[:w.keySize], header[w.keySize:]
+	timestamp := int64(binary.LittleEndian.Uint64(header[:shared.Uint64Size]))
+	vlength := binary.LittleEndian.Uint32(header[shared.Uint64Size:])
+
+	value := make([]byte, vlength)
+	_, err = io.ReadFull(r, value)
+	if err == io.ErrUnexpectedEOF {
+		return 0, WALEntry{}, io.EOF
+	}
+	if err != nil {
+		return 0, WALEntry{}, err
+	}
+
+	return kind[0], WALEntry{
+		Key:       shared.TrimPaddedKey(string(keyBytes)),
+		Value:     value,
+		Timestamp: timestamp,
+	}, nil
 }
 
-func (w *WAL) ParseLogs() ([]WALEntry, error) {
-	rfile, err := os.Open(w.source)
+// encodeLink serializes the location of a linked value as "<offset><size><flags>",
+// followed by "<expiry>" when the key has a TTL or the value extents, which are
+// serialized like the location after it.
+func encodeLink(node memtable.IndexNode) []byte {
+	value := binary.LittleEndian.AppendUint32(nil, node.Offset)
+	value = binary.LittleEndian.AppendUint32(value, node.Size)
+	flags := byte(0)
+	if node.Compressed {
+		flags = 1
+	}
+	value = append(value, flags)
+	if node.ExpiresAt != 0 || len(node.Extents) > 0 {
+		value = binary.LittleEndian.AppendUint64(value, uint64(node.ExpiresAt))
+	}
+	for _, extent := range node.Extents {
+		value = binary.LittleEndian.AppendUint32(value, extent.Offset)
+		value = binary.LittleEndian.AppendUint32(value, extent.Size)
+		compressed := byte(0)
+		if extent.Compressed {
+			compressed = 1
+		}
+		value = append(value, compressed)
+	}
+	return value
+}
+
+// decodeEntry turns a decoded record of an entry kind into the entry to replay.
+func decodeEntry(kind byte, entry WALEntry) (WALEntry, error) {
+	if kind == recordExpiring {
+		if len(entry.Value) <= shared.Uint64Size {
+			return WALEntry{}, fmt.Errorf("expiring entry of key %q is malformed", entry.Key)
+		}
+		entry.ExpiresAt = int64(binary.LittleEndian.Uint64(entry.Value))
+		entry.Value = entry.Value[shared.Uint64Size:]
+		return entry, nil
+	}
+	if kind != recordLink {
+		return entry, nil
+	}
+
+	size := shared.UintSize*2 + 1
+	if len(entry.Value) != size && (len(entry.Value) < size+shared.Uint64Size || (len(entry.Value)-size-shared.Uint64Size)%size != 0) {
+		return WALEntry{}, fmt.Errorf("link of key %q is malformed", entry.Key)
+	}
+	link := &memtable.IndexNode{
+		Offset:     binary.LittleEndian.Uint32(entry.Value),
+		Size:       binary.LittleEndian.Uint32(entry.Value[shared.UintSize:]),
+		Timestamp:  entry.Timestamp,
+		Compressed: entry.Value[shared.UintSize*2] == 1,
+	}
+	if len(entry.Value) > size {
+		link.ExpiresAt = int64(binary.LittleEndian.Uint64(entry.Value[size:]))
+	}
+	// the extents have the layout of the location of the value
+	for extent := entry.Value[min(len(entry.Value), size+shared.Uint64Size):]; len(extent) > 0; extent = extent[size:] {
+		link.Extents = append(link.Extents, memtable.Extent{
+			Offset:     binary.LittleEndian.Uint32(extent),
+			Size:       binary.LittleEndian.Uint32(extent[shared.UintSize:]),
+			Compressed: extent[shared.UintSize*2] == 1,
+		})
+	}
+	return WALEntry{Key: entry.Key, Timestamp: entry.Timestamp, Link: link}, nil
+}
+
+// ParseLogs returns the entries to replay, one per key, along with the prepared
+// batches that were neither committed nor rolled back.
+func (w *WAL) ParseLogs() ([]WALEntry, []PreparedBatch, error) {
+	w.mu.Lock()
+	paths, err := w.segmentFiles()
+	w.mu.Unlock()
 	if err != nil {
-		return nil, fmt.Errorf("WAL %q can not be opened: %v", w.source, err)
+		return nil, nil, err
+	}
+	return w.parse(paths)
+}
+
+// Inspect parses the log files at paths, oldest first, like ParseLogs without
+// opening a WAL: nothing is written, a torn write at the tail of the last file
+// is left in place and one in any other file is an error. It returns the
+// entries to replay, one per key.
+func Inspect(paths []string, keySize uint32, provider shared.KeyProvider, encryptionKeys ...[]byte) ([]WALEntry, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	w := &WAL{source: paths[0], keySize: keySize, inspecting: true}
+	if provider != nil {
+		w.keys = &keyring{keys: map[uint32]cipher.AEAD{}, used: map[uint32]struct{}{}, provider: provider}
+	} else if len(encryptionKeys) > 0 && len(encryptionKeys[0]) > 0 {
+		keys, err := newKeyring(encryptionKeys)
+		if err != nil {
+			return nil, fmt.Errorf("WAL %q can not set up encryption: %v", w.source, err)
+		}
+		w.keys = keys
 	}
-	defer rfile.Close()
 
-	buf := bytes.NewBuffer(nil)
-	_, err = io.Copy(buf, rfile)
+	entries, _, err := w.parse(paths)
+	return entries, err
+}
+
+// parse reads the records of the log files at paths.
+func (w *WAL) parse(paths []string) ([]WALEntry, []PreparedBatch, error) {
+	buf, err := w.readWrites(paths)
 	if err != nil {
-		return nil, fmt.Errorf("WAL %q can not be read: %v", w.source, err)
+		return nil, nil, fmt.Errorf("WAL %q %v", w.source, err)
 	}
 
 	pairs := []WALEntry{}
-	mp := map[string][]byte{}
+	mp := map[string]WALEntry{}
+	prepared := map[string][]WALEntry{}
+	preparedOrder := []string{}
+	logged := 0
+	next := uint64(0) // Number of the next entry, zero while they are not numbered.
 
 	for {
-		keyBytes, vlength := make([]byte, w.keySize), make([]byte, 4)
-		_, err = buf.Read(keyBytes)
+		kind, entry, err := w.decode(buf)
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				return nil, fmt.Errorf("WAL %q can not be parsed: %v", w.source, err)
-			}
+			return nil, nil, fmt.Errorf("WAL %q can not be parsed: %v", w.source, err)
 		}
 
-		_, err = buf.Read(vlength)
-		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				return nil, fmt.Errorf("WAL %q can not be parsed: %v", w.source, err)
+		switch kind {
+		case recordSequence:
+			next = entry.Sequence
+		case recordEntry, recordLink, recordExpiring:
+			entry, err := decodeEntry(kind, entry)
+			if err != nil {
+				return nil, nil, fmt.Errorf("WAL %q can not be parsed: %v", w.source, err)
+			}
+			if next != 0 {
+				entry.Sequence = next
+				next++
 			}
+			// add to the to map not the pairs array for compaction
+			keepNewest(mp, entry)
+			logged++
+		case recordPrepare:
+			entries := []WALEntry{}
+			payload := bytes.NewBuffer(entry.Value)
+			for {
+				kind, nested, err := w.decode(payload)
+				if err == io.EOF {
+					break
+				}
+				if kind == recordSequence {
+					// prepared entries are numbered by their commit
+					continue
+				}
+				if err == nil {
+					nested, err = decodeEntry(kind, nested)
+				}
+				if err != nil {
+					return nil, nil, fmt.Errorf("WAL %q can not parse prepared batch %q: %v", w.source, entry.Key, err)
+				}
+				entries = append(entries, nested)
+			}
+			prepared[entry.Key] = entries
+			preparedOrder = append(preparedOrder, entry.Key)
+		case recordCommit:
+			for _, nested := range prepared[entry.Key] {
+				if next != 0 {
+					nested.Sequence = next
+					next++
+				}
+				keepNewest(mp, nested)
+			}
+			logged += len(prepared[entry.Key])
+			delete(prepared, entry.Key)
+		case recordRollback:
+			delete(prepared, entry.Key)
+		default:
+			return nil, nil, fmt.Errorf("WAL %q has a record of unknown kind %d", w.source, kind)
+		}
+	}
+
+	for _, entry := range mp {
+		pairs = append(pairs, entry)
+	}
+	w.logged = logged
+
+	pending := []PreparedBatch{}
+	for _, id := range preparedOrder {
+		if entries, ok := prepared[id]; ok {
+			pending = append(pending, PreparedBatch{ID: id, Entries: entries})
+			delete(prepared, id)
 		}
+	}
+
+	return pairs, pending, nil
+}
 
-		value := make([]byte, binary.LittleEndian.Uint32(vlength))
-		_, err = buf.Read(value)
+// keepNewest records entry as the last write of its key unless a write with a
+// higher sequence number was read before it: relaxed writes reach the log in the
+// order they were applied, which is not always the order they were numbered in.
+// Writes that are not numbered follow the order of the log.
+func keepNewest(mp map[string]WALEntry, entry WALEntry) {
+	if old, ok := mp[entry.Key]; ok && entry.Sequence != 0 && entry.Sequence < old.Sequence {
+		return
+	}
+	mp[entry.Key] = entry
+}
+
+// Logged returns the number of writes read by the last ParseLogs. Only the last
+// write of every key is returned by it, this counts the ones it overwrote too.
+func (w *WAL) Logged() int {
+	return w.logged
+}
+
+// Rewrite replaces the log with a new segment holding only the given prepared
+// batches, as done once its entries are flushed. The segment is written to a
+// temporary file, synced and renamed into place before the older segments are
+// deleted, so a crash never loses the prepared batches.
+func (w *WAL) Rewrite(prepared []PreparedBatch) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	next, err := w.rotate(prepared)
+	if err != nil {
+		return err
+	}
+	return w.removeFlushed(next)
+}
+
+// Rotate moves on to a new segment holding only the given prepared batches like
+// Rewrite, but leaves the older segments in place, and returns the number of the
+// new segment. It is called when the memtable is frozen: once it is flushed,
+// RemoveSegmentsBefore drops the segments it covers.
+func (w *WAL) Rotate(prepared []PreparedBatch) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rotate(prepared)
+}
+
+// RemoveSegmentsBefore deletes the segments older than segment n, once the
+// memtable frozen when Rotate returned n is flushed.
+func (w *WAL) RemoveSegmentsBefore(n int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.removeFlushed(n)
+}
+
+func (w *WAL) removeFlushed(n int) error {
+	// the flushed memtable covers every older segment
+	if err := w.removeSegmentsBefore(n); err != nil {
+		return fmt.Errorf("WAL %q can not remove flushed segments: %v", w.source, err)
+	}
+	if w.keys != nil {
+		w.keys.forgetRotated()
+	}
+	return nil
+}
+
+func (w *WAL) rotate(prepared []PreparedBatch) (int, error) {
+	if w.keys != nil {
+		w.keys.rotate()
+	}
+
+	bytesToWrite := []byte{}
+	for _, batch := range prepared {
+		record, err := w.encodePrepare(batch.ID, batch.Entries)
 		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				return nil, fmt.Errorf("WAL %q can not be parsed: %v", w.source, err)
-			}
+			return 0, err
+		}
+		bytesToWrite = append(bytesToWrite, record...)
+	}
+	if len(bytesToWrite) > 0 {
+		sealed, err := w.seal(bytesToWrite)
+		if err != nil {
+			return 0, err
 		}
+		bytesToWrite = frame(sealed)
+	}
 
-		// add to the to map not the pairs array for compaction
-		mp[shared.TrimPaddedKey(string(keyBytes))] = value
+	next := w.segment + 1
+	path := w.segmentPath(next)
+	tmp := path + ".tmp"
+	if err := writeSynced(tmp, append(append([]byte{}, fileHeader...), bytesToWrite...)); err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("WAL %q can not be rewritten: %v", w.source, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("WAL %q can not be rewritten: %v", w.source, err)
+	}
+	if err := syncDir(filepath.Dir(w.source)); err != nil {
+		return 0, fmt.Errorf("WAL %q can not be rewritten: %v", w.source, err)
 	}
 
-	for key, value := range mp {
-		pairs = append(pairs, WALEntry{Key: key, Value: value})
+	w.writer.Close()
+	if err := w.archiveSegment(w.segmentPath(w.segment)); err != nil {
+		return 0, err
 	}
+	if err := w.openSegment(next); err != nil {
+		return 0, err
+	}
+	return next, nil
+}
 
-	return pairs, nil
+func writeSynced(path string, data []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
 }
 
+// Clear empties the log, prepared batches included.
 func (w *WAL) Clear() error {
-	return os.Truncate(w.source, 0)
+	return w.Rewrite(nil)
 }
 
 func (w *WAL) Close() {
@@ -0,0 +1,432 @@
+package goldb
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/index_manager"
+	"github.com/hasssanezzz/goldb/internal/memtable"
+	"github.com/hasssanezzz/goldb/internal/shared"
+	"github.com/hasssanezzz/goldb/internal/storage_manager"
+)
+
+var errKeysOnly = errors.New("iterator was opened with KeysOnly, values are not available")
+
+// IteratorOption customizes the entries an Iterator walks over.
+type IteratorOption func(*index_manager.ScanOptions)
+
+// WithTimeBounds limits the iterator to entries written within [from, to].
+// Whole SSTables whose time range falls outside the window are skipped without
+// being read, which keeps scans over recent data cheap in time-series workloads.
+func WithTimeBounds(from, to time.Time) IteratorOption {
+	return func(opts *index_manager.ScanOptions) {
+		opts.From = from.UnixNano()
+		opts.To = to.UnixNano()
+	}
+}
+
+// WithCreationTimeBounds limits the iterator to the tables created within
+// [from, to], the memtable counting as created now. Tables created outside the
+// window are not read at all, so a pipeline can process only the data flushed
+// since its last run. Tables are picked as a whole: an entry of a picked table
+// shows even when a skipped newer table overwrote or deleted its key, and a
+// compaction moves the entries it merges to a newly created table.
+func WithCreationTimeBounds(from, to time.Time) IteratorOption {
+	return func(opts *index_manager.ScanOptions) {
+		opts.CreatedFrom = from.UnixNano()
+		opts.CreatedTo = to.UnixNano()
+	}
+}
+
+// WithRange limits the iterator to keys within [start, end).
+// An empty end leaves the range unbounded.
+func WithRange(start, end string) IteratorOption {
+	return func(opts *index_manager.ScanOptions) {
+		opts.Start = start
+		opts.End = end
+	}
+}
+
+// KeysOnly makes the iterator walk the SSTable indexes alone. The value file is
+// never touched and Value returns an error, which keeps jobs that count or
+// inventory keys over large datasets cheap.
+func KeysOnly() IteratorOption {
+	return func(opts *index_manager.ScanOptions) {
+		opts.KeysOnly = true
+	}
+}
+
+// Iterator walks over the live key-value pairs of the database in key order,
+// following the collation of their namespace. The memtable and the tables are
+// merged as the iterator moves, only the keys of a namespace with a collation
+// are loaded at once to be sorted. Seek jumps to a key without walking the
+// entries before it. Last, SeekForPrev and Prev walk the keys backwards.
+//
+// The iterator reads the database as it was when it was created. It keeps the
+// tables it reads open until Close, so it has to be closed.
+//
+//	it, err := db.NewIterator()
+//	defer it.Close()
+//	for it.Next() {
+//		value, err := it.Value()
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+type Iterator struct {
+	config    *shared.EngineConfig
+	values    *storage_manager.StorageManager
+	cursor    *index_manager.Cursor
+	pair      memtable.KVPair // Entry at the current position.
+	valid     bool
+	before    bool // Out of the entries before the first one rather than after the last one.
+	err       error
+	keysOnly  bool
+	stats     IteratorStats
+	scanStats *index_manager.ScanStats
+	reads     *readLimiter // Paces the reads of low priority iterators, nil leaves them unpaced.
+	low       bool
+
+	// The keys of a namespace with a collation, sorted by it, while the
+	// iterator is within one. Its prefix is namespace.
+	block     []memtable.KVPair
+	blockPos  int
+	namespace string
+
+	lock       *valueLock // Keeps the values in place while read, nil when nothing moves them.
+	generation uint64     // Collections of the value file done before the pairs were merged.
+}
+
+// IteratorStats counts the work done by an iterator, to tell why a scan is slow.
+type IteratorStats struct {
+	KeysScanned       int   // Keys the merge went through, deleted and filtered out ones included.
+	TombstonesSkipped int   // Deleted keys left out.
+	TablesRead        int   // SSTables and levels merged by the iterator.
+	EntriesRead       int   // Index entries read from disk rather than from a loaded index.
+	BytesRead         int64 // Size of the index entries read from disk.
+	ValuesRead        int   // Values read by Value.
+	ValueBytesRead    int64 // Size of the values read by Value.
+
+	// The longest run of deleted keys skipped, a long one is worth compacting.
+	LongestTombstoneRun int
+	TombstoneRunStart   string
+	TombstoneRunEnd     string
+}
+
+// NewIterator returns an iterator positioned before the first key.
+func (e *Engine) NewIterator(options ...IteratorOption) (*Iterator, error) {
+	return newIterator(&e.Config, e.indexManager, e.storageManager, e.values, e.reads, options...)
+}
+
+func newIterator(config *shared.EngineConfig, im *index_manager.IndexManager, values *storage_manager.StorageManager, lock *valueLock, reads *readLimiter, options ...IteratorOption) (*Iterator, error) {
+	opts := index_manager.NewScanOptions()
+	for _, option := range options {
+		option(&opts)
+	}
+	scanStats := &index_manager.ScanStats{}
+	opts.Stats = scanStats
+
+	it := &Iterator{config: config, values: values, before: true, keysOnly: opts.KeysOnly, scanStats: scanStats, reads: reads, low: opts.LowPriority, lock: lock}
+	if lock != nil {
+		it.generation = lock.generation.Load()
+	}
+	done := it.beginRead()
+	cursor, err := im.NewCursor(opts)
+	done(scanStats.BytesRead)
+	if err != nil {
+		return nil, err
+	}
+	it.cursor = cursor
+	return it, nil
+}
+
+// withDeleted makes the iterator walk the deleted keys too, see deleted.
+func withDeleted() IteratorOption {
+	return func(opts *index_manager.ScanOptions) {
+		opts.Deleted = true
+	}
+}
+
+// Next advances the iterator and reports whether an entry is available.
+func (it *Iterator) Next() bool {
+	if it.err != nil || it.cursor == nil || (!it.valid && !it.before) {
+		return false
+	}
+	if it.block != nil {
+		if it.blockPos+1 < len(it.block) {
+			it.blockPos++
+			it.pair = it.block[it.blockPos]
+			return true
+		}
+		// the merge went past the namespace while loading it
+		end := shared.PrefixEnd(it.namespace)
+		it.block = nil
+		if end == "" {
+			// nothing sorts after the namespace, Prev comes back to its last key
+			it.valid, it.before = false, false
+			it.step(it.cursor.Last)
+			return false
+		}
+		if !it.step(func() error { return it.cursor.Seek(end) }) {
+			return false
+		}
+	}
+	return it.advance(false)
+}
+
+// Prev moves the iterator back and reports whether an entry is available.
+func (it *Iterator) Prev() bool {
+	if it.err != nil || it.cursor == nil || (!it.valid && it.before) {
+		return false
+	}
+	if it.block != nil {
+		if it.blockPos > 0 {
+			it.blockPos--
+			it.pair = it.block[it.blockPos]
+			return true
+		}
+		it.block = nil
+		if !it.step(func() error { return it.cursor.SeekBefore(it.namespace) }) {
+			return false
+		}
+	}
+	return it.advance(true)
+}
+
+// advance moves the merge to its next entry, or to the previous one when
+// backwards is set. When the entry falls in a namespace with a collation, the
+// namespace is loaded and the iterator stops at its first key in the direction
+// it walks.
+func (it *Iterator) advance(backwards bool) bool {
+	move := it.cursor.Next
+	if backwards {
+		move = it.cursor.Prev
+	}
+	var pair memtable.KVPair
+	if !it.step(func() (err error) {
+		pair, it.valid, err = move()
+		return err
+	}) {
+		return false
+	}
+	if !it.valid {
+		it.before = backwards
+		return false
+	}
+
+	namespace, collated := it.config.CollationFor(pair.Key)
+	if !collated {
+		it.pair = pair
+		return true
+	}
+	it.block = []memtable.KVPair{pair}
+	it.namespace = namespace.Prefix
+	for {
+		var ok bool
+		if !it.step(func() (err error) {
+			pair, ok, err = move()
+			return err
+		}) {
+			it.block = nil
+			return false
+		}
+		if !ok || !strings.HasPrefix(pair.Key, namespace.Prefix) {
+			break
+		}
+		it.block = append(it.block, pair)
+	}
+	collatePairs(it.config, it.block)
+	it.blockPos = 0
+	if backwards {
+		it.blockPos = len(it.block) - 1
+	}
+	it.pair, it.valid = it.block[it.blockPos], true
+	return true
+}
+
+// step runs a move of the merge, paced by the read limiter, and keeps its error.
+func (it *Iterator) step(move func() error) bool {
+	before := it.scanStats.BytesRead
+	done := it.beginRead()
+	err := move()
+	done(it.scanStats.BytesRead - before)
+	if err != nil {
+		it.err = err
+		it.valid = false
+		return false
+	}
+	return true
+}
+
+// Seek positions the iterator at the first key not less than key, in the order
+// of the iterator, and reports whether there is one. Key and Value return that
+// entry right away, and Next moves on to the one after it.
+//
+//	for ok := it.Seek("user:100"); ok; ok = it.Next() {
+//		fmt.Println(it.Key())
+//	}
+func (it *Iterator) Seek(key string) bool {
+	if it.err != nil || it.cursor == nil {
+		return false
+	}
+
+	it.block = nil
+	namespace, collated := it.config.CollationFor(key)
+	target := key
+	if collated {
+		// the namespace is loaded from its first key and searched in its order
+		target = namespace.Prefix
+	}
+	if !it.step(func() error { return it.cursor.Seek(target) }) || !it.advance(false) {
+		return false
+	}
+	if it.block == nil || it.namespace != namespace.Prefix || !collated {
+		return true
+	}
+	it.blockPos = sort.Search(len(it.block), func(i int) bool {
+		return !collatedLess(it.config, it.block[i].Key, key)
+	})
+	if it.blockPos < len(it.block) {
+		it.pair = it.block[it.blockPos]
+		return true
+	}
+	it.blockPos = len(it.block) - 1
+	return it.Next()
+}
+
+// SeekForPrev positions the iterator at the last key not greater than key, in
+// the order of the iterator, and reports whether there is one. Prev moves on to
+// the one before it, which walks the keys in descending order.
+//
+//	for ok := it.SeekForPrev("events:\xff"); ok; ok = it.Prev() {
+//		fmt.Println(it.Key())
+//	}
+func (it *Iterator) SeekForPrev(key string) bool {
+	if it.err != nil || it.cursor == nil {
+		return false
+	}
+
+	it.block = nil
+	namespace, collated := it.config.CollationFor(key)
+	// the smallest key greater than key
+	seek := func() error { return it.cursor.SeekBefore(key + "\x00") }
+	if collated {
+		// the namespace is loaded from its last key and searched in its order
+		seek = it.cursor.Last
+		if end := shared.PrefixEnd(namespace.Prefix); end != "" {
+			seek = func() error { return it.cursor.SeekBefore(end) }
+		}
+	}
+	if !it.step(seek) || !it.advance(true) {
+		return false
+	}
+	if it.block == nil || it.namespace != namespace.Prefix || !collated {
+		return true
+	}
+	it.blockPos = sort.Search(len(it.block), func(i int) bool {
+		return collatedLess(it.config, key, it.block[i].Key)
+	}) - 1
+	if it.blockPos >= 0 {
+		it.pair = it.block[it.blockPos]
+		return true
+	}
+	it.blockPos = 0
+	return it.Prev()
+}
+
+// Last positions the iterator at the last key and reports whether there is one.
+func (it *Iterator) Last() bool {
+	if it.err != nil || it.cursor == nil {
+		return false
+	}
+	it.block = nil
+	return it.step(it.cursor.Last) && it.advance(true)
+}
+
+// Err returns the error that stopped the iterator, if reading the tables failed.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Key returns the key at the current position.
+func (it *Iterator) Key() string {
+	return it.pair.Key
+}
+
+// Timestamp returns the write time of the current entry.
+func (it *Iterator) Timestamp() time.Time {
+	return time.Unix(0, it.pair.Value.Timestamp)
+}
+
+// deleted reports whether the key at the current position is deleted, which only
+// iterators opened withDeleted walk over.
+func (it *Iterator) deleted() bool {
+	return it.pair.Value.IsDeleted()
+}
+
+// Value reads the value at the current position from disk. Read hooks transform
+// the value, but unlike Get the iterator never writes it back.
+func (it *Iterator) Value() ([]byte, error) {
+	if it.keysOnly {
+		return nil, errKeysOnly
+	}
+
+	if it.lock != nil {
+		it.lock.mu.RLock()
+		defer it.lock.mu.RUnlock()
+		if it.lock.generation.Load() != it.generation {
+			return nil, errValuesMoved
+		}
+	}
+
+	done := it.beginRead()
+	value, err := it.values.ReadValue(it.pair.Value)
+	done(int64(len(value)))
+	if err != nil {
+		return nil, err
+	}
+	it.stats.ValuesRead++
+	it.stats.ValueBytesRead += int64(len(value))
+	value, _, err = transformValue(it.config, it.Key(), value)
+	return value, err
+}
+
+// beginRead waits for the turn of the iterator to read, and returns the function
+// to call with the number of bytes read once done.
+func (it *Iterator) beginRead() func(n int64) {
+	if it.reads == nil {
+		return func(int64) {}
+	}
+	if it.low {
+		it.reads.yield()
+		return it.reads.charge
+	}
+	done := it.reads.foregroundRead()
+	return func(int64) { done() }
+}
+
+// Stats returns the work done by the iterator so far. It is still available after Close.
+func (it *Iterator) Stats() IteratorStats {
+	stats := it.stats
+	stats.KeysScanned = it.scanStats.KeysScanned
+	stats.TombstonesSkipped = it.scanStats.TombstonesSkipped
+	stats.TablesRead = it.scanStats.TablesRead
+	stats.EntriesRead = it.scanStats.EntriesRead
+	stats.BytesRead = it.scanStats.BytesRead
+	stats.LongestTombstoneRun = it.scanStats.LongestTombstoneRun
+	stats.TombstoneRunStart = it.scanStats.TombstoneRunStart
+	stats.TombstoneRunEnd = it.scanStats.TombstoneRunEnd
+	return stats
+}
+
+// Close releases the tables the iterator reads.
+func (it *Iterator) Close() {
+	if it.cursor != nil {
+		it.cursor.Close()
+		it.cursor = nil
+	}
+	it.block = nil
+	it.valid = false
+}
@@ -0,0 +1,268 @@
+package goldb
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// TestIteratorStreamsAcrossMaintenance opens iterators over many tables,
+// compacts them away or relocates their values while they are halfway through,
+// and checks they still walk every key once, in order.
+func TestIteratorStreamsAcrossMaintenance(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		maintain func(e *Engine) error
+	}{
+		{"compaction", (*Engine).Compact},
+		{"value collection", func(e *Engine) error {
+			_, err := e.CollectValueGarbage()
+			return err
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			e := openTestEngine(t, t.TempDir(), func(c *EngineConfig) {
+				c.MemtableSizeThreshold = 16
+				// the flushed tables are left apart until the maintenance runs
+				c.CompactionThreshold = 64
+				// the pairs are read from the table files rather than from memory
+				c.PinLevels = 0
+				c.BlockCacheSize = 0
+			})
+			defer e.Close()
+
+			const keys = 200
+			for i := 0; i < keys; i++ {
+				if err := e.Set(fmt.Sprintf("key-%03d", i), []byte(fmt.Sprintf("old-%03d", i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			it, err := e.NewIterator(KeysOnly())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer it.Close()
+			if n := it.Stats().TablesRead; n < 2 {
+				t.Fatalf("the iterator merges %d tables, want several", n)
+			}
+
+			seen := 0
+			for it.Next() {
+				if want := fmt.Sprintf("key-%03d", seen); it.Key() != want {
+					t.Fatalf("key %d is %q, want %q", seen, it.Key(), want)
+				}
+				seen++
+
+				if seen == keys/2 {
+					for i := 0; i < keys; i++ {
+						if err := e.Set(fmt.Sprintf("key-%03d", i), []byte("new")); err != nil {
+							t.Fatal(err)
+						}
+					}
+					if err := c.maintain(e); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if err := it.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if seen != keys {
+				t.Fatalf("the iterator walked %d keys, want %d", seen, keys)
+			}
+		})
+	}
+}
+
+// TestIteratorCollatedNamespace walks and seeks keys around a namespace with a
+// collation, which the iterator loads at once to sort.
+func TestIteratorCollatedNamespace(t *testing.T) {
+	e := openTestEngine(t, t.TempDir(), func(c *EngineConfig) {
+		c.MemtableSizeThreshold = 4
+		c.Collations = []shared.NamespaceCollation{{Prefix: "item:", Collation: NaturalCollation{}}}
+	})
+	defer e.Close()
+
+	for _, key := range []string{"item:10", "b", "item:2", "item:1", "z", "item:9", "item:11"} {
+		if err := e.Set(key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"b", "item:1", "item:2", "item:9", "item:10", "item:11", "z"}
+
+	it, err := e.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	got := []string{}
+	for it.Next() {
+		got = append(got, it.Key())
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("the iterator walks %v, want %v", got, want)
+	}
+
+	for _, c := range []struct{ seek, at, next string }{
+		{"item:3", "item:9", "item:10"},
+		{"item:11", "item:11", "z"},
+		{"item:12", "z", ""},
+		{"a", "b", "item:1"},
+	} {
+		if !it.Seek(c.seek) || it.Key() != c.at {
+			t.Fatalf("seeking %q does not stop at %q", c.seek, c.at)
+		}
+		if ok := it.Next(); ok != (c.next != "") || (ok && it.Key() != c.next) {
+			t.Fatalf("the key after %q is not %q", c.at, c.next)
+		}
+	}
+
+	got = []string{}
+	for ok := it.Last(); ok; ok = it.Prev() {
+		got = append([]string{it.Key()}, got...)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("the iterator walks %v backwards, want %v", got, want)
+	}
+	for _, c := range []struct{ seek, at, prev string }{
+		{"item:3", "item:2", "item:1"},
+		{"item:10", "item:10", "item:9"},
+		{"item:0", "b", ""},
+		{"c", "b", ""},
+		{"zz", "z", "item:11"},
+	} {
+		if !it.SeekForPrev(c.seek) || it.Key() != c.at {
+			t.Fatalf("seeking back from %q does not stop at %q", c.seek, c.at)
+		}
+		if ok := it.Prev(); ok != (c.prev != "") || (ok && it.Key() != c.prev) {
+			t.Fatalf("the key before %q is not %q", c.at, c.prev)
+		}
+	}
+	if !it.Seek("item:9") || !it.Prev() || it.Key() != "item:2" || !it.Next() || !it.Next() || it.Key() != "item:10" {
+		t.Fatal("turning around within the namespace loses the order")
+	}
+}
+
+// TestIteratorWalksBackwards spreads keys over the memtable, SSTables and levels
+// with overwrites and deletes, reading the tables from disk, and walks them
+// backwards, seeks for the previous key and turns around midway.
+func TestIteratorWalksBackwards(t *testing.T) {
+	e := openTestEngine(t, t.TempDir(), func(c *EngineConfig) {
+		c.MemtableSizeThreshold = 32
+		c.PinLevels = 0
+		c.BlockCacheSize = 0
+	})
+	defer e.Close()
+
+	const keys = 600
+	model := map[string]string{}
+	write := func(i int, value string) {
+		key := fmt.Sprintf("key-%04d", i)
+		if value == "" {
+			if err := e.Delete(key); err != nil {
+				t.Fatal(err)
+			}
+			delete(model, key)
+			return
+		}
+		if err := e.Set(key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+		model[key] = value
+	}
+	for i := 0; i < keys; i++ {
+		write(i, fmt.Sprintf("v1-%d", i))
+	}
+	if err := e.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < keys; i += 3 {
+		write(i, fmt.Sprintf("v2-%d", i))
+	}
+	for i := 1; i < keys; i += 7 {
+		write(i, "")
+	}
+	for i := 5; i < 40; i += 5 {
+		write(i, fmt.Sprintf("v3-%d", i))
+	}
+
+	sorted := make([]string, 0, len(model))
+	for key := range model {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	it, err := e.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+
+	// only the end of the tables is read to start from the last key
+	if !it.Last() {
+		t.Fatal("Last finds no key")
+	}
+	if read := it.Stats().EntriesRead; read == 0 || read > keys/4 {
+		t.Fatalf("Last read %d entries from disk, want a few", read)
+	}
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if it.Key() != sorted[i] {
+			t.Fatalf("walking backwards reaches %q, want %q", it.Key(), sorted[i])
+		}
+		value, err := it.Value()
+		if err != nil || string(value) != model[sorted[i]] {
+			t.Fatalf("%q reads %q, %v, want %q", sorted[i], value, err, model[sorted[i]])
+		}
+		if ok := it.Prev(); ok != (i > 0) {
+			t.Fatalf("Prev after %q reports %v", sorted[i], ok)
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !it.Next() || it.Key() != sorted[0] {
+		t.Fatal("Next after walking past the first key does not come back to it")
+	}
+
+	// key-0001 is deleted, key-0015 overwritten in the memtable
+	for _, c := range []struct{ key, want string }{
+		{"key-0001", "key-0000"},
+		{"key-0015", "key-0015"},
+		{"key-0300x", "key-0300"},
+		{"zzz", sorted[len(sorted)-1]},
+	} {
+		if !it.SeekForPrev(c.key) || it.Key() != c.want {
+			t.Fatalf("SeekForPrev(%q) does not stop at %q", c.key, c.want)
+		}
+	}
+	if it.SeekForPrev("a") {
+		t.Fatalf("SeekForPrev before the first key stops at %q", it.Key())
+	}
+
+	// turning around returns the neighbours of the key
+	at := sort.SearchStrings(sorted, "key-0300")
+	if !it.Seek("key-0300") || !it.Next() || !it.Prev() || !it.Prev() || it.Key() != sorted[at-1] {
+		t.Fatalf("turning around after key-0300 stops at %q, want %q", it.Key(), sorted[at-1])
+	}
+	if !it.Next() || it.Key() != sorted[at] {
+		t.Fatalf("turning around before key-0300 stops at %q", it.Key())
+	}
+
+	// a range bounds the walk at both ends
+	ranged, err := e.NewIterator(WithRange("key-0100", "key-0200"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ranged.Close()
+	want := sorted[sort.SearchStrings(sorted, "key-0100"):sort.SearchStrings(sorted, "key-0200")]
+	got := []string{}
+	for ok := ranged.Last(); ok; ok = ranged.Prev() {
+		got = append([]string{ranged.Key()}, got...)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("the range walked backwards holds %d keys, want %d", len(got), len(want))
+	}
+}
@@ -0,0 +1,58 @@
+package goldb
+
+import "sync"
+
+// keyLocks hands out a mutex per key, creating it on first use and dropping it
+// once nobody holds or waits for it, so memory stays proportional to the keys
+// currently locked.
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	mu   sync.Mutex
+	refs int // Holders plus waiters.
+}
+
+func newKeyLocks() *keyLocks {
+	return &keyLocks{locks: map[string]*keyLock{}}
+}
+
+func (kl *keyLocks) lock(key string) func() {
+	kl.mu.Lock()
+	l, ok := kl.locks[key]
+	if !ok {
+		l = &keyLock{}
+		kl.locks[key] = l
+	}
+	l.refs++
+	kl.mu.Unlock()
+
+	l.mu.Lock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Unlock()
+
+			kl.mu.Lock()
+			l.refs--
+			if l.refs == 0 {
+				delete(kl.locks, key)
+			}
+			kl.mu.Unlock()
+		})
+	}
+}
+
+// LockKey blocks until the lock for key is acquired and returns the function
+// releasing it. The locks are advisory: they serialize callers that take them,
+// like multi-step read-modify-write sequences on the same key, but do not stop
+// plain Set or Delete calls.
+//
+//	unlock := db.LockKey("counter")
+//	defer unlock()
+func (e *Engine) LockKey(key string) (unlock func()) {
+	return e.keyLocks.lock(key)
+}
@@ -0,0 +1,251 @@
+// Package leaderboard ranks members by score in a goldb database, keeping a
+// score-ordered index next to the score of every member so the top of the board
+// and the rank of a member are read without sorting.
+//
+//	board := leaderboard.New(engine, "weekly")
+//	board.Set("alice", 1200)
+//	board.Set("bob", 950)
+//	top, err := board.Top(10)
+//	rank, err := board.Rank("bob") // 1, alice comes first
+//
+// A board is stored under its name: "<name>/m/<member>" holds the score of a
+// member and "<name>/s/<score><member>" is its entry in the index, the score
+// encoded so the entries sort by descending score, members with the same score
+// in ascending order. A score and its index entry are written together in a
+// transaction, which is retried when a concurrent update of the same member
+// conflicts with it, so the index never holds a stale or a duplicate entry.
+package leaderboard
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/hasssanezzz/goldb"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+var errNaN = errors.New("goldb: a score can not be NaN")
+
+// Entry is a member of a board with its score.
+type Entry struct {
+	Member string
+	Score  float64
+}
+
+// Board ranks members by score under a name.
+type Board struct {
+	engine *goldb.Engine
+	name   string
+}
+
+// New returns the board stored under name, empty if it was never written.
+func New(engine *goldb.Engine, name string) *Board {
+	return &Board{engine: engine, name: name}
+}
+
+func (b *Board) memberKey(member string) string {
+	return b.name + "/m/" + member
+}
+
+func (b *Board) indexPrefix() string {
+	return b.name + "/s/"
+}
+
+// indexKey returns the entry of member in the index. The bits of the score are
+// turned so that they sort like the score, then flipped for the highest scores
+// to come first.
+func (b *Board) indexKey(member string, score float64) string {
+	bits := math.Float64bits(score)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return fmt.Sprintf("%s%016x%s", b.indexPrefix(), ^bits, member)
+}
+
+// parseIndexKey returns the member and the score of an entry of the index.
+func (b *Board) parseIndexKey(key string) (Entry, error) {
+	encoded := key[len(b.indexPrefix()):]
+	if len(encoded) < 16 {
+		return Entry{}, fmt.Errorf("goldb: board %q has a malformed index entry %q", b.name, key)
+	}
+	bits, err := strconv.ParseUint(encoded[:16], 16, 64)
+	if err != nil {
+		return Entry{}, fmt.Errorf("goldb: board %q has a malformed index entry %q", b.name, key)
+	}
+	bits = ^bits
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return Entry{Member: encoded[16:], Score: math.Float64frombits(bits)}, nil
+}
+
+// update runs fn in a transaction until it commits without a conflict.
+func (b *Board) update(fn func(txn *goldb.Txn) error) error {
+	for {
+		txn := b.engine.Begin()
+		err := fn(txn)
+		if err != nil {
+			txn.Rollback()
+		} else {
+			err = txn.Commit()
+		}
+		if _, ok := err.(*goldb.ErrTxnConflict); !ok {
+			return err
+		}
+	}
+}
+
+// score reads the score of member in txn, found is false when it is not on the
+// board.
+func (b *Board) score(txn *goldb.Txn, member string) (score float64, found bool, err error) {
+	data, err := txn.Get(b.memberKey(member))
+	if err != nil {
+		if _, ok := err.(*shared.ErrKeyNotFound); ok {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	if len(data) != 8 {
+		return 0, false, fmt.Errorf("goldb: board %q has a malformed score for member %q", b.name, member)
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(data)), true, nil
+}
+
+// move writes the score of member and its index entry in txn, in place of the
+// entry of its previous score when it was found on the board.
+func (b *Board) move(txn *goldb.Txn, member string, previous float64, found bool, score float64) error {
+	if found {
+		if err := txn.Delete(b.indexKey(member, previous)); err != nil {
+			return err
+		}
+	}
+	if err := txn.Set(b.memberKey(member), binary.BigEndian.AppendUint64(nil, math.Float64bits(score))); err != nil {
+		return err
+	}
+	return txn.Set(b.indexKey(member, score), []byte{1})
+}
+
+// Set sets the score of member, adding it to the board if needed.
+func (b *Board) Set(member string, score float64) error {
+	if math.IsNaN(score) {
+		return errNaN
+	}
+	return b.update(func(txn *goldb.Txn) error {
+		previous, found, err := b.score(txn, member)
+		if err != nil {
+			return err
+		}
+		return b.move(txn, member, previous, found, score)
+	})
+}
+
+// Add adds delta to the score of member, a member not on the board starting
+// from zero, and returns the new score.
+func (b *Board) Add(member string, delta float64) (float64, error) {
+	score := 0.0
+	err := b.update(func(txn *goldb.Txn) error {
+		previous, found, err := b.score(txn, member)
+		if err != nil {
+			return err
+		}
+		if score = previous + delta; math.IsNaN(score) {
+			return errNaN
+		}
+		return b.move(txn, member, previous, found, score)
+	})
+	return score, err
+}
+
+// Remove takes member off the board, a member not on it is ignored.
+func (b *Board) Remove(member string) error {
+	return b.update(func(txn *goldb.Txn) error {
+		previous, found, err := b.score(txn, member)
+		if err != nil || !found {
+			return err
+		}
+		if err := txn.Delete(b.indexKey(member, previous)); err != nil {
+			return err
+		}
+		return txn.Delete(b.memberKey(member))
+	})
+}
+
+// Score returns the score of member, or ErrKeyNotFound when it is not on the
+// board.
+func (b *Board) Score(member string) (float64, error) {
+	data, err := b.engine.Get(b.memberKey(member))
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 8 {
+		return 0, fmt.Errorf("goldb: board %q has a malformed score for member %q", b.name, member)
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+}
+
+// Top returns the n members with the highest scores, highest first.
+func (b *Board) Top(n int) ([]Entry, error) {
+	entries := []Entry{}
+	if n <= 0 {
+		return entries, nil
+	}
+	err := b.walk(shared.PrefixEnd(b.indexPrefix()), func(key string) (bool, error) {
+		entry, err := b.parseIndexKey(key)
+		if err != nil {
+			return false, err
+		}
+		entries = append(entries, entry)
+		return len(entries) < n, nil
+	})
+	return entries, err
+}
+
+// Rank returns the position of member on the board, 0 for the highest score,
+// or ErrKeyNotFound when it is not on the board. The members ranked before it
+// are counted, which walks their index entries.
+func (b *Board) Rank(member string) (int, error) {
+	score, err := b.Score(member)
+	if err != nil {
+		return 0, err
+	}
+	rank := 0
+	err = b.walk(b.indexKey(member, score), func(string) (bool, error) {
+		rank++
+		return true, nil
+	})
+	return rank, err
+}
+
+// Len returns the number of members on the board.
+func (b *Board) Len() (int, error) {
+	n := 0
+	err := b.walk(shared.PrefixEnd(b.indexPrefix()), func(string) (bool, error) {
+		n++
+		return true, nil
+	})
+	return n, err
+}
+
+// walk calls fn with the index entries before end, highest score first, until
+// it returns false or an error. Only the keys of the index are read.
+func (b *Board) walk(end string, fn func(key string) (bool, error)) error {
+	it, err := b.engine.NewIterator(goldb.WithRange(b.indexPrefix(), end), goldb.KeysOnly())
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for it.Next() {
+		more, err := fn(it.Key())
+		if err != nil || !more {
+			return err
+		}
+	}
+	return it.Err()
+}
@@ -0,0 +1,100 @@
+package goldb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+	"github.com/hasssanezzz/goldb/internal/shared"
+	"github.com/hasssanezzz/goldb/internal/wal"
+)
+
+// Link makes dstKey refer to the value of srcKey without copying it, replacing
+// the value dstKey may have. Both keys share the value record until one of them
+// is written again, which stores a new value for that key only. The record is
+// kept as long as a key refers to it, see ValueRefs.
+func (e *Engine) Link(srcKey, dstKey string) error {
+	for _, key := range []string{srcKey, dstKey} {
+		if len([]byte(key)) > int(e.Config.KeySize) {
+			return &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
+		}
+		if err := checkUserKey(key); err != nil {
+			return err
+		}
+	}
+	if srcKey == dstKey {
+		_, err := e.indexManager.Get(srcKey)
+		return err
+	}
+
+	for {
+		err := e.link(srcKey, dstKey)
+		if err != errKeyRaced {
+			return err
+		}
+	}
+}
+
+func (e *Engine) link(srcKey, dstKey string) error {
+	indexNode, err := e.indexManager.Get(srcKey)
+	if err != nil {
+		return err
+	}
+	// the link is only logged, the value it points at must outlive a crash
+	if err := e.syncValues(); err != nil {
+		return err
+	}
+
+	req := writeRequest{
+		entry: linkEntry(dstKey, time.Now().UnixNano(), indexNode),
+		done:  make(chan error, 1),
+	}
+
+	// the value linked must still be the one of srcKey when the batch commits
+	validate := func() error {
+		current, found, err := e.indexManager.Lookup(srcKey)
+		if err != nil {
+			return fmt.Errorf("db engine can not locate key (%q): %v", srcKey, err)
+		}
+		if !found || !current.Equal(indexNode) {
+			return errKeyRaced
+		}
+		return nil
+	}
+	e.pipeline.submitBatch(&commitBatch{requests: []writeRequest{req}, validate: validate})
+	return <-req.done
+}
+
+// linkEntry returns the WAL entry pointing key at the value of indexNode. A value
+// kept inline in the tables has no record to share, it is copied. The key expires
+// along with the one it shares the value of.
+func linkEntry(key string, timestamp int64, indexNode memtable.IndexNode) wal.WALEntry {
+	if indexNode.Inline != nil {
+		return wal.WALEntry{Key: key, Value: indexNode.Inline, Timestamp: timestamp, ExpiresAt: indexNode.ExpiresAt}
+	}
+	return wal.WALEntry{Key: key, Timestamp: timestamp, Link: &indexNode}
+}
+
+// ValueRefs returns the number of keys referring to the value record of key,
+// one unless the value is shared through Link, CopyRange or DedupValues.
+// Deleted keys that can still be restored count as well. The whole index is
+// walked. Values kept inline in the tables are never shared.
+func (e *Engine) ValueRefs(key string) (int, error) {
+	if err := checkUserKey(key); err != nil {
+		return 0, err
+	}
+	indexNode, err := e.indexManager.Get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if indexNode.Inline != nil {
+		return 1, nil
+	}
+
+	refs, err := e.indexManager.ValueRefs()
+	if err != nil {
+		return 0, err
+	}
+	return refs[indexNode.Offset], nil
+}
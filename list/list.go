@@ -0,0 +1,254 @@
+// Package list keeps ordered lists of values in a goldb database, the way a
+// deque or a queue is used: values are pushed and popped at both ends and read
+// by position.
+//
+//	jobs := list.New(engine, "jobs")
+//	jobs.PushBack([]byte("resize:42"))
+//	job, err := jobs.PopFront()
+//	recent, err := jobs.Range(-10, 0)
+//
+// A list is stored under its name: "<name>/meta" holds the positions of its
+// ends and every value is a key of its own, "<name>/i/<position>", positions
+// being encoded so the keys sort in list order. Every operation runs in a
+// transaction moving the ends along with the values, so concurrent pushes and
+// pops neither lose nor duplicate values; conflicting operations are retried.
+package list
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/hasssanezzz/goldb"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// ErrEmpty is returned when popping from an empty list.
+var ErrEmpty = errors.New("goldb: the list is empty")
+
+var errEmptyValue = errors.New("goldb: a list can not hold empty values")
+
+// List is an ordered list of values stored under a name.
+type List struct {
+	engine *goldb.Engine
+	name   string
+}
+
+// New returns the list stored under name, empty if it was never written.
+func New(engine *goldb.Engine, name string) *List {
+	return &List{engine: engine, name: name}
+}
+
+// ends are the positions of the first value of a list and past its last one.
+type ends struct {
+	head int64
+	tail int64
+}
+
+func (l *List) metaKey() string {
+	return l.name + "/meta"
+}
+
+// itemKey returns the key of the value at position. The sign bit is flipped so
+// the positions before zero, taken by PushFront, sort first.
+func (l *List) itemKey(position int64) string {
+	return fmt.Sprintf("%s/i/%016x", l.name, uint64(position)^(1<<63))
+}
+
+// update runs fn in a transaction until it commits without a conflict.
+func (l *List) update(fn func(txn *goldb.Txn, e *ends) error) error {
+	for {
+		txn := l.engine.Begin()
+		e, err := l.readEnds(txn)
+		if err == nil {
+			err = fn(txn, &e)
+		}
+		if err == nil {
+			err = writeEnds(txn, l.metaKey(), e)
+		}
+		if err != nil {
+			txn.Rollback()
+		} else {
+			err = txn.Commit()
+		}
+		if _, ok := err.(*goldb.ErrTxnConflict); !ok {
+			return err
+		}
+	}
+}
+
+// view runs fn in a transaction that writes nothing, until it reads without a
+// conflict.
+func (l *List) view(fn func(txn *goldb.Txn, e ends) error) error {
+	for {
+		txn := l.engine.Begin()
+		e, err := l.readEnds(txn)
+		if err == nil {
+			err = fn(txn, e)
+		}
+		txn.Rollback()
+		if _, ok := err.(*goldb.ErrTxnConflict); !ok {
+			return err
+		}
+	}
+}
+
+func (l *List) readEnds(txn *goldb.Txn) (ends, error) {
+	data, err := txn.Get(l.metaKey())
+	if err != nil {
+		if _, ok := err.(*shared.ErrKeyNotFound); ok {
+			return ends{}, nil
+		}
+		return ends{}, err
+	}
+	if len(data) != 16 {
+		return ends{}, fmt.Errorf("goldb: list %q has a malformed meta key of %d bytes", l.name, len(data))
+	}
+	return ends{head: int64(binary.BigEndian.Uint64(data)), tail: int64(binary.BigEndian.Uint64(data[8:]))}, nil
+}
+
+func writeEnds(txn *goldb.Txn, key string, e ends) error {
+	// an empty list is not stored at all
+	if e.head == e.tail {
+		return txn.Delete(key)
+	}
+	data := binary.BigEndian.AppendUint64(nil, uint64(e.head))
+	return txn.Set(key, binary.BigEndian.AppendUint64(data, uint64(e.tail)))
+}
+
+// PushBack adds values at the end of the list, in order, and returns the length
+// of the list.
+func (l *List) PushBack(values ...[]byte) (int, error) {
+	length := 0
+	err := l.update(func(txn *goldb.Txn, e *ends) error {
+		for _, value := range values {
+			if len(value) == 0 {
+				return errEmptyValue
+			}
+			if err := txn.Set(l.itemKey(e.tail), value); err != nil {
+				return err
+			}
+			e.tail++
+		}
+		length = int(e.tail - e.head)
+		return nil
+	})
+	return length, err
+}
+
+// PushFront adds values at the start of the list, one after the other, so the
+// last one ends up first, and returns the length of the list.
+func (l *List) PushFront(values ...[]byte) (int, error) {
+	length := 0
+	err := l.update(func(txn *goldb.Txn, e *ends) error {
+		for _, value := range values {
+			if len(value) == 0 {
+				return errEmptyValue
+			}
+			e.head--
+			if err := txn.Set(l.itemKey(e.head), value); err != nil {
+				return err
+			}
+		}
+		length = int(e.tail - e.head)
+		return nil
+	})
+	return length, err
+}
+
+// PopFront removes the first value of the list and returns it, or ErrEmpty.
+func (l *List) PopFront() ([]byte, error) {
+	return l.pop(func(e *ends) int64 {
+		e.head++
+		return e.head - 1
+	})
+}
+
+// PopBack removes the last value of the list and returns it, or ErrEmpty.
+func (l *List) PopBack() ([]byte, error) {
+	return l.pop(func(e *ends) int64 {
+		e.tail--
+		return e.tail
+	})
+}
+
+// pop removes the value at the position taken off the ends by take.
+func (l *List) pop(take func(e *ends) int64) ([]byte, error) {
+	var value []byte
+	err := l.update(func(txn *goldb.Txn, e *ends) error {
+		if e.head == e.tail {
+			return ErrEmpty
+		}
+		key := l.itemKey(take(e))
+		var err error
+		if value, err = txn.Get(key); err != nil {
+			return err
+		}
+		return txn.Delete(key)
+	})
+	return value, err
+}
+
+// Len returns the number of values in the list.
+func (l *List) Len() (int, error) {
+	length := 0
+	err := l.view(func(txn *goldb.Txn, e ends) error {
+		length = int(e.tail - e.head)
+		return nil
+	})
+	return length, err
+}
+
+// Index returns the value at index, counted from the end when negative: -1 is
+// the last value. Returns ErrKeyNotFound when the list is shorter.
+func (l *List) Index(index int) ([]byte, error) {
+	var value []byte
+	err := l.view(func(txn *goldb.Txn, e ends) error {
+		start, stop := bounds(e, index, index+1)
+		if index == -1 {
+			stop = e.tail
+		}
+		if start >= stop {
+			return &shared.ErrKeyNotFound{Key: fmt.Sprintf("%s[%d]", l.name, index)}
+		}
+		var err error
+		value, err = txn.Get(l.itemKey(start))
+		return err
+	})
+	return value, err
+}
+
+// Range returns the values from index start up to index stop excluded, both
+// counted from the end when negative and a stop of zero or past the end
+// meaning the end: Range(0, 0) returns the whole list and Range(-10, 0) its
+// last ten values. The values are read as of a single point in time.
+func (l *List) Range(start, stop int) ([][]byte, error) {
+	var values [][]byte
+	err := l.view(func(txn *goldb.Txn, e ends) error {
+		from, to := bounds(e, start, stop)
+		if stop == 0 {
+			to = e.tail
+		}
+		values = [][]byte{}
+		for position := from; position < to; position++ {
+			value, err := txn.Get(l.itemKey(position))
+			if err != nil {
+				return err
+			}
+			values = append(values, value)
+		}
+		return nil
+	})
+	return values, err
+}
+
+// bounds turns the indexes start and stop into positions clamped to the list.
+func bounds(e ends, start, stop int) (int64, int64) {
+	position := func(index int) int64 {
+		if index < 0 {
+			return max(e.head, e.tail+int64(index))
+		}
+		return min(e.tail, e.head+int64(index))
+	}
+	return position(start), position(stop)
+}
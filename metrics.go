@@ -0,0 +1,68 @@
+package goldb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+const defaultMetricsInterval = 10 * time.Second
+
+// metricsPusher pushes what the counters of Stats grew by since the last push,
+// every interval and once more when the database is closed.
+type metricsPusher struct {
+	engine   *Engine
+	reporter shared.MetricsReporter
+	last     EngineStats // Stats at the last push.
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func newMetricsPusher(e *Engine, reporter shared.MetricsReporter, interval time.Duration) *metricsPusher {
+	if interval <= 0 {
+		interval = defaultMetricsInterval
+	}
+	m := &metricsPusher{engine: e, reporter: reporter, last: e.Stats(), stop: make(chan struct{})}
+	m.wg.Add(1)
+	go labeled("metrics", func() { m.run(interval) })
+	return m
+}
+
+func (m *metricsPusher) run(interval time.Duration) {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.push()
+		case <-m.stop:
+			m.push()
+			return
+		}
+	}
+}
+
+func (m *metricsPusher) push() {
+	stats := m.engine.Stats()
+	m.count("keys_written", stats.KeysWritten, m.last.KeysWritten)
+	m.count("bytes_written", stats.BytesWritten, m.last.BytesWritten)
+	m.count("flushes", stats.Flushes, m.last.Flushes)
+	m.count("compactions", stats.Compactions, m.last.Compactions)
+	m.count("reclaimed_bytes", stats.ReclaimedBytes, m.last.ReclaimedBytes)
+	m.last = stats
+}
+
+// count reports the growth of a counter, counters that did not move are left out.
+func (m *metricsPusher) count(name string, value, last uint64) {
+	if value > last {
+		m.reporter.Count(name, int64(value-last), nil)
+	}
+}
+
+func (m *metricsPusher) close() {
+	close(m.stop)
+	m.wg.Wait()
+}
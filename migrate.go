@@ -0,0 +1,88 @@
+package goldb
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/hasssanezzz/goldb/internal/index_manager"
+	"github.com/hasssanezzz/goldb/internal/shared"
+	"github.com/hasssanezzz/goldb/internal/wal"
+)
+
+// legacyWALFile is where the WAL of a database of format version 0 is set aside
+// until its writes are replayed, see migrateFormat.
+const legacyWALFile = "wal.log.v0"
+
+// migrateFormat brings a database of format version 0, written before the
+// tables and the WAL kept the write times, to the current layout before its
+// files are opened: the tables are rewritten in the current format and the WAL
+// is set aside for replayLegacyWAL to write its writes again once the database
+// is open. Every step is atomic and a crash midway leaves the rest to the next
+// open. A database in the current format is left untouched.
+func migrateFormat(config *shared.EngineConfig) error {
+	tables, err := index_manager.UpgradeLegacyTables(config)
+	if err != nil {
+		return fmt.Errorf("db engine can not migrate format version 0: %v", err)
+	}
+
+	path := filepath.Join(config.Homepath, "wal.log.bin")
+	entries, legacy, err := wal.ReadLegacy(path, config.KeySize)
+	if err != nil {
+		return fmt.Errorf("db engine can not migrate format version 0: %v", err)
+	}
+	if legacy {
+		if err := os.Rename(path, filepath.Join(config.Homepath, legacyWALFile)); err != nil {
+			return fmt.Errorf("db engine can not migrate format version 0: %v", err)
+		}
+		if err := syncPath(config.Homepath); err != nil {
+			return fmt.Errorf("db engine can not migrate format version 0: %v", err)
+		}
+	}
+	if tables > 0 || legacy {
+		log.Printf("db engine: migrating format version 0, %d tables rewritten, %d writes of the WAL to replay\n", tables, len(entries))
+	}
+	return nil
+}
+
+// replayLegacyWAL writes the writes of the WAL set aside by migrateFormat again,
+// in batches, then removes it. They take the time of the replay as their write
+// time, the log did not record theirs.
+func (e *Engine) replayLegacyWAL() error {
+	path := filepath.Join(e.Config.Homepath, legacyWALFile)
+	entries, legacy, err := wal.ReadLegacy(path, e.Config.KeySize)
+	if err != nil {
+		return fmt.Errorf("db engine can not migrate format version 0: %v", err)
+	}
+	if !legacy {
+		// an empty log holds nothing to replay
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("db engine can not migrate format version 0: %v", err)
+		}
+		return nil
+	}
+
+	batch := e.NewBatch()
+	for _, entry := range entries {
+		if !batch.fits(entry.Key, entry.Value) {
+			if err := batch.Commit(); err != nil {
+				return fmt.Errorf("db engine can not migrate format version 0: %v", err)
+			}
+			batch = e.NewBatch()
+		}
+		if len(entry.Value) > 0 {
+			batch.Set(entry.Key, entry.Value)
+		} else {
+			batch.Delete(entry.Key)
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("db engine can not migrate format version 0: %v", err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("db engine can not migrate format version 0: %v", err)
+	}
+	return syncPath(e.Config.Homepath)
+}
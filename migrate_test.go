@@ -0,0 +1,104 @@
+package goldb
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// writeLegacyDir lays out a database of format version 0 at dir: a table of
+// pairs without write times and an unframed WAL of records without them.
+func writeLegacyDir(t *testing.T, dir string, keySize uint32) {
+	t.Helper()
+	key := func(k string) []byte {
+		padded, err := shared.KeyToBytes(k, keySize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return padded
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "data.bin"), []byte("alpha-valuebeta-valuegamma"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pairs := []struct {
+		key          string
+		offset, size uint32
+	}{{"alpha", 0, 11}, {"beta", 11, 10}, {"delta", 0, 0}, {"gamma", 21, 5}}
+	table := []byte{0x00}
+	table = binary.LittleEndian.AppendUint32(table, 1)
+	table = binary.LittleEndian.AppendUint32(table, uint32(len(pairs)))
+	table = append(table, key("alpha")...)
+	table = append(table, key("gamma")...)
+	for _, pair := range pairs {
+		table = append(table, key(pair.key)...)
+		table = binary.LittleEndian.AppendUint32(table, pair.offset)
+		table = binary.LittleEndian.AppendUint32(table, pair.size)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sst_1"), table, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	log := []byte{}
+	for _, write := range []struct{ key, value string }{{"beta", "beta-old"}, {"alpha", ""}, {"epsilon", "eps"}, {"beta", "beta-new"}} {
+		log = append(log, key(write.key)...)
+		log = binary.LittleEndian.AppendUint32(log, uint32(len(write.value)))
+		log = append(log, write.value...)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "wal.log.bin"), log, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMigrateFormatVersion0 opens a database of format version 0 and checks the
+// readers opening it as it is refuse it, while New migrates its tables and
+// replays its WAL.
+func TestMigrateFormatVersion0(t *testing.T) {
+	dir := t.TempDir()
+	writeLegacyDir(t, dir, shared.DefaultConfig.KeySize)
+
+	if s, err := OpenSnapshot(dir); err == nil {
+		s.Close()
+		t.Fatal("OpenSnapshot reads a database of format version 0")
+	} else if !strings.Contains(err.Error(), "format version 0") {
+		t.Fatalf("OpenSnapshot fails with %v", err)
+	}
+
+	want := map[string]string{"alpha": "", "beta": "beta-new", "delta": "", "gamma": "gamma", "epsilon": "eps"}
+	check := func(e *Engine) {
+		t.Helper()
+		for key, value := range want {
+			got, err := e.Get(key)
+			if value == "" {
+				if _, ok := err.(*shared.ErrKeyNotFound); !ok {
+					t.Fatalf("%q reads %q, %v, want it missing", key, got, err)
+				}
+				continue
+			}
+			if err != nil || string(got) != value {
+				t.Fatalf("%q reads %q, %v, want %q", key, got, err, value)
+			}
+		}
+	}
+
+	e := openTestEngine(t, dir)
+	check(e)
+	if _, err := os.Stat(filepath.Join(dir, legacyWALFile)); !os.IsNotExist(err) {
+		t.Fatalf("the WAL of format version 0 is left over: %v", err)
+	}
+	version, err := e.getSystem("format")
+	if err != nil || string(version) != strconv.Itoa(formatVersion) {
+		t.Fatalf("the migrated database records format version %q, %v", version, err)
+	}
+	e.Close()
+
+	e = openTestEngine(t, dir)
+	defer e.Close()
+	check(e)
+}
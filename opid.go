@@ -0,0 +1,53 @@
+package goldb
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync/atomic"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// OpError is the failure of an operation along with the ID the engine logged it
+// under, so a failure reported by a user can be found in the engine log. It
+// wraps the error of the operation, errors.As reaches it.
+type OpError struct {
+	ID  string // Operation ID, like "6f1c09a2-17".
+	Op  string // "get", "set" or "delete".
+	Key string
+	Err error
+}
+
+func (e *OpError) Error() string {
+	return fmt.Sprintf("%v (op %s)", e.Err, e.ID)
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// opIDs hands out the operation IDs: the run of the process, random, then a
+// counter. Only the failed and slow operations get one.
+type opIDs struct {
+	run  uint32
+	next atomic.Uint64
+}
+
+func newOpIDs() *opIDs {
+	return &opIDs{run: rand.Uint32()}
+}
+
+func (ids *opIDs) new() string {
+	return fmt.Sprintf("%08x-%d", ids.run, ids.next.Add(1))
+}
+
+// failed reports whether err is a failure of the engine, rather than an answer
+// like a missing key or a key the caller should not have passed, which are
+// returned as they are.
+func failed(err error) bool {
+	switch err.(type) {
+	case nil, *shared.ErrKeyNotFound, *shared.ErrKeyTooLong, *ErrReservedKey:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+package goldb
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Options that can be changed with SetOption while the database is running.
+const (
+	OptionCompactionWorkers   = "compaction_workers"     // Number of tables a compaction reads in parallel.
+	OptionCompactionRateLimit = "compaction_rate_limit"  // Bytes per second a compaction may write, 0 is unlimited.
+	OptionLowPriorityReadRate = "low_priority_read_rate" // Bytes per second low priority reads may read, 0 is unlimited.
+)
+
+// SetOption changes a runtime option without reopening the database, for example
+// to slow compactions down during traffic peaks. The value is parsed from its
+// string form so the option can be driven from an admin endpoint.
+func (e *Engine) SetOption(name, value string) error {
+	switch name {
+	case OptionCompactionWorkers:
+		workers, err := strconv.Atoi(value)
+		if err != nil || workers <= 0 {
+			return fmt.Errorf("db engine can not set option %s: %q is not a positive integer", name, value)
+		}
+		e.indexManager.SetCompactionWorkers(workers)
+	case OptionCompactionRateLimit:
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 0 {
+			return fmt.Errorf("db engine can not set option %s: %q is not a non-negative integer", name, value)
+		}
+		e.indexManager.SetCompactionRateLimit(limit)
+	case OptionLowPriorityReadRate:
+		rate, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || rate < 0 {
+			return fmt.Errorf("db engine can not set option %s: %q is not a non-negative integer", name, value)
+		}
+		e.reads.rate.Store(rate)
+	default:
+		return fmt.Errorf("db engine has no option %q", name)
+	}
+	return nil
+}
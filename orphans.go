@@ -0,0 +1,46 @@
+package goldb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hasssanezzz/goldb/internal/index_manager"
+)
+
+// OrphanFile is a file in the homepath the engine does not use, see OrphanFiles.
+type OrphanFile = index_manager.OrphanFile
+
+// OrphanFiles reports the files in the homepath that are not part of the
+// database: temporary files left by interrupted writes, table files that could
+// not be read when the database was opened, and unknown files.
+func (e *Engine) OrphanFiles() ([]OrphanFile, error) {
+	// flushes and WAL rewrites write temporary files while holding the lock
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+	return e.indexManager.OrphanFiles()
+}
+
+// RemoveOrphanFiles deletes the orphan files left behind by the engine and
+// returns them. Unknown files are never touched.
+func (e *Engine) RemoveOrphanFiles() ([]OrphanFile, error) {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	orphans, err := e.indexManager.OrphanFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []OrphanFile{}
+	for _, orphan := range orphans {
+		if !orphan.Removable {
+			continue
+		}
+		if err := os.Remove(filepath.Join(e.Config.Homepath, orphan.Name)); err != nil {
+			return removed, fmt.Errorf("db engine can not remove orphan file %q: %v", orphan.Name, err)
+		}
+		removed = append(removed, orphan)
+	}
+	return removed, nil
+}
@@ -0,0 +1,59 @@
+package goldb
+
+import (
+	"sync"
+)
+
+// ParallelScan splits the keyspace into at most shards ranges using split keys
+// sampled from the SSTables and calls fn concurrently with an iterator over each range.
+// Every range is walked in key order and the ranges do not overlap, so each key
+// is seen exactly once. The first error returned by fn is returned once every
+// callback finished.
+func (e *Engine) ParallelScan(shards int, fn func(*Iterator) error) error {
+	splits, err := e.indexManager.SplitKeys(shards)
+	if err != nil {
+		return err
+	}
+	ranges := keyRanges(splits)
+
+	// the iterators are built up front, only the callbacks run concurrently
+	iterators := make([]*Iterator, len(ranges))
+	for i, r := range ranges {
+		it, err := e.NewIterator(WithRange(r[0], r[1]))
+		if err != nil {
+			return err
+		}
+		iterators[i] = it
+	}
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for _, it := range iterators {
+		wg.Add(1)
+		go func(it *Iterator) {
+			defer wg.Done()
+			defer it.Close()
+			if err := fn(it); err != nil {
+				once.Do(func() { firstErr = err })
+			}
+		}(it)
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// keyRanges returns the [start, end) ranges between the ascending split keys,
+// the last one unbounded.
+func keyRanges(splits []string) [][2]string {
+	ranges := [][2]string{}
+	start := ""
+	for _, split := range splits {
+		ranges = append(ranges, [2]string{start, split})
+		start = split
+	}
+	return append(ranges, [2]string{start, ""})
+}
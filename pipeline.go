@@ -0,0 +1,423 @@
+package goldb
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+	"github.com/hasssanezzz/goldb/internal/syncpoint"
+	"github.com/hasssanezzz/goldb/internal/wal"
+)
+
+// commitBatch is a group of writes committed together.
+type commitBatch struct {
+	requests []writeRequest
+	sequence uint64       // Sequence number of the last write in the batch.
+	txnID    string       // Id of the prepared batch being committed, its entries are already logged.
+	validate func() error // Decides whether the batch may commit, once every earlier write is applied.
+	run      func() error // Runs in place of a write, once every earlier write is applied.
+	done     chan error   // Receives the outcome of run.
+}
+
+// pipeline splits committing writes into two stages running on their own
+// goroutines: the WAL stage appends a batch to the log and hands it over to the
+// memtable stage, which inserts it and publishes its sequence number. While one
+// batch is being inserted into the memtable the next one is already being
+// written to the WAL.
+//
+// The WAL stage commits writes in groups: the writes that queued up while the
+// previous append was going on are appended together, with a single write and
+// a single sync. Unlike coalescing no write waits for company, the groups grow
+// with the load.
+type pipeline struct {
+	engine   *Engine
+	logCh    chan *commitBatch
+	applyCh  chan *commitBatch
+	stop     chan struct{}
+	inflight sync.WaitGroup // Batches logged but not applied yet.
+	wg       sync.WaitGroup
+
+	sequenceMu sync.Mutex
+	sequence   uint64 // Last sequence number handed out, see allocate.
+
+	relaxedMu sync.Mutex
+	relaxed   []wal.WALEntry // Relaxed writes applied but not logged yet, in the order of the memtable.
+	relaxedCh chan struct{}  // Wakes the WAL stage up to log the relaxed writes.
+}
+
+func newPipeline(e *Engine) *pipeline {
+	p := &pipeline{
+		engine:    e,
+		logCh:     make(chan *commitBatch),
+		applyCh:   make(chan *commitBatch, 16),
+		stop:      make(chan struct{}),
+		sequence:  e.sequence.Load(),
+		relaxedCh: make(chan struct{}, 1),
+	}
+	p.wg.Add(2)
+	go labeled("wal-stage", p.logStage)
+	go labeled("apply-stage", p.applyStage)
+	return p
+}
+
+// commit sends a single write through the pipeline, grouping it with others when
+// coalescing is enabled, and waits for it to be visible to readers.
+// An empty value is a delete, the same way the WAL records it.
+func (e *Engine) commit(key string, value []byte) error {
+	return e.commitEntry(wal.WALEntry{Key: key, Value: value, Timestamp: time.Now().UnixNano()})
+}
+
+// commitEntry is commit for an entry carrying more than a value, like an expiry.
+func (e *Engine) commitEntry(entry wal.WALEntry) error {
+	if len([]byte(entry.Key)) > int(e.Config.KeySize) {
+		return &shared.ErrKeyTooLong{Key: entry.Key, KeySize: e.Config.KeySize}
+	}
+
+	req := writeRequest{
+		entry: entry,
+		done:  make(chan error, 1),
+	}
+
+	if e.Config.RelaxedWrites {
+		return e.pipeline.commitRelaxed(req)
+	}
+
+	if e.coalescer != nil {
+		e.coalescer.submit(req)
+	} else {
+		e.pipeline.submit([]writeRequest{req})
+	}
+	return <-req.done
+}
+
+// submit queues a batch of requests for the WAL stage.
+func (p *pipeline) submit(requests []writeRequest) {
+	p.submitBatch(&commitBatch{requests: requests})
+}
+
+func (p *pipeline) submitBatch(batch *commitBatch) {
+	select {
+	case p.logCh <- batch:
+	case <-p.stop:
+		for _, req := range batch.requests {
+			req.done <- errEngineClosed
+		}
+	}
+}
+
+// commitRelaxed applies a write to the memtable right away and queues it for the
+// WAL stage without waiting for the append to happen. It never waits for the
+// WAL stage, which may itself be waiting for writeMu; the memtable is flushed by
+// the WAL stage once it logged the queued writes.
+//
+// A skiplist memtable takes concurrent inserts, relaxed writes to it only share
+// writeMu with each other: they are numbered one after the other but applied
+// side by side, the skiplist and the WAL replay keeping the highest numbered
+// write of a key. The other memtables take them one at a time.
+func (p *pipeline) commitRelaxed(req writeRequest) error {
+	e := p.engine
+	lock, unlock := e.writeMu.Lock, e.writeMu.Unlock
+	if e.Config.MemtableKind == shared.MemtableSkiplist {
+		lock, unlock = e.writeMu.RLock, e.writeMu.RUnlock
+	}
+	lock()
+	defer unlock()
+	select {
+	case <-p.stop:
+		return errEngineClosed
+	default:
+	}
+
+	req.entry.Sequence = p.allocate(1)
+	entry := req.entry
+	e.txns.record(entry.Key, entry.Sequence)
+	e.clock.record(entry.Sequence, entry.Timestamp)
+
+	e.counters.countWrite(entry)
+	if err := e.apply(entry); err != nil {
+		return err
+	}
+	e.publish(entry.Sequence)
+
+	// queued before writeMu is released, so a flush or an exclusive operation
+	// finds every applied write queued
+	p.relaxedMu.Lock()
+	p.relaxed = append(p.relaxed, entry)
+	p.relaxedMu.Unlock()
+
+	select {
+	case p.relaxedCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (p *pipeline) logStage() {
+	defer p.wg.Done()
+	defer close(p.applyCh)
+
+	for {
+		select {
+		case batch := <-p.logCh:
+			group, next := p.group(batch)
+			p.log(group)
+			if next != nil {
+				p.log(next)
+			}
+		case <-p.relaxedCh:
+			p.logRelaxed()
+			p.flushIfFull()
+		case <-p.stop:
+			p.logRelaxed()
+			return
+		}
+	}
+}
+
+// grouped reports whether a batch may be appended along with others.
+func (b *commitBatch) grouped() bool {
+	return b.validate == nil && b.run == nil && b.txnID == ""
+}
+
+// group merges into batch the plain batches already waiting for the WAL stage.
+// A waiting batch that can not join the group is returned to be logged after it.
+func (p *pipeline) group(batch *commitBatch) (*commitBatch, *commitBatch) {
+	if !batch.grouped() {
+		return batch, nil
+	}
+
+	group := batch
+	for len(group.requests) < maxCoalescedWrites {
+		select {
+		case next := <-p.logCh:
+			if !next.grouped() {
+				return group, next
+			}
+			if group == batch {
+				group = &commitBatch{requests: append([]writeRequest{}, batch.requests...)}
+			}
+			group.requests = append(group.requests, next.requests...)
+		default:
+			return group, nil
+		}
+	}
+	return group, nil
+}
+
+func (p *pipeline) log(batch *commitBatch) {
+	e := p.engine
+
+	// the relaxed writes applied before the batch go first in the WAL
+	p.logRelaxed()
+	if batch.validate != nil {
+		p.logValidated(batch)
+		return
+	}
+	if batch.run != nil {
+		p.logExclusive(batch)
+		return
+	}
+
+	p.flushIfFull()
+
+	entries := p.number(batch)
+
+	var err error
+	if batch.txnID != "" {
+		err = e.wal.LogDecision(batch.txnID, true, entries[0].Sequence)
+	} else {
+		err = e.wal.LogBatch(entries)
+	}
+	if err != nil {
+		for _, req := range batch.requests {
+			req.done <- err
+		}
+		return
+	}
+	e.Config.ReachCrashPoint(shared.CrashAfterWALAppend)
+	syncpoint.Reach(syncpoint.WALAppended)
+
+	p.inflight.Add(1)
+	p.applyCh <- batch
+}
+
+// number stamps the entries of a batch with sequence numbers in a row, and
+// returns them. The requests are copied first, the submitters still read theirs.
+func (p *pipeline) number(batch *commitBatch) []wal.WALEntry {
+	first := p.allocate(len(batch.requests))
+	batch.requests = append([]writeRequest{}, batch.requests...)
+	entries := make([]wal.WALEntry, len(batch.requests))
+	for i := range batch.requests {
+		batch.requests[i].entry.Sequence = first + uint64(i)
+		entries[i] = batch.requests[i].entry
+	}
+	batch.sequence = first + uint64(len(batch.requests)) - 1
+	return entries
+}
+
+// allocate hands out n sequence numbers following the last one and returns the
+// first of them. Every write is numbered here, the batches of the WAL stage as
+// well as the relaxed writes numbered as they are applied, so no number is
+// handed out twice. The numbers of a write that fails are skipped.
+func (p *pipeline) allocate(n int) uint64 {
+	p.sequenceMu.Lock()
+	defer p.sequenceMu.Unlock()
+	first := p.sequence + 1
+	p.sequence += uint64(n)
+	return first
+}
+
+// publish makes the writes up to seq visible to readers. Relaxed writes are
+// applied side by side with the batches, a write numbered before the latest
+// published one never moves the sequence number back.
+func (e *Engine) publish(seq uint64) {
+	for {
+		current := e.sequence.Load()
+		if seq <= current || e.sequence.CompareAndSwap(current, seq) {
+			return
+		}
+	}
+}
+
+// flushIfFull freezes the memtable once it hits its threshold. Flushing drops
+// WAL segments, so every logged batch has to reach the memtable first; the
+// relaxed writes not logged yet are in the memtable already and logged to the
+// new segment. Runs on the WAL stage.
+func (p *pipeline) flushIfFull() {
+	e := p.engine
+	e.writeMu.Lock()
+	full := e.indexManager.MemtableSize() >= e.Config.MemtableSizeThreshold
+	e.writeMu.Unlock()
+	if full {
+		p.inflight.Wait()
+		e.writeMu.Lock()
+		e.flushIfFull()
+		e.writeMu.Unlock()
+	}
+}
+
+// logRelaxed appends the relaxed writes queued so far, which are already in the
+// memtable. Nobody waits for the outcome, so failures can only be logged. Runs on
+// the WAL stage, or once it stopped.
+func (p *pipeline) logRelaxed() {
+	p.relaxedMu.Lock()
+	entries := p.relaxed
+	p.relaxed = nil
+	p.relaxedMu.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+
+	if err := p.engine.wal.LogBatch(entries); err != nil {
+		log.Printf("engine: relaxed write of %d entries missed the WAL: %v", len(entries), err)
+	}
+}
+
+func (p *pipeline) applyStage() {
+	defer p.wg.Done()
+
+	for batch := range p.applyCh {
+		p.apply(batch)
+	}
+}
+
+func (p *pipeline) apply(batch *commitBatch) {
+	e := p.engine
+	defer p.inflight.Done()
+
+	e.writeMu.Lock()
+	if batch.txnID != "" {
+		delete(e.prepared, batch.txnID)
+	}
+	errs := p.applyLocked(batch)
+	syncpoint.Reach(syncpoint.MemtableApplied)
+	// batches are applied in the order they were logged
+	e.clock.record(batch.sequence, earliestRequest(batch.requests))
+	e.publish(batch.sequence)
+	e.writeMu.Unlock()
+
+	for i, req := range batch.requests {
+		req.done <- errs[i]
+	}
+}
+
+// applyLocked inserts the entries of a batch into the memtable, the caller holds writeMu.
+func (p *pipeline) applyLocked(batch *commitBatch) []error {
+	e := p.engine
+	errs := make([]error, len(batch.requests))
+	for i, req := range batch.requests {
+		entry := req.entry
+		e.txns.record(entry.Key, batch.sequence)
+		e.counters.countWrite(entry)
+		errs[i] = e.apply(entry)
+	}
+	return errs
+}
+
+// logValidated commits a batch whose fate depends on the writes before it, like
+// a transaction checking for conflicts. The apply stage is drained and the write
+// lock held from the check until the batch is applied, so no write slips in
+// between. A batch failing the check never reaches the WAL.
+func (p *pipeline) logValidated(batch *commitBatch) {
+	e := p.engine
+	p.inflight.Wait()
+
+	e.writeMu.Lock()
+	err := batch.validate()
+	if err == nil {
+		err = e.wal.LogBatch(p.number(batch))
+	}
+	if err != nil {
+		e.writeMu.Unlock()
+		for _, req := range batch.requests {
+			req.done <- err
+		}
+		return
+	}
+	e.Config.ReachCrashPoint(shared.CrashAfterWALAppend)
+	syncpoint.Reach(syncpoint.WALAppended)
+
+	errs := p.applyLocked(batch)
+	e.clock.record(batch.sequence, earliestRequest(batch.requests))
+	e.publish(batch.sequence)
+	e.writeMu.Unlock()
+
+	for i, req := range batch.requests {
+		req.done <- errs[i]
+	}
+}
+
+// exclusive runs fn with every write committed before it applied and writeMu
+// held, so no write goes on meanwhile. It suits operations that change the
+// tables behind the back of the WAL, like ingesting a table.
+func (p *pipeline) exclusive(fn func() error) error {
+	batch := &commitBatch{run: fn, done: make(chan error, 1)}
+	select {
+	case p.logCh <- batch:
+	case <-p.stop:
+		return errEngineClosed
+	}
+	return <-batch.done
+}
+
+func (p *pipeline) logExclusive(batch *commitBatch) {
+	e := p.engine
+	p.inflight.Wait()
+
+	e.writeMu.Lock()
+	err := batch.run()
+	e.writeMu.Unlock()
+	batch.done <- err
+}
+
+// close stops accepting batches and waits for the queued ones to be applied.
+func (p *pipeline) close() {
+	close(p.stop)
+	p.wg.Wait()
+
+	// relaxed writes check for the stop under writeMu, none is queued after this
+	p.engine.writeMu.Lock()
+	p.logRelaxed()
+	p.engine.writeMu.Unlock()
+}
@@ -0,0 +1,299 @@
+package goldb
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/wal"
+)
+
+// openTestEngine opens a database in a temporary directory with the options
+// applied to the default configuration.
+func openTestEngine(t *testing.T, dir string, options ...func(*EngineConfig)) *Engine {
+	t.Helper()
+	config := *NewEngineConfig()
+	for _, option := range options {
+		option(&config)
+	}
+	e, err := New(dir, config)
+	if err != nil {
+		t.Fatalf("can not open the database: %v", err)
+	}
+	return e
+}
+
+// TestRelaxedWritesAlongsideExclusiveWork runs relaxed writes along with
+// renames, transactions and compactions, which the WAL stage commits holding
+// writeMu, and checks none of them waits on the others for good.
+func TestRelaxedWritesAlongsideExclusiveWork(t *testing.T) {
+	dir := t.TempDir()
+	e := openTestEngine(t, dir, func(c *EngineConfig) {
+		c.RelaxedWrites = true
+		c.MemtableSizeThreshold = 64
+	})
+
+	if err := e.Set("renamed-a", []byte("moving")); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, writes = 4, 500
+	stop := make(chan struct{})
+	var wg, others sync.WaitGroup
+	errs := make(chan error, writers+3)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				if err := e.Set(fmt.Sprintf("w%d-%04d", w, i), []byte(fmt.Sprint(i))); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	others.Add(3)
+	go func() {
+		defer others.Done()
+		from, to := "renamed-a", "renamed-b"
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := e.Rename(from, to); err != nil {
+				errs <- fmt.Errorf("rename: %v", err)
+				return
+			}
+			from, to = to, from
+		}
+	}()
+	go func() {
+		defer others.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			txn := e.Begin()
+			if err := txn.Set(fmt.Sprintf("txn-%d", i%10), []byte(fmt.Sprint(i))); err != nil {
+				errs <- err
+				return
+			}
+			if err := txn.Commit(); err != nil {
+				if _, ok := err.(*ErrTxnConflict); !ok {
+					errs <- fmt.Errorf("commit: %v", err)
+					return
+				}
+			}
+		}
+	}()
+	go func() {
+		defer others.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+			if err := e.Compact(); err != nil {
+				errs <- fmt.Errorf("compact: %v", err)
+				return
+			}
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stop)
+		others.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(60 * time.Second):
+		t.Fatal("the writes did not finish within a minute, the pipeline is stuck")
+	}
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	e.Close()
+
+	// every relaxed write made it to the WAL or a table
+	e = openTestEngine(t, dir)
+	defer e.Close()
+	for w := 0; w < writers; w++ {
+		for i := 0; i < writes; i++ {
+			key := fmt.Sprintf("w%d-%04d", w, i)
+			value, err := e.Get(key)
+			if err != nil || string(value) != fmt.Sprint(i) {
+				t.Fatalf("Get(%q) = %q, %v after reopening, want %q", key, value, err, fmt.Sprint(i))
+			}
+		}
+	}
+	_, errA := e.Get("renamed-a")
+	_, errB := e.Get("renamed-b")
+	if (errA == nil) == (errB == nil) {
+		t.Fatalf("the renamed key is held by both or neither of its names: %v, %v", errA, errB)
+	}
+}
+
+// TestRelaxedWritesToSkiplist writes the same keys from several goroutines to a
+// skiplist memtable, which takes relaxed writes side by side, and checks every
+// key holds its last write, before and after reopening.
+func TestRelaxedWritesToSkiplist(t *testing.T) {
+	dir := t.TempDir()
+	e := openTestEngine(t, dir, func(c *EngineConfig) {
+		c.RelaxedWrites = true
+		c.MemtableKind = MemtableSkiplist
+		c.MemtableSizeThreshold = 200
+	})
+
+	const writers, keys, rounds = 8, 50, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				for i := 0; i < keys; i++ {
+					if err := e.Set(fmt.Sprintf("w%d-%03d", w, i), []byte(fmt.Sprint(r))); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	check := func(e *Engine) {
+		t.Helper()
+		for w := 0; w < writers; w++ {
+			for i := 0; i < keys; i++ {
+				key := fmt.Sprintf("w%d-%03d", w, i)
+				value, err := e.Get(key)
+				if err != nil || string(value) != fmt.Sprint(rounds-1) {
+					t.Fatalf("Get(%q) = %q, %v, want %q", key, value, err, fmt.Sprint(rounds-1))
+				}
+			}
+		}
+	}
+	check(e)
+	e.Close()
+	e = openTestEngine(t, dir, func(c *EngineConfig) { c.MemtableKind = MemtableSkiplist })
+	defer e.Close()
+	check(e)
+}
+
+// TestSequenceNumbersOfRelaxedWritesAndBatches commits relaxed writes and
+// batches side by side and checks every write gets a sequence number of its
+// own, with LastSequence only going up.
+func TestSequenceNumbersOfRelaxedWritesAndBatches(t *testing.T) {
+	e := openTestEngine(t, t.TempDir(), func(c *EngineConfig) {
+		c.RelaxedWrites = true
+		// nothing is flushed, the WAL holds every write
+		c.MemtableSizeThreshold = 1 << 20
+	})
+	defer e.Close()
+	start := e.LastSequence()
+
+	const writers, writes, batchSize = 4, 300, 3
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*writers+1)
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				if err := e.Set(fmt.Sprintf("relaxed-%d-%04d", w, i), []byte("v")); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				b := e.NewBatch()
+				for j := 0; j < batchSize; j++ {
+					b.Set(fmt.Sprintf("batch-%d-%04d-%d", w, i, j), []byte("v"))
+				}
+				if err := b.Commit(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	stop := make(chan struct{})
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		last := start
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if seq := e.LastSequence(); seq < last {
+				errs <- fmt.Errorf("LastSequence went back from %d to %d", last, seq)
+				return
+			} else {
+				last = seq
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-watched
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	total := uint64(writers*writes + writers*writes*batchSize)
+	if seq := e.LastSequence(); seq != start+total {
+		t.Fatalf("LastSequence is %d after %d writes from %d", seq, total, start)
+	}
+
+	// the exclusive operation waits for the relaxed writes to be logged
+	var entries []wal.WALEntry
+	err := e.pipeline.exclusive(func() error {
+		var err error
+		entries, _, err = e.wal.ParseLogs()
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[uint64]string{}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Key, "relaxed-") && !strings.HasPrefix(entry.Key, "batch-") {
+			continue
+		}
+		if other, ok := keys[entry.Sequence]; ok {
+			t.Fatalf("%q and %q are both numbered %d", other, entry.Key, entry.Sequence)
+		}
+		if entry.Sequence <= start || entry.Sequence > start+total {
+			t.Fatalf("%q is numbered %d, out of the %d numbers from %d", entry.Key, entry.Sequence, total, start)
+		}
+		keys[entry.Sequence] = entry.Key
+	}
+	if uint64(len(keys)) != total {
+		t.Fatalf("the WAL holds %d writes, want %d", len(keys), total)
+	}
+}
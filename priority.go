@@ -0,0 +1,96 @@
+package goldb
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/index_manager"
+)
+
+// ReadPriority tells foreground reads, which someone waits for, from background
+// ones like exports and batch jobs.
+type ReadPriority int
+
+const (
+	PriorityHigh ReadPriority = iota // Interactive reads, the default.
+	PriorityLow                      // Background reads, they yield to the high priority ones.
+)
+
+// maxReadYield bounds how long a low priority read waits for the high priority
+// reads in flight to finish, so background jobs slow down but never starve.
+const maxReadYield = 10 * time.Millisecond
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose reads run with priority p.
+func WithPriority(ctx context.Context, p ReadPriority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext returns the priority set with WithPriority, PriorityHigh if there is none.
+func PriorityFromContext(ctx context.Context) ReadPriority {
+	p, _ := ctx.Value(priorityKey{}).(ReadPriority)
+	return p
+}
+
+// LowPriority makes the iterator read with PriorityLow.
+func LowPriority() IteratorOption {
+	return func(opts *index_manager.ScanOptions) {
+		opts.LowPriority = true
+	}
+}
+
+// readLimiter lets the high priority reads go first. Low priority reads wait for
+// the high priority ones in flight, and together stay under LowPriorityReadRate.
+type readLimiter struct {
+	foreground atomic.Int64 // High priority reads in flight.
+	rate       atomic.Int64 // Bytes per second low priority reads may read, zero is unlimited.
+
+	mu      sync.Mutex
+	current int64 // Rate the window starting at start was measured against.
+	start   time.Time
+	read    int64
+}
+
+func newReadLimiter(rate int64) *readLimiter {
+	rl := &readLimiter{start: time.Now(), current: rate}
+	rl.rate.Store(rate)
+	return rl
+}
+
+// foregroundRead marks a high priority read in flight until the returned function is called.
+func (rl *readLimiter) foregroundRead() func() {
+	rl.foreground.Add(1)
+	return func() { rl.foreground.Add(-1) }
+}
+
+// yield waits, up to maxReadYield, for the high priority reads in flight.
+func (rl *readLimiter) yield() {
+	deadline := time.Now().Add(maxReadYield)
+	for rl.foreground.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Microsecond)
+	}
+}
+
+// charge accounts n bytes read with low priority, sleeping when the low priority
+// reads are ahead of the rate.
+func (rl *readLimiter) charge(n int64) {
+	rl.mu.Lock()
+	rate := rl.rate.Load()
+	if rate != rl.current {
+		rl.current, rl.start, rl.read = rate, time.Now(), 0
+	}
+	rl.read += n
+	var ahead time.Duration
+	if rate > 0 {
+		expected := time.Duration(float64(rl.read) / float64(rate) * float64(time.Second))
+		ahead = expected - time.Since(rl.start)
+	}
+	rl.mu.Unlock()
+
+	if ahead > 0 {
+		time.Sleep(ahead)
+	}
+}
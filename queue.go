@@ -0,0 +1,52 @@
+package goldb
+
+import (
+	"fmt"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// Oldest returns the smallest key starting with prefix, in byte-wise order, and
+// its value. Keys named after their enqueue time or a sequence number make the
+// prefix a FIFO queue: Oldest reads its head, and the engine remembers where the
+// live keys of the prefix start, so the deleted heads are not scanned again.
+// Returns ErrKeyNotFound if no key starts with prefix.
+func (e *Engine) Oldest(prefix string) (string, []byte, error) {
+	e.values.mu.RLock()
+	pair, found, err := e.indexManager.First(prefix)
+	if err != nil {
+		e.values.mu.RUnlock()
+		return "", nil, err
+	}
+	if !found {
+		e.values.mu.RUnlock()
+		return "", nil, &shared.ErrKeyNotFound{Key: prefix}
+	}
+
+	value, err := e.storageManager.ReadValue(pair.Value)
+	e.values.mu.RUnlock()
+	if err != nil {
+		return "", nil, fmt.Errorf("db engine can not read key (%q): %v", pair.Key, err)
+	}
+	value, _, err = transformValue(&e.Config, pair.Key, value)
+	if err != nil {
+		return "", nil, err
+	}
+	return pair.Key, value, nil
+}
+
+// PopOldest removes the head of the queue under prefix and returns it, see
+// Oldest. Concurrent consumers of the same prefix never get the same key.
+func (e *Engine) PopOldest(prefix string) (string, []byte, error) {
+	unlock := e.queueLocks.lock(prefix)
+	defer unlock()
+
+	key, value, err := e.Oldest(prefix)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := e.Delete(key); err != nil {
+		return "", nil, err
+	}
+	return key, value, nil
+}
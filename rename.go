@@ -0,0 +1,77 @@
+package goldb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+	"github.com/hasssanezzz/goldb/internal/wal"
+)
+
+// errKeyRaced is returned by the check of a rename or link whose source key was
+// written after its value was located, the operation is retried then.
+var errKeyRaced = errors.New("key was written during the operation")
+
+// Rename moves the value of oldKey to newKey, replacing the value newKey may
+// have, and deletes oldKey. Both writes are committed as one batch, so readers
+// see either the old key or the new one, never both or none. The value itself
+// is not copied, newKey is linked to it.
+func (e *Engine) Rename(oldKey, newKey string) error {
+	for _, key := range []string{oldKey, newKey} {
+		if len([]byte(key)) > int(e.Config.KeySize) {
+			return &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
+		}
+		if err := checkUserKey(key); err != nil {
+			return err
+		}
+	}
+	if oldKey == newKey {
+		_, err := e.indexManager.Get(oldKey)
+		return err
+	}
+
+	for {
+		err := e.rename(oldKey, newKey)
+		if err != errKeyRaced {
+			return err
+		}
+	}
+}
+
+func (e *Engine) rename(oldKey, newKey string) error {
+	indexNode, err := e.indexManager.Get(oldKey)
+	if err != nil {
+		return err
+	}
+	// the link is only logged, the value it points at must outlive a crash
+	if err := e.syncValues(); err != nil {
+		return err
+	}
+
+	now := time.Now().UnixNano()
+	requests := []writeRequest{
+		{entry: linkEntry(newKey, now, indexNode), done: make(chan error, 1)},
+		{entry: wal.WALEntry{Key: oldKey, Value: []byte{}, Timestamp: now}, done: make(chan error, 1)},
+	}
+
+	// the value moved must still be the one of oldKey when the batch commits
+	validate := func() error {
+		current, found, err := e.indexManager.Lookup(oldKey)
+		if err != nil {
+			return fmt.Errorf("db engine can not locate key (%q): %v", oldKey, err)
+		}
+		if !found || !current.Equal(indexNode) {
+			return errKeyRaced
+		}
+		return nil
+	}
+	e.pipeline.submitBatch(&commitBatch{requests: requests, validate: validate})
+
+	for _, req := range requests {
+		if err := <-req.done; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package goldb
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// replicas tracks the read-only handles opened with OpenReplica in this process,
+// by the absolute path of their directory.
+var replicas = struct {
+	sync.Mutex
+	handles map[string][]*SnapshotReader
+}{handles: map[string][]*SnapshotReader{}}
+
+// OpenReplica opens a read-only handle on a directory an Engine of the same
+// process writes to. Whenever that Engine flushes or compacts, the replica is
+// told to reload its tables before its next read, so it sees the flushed data
+// without being reopened. Like SnapshotReader, it does not see the writes that
+// are still in the memtable of the writer.
+func OpenReplica(homepath string, configs ...shared.EngineConfig) (*SnapshotReader, error) {
+	s, err := OpenSnapshot(homepath, configs...)
+	if err != nil {
+		return nil, err
+	}
+
+	replicas.Lock()
+	defer replicas.Unlock()
+	path := replicaPath(homepath)
+	replicas.handles[path] = append(replicas.handles[path], s)
+	return s, nil
+}
+
+func replicaPath(homepath string) string {
+	path, err := filepath.Abs(homepath)
+	if err != nil {
+		return filepath.Clean(homepath)
+	}
+	return path
+}
+
+func unregisterReplica(s *SnapshotReader) {
+	replicas.Lock()
+	defer replicas.Unlock()
+
+	path := replicaPath(s.Config.Homepath)
+	kept := []*SnapshotReader{}
+	for _, handle := range replicas.handles[path] {
+		if handle != s {
+			kept = append(kept, handle)
+		}
+	}
+	if len(kept) == 0 {
+		delete(replicas.handles, path)
+		return
+	}
+	replicas.handles[path] = kept
+}
+
+// invalidateReplicas marks the replicas of homepath stale after its tables changed.
+func invalidateReplicas(homepath string) {
+	replicas.Lock()
+	defer replicas.Unlock()
+
+	for _, handle := range replicas.handles[replicaPath(homepath)] {
+		handle.stale.Store(true)
+	}
+}
@@ -0,0 +1,387 @@
+package goldb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hasssanezzz/goldb/internal/index_manager"
+	"github.com/hasssanezzz/goldb/internal/shared"
+	"github.com/hasssanezzz/goldb/internal/storage_manager"
+	"github.com/hasssanezzz/goldb/internal/wal"
+)
+
+// RestoreOptions tunes RestoreBackup.
+type RestoreOptions struct {
+	DryRun     bool   // Only verify the backup and report what a restore would bring back, nothing is written.
+	ArchiveDir string // Directory of the WAL segments shipped by a DirArchive, replayed on top of the backup.
+}
+
+// RestoreReport describes the backup checked by RestoreBackup.
+type RestoreReport struct {
+	Number   int    // Backup of the chain restored, zero for a copy of a database directory.
+	Sequence uint64 // Sequence number of the last write the backup of the chain holds.
+	Tables   int    // Tables read back and checked.
+	Values   int    // Values read back in full.
+	Segments int    // WAL segments the restored database replays, the archived ones included.
+	Keys     int    // Keys the restored database holds.
+}
+
+// Restore restores the backup at backupDir into homepath, which must not exist
+// or be empty, leaving a database New opens. It is RestoreBackup with the
+// default options and configuration.
+func Restore(backupDir, homepath string) (RestoreReport, error) {
+	return RestoreBackup(backupDir, homepath, RestoreOptions{})
+}
+
+// RestoreBackup restores the backup at backupDir into homepath, which must not
+// exist or be empty. backupDir is either a copy of the directory of a database
+// like the ones SnapshotReader reads, a chain of backups written by Backup,
+// whose latest backup is restored, or one of its numbered directories. The WAL
+// segments shipped by the archiver to the directory options.ArchiveDir carry the
+// backup on to the last archived write: the ones numbered from the oldest
+// segment of the backup on are restored with it.
+//
+// The backup is verified before it is relied on: the parts of the files of a
+// backup of a chain must be found whole in the backups holding them and pass
+// their checksums, then every table and every value is read back, the segments
+// must follow each other without a gap and their frames must pass their
+// checksums, and a segment the backup holds must be the start of its archived
+// copy. A backup of a chain is joined into homepath to be read, its files are
+// removed again if it fails. With DryRun nothing is written, a backup of a
+// chain is joined into a temporary directory, which rehearses a restore; the
+// report tells what the restored database will hold. The configuration must
+// match the one the backup was written with.
+func RestoreBackup(backupDir, homepath string, options RestoreOptions, configs ...shared.EngineConfig) (RestoreReport, error) {
+	config := shared.DefaultConfig
+	if len(configs) > 0 {
+		config = configs[0]
+	}
+	config.Homepath = backupDir
+	config.ResolveDefaults()
+	if err := config.Validate(); err != nil {
+		return RestoreReport{}, err
+	}
+	staging := ""
+	if !options.DryRun {
+		if entries, err := os.ReadDir(homepath); err == nil && len(entries) > 0 {
+			return RestoreReport{}, fmt.Errorf("db engine can not restore into %q: the directory is not empty", homepath)
+		}
+		staging = homepath
+	}
+
+	source, manifest, remove, err := stageBackup(backupDir, staging)
+	if err != nil {
+		return RestoreReport{}, fmt.Errorf("db engine can not restore backup %q: %v", backupDir, err)
+	}
+	config.Homepath = source
+	if err := checkCollations(&config, false); err != nil {
+		remove()
+		return RestoreReport{}, err
+	}
+
+	report, segments, err := verifyBackup(&config, options.ArchiveDir)
+	report.Number, report.Sequence = manifest.Number, manifest.Sequence
+	if err != nil {
+		remove()
+		return report, fmt.Errorf("db engine can not restore backup %q: %v", backupDir, err)
+	}
+	if options.DryRun {
+		remove()
+		return report, nil
+	}
+
+	if source != homepath {
+		err = copyBackup(backupDir, homepath)
+	}
+	if err == nil {
+		err = copyArchived(source, homepath, segments)
+	}
+	if err != nil {
+		return report, fmt.Errorf("db engine can not restore backup %q: %v", backupDir, err)
+	}
+	return report, nil
+}
+
+// stageBackup returns the directory of the database backed up at backupDir,
+// and the manifest of the backup when it belongs to a chain written by Backup.
+// A copy of the directory of a database is read where it is. The files of a
+// backup of a chain are checked against their checksums and joined into dir,
+// or into a temporary directory when dir is empty; remove deletes them again.
+func stageBackup(backupDir, dir string) (string, backupManifest, func(), error) {
+	nothing := func() {}
+	chain := backupDir
+	manifest, err := lastBackup(backupDir)
+	if _, statErr := os.Stat(filepath.Join(backupDir, backupManifestFile)); statErr == nil {
+		chain = filepath.Dir(filepath.Clean(backupDir))
+		manifest, err = readBackupManifest(backupDir)
+	}
+	if err != nil {
+		return "", backupManifest{}, nothing, err
+	}
+	if manifest.Number == 0 {
+		return backupDir, manifest, nothing, nil
+	}
+
+	for _, file := range manifest.Files {
+		if err := verifyBackupFile(chain, manifest.Number, file); err != nil {
+			return "", manifest, nothing, fmt.Errorf("file %q: %v", file.Name, err)
+		}
+	}
+
+	remove := func() {
+		for _, file := range manifest.Files {
+			os.Remove(filepath.Join(dir, file.Name))
+		}
+	}
+	if dir == "" {
+		if dir, err = os.MkdirTemp("", "goldb-restore-"); err != nil {
+			return "", manifest, nothing, err
+		}
+		remove = func() { os.RemoveAll(dir) }
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", manifest, nothing, err
+	}
+	for _, file := range manifest.Files {
+		if err := restoreBackupFile(chain, dir, file); err != nil {
+			remove()
+			return "", manifest, nothing, fmt.Errorf("can not join file %q: %v", file.Name, err)
+		}
+	}
+	return dir, manifest, remove, nil
+}
+
+// verifyBackup checks the backup at config.Homepath along with the archived
+// segments continuing it, and returns the segments to restore, oldest first.
+func verifyBackup(config *shared.EngineConfig, archiveDir string) (RestoreReport, []string, error) {
+	report := RestoreReport{}
+	indexManager, err := index_manager.New(config)
+	if err != nil {
+		return report, nil, err
+	}
+	defer indexManager.Close()
+	if report.Tables, err = indexManager.VerifyTables(); err != nil {
+		return report, nil, err
+	}
+
+	storageManager, err := storage_manager.NewReadOnly(filepath.Join(config.Homepath, "data.bin"))
+	if err != nil {
+		return report, nil, err
+	}
+	defer storageManager.Close()
+	storageManager.SetCompressor(config.Compression)
+
+	opts := index_manager.NewScanOptions()
+	opts.System = true
+	pairs, err := indexManager.Items(opts)
+	if err != nil {
+		return report, nil, err
+	}
+	keys := map[string]struct{}{}
+	for _, pair := range pairs {
+		if _, err := storageManager.ReadValue(pair.Value); err != nil {
+			return report, nil, fmt.Errorf("can not read key (%q): %v", pair.Key, err)
+		}
+		report.Values++
+		if !shared.IsSystemKey(pair.Key) {
+			keys[pair.Key] = struct{}{}
+		}
+	}
+
+	segments, err := backupSegments(config.Homepath, archiveDir)
+	if err != nil {
+		return report, nil, err
+	}
+	report.Segments = len(segments)
+	walKeys := append([][]byte{config.WALEncryptionKey}, config.WALDecryptionKeys...)
+	entries, err := wal.Inspect(segments, config.KeySize, config.WALKeyProvider, walKeys...)
+	if err != nil {
+		return report, nil, err
+	}
+	for _, entry := range entries {
+		if entry.Link != nil {
+			if _, err := storageManager.ReadValue(*entry.Link); err != nil {
+				return report, nil, fmt.Errorf("can not read the value linked to key (%q): %v", entry.Key, err)
+			}
+		}
+		if shared.IsSystemKey(entry.Key) {
+			continue
+		}
+		if entry.Link != nil || len(entry.Value) > 0 {
+			keys[entry.Key] = struct{}{}
+		} else {
+			delete(keys, entry.Key)
+		}
+	}
+	report.Keys = len(keys)
+
+	return report, segments, nil
+}
+
+// backupSegments returns the WAL files of the backup at dir followed by the
+// archived segments continuing them, the archived copy replacing a segment the
+// backup holds.
+func backupSegments(dir, archiveDir string) ([]string, error) {
+	source := filepath.Join(dir, "wal.log.bin")
+	chain := map[int]string{}
+	if err := listSegments(source, dir, chain); err != nil {
+		return nil, err
+	}
+	first := 0
+	for n := range chain {
+		if first == 0 || n < first {
+			first = n
+		}
+	}
+
+	if archiveDir != "" {
+		archived := map[int]string{}
+		if err := listSegments(source, archiveDir, archived); err != nil {
+			return nil, err
+		}
+		if len(chain) == 0 && len(archived) > 0 {
+			return nil, fmt.Errorf("the backup holds no WAL segment for the archived ones to continue")
+		}
+		for n, path := range archived {
+			if n < first {
+				// flushed to the tables of the backup
+				continue
+			}
+			if kept, ok := chain[n]; ok {
+				if err := checkPrefix(kept, path); err != nil {
+					return nil, err
+				}
+			}
+			chain[n] = path
+		}
+	}
+
+	numbers := make([]int, 0, len(chain))
+	for n := range chain {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	paths := []string{}
+	if _, err := os.Stat(source); err == nil {
+		paths = append(paths, source)
+	}
+	for i, n := range numbers {
+		if i > 0 && n == numbers[i-1]+2 {
+			return nil, fmt.Errorf("WAL segment %d is missing", n-1)
+		}
+		if i > 0 && n != numbers[i-1]+1 {
+			return nil, fmt.Errorf("WAL segments %d to %d are missing", numbers[i-1]+1, n-1)
+		}
+		paths = append(paths, chain[n])
+	}
+	return paths, nil
+}
+
+// listSegments adds the segments of the WAL at source found in dir to segments.
+func listSegments(source, dir string, segments map[int]string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if n, ok := wal.SegmentNumber(source, entry.Name()); ok {
+			segments[n] = filepath.Join(dir, entry.Name())
+		}
+	}
+	return nil
+}
+
+// checkPrefix makes sure the segment kept by the backup is the start of its
+// archived copy, the backup may have been taken before the segment was closed.
+func checkPrefix(kept, archived string) error {
+	keptData, err := os.ReadFile(kept)
+	if err != nil {
+		return err
+	}
+	archivedData, err := os.ReadFile(archived)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(archivedData, keptData) {
+		return fmt.Errorf("WAL segment %q differs from its archived copy %q", kept, archived)
+	}
+	return nil
+}
+
+// copyBackup copies the files of the backup, syncing every file.
+func copyBackup(backupDir, homepath string) error {
+	return filepath.WalkDir(backupDir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(backupDir, path)
+		if err != nil {
+			return err
+		}
+		// the files waiting for the archiver were shipped by the backed up database
+		if rel == archiveDir && entry.IsDir() {
+			return filepath.SkipDir
+		}
+		if entry.IsDir() {
+			return os.MkdirAll(filepath.Join(homepath, rel), 0755)
+		}
+		if strings.HasSuffix(rel, ".tmp") {
+			return nil
+		}
+		return copySynced(path, filepath.Join(homepath, rel))
+	})
+}
+
+// copyArchived copies the archived segments over the ones the backup at
+// backupDir holds, then syncs every directory of the restored database.
+func copyArchived(backupDir, homepath string, segments []string) error {
+	for _, path := range segments {
+		if filepath.Dir(path) == filepath.Clean(backupDir) {
+			continue
+		}
+		if err := copySynced(path, filepath.Join(homepath, filepath.Base(path))); err != nil {
+			return err
+		}
+	}
+
+	return filepath.WalkDir(homepath, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return err
+		}
+		return syncPath(path)
+	})
+}
+
+func copySynced(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func syncPath(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
@@ -0,0 +1,76 @@
+package goldb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRestoreBackupOfChain rehearses and runs restores of a chain of backups,
+// the incremental backup holding only the bytes written since the first one,
+// and checks each restores the keys it was taken with.
+func TestRestoreBackupOfChain(t *testing.T) {
+	dir, chain := t.TempDir(), filepath.Join(t.TempDir(), "chain")
+	e := openTestEngine(t, dir)
+
+	write := func(from, to int) {
+		for i := from; i < to; i++ {
+			if err := e.Set(fmt.Sprintf("key-%03d", i), []byte(fmt.Sprintf("value-%03d", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	write(0, 50)
+	if _, err := e.Backup(chain); err != nil {
+		t.Fatal(err)
+	}
+	write(50, 80)
+	info, err := e.Backup(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Number != 2 {
+		t.Fatalf("the second backup is numbered %d", info.Number)
+	}
+	e.Close()
+
+	for _, c := range []struct {
+		backupDir string
+		number    int
+		keys      int
+	}{
+		{filepath.Join(chain, "000002"), 2, 80},
+		{chain, 2, 80},
+		{filepath.Join(chain, "000001"), 1, 50},
+	} {
+		homepath := filepath.Join(t.TempDir(), "restored")
+		report, err := RestoreBackup(c.backupDir, homepath, RestoreOptions{DryRun: true})
+		if err != nil {
+			t.Fatalf("rehearsing the restore of %q: %v", c.backupDir, err)
+		}
+		if report.Number != c.number || report.Keys != c.keys {
+			t.Fatalf("rehearsing the restore of %q reports backup %d with %d keys, want backup %d with %d", c.backupDir, report.Number, report.Keys, c.number, c.keys)
+		}
+		if _, err := os.Stat(homepath); !os.IsNotExist(err) {
+			t.Fatalf("rehearsing the restore of %q wrote to the target: %v", c.backupDir, err)
+		}
+
+		if _, err := Restore(c.backupDir, homepath); err != nil {
+			t.Fatalf("restoring %q: %v", c.backupDir, err)
+		}
+		restored := openTestEngine(t, homepath)
+		keys, err := restored.Scan("key-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != c.keys {
+			t.Fatalf("%q restores %d keys, want %d", c.backupDir, len(keys), c.keys)
+		}
+		value, err := restored.Get(fmt.Sprintf("key-%03d", c.keys-1))
+		if err != nil || string(value) != fmt.Sprintf("value-%03d", c.keys-1) {
+			t.Fatalf("%q restores the last key as %q, %v", c.backupDir, value, err)
+		}
+		restored.Close()
+	}
+}
@@ -0,0 +1,161 @@
+package goldb
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// settlingPicker picks every SSTable while on is set and none otherwise, so the
+// tables are only compacted when the test says so.
+type settlingPicker struct {
+	on *atomic.Bool
+}
+
+func (p settlingPicker) Pick(tables []shared.TableInfo, config *shared.EngineConfig) []shared.TableInfo {
+	if !p.on.Load() {
+		return nil
+	}
+	return tables
+}
+
+// TestMergedOrderAcrossLevels spreads overwrites and deletes over the deepest
+// level, a shallower level, the SSTables and the memtable, and checks Scan,
+// ScanPage and the iterator list the live keys once each, in ascending order,
+// with their latest values.
+func TestMergedOrderAcrossLevels(t *testing.T) {
+	settling := &atomic.Bool{}
+	e := openTestEngine(t, t.TempDir(), func(c *EngineConfig) {
+		c.MemtableSizeThreshold = 16
+		c.CompactionPicker = settlingPicker{on: settling}
+		c.CompactionStrategy = shared.LeveledStrategy{BaseSize: 32000, Fanout: 2, Levels: 4}
+	})
+	defer e.Close()
+
+	model := map[string]string{}
+	write := func(i int, value string) {
+		key := fmt.Sprintf("key-%03d", i)
+		var err error
+		if value == "" {
+			err = e.Delete(key)
+			delete(model, key)
+		} else {
+			err = e.Set(key, []byte(value))
+			model[key] = value
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// flushes the round, and with merge set compacts its tables until the
+	// strategy has nothing left to do. The tables are never compacted in the
+	// background meanwhile, so the layout does not depend on when that runs.
+	settle := func(merge bool) {
+		if err := e.pipeline.exclusive(func() error { e.flush(); return nil }); err != nil {
+			t.Fatal(err)
+		}
+		if !merge {
+			return
+		}
+		settling.Store(true)
+		defer settling.Store(false)
+		for {
+			if running, pending := e.compactor.state(); running || pending {
+				time.Sleep(time.Millisecond)
+				continue
+			}
+			if e.indexManager.Shape().NextCompaction.Merge == nil {
+				return
+			}
+			if err := e.indexManager.CompactionCheck(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// written out of order, the first round cascades down to a deep level
+	for i := 0; i < 300; i++ {
+		write((i*37)%300, fmt.Sprintf("deep-%d", i))
+	}
+	settle(true)
+	for i := 0; i < 300; i += 9 {
+		write(i, fmt.Sprintf("shallow-%d", i))
+		write(i+4, "")
+	}
+	settle(true)
+	for i := 2; i < 300; i += 11 {
+		write(i, fmt.Sprintf("sstable-%d", i))
+		write(i+1, "")
+	}
+	settle(false)
+	for i := 7; i < 300; i += 50 {
+		write(i, fmt.Sprintf("memtable-%d", i))
+		write(i+2, "")
+	}
+
+	shape := e.indexManager.Shape()
+	depths := map[int]bool{}
+	for _, level := range shape.Levels {
+		depths[level.Depth] = true
+	}
+	if len(depths) < 2 || len(shape.SSTables) == 0 || shape.MemtableKeys == 0 {
+		t.Fatalf("the keys are not spread over the memtable, SSTables and levels of several depths: %d memtable keys, %d SSTables, level depths %v", shape.MemtableKeys, len(shape.SSTables), depths)
+	}
+
+	want := make([]string, 0, len(model))
+	for key := range model {
+		want = append(want, key)
+	}
+	sort.Strings(want)
+
+	keys, err := e.Scan("key-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Fatalf("Scan lists %d keys, want the %d live ones in order", len(keys), len(want))
+	}
+
+	paged := []string{}
+	for cursor := ""; ; {
+		page, next, err := e.ScanPage("key-", 17, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paged = append(paged, page...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if fmt.Sprint(paged) != fmt.Sprint(want) {
+		t.Fatalf("ScanPage lists %d keys, want the %d live ones in order", len(paged), len(want))
+	}
+
+	it, err := e.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	i := 0
+	for it.Next() {
+		if i >= len(want) || it.Key() != want[i] {
+			t.Fatalf("the iterator walks %q at %d, want %v", it.Key(), i, want[i:min(i+1, len(want))])
+		}
+		value, err := it.Value()
+		if err != nil || string(value) != model[want[i]] {
+			t.Fatalf("%q reads %q, %v, want %q", want[i], value, err, model[want[i]])
+		}
+		i++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if i != len(want) {
+		t.Fatalf("the iterator walks %d keys, want %d", i, len(want))
+	}
+}
@@ -0,0 +1,127 @@
+package goldb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// sequenceKey is the system key holding the last sequence number handed out, so
+// the numbering carries on when the database is opened again.
+const sequenceKey = "sequence"
+
+// maxClockMarks bounds the marks kept by the sequence clock, older marks are
+// merged two by two once it is reached.
+const maxClockMarks = 4096
+
+// clockMark covers the writes numbered after the previous mark up to seq, with
+// the earliest write time among them.
+type clockMark struct {
+	seq       uint64
+	timestamp int64
+}
+
+// sequenceClock maps the sequence numbers handed out since the database was
+// opened to write times, which is what the tables keep. It answers with the
+// earliest time a write after a sequence number can carry, write times are set
+// before the writes are numbered and are not in their order.
+type sequenceClock struct {
+	mu    sync.Mutex
+	start uint64 // Sequence number the database was opened at, older ones are unknown.
+	marks []clockMark
+}
+
+// record notes the writes numbered up to seq since the last mark, the earliest
+// of them written at timestamp. Writes record themselves before their sequence
+// number is published.
+func (c *sequenceClock) record(seq uint64, timestamp int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if n := len(c.marks); n == maxClockMarks {
+		// a merged mark covers both writes ranges with the earlier time
+		for i := 0; i < n/2; i++ {
+			a, b := c.marks[2*i], c.marks[2*i+1]
+			c.marks[i] = clockMark{seq: b.seq, timestamp: min(a.timestamp, b.timestamp)}
+		}
+		c.marks = c.marks[:n/2]
+	}
+	c.marks = append(c.marks, clockMark{seq: seq, timestamp: timestamp})
+}
+
+// since returns the earliest write time of the writes numbered after seq, and
+// false when seq was not handed out since the database was opened.
+func (c *sequenceClock) since(seq uint64) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	last := c.start
+	if len(c.marks) > 0 {
+		last = c.marks[len(c.marks)-1].seq
+	}
+	if seq < c.start || seq > last {
+		return 0, false
+	}
+
+	since := int64(math.MaxInt64)
+	for i := len(c.marks) - 1; i >= 0 && c.marks[i].seq > seq; i-- {
+		since = min(since, c.marks[i].timestamp)
+	}
+	return since, true
+}
+
+// earliestRequest returns the earliest write time of the requests.
+func earliestRequest(requests []writeRequest) int64 {
+	earliest := int64(math.MaxInt64)
+	for _, req := range requests {
+		earliest = min(earliest, req.entry.Timestamp)
+	}
+	return earliest
+}
+
+// earliestPair returns the earliest write time of the pairs.
+func earliestPair(pairs []memtable.KVPair) int64 {
+	earliest := int64(math.MaxInt64)
+	for _, pair := range pairs {
+		earliest = min(earliest, pair.Value.Timestamp)
+	}
+	return earliest
+}
+
+// loadSequence carries on the numbering of the previous runs: the writes replayed
+// from the WAL, already counted, were numbered after the checkpointed sequence
+// number. System writes sitting in the WAL are counted too, which only skips a
+// few numbers. A WAL numbering its writes tells the last number given out,
+// replayed, rather than how many came after the checkpoint.
+func (e *Engine) loadSequence(replayed uint64) error {
+	stored := uint64(0)
+	data, err := e.getSystem(sequenceKey)
+	if err != nil {
+		if _, ok := err.(*shared.ErrKeyNotFound); !ok {
+			return err
+		}
+	} else {
+		if len(data) != 8 {
+			return fmt.Errorf("db engine can not parse the sequence checkpoint of %d bytes", len(data))
+		}
+		stored = binary.BigEndian.Uint64(data)
+	}
+
+	if replayed != 0 {
+		e.sequence.Store(max(stored, replayed))
+	} else {
+		e.sequence.Add(stored)
+	}
+	e.clock = &sequenceClock{start: e.sequence.Load()}
+	return nil
+}
+
+// checkpointSequence persists the last sequence number, the caller holds writeMu.
+func (e *Engine) checkpointSequence() error {
+	data := binary.BigEndian.AppendUint64(nil, e.sequence.Load())
+	return e.setSystemLocked(sequenceKey, data)
+}
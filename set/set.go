@@ -0,0 +1,100 @@
+// Package set keeps sets of strings in a goldb database: members are added and
+// removed, tested for and listed in ascending order.
+//
+//	tags := set.New(engine, "post:42:tags")
+//	tags.Add("go", "databases")
+//	ok, err := tags.Contains("go")
+//	members, err := tags.Members()
+//
+// A set is stored under its name, every member being a key of its own,
+// "<name>/<member>", holding a single byte. Adding or removing members is a
+// single batch, so the members given together show up or go away together.
+// Members are listed by walking the keys of the set alone, the value file is
+// never read.
+package set
+
+import (
+	"github.com/hasssanezzz/goldb"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// present is the value of the keys of the members, an empty value would read
+// as a delete.
+var present = []byte{1}
+
+// Set is a set of strings stored under a name.
+type Set struct {
+	engine *goldb.Engine
+	prefix string
+}
+
+// New returns the set stored under name, empty if it was never written.
+func New(engine *goldb.Engine, name string) *Set {
+	return &Set{engine: engine, prefix: name + "/"}
+}
+
+// Add adds members to the set, the ones already in it are left as they are.
+func (s *Set) Add(members ...string) error {
+	batch := s.engine.NewBatch()
+	for _, member := range members {
+		batch.Set(s.prefix+member, present)
+	}
+	return batch.Commit()
+}
+
+// Remove removes members from the set, the ones not in it are ignored.
+func (s *Set) Remove(members ...string) error {
+	batch := s.engine.NewBatch()
+	for _, member := range members {
+		batch.Delete(s.prefix + member)
+	}
+	return batch.Commit()
+}
+
+// Contains reports whether member is in the set.
+func (s *Set) Contains(member string) (bool, error) {
+	_, err := s.engine.Get(s.prefix + member)
+	if err != nil {
+		if _, ok := err.(*shared.ErrKeyNotFound); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Members returns the members of the set in ascending order.
+func (s *Set) Members() ([]string, error) {
+	members := []string{}
+	err := s.Each(func(member string) bool {
+		members = append(members, member)
+		return true
+	})
+	return members, err
+}
+
+// Len returns the number of members of the set.
+func (s *Set) Len() (int, error) {
+	n := 0
+	err := s.Each(func(string) bool {
+		n++
+		return true
+	})
+	return n, err
+}
+
+// Each calls fn with the members of the set in ascending order until it
+// returns false.
+func (s *Set) Each(fn func(member string) bool) error {
+	it, err := s.engine.NewIterator(goldb.WithRange(s.prefix, shared.PrefixEnd(s.prefix)), goldb.KeysOnly())
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for it.Next() {
+		if !fn(it.Key()[len(s.prefix):]) {
+			break
+		}
+	}
+	return it.Err()
+}
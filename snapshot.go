@@ -0,0 +1,258 @@
+package goldb
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hasssanezzz/goldb/internal/index_manager"
+	"github.com/hasssanezzz/goldb/internal/memtable"
+	"github.com/hasssanezzz/goldb/internal/shared"
+	"github.com/hasssanezzz/goldb/internal/storage_manager"
+)
+
+// SnapshotReader reads a backup or checkpoint directory without opening a full
+// Engine: the WAL is not replayed, nothing is written to the directory and no
+// background work is started. It suits verifying a backup or previewing what a
+// restore would bring back. Writes still sitting in the WAL of the directory are
+// not visible.
+type SnapshotReader struct {
+	Config         shared.EngineConfig
+	mu             sync.RWMutex // Guards indexManager and storageManager against a refresh.
+	indexManager   *index_manager.IndexManager
+	storageManager *storage_manager.StorageManager
+	stale          atomic.Bool // Set when a writer changed the tables, see OpenReplica.
+}
+
+// OpenSnapshot opens the directory at homepath for reading. The configuration
+// must match the one the directory was written with.
+func OpenSnapshot(homepath string, configs ...shared.EngineConfig) (*SnapshotReader, error) {
+	config := shared.DefaultConfig
+	if len(configs) > 0 {
+		config = configs[0]
+	}
+	config.Homepath = homepath
+	config.ResolveDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if err := checkCollations(&config, false); err != nil {
+		return nil, err
+	}
+
+	indexManager, err := index_manager.New(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	storageManager, err := storage_manager.NewReadOnly(filepath.Join(homepath, "data.bin"))
+	if err != nil {
+		indexManager.Close()
+		return nil, err
+	}
+	storageManager.SetCompressor(config.Compression)
+
+	return &SnapshotReader{Config: config, indexManager: indexManager, storageManager: storageManager}, nil
+}
+
+// refresh reloads the tables and the value file when a writer invalidated them
+// since the last read, a garbage collection of the value file replaces both, and
+// returns with the read lock held.
+func (s *SnapshotReader) refresh() error {
+	if s.stale.Swap(false) {
+		indexManager, err := index_manager.New(&s.Config)
+		if err != nil {
+			s.stale.Store(true)
+			return err
+		}
+		storageManager, err := storage_manager.NewReadOnly(filepath.Join(s.Config.Homepath, "data.bin"))
+		if err != nil {
+			indexManager.Close()
+			s.stale.Store(true)
+			return err
+		}
+		storageManager.SetCompressor(s.Config.Compression)
+
+		s.mu.Lock()
+		s.indexManager, indexManager = indexManager, s.indexManager
+		s.storageManager, storageManager = storageManager, s.storageManager
+		s.mu.Unlock()
+		indexManager.Close()
+		storageManager.Close()
+	}
+
+	s.mu.RLock()
+	return nil
+}
+
+// Get returns the value of key in the snapshot.
+func (s *SnapshotReader) Get(key string) ([]byte, error) {
+	if len([]byte(key)) > int(s.Config.KeySize) {
+		return nil, &shared.ErrKeyTooLong{Key: key, KeySize: s.Config.KeySize}
+	}
+	if err := checkUserKey(key); err != nil {
+		return nil, err
+	}
+
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+
+	indexNode, err := s.indexManager.Get(key)
+	if err != nil {
+		if _, ok := err.(*shared.ErrKeyNotFound); ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("snapshot reader can not locate key (%q): %v", key, err)
+	}
+
+	data, err := s.storageManager.ReadValue(indexNode)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot reader can not read key (%q): %v", key, err)
+	}
+	return data, nil
+}
+
+// NewIterator returns an iterator over the snapshot positioned before the first key.
+func (s *SnapshotReader) NewIterator(options ...IteratorOption) (*Iterator, error) {
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+
+	return newIterator(&s.Config, s.indexManager, s.storageManager, nil, nil, options...)
+}
+
+func (s *SnapshotReader) Close() {
+	unregisterReplica(s)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.indexManager.Close()
+	s.storageManager.Close()
+}
+
+// errSnapshotReleased is returned by the reads of a released Snapshot.
+var errSnapshotReleased = fmt.Errorf("snapshot was released")
+
+// Snapshot is a consistent view of an open database: its reads see the keys as
+// they were when Engine.Snapshot took it, whatever was written, deleted or
+// compacted since. It only pins the sequence number it was taken at: the writes
+// after it keep the versions they supersede in memory, and a garbage collection
+// of the value file keeps their values, for as long as the snapshot is not
+// released. It is safe for concurrent use.
+type Snapshot struct {
+	engine   *Engine
+	sequence uint64
+	released bool // Guarded by the value lock.
+}
+
+// Snapshot returns a view of the database as of now, for reads that have to be
+// consistent with each other while writes go on, like an export. Release it
+// once done, until then the versions it sees take room in memory and in the
+// value file.
+func (e *Engine) Snapshot() (*Snapshot, error) {
+	s := &Snapshot{engine: e}
+
+	// no write is applied while the sequence number is pinned
+	e.writeMu.Lock()
+	s.sequence = e.sequence.Load()
+	e.txns.begin(s, s.sequence)
+	e.writeMu.Unlock()
+
+	return s, nil
+}
+
+// Sequence returns the sequence number of the latest write the snapshot sees,
+// see LastSequence.
+func (s *Snapshot) Sequence() uint64 {
+	return s.sequence
+}
+
+// Get returns the value key had when the snapshot was taken. Read hooks
+// transform the value, but unlike Engine.Get it is never written back.
+func (s *Snapshot) Get(key string) ([]byte, error) {
+	e := s.engine
+	if len([]byte(key)) > int(e.Config.KeySize) {
+		return nil, &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
+	}
+	if err := checkUserKey(key); err != nil {
+		return nil, err
+	}
+
+	e.values.mu.RLock()
+	if s.released {
+		e.values.mu.RUnlock()
+		return nil, errSnapshotReleased
+	}
+	_, value, err := e.readAt(key, s.sequence)
+	e.values.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	value, _, err = transformValue(&e.Config, key, value)
+	return value, err
+}
+
+// Scan returns the keys starting with pattern when the snapshot was taken, in
+// the order of Engine.Scan.
+func (s *Snapshot) Scan(pattern string) ([]string, error) {
+	e := s.engine
+	e.values.mu.RLock()
+	defer e.values.mu.RUnlock()
+	if s.released {
+		return nil, errSnapshotReleased
+	}
+
+	opts := index_manager.NewScanOptions()
+	opts.Start, opts.End = pattern, shared.PrefixEnd(pattern)
+	opts.KeysOnly = true
+	pairs, err := e.indexManager.Items(opts)
+	if err != nil {
+		return nil, fmt.Errorf("db engine can not scan the snapshot: %v", err)
+	}
+
+	keys := []string{}
+	add := func(key string, current memtable.IndexNode, found bool) error {
+		node, found, err := e.versionAt(key, s.sequence, current, found)
+		if err == nil && found && !node.IsDeleted() && !node.Expired() && !e.Config.Expired(key, node.Timestamp) {
+			keys = append(keys, key)
+		}
+		return err
+	}
+
+	listed := make(map[string]struct{}, len(pairs))
+	for _, pair := range pairs {
+		listed[pair.Key] = struct{}{}
+		if err := add(pair.Key, pair.Value, true); err != nil {
+			return nil, err
+		}
+	}
+	// the keys deleted since the snapshot was taken are only found among the kept versions
+	for _, key := range e.txns.superseded(pattern) {
+		if _, ok := listed[key]; ok || shared.IsSystemKey(key) {
+			continue
+		}
+		if err := add(key, memtable.IndexNode{}, false); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(keys)
+	collateKeys(&e.Config, keys)
+	return keys, nil
+}
+
+// Release frees the snapshot, its reads fail afterwards. Releasing it again
+// does nothing.
+func (s *Snapshot) Release() {
+	e := s.engine
+	e.values.mu.Lock()
+	s.released = true
+	e.values.mu.Unlock()
+
+	e.txns.end(s)
+}
@@ -0,0 +1,130 @@
+package goldb
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// TestSnapshotPinsItsSequence writes, deletes and creates keys after a snapshot
+// was taken, compacting and collecting the value garbage in between, and checks
+// the snapshot reads and scans the keys as they were, keeping nothing but what
+// the writes superseded.
+func TestSnapshotPinsItsSequence(t *testing.T) {
+	e := openTestEngine(t, t.TempDir(), func(c *EngineConfig) {
+		c.MemtableSizeThreshold = 16
+	})
+	defer e.Close()
+
+	want := []string{}
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		if err := e.Set(key, []byte(fmt.Sprintf("old-%02d", i))); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, key)
+	}
+
+	s, err := e.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e.txns.versions) != 0 {
+		t.Fatalf("taking the snapshot kept %d versions", len(e.txns.versions))
+	}
+
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		if i%4 == 0 {
+			err = e.Delete(key)
+		} else {
+			err = e.Set(key, []byte(fmt.Sprintf("new-%02d", i)))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Set("key-created", []byte("after")); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.CollectValueGarbage(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		value, err := s.Get(key)
+		if err != nil {
+			t.Fatalf("reading %q: %v", key, err)
+		}
+		if want := fmt.Sprintf("old-%02d", i); string(value) != want {
+			t.Fatalf("%q is %q in the snapshot, want %q", key, value, want)
+		}
+	}
+	if _, err := s.Get("key-created"); err == nil {
+		t.Fatal("the snapshot reads a key created after it was taken")
+	}
+	keys, err := s.Scan("key-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(keys, want) {
+		t.Fatalf("the snapshot scans %v, want %v", keys, want)
+	}
+
+	s.Release()
+	if _, err := s.Get("key-01"); err != errSnapshotReleased {
+		t.Fatalf("reading a released snapshot: %v", err)
+	}
+	if len(e.txns.versions) != 0 {
+		t.Fatalf("%d keys have versions kept once the snapshot is released", len(e.txns.versions))
+	}
+}
+
+// TestSnapshotsHideSystemKeys reads the system keyspace through a snapshot of
+// the open database and through a snapshot reader, which both refuse it.
+func TestSnapshotsHideSystemKeys(t *testing.T) {
+	dir := t.TempDir()
+	e := openTestEngine(t, dir)
+	defer e.Close()
+	if err := e.Set("user", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	// the checkpoint stores the sequence number under a system key
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	if err := e.Checkpoint(checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	key := shared.SystemPrefix + sequenceKey
+	if _, err := e.getSystem(sequenceKey); err != nil {
+		t.Fatalf("the system key %q is not there to hide: %v", key, err)
+	}
+
+	s, err := e.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Release()
+	reader, err := OpenSnapshot(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	for name, get := range map[string]func(string) ([]byte, error){"snapshot": s.Get, "snapshot reader": reader.Get} {
+		if value, err := get(key); err == nil {
+			t.Fatalf("the %s reads the system key %q as %q", name, key, value)
+		} else if _, ok := err.(*ErrReservedKey); !ok {
+			t.Fatalf("reading the system key %q through the %s: %v", key, name, err)
+		}
+		if value, err := get("user"); err != nil || string(value) != "value" {
+			t.Fatalf("the %s reads %q as %q, %v", name, "user", value, err)
+		}
+	}
+}
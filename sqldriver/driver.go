@@ -0,0 +1,187 @@
+// Package sqldriver exposes a goldb database through database/sql with a small,
+// read-only SQL dialect, for ad-hoc exploration and existing SQL tooling.
+//
+//	db := sql.OpenDB(sqldriver.NewConnector(engine))
+//	rows, err := db.Query("SELECT key, value FROM kv WHERE key LIKE 'user:%' LIMIT 10")
+//
+// Only SELECT statements are supported, see query for the grammar. Prefix LIKE
+// patterns and BETWEEN are answered with a range scan instead of a full one.
+package sqldriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/hasssanezzz/goldb"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+var errReadOnly = errors.New("goldb: the SQL layer is read-only")
+
+// Connector opens connections to an already open engine.
+type Connector struct {
+	engine *goldb.Engine
+}
+
+// NewConnector returns a connector for sql.OpenDB. Closing the sql.DB does not
+// close the engine.
+func NewConnector(engine *goldb.Engine) *Connector {
+	return &Connector{engine: engine}
+}
+
+func (c *Connector) Connect(context.Context) (driver.Conn, error) {
+	return &conn{engine: c.engine}, nil
+}
+
+func (c *Connector) Driver() driver.Driver {
+	return sqlDriver{}
+}
+
+// sqlDriver only exists to satisfy driver.Connector, connections come from Connector.
+type sqlDriver struct{}
+
+func (sqlDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("goldb: open the database with sql.OpenDB(sqldriver.NewConnector(engine))")
+}
+
+type conn struct {
+	engine *goldb.Engine
+}
+
+func (c *conn) Prepare(sql string) (driver.Stmt, error) {
+	q, err := parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{engine: c.engine, query: q}, nil
+}
+
+func (c *conn) Close() error              { return nil }
+func (c *conn) Begin() (driver.Tx, error) { return nil, errReadOnly }
+
+type stmt struct {
+	engine *goldb.Engine
+	query  *query
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return s.query.params }
+
+func (s *stmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errReadOnly
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	q := s.query
+	options := []goldb.IteratorOption{}
+	var match func(key string) bool
+
+	operands := make([]string, len(q.args))
+	for i, operand := range q.args {
+		value, err := operand.resolve(args)
+		if err != nil {
+			return nil, err
+		}
+		operands[i] = value
+	}
+
+	switch q.op {
+	case "=":
+		options = append(options, goldb.WithRange(operands[0], operands[0]+"\x00"))
+	case "BETWEEN":
+		options = append(options, goldb.WithRange(operands[0], operands[1]+"\x00"))
+	case "LIKE":
+		pattern := operands[0]
+		prefix := pattern[:strings.IndexAny(pattern+"%", "%_")]
+		if prefix != "" {
+			options = append(options, goldb.WithRange(prefix, shared.PrefixEnd(prefix)))
+		}
+		re, err := likeToRegexp(pattern)
+		if err != nil {
+			return nil, err
+		}
+		match = re.MatchString
+	}
+
+	wantsValue := false
+	for _, col := range q.columns {
+		wantsValue = wantsValue || col == "value"
+	}
+	if !wantsValue {
+		options = append(options, goldb.KeysOnly())
+	}
+
+	it, err := s.engine.NewIterator(options...)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{it: it, columns: q.columns, match: match, limit: q.limit}, nil
+}
+
+// likeToRegexp translates a LIKE pattern, % matches any run of bytes and _ a single byte.
+func likeToRegexp(pattern string) (*regexp.Regexp, error) {
+	expr := strings.Builder{}
+	expr.WriteString("^(?s)")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			expr.WriteString(".*")
+		case '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+type rows struct {
+	it       *goldb.Iterator
+	columns  []string
+	match    func(key string) bool
+	limit    int
+	returned int
+}
+
+func (r *rows) Columns() []string { return r.columns }
+
+func (r *rows) Close() error {
+	r.it.Close()
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.limit >= 0 && r.returned >= r.limit {
+		return io.EOF
+	}
+
+	for r.it.Next() {
+		key := r.it.Key()
+		if r.match != nil && !r.match(key) {
+			continue
+		}
+
+		for i, col := range r.columns {
+			if col == "key" {
+				dest[i] = key
+				continue
+			}
+			value, err := r.it.Value()
+			if err != nil {
+				return err
+			}
+			dest[i] = value
+		}
+		r.returned++
+		return nil
+	}
+	if err := r.it.Err(); err != nil {
+		return err
+	}
+	return io.EOF
+}
@@ -0,0 +1,212 @@
+package sqldriver
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// query is a parsed SELECT statement.
+//
+//	SELECT <*|key|value|key, value> [FROM <name>]
+//	  [WHERE key = <lit> | key LIKE <lit> | key BETWEEN <lit> AND <lit>]
+//	  [LIMIT <n>]
+//
+// Literals are single quoted strings or ? placeholders.
+type query struct {
+	columns []string
+	op      string // "", "=", "LIKE" or "BETWEEN"
+	args    []operand
+	limit   int // -1 for no limit
+	params  int // Number of ? placeholders.
+}
+
+// operand is either a literal or a reference to a placeholder.
+type operand struct {
+	literal string
+	param   int // Index of the placeholder, -1 for literals.
+}
+
+func (o operand) resolve(args []driver.Value) (string, error) {
+	if o.param < 0 {
+		return o.literal, nil
+	}
+	switch v := args[o.param].(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	default:
+		return "", fmt.Errorf("goldb: placeholder %d must be a string, got %T", o.param+1, v)
+	}
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+	params int
+}
+
+func parse(sql string) (*query, error) {
+	tokens, err := tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+
+	q := &query{limit: -1}
+	if !p.peekKeyword("SELECT") {
+		return nil, fmt.Errorf("goldb: only SELECT statements are supported, the SQL layer is read-only")
+	}
+	p.next()
+
+	for {
+		col := strings.ToLower(p.next())
+		switch col {
+		case "*":
+			q.columns = append(q.columns, "key", "value")
+		case "key", "value":
+			q.columns = append(q.columns, col)
+		default:
+			return nil, fmt.Errorf("goldb: unknown column %q, only key and value exist", col)
+		}
+		if p.peek() != "," {
+			break
+		}
+		p.next()
+	}
+
+	if p.peekKeyword("FROM") {
+		p.next()
+		p.next() // the table name is ignored, there is only one
+	}
+
+	if p.peekKeyword("WHERE") {
+		p.next()
+		if col := p.next(); !strings.EqualFold(col, "key") {
+			return nil, fmt.Errorf("goldb: WHERE only supports the key column, got %q", col)
+		}
+		q.op = strings.ToUpper(p.next())
+		switch q.op {
+		case "=", "LIKE":
+			value, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			q.args = []operand{value}
+		case "BETWEEN":
+			low, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("AND"); err != nil {
+				return nil, err
+			}
+			high, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			q.args = []operand{low, high}
+		default:
+			return nil, fmt.Errorf("goldb: unsupported operator %q, use =, LIKE or BETWEEN", q.op)
+		}
+	}
+
+	if p.peekKeyword("LIMIT") {
+		p.next()
+		limit, err := strconv.Atoi(p.next())
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("goldb: LIMIT needs a non-negative number")
+		}
+		q.limit = limit
+	}
+
+	if p.peek() == ";" {
+		p.next()
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("goldb: unexpected %q", p.tokens[p.pos])
+	}
+
+	q.params = p.params
+	return q, nil
+}
+
+func (p *parser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekKeyword(keyword string) bool {
+	return strings.EqualFold(p.peek(), keyword)
+}
+
+func (p *parser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *parser) expect(keyword string) error {
+	if token := p.next(); !strings.EqualFold(token, keyword) {
+		return fmt.Errorf("goldb: expected %s, got %q", keyword, token)
+	}
+	return nil
+}
+
+func (p *parser) operand() (operand, error) {
+	token := p.next()
+	if token == "?" {
+		p.params++
+		return operand{param: p.params - 1}, nil
+	}
+	if len(token) >= 2 && token[0] == '\'' {
+		return operand{literal: strings.ReplaceAll(token[1:len(token)-1], "''", "'"), param: -1}, nil
+	}
+	return operand{}, fmt.Errorf("goldb: expected a quoted string or ?, got %q", token)
+}
+
+// tokenize splits a statement into words, quoted strings and punctuation.
+func tokenize(sql string) ([]string, error) {
+	tokens := []string{}
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '\'':
+			j := i + 1
+			for {
+				if j >= len(sql) {
+					return nil, fmt.Errorf("goldb: unterminated string literal")
+				}
+				if sql[j] == '\'' {
+					// a doubled quote is an escaped quote
+					if j+1 < len(sql) && sql[j+1] == '\'' {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			tokens = append(tokens, sql[i:j+1])
+			i = j + 1
+		case strings.IndexByte("*,=?;", c) >= 0:
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			j := i
+			for j < len(sql) && !unicode.IsSpace(rune(sql[j])) && strings.IndexByte("*,=?;'", sql[j]) < 0 {
+				j++
+			}
+			tokens = append(tokens, sql[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,137 @@
+package goldb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+	"github.com/hasssanezzz/goldb/internal/wal"
+)
+
+// statsKey is the name of the system key the cumulative stats are checkpointed under.
+const statsKey = "stats"
+
+// EngineStats sums up the work of the engine over its whole life, across
+// restarts, for long-term trends. The stats are checkpointed at every flush and
+// when the database is closed, a crash loses what happened since the last one.
+type EngineStats struct {
+	KeysWritten    uint64 // Sets and deletes committed.
+	BytesWritten   uint64 // Bytes of the values committed.
+	Flushes        uint64 // Memtables flushed to SSTables.
+	Compactions    uint64 // Tables merged into levels.
+	ReclaimedBytes uint64 // Disk space freed by compactions, expired tables and value garbage collections.
+}
+
+// engineCounters counts the writes since the database was opened, on top of the
+// stats checkpointed before.
+type engineCounters struct {
+	base                EngineStats
+	keysWritten         atomic.Uint64
+	bytesWritten        atomic.Uint64
+	valueBytesReclaimed atomic.Uint64
+}
+
+// countWrite accounts a committed write, the ones of the system keyspace aside.
+func (c *engineCounters) countWrite(entry wal.WALEntry) {
+	if shared.IsSystemKey(entry.Key) {
+		return
+	}
+	c.keysWritten.Add(1)
+	c.bytesWritten.Add(uint64(len(entry.Value)))
+}
+
+// Stats returns the cumulative stats of the database.
+func (e *Engine) Stats() EngineStats {
+	counters := e.indexManager.Counters()
+	base := e.counters.base
+	return EngineStats{
+		KeysWritten:    base.KeysWritten + e.counters.keysWritten.Load(),
+		BytesWritten:   base.BytesWritten + e.counters.bytesWritten.Load(),
+		Flushes:        base.Flushes + counters.Flushes,
+		Compactions:    base.Compactions + counters.Compactions,
+		ReclaimedBytes: base.ReclaimedBytes + counters.ReclaimedBytes + e.counters.valueBytesReclaimed.Load(),
+	}
+}
+
+// loadStats reads the stats checkpointed by the previous runs.
+func (e *Engine) loadStats() error {
+	data, err := e.getSystem(statsKey)
+	if err != nil {
+		if _, ok := err.(*shared.ErrKeyNotFound); ok {
+			return nil
+		}
+		return err
+	}
+	if err := json.Unmarshal(data, &e.counters.base); err != nil {
+		return fmt.Errorf("db engine can not parse the stats checkpoint: %v", err)
+	}
+	return nil
+}
+
+// checkpointStats persists the stats, the caller holds writeMu.
+func (e *Engine) checkpointStats() error {
+	data, err := json.Marshal(e.Stats())
+	if err != nil {
+		return err
+	}
+	return e.setSystemLocked(statsKey, data)
+}
+
+// setSystemLocked is setSystem for callers holding writeMu, which the commit
+// pipeline needs to apply writes. The write is logged and applied right away.
+func (e *Engine) setSystemLocked(name string, value []byte) error {
+	entry := wal.WALEntry{Key: shared.SystemPrefix + name, Value: value, Timestamp: time.Now().UnixNano()}
+	if err := e.wal.LogBatch([]wal.WALEntry{entry}); err != nil {
+		return err
+	}
+	return e.set(entry.Key, entry.Value, entry.Timestamp, 0, 0)
+}
+
+// BlockCacheStats reports how well the block cache serves the reads since the
+// database was opened.
+type BlockCacheStats struct {
+	Hits   uint64 // Table blocks and values found in the cache.
+	Misses uint64 // Table blocks and values read from the disk.
+	Size   int64  // Bytes held by the cache.
+	Budget int64  // Bytes the cache may hold, BlockCacheSize.
+}
+
+// BlockCacheStats returns the stats of the block cache, all zero when it is
+// disabled.
+func (e *Engine) BlockCacheStats() BlockCacheStats {
+	cache := e.indexManager.BlockCache()
+	if cache == nil {
+		return BlockCacheStats{}
+	}
+	hits, misses, size := cache.Stats()
+	return BlockCacheStats{Hits: hits, Misses: misses, Size: size, Budget: cache.Budget()}
+}
+
+// TreeShape is a picture of the memtables, the tables and the compactions of the
+// database at one point, for monitoring.
+type TreeShape struct {
+	MemtableKeys      uint32
+	FrozenKeys        uint32         // Keys of the memtable being flushed, zero when none is.
+	SSTables          []TableInfo    // Newest first.
+	Levels            []TableInfo    // In the order they are searched.
+	NextCompaction    CompactionStep // Tables the compaction strategy merges or drops next, always empty for partitioned flushes.
+	CompactionRunning bool
+	CompactionPending bool // A compaction check waits for the running one.
+}
+
+// TreeShape returns the current shape of the database.
+func (e *Engine) TreeShape() TreeShape {
+	shape := e.indexManager.Shape()
+	running, pending := e.compactor.state()
+	return TreeShape{
+		MemtableKeys:      shape.MemtableKeys,
+		FrozenKeys:        shape.FrozenKeys,
+		SSTables:          shape.SSTables,
+		Levels:            shape.Levels,
+		NextCompaction:    shape.NextCompaction,
+		CompactionRunning: running,
+		CompactionPending: pending,
+	}
+}
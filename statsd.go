@@ -0,0 +1,62 @@
+package goldb
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// StatsD is a MetricsReporter sending the metrics over UDP to a StatsD agent, or
+// a Datadog agent along with their tags. Like with any StatsD client the metrics
+// are sent without waiting for an answer, the ones lost on the way are gone.
+type StatsD struct {
+	conn   net.Conn
+	prefix string
+	tagged bool
+}
+
+// NewStatsD returns a reporter sending to the StatsD agent at address, like
+// "127.0.0.1:8125", the names of the metrics prefixed with prefix and a dot when
+// it is not empty. Plain StatsD has no tags, they are dropped.
+func NewStatsD(address, prefix string) (*StatsD, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("db engine can not reach StatsD at %q: %v", address, err)
+	}
+	if prefix != "" {
+		prefix += "."
+	}
+	return &StatsD{conn: conn, prefix: prefix}, nil
+}
+
+// NewDogStatsD is NewStatsD for a Datadog agent, which keeps the tags.
+func NewDogStatsD(address, prefix string) (*StatsD, error) {
+	s, err := NewStatsD(address, prefix)
+	if err != nil {
+		return nil, err
+	}
+	s.tagged = true
+	return s, nil
+}
+
+func (s *StatsD) Count(name string, delta int64, tags []string) {
+	s.send(fmt.Sprintf("%s%s:%d|c", s.prefix, name, delta), tags)
+}
+
+func (s *StatsD) Timing(name string, duration time.Duration, tags []string) {
+	s.send(fmt.Sprintf("%s%s:%g|ms", s.prefix, name, float64(duration)/float64(time.Millisecond)), tags)
+}
+
+func (s *StatsD) send(line string, tags []string) {
+	if s.tagged && len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	// a datagram that could not be sent is dropped like one lost on the network
+	s.conn.Write([]byte(line))
+}
+
+// Close closes the connection, the engine must be closed first.
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
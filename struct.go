@@ -0,0 +1,130 @@
+package goldb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// JSONCodec encodes structs with encoding/json, it is the default.
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string                       { return "json" }
+func (JSONCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (JSONCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// GobCodec encodes structs with encoding/gob.
+type GobCodec struct{}
+
+func (GobCodec) Name() string { return "gob" }
+
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	err := gob.NewEncoder(buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// ErrTypeMismatch is returned by GetStruct when the stored value was written
+// from a different Go type than the one asked for.
+type ErrTypeMismatch struct {
+	Key    string
+	Stored string
+	Wanted string
+}
+
+func (e *ErrTypeMismatch) Error() string {
+	return fmt.Sprintf("key %q holds a %s, not a %s", e.Key, e.Stored, e.Wanted)
+}
+
+func (e *Engine) structCodec() Codec {
+	if e.Config.StructCodec == nil {
+		return JSONCodec{}
+	}
+	return e.Config.StructCodec
+}
+
+// codecByName finds the codec a value was written with.
+func (e *Engine) codecByName(name string) (Codec, error) {
+	for _, codec := range []Codec{e.structCodec(), JSONCodec{}, GobCodec{}} {
+		if codec.Name() == name {
+			return codec, nil
+		}
+	}
+	return nil, fmt.Errorf("db engine has no codec named %q", name)
+}
+
+// typeName names the type behind v, looking through pointers.
+func typeName(v any) string {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil {
+		return "nil"
+	}
+	if t.Name() != "" && t.PkgPath() != "" {
+		return t.PkgPath() + "." + t.Name()
+	}
+	return t.String()
+}
+
+// SetStruct encodes v with the configured codec and stores it under key.
+// The stored value is tagged with the codec name and the Go type of v, which
+// GetStruct checks before decoding.
+func (e *Engine) SetStruct(key string, v any) error {
+	codec := e.structCodec()
+	payload, err := codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("db engine can not encode (%q): %v", key, err)
+	}
+
+	// "<codec name length><codec name><type length><type><payload>"
+	name, typ := codec.Name(), typeName(v)
+	value := []byte{byte(len(name))}
+	value = append(value, name...)
+	value = binary.LittleEndian.AppendUint16(value, uint16(len(typ)))
+	value = append(value, typ...)
+	value = append(value, payload...)
+
+	return e.Set(key, value)
+}
+
+// GetStruct decodes the value stored under key by SetStruct into out, which must
+// be a pointer to the type the value was written from.
+func (e *Engine) GetStruct(key string, out any) error {
+	value, err := e.Get(key)
+	if err != nil {
+		return err
+	}
+
+	malformed := fmt.Errorf("db engine can not decode (%q): not written by SetStruct", key)
+	if len(value) < 1 || len(value) < 1+int(value[0])+2 {
+		return malformed
+	}
+	name, value := string(value[1:1+value[0]]), value[1+value[0]:]
+	typeLength := int(binary.LittleEndian.Uint16(value))
+	if len(value) < 2+typeLength {
+		return malformed
+	}
+	typ, payload := string(value[2:2+typeLength]), value[2+typeLength:]
+
+	if wanted := typeName(out); typ != wanted {
+		return &ErrTypeMismatch{Key: key, Stored: typ, Wanted: wanted}
+	}
+
+	codec, err := e.codecByName(name)
+	if err != nil {
+		return err
+	}
+	if err := codec.Unmarshal(payload, out); err != nil {
+		return fmt.Errorf("db engine can not decode (%q): %v", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package goldb
+
+import "github.com/hasssanezzz/goldb/internal/syncpoint"
+
+// SyncPoint names a point of the write path, the flush or the compaction where
+// goroutines hand work over to each other. Built with the goldb_syncpoints tag,
+// tests can run a hook at the points or stop a goroutine at one with PauseAt,
+// and check what the others see meanwhile. Without the tag the points cost
+// nothing and SetSyncHook and PauseAt do not exist.
+type SyncPoint = syncpoint.Point
+
+const (
+	SyncWALAppended         = syncpoint.WALAppended
+	SyncMemtableApplied     = syncpoint.MemtableApplied
+	SyncMemtableFrozen      = syncpoint.MemtableFrozen
+	SyncFlushWritten        = syncpoint.FlushWritten
+	SyncFlushInstalled      = syncpoint.FlushInstalled
+	SyncCompactionMerged    = syncpoint.CompactionMerged
+	SyncCompactionInstalled = syncpoint.CompactionInstalled
+)
+
+// SyncPointsEnabled reports whether the engine was built with the sync points.
+const SyncPointsEnabled = syncpoint.Enabled
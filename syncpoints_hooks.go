@@ -0,0 +1,28 @@
+//go:build goldb_syncpoints
+
+package goldb
+
+import "github.com/hasssanezzz/goldb/internal/syncpoint"
+
+// SyncPause stops the next goroutine reaching a sync point, see PauseAt.
+type SyncPause = syncpoint.Pause
+
+// SetSyncHook makes every goroutine reaching a sync point call hook with it, nil
+// removes the hook. The hook is shared by every engine of the process, tests
+// setting it must not run in parallel. It runs on the engine goroutines, often
+// several at once, and must be safe for concurrent use.
+func SetSyncHook(hook func(SyncPoint)) {
+	syncpoint.SetHook(hook)
+}
+
+// PauseAt stops the next goroutine of any engine reaching point until the pause
+// is released: wait on Reached, do what must happen in between, then Release.
+//
+//	pause := goldb.PauseAt(goldb.SyncFlushWritten)
+//	go db.Set(key, value) // the write crossing the threshold freezes the memtable
+//	<-pause.Reached()
+//	value, err := db.Get(key) // read while the frozen memtable is not installed
+//	pause.Release()
+func PauseAt(point SyncPoint) *SyncPause {
+	return syncpoint.PauseAt(point)
+}
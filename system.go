@@ -0,0 +1,97 @@
+package goldb
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// formatVersion is the version of the on-disk format written by this engine,
+// recorded in the system keyspace when the database is created and raised when
+// an older database is opened. Version 1 keeps the write times in the WAL and
+// the tables, version 2 numbers the writes, version 3 keeps the extents of the
+// values appended to. Version 0, the layout before them, has no room for the
+// record: its files are told apart by their layout and migrated by New, see
+// migrateFormat, while the readers opening a directory as it is refuse it.
+const formatVersion = 3
+
+// ErrReservedKey is returned when a key of the system keyspace, where the engine
+// keeps its own metadata, is passed to a user operation.
+type ErrReservedKey struct{ Key string }
+
+func (e *ErrReservedKey) Error() string {
+	return fmt.Sprintf("key %q belongs to the reserved system keyspace", e.Key)
+}
+
+// checkUserKey rejects the keys of the system keyspace.
+func checkUserKey(key string) error {
+	if shared.IsSystemKey(key) {
+		return &ErrReservedKey{Key: key}
+	}
+	return nil
+}
+
+// getSystem reads the engine metadata stored under name.
+func (e *Engine) getSystem(name string) ([]byte, error) {
+	return e.get(shared.SystemPrefix + name)
+}
+
+// setSystem stores engine metadata under name, like format markers, stats
+// checkpoints or replication offsets, out of reach of the user operations.
+func (e *Engine) setSystem(name string, value []byte) error {
+	return e.commit(shared.SystemPrefix+name, value)
+}
+
+// checkFormat records the format version of a new database, upgrades the one of
+// an older database, whose next tables are written in the current format, and
+// refuses to open one written in a newer format.
+func (e *Engine) checkFormat() error {
+	value, err := e.getSystem("format")
+	if err != nil {
+		if _, ok := err.(*shared.ErrKeyNotFound); !ok {
+			return err
+		}
+		e.format = formatVersion
+		return e.setSystem("format", []byte(strconv.Itoa(formatVersion)))
+	}
+
+	version, err := strconv.Atoi(string(value))
+	if err != nil {
+		return fmt.Errorf("db engine can not parse the format version %q: %v", value, err)
+	}
+	if version > formatVersion {
+		return fmt.Errorf("db engine can not open format version %d, the newest supported is %d", version, formatVersion)
+	}
+	e.format = formatVersion
+	if version < formatVersion {
+		return e.setSystem("format", []byte(strconv.Itoa(formatVersion)))
+	}
+	return nil
+}
+
+// checkCompression records the compressor of the database once values are
+// compressed, and refuses to open it without a compressor of the same name,
+// which could not read them back.
+func (e *Engine) checkCompression() error {
+	configured := ""
+	if e.Config.Compression != nil {
+		configured = e.Config.Compression.Name()
+	}
+
+	value, err := e.getSystem("compression")
+	if err != nil {
+		if _, ok := err.(*shared.ErrKeyNotFound); !ok {
+			return err
+		}
+		if configured == "" {
+			return nil
+		}
+		return e.setSystem("compression", []byte(configured))
+	}
+
+	if stored := string(value); stored != configured {
+		return &shared.ErrInvalidConfig{Field: "Compression", Reason: "values were compressed with " + stored}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package goldb
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type tagKey struct{}
+
+// WithTag returns a context that accounts the operations it is passed to under
+// tag, for example the feature issuing them ("checkout", "analytics"), so the
+// operation stats and the slow log can be broken down by caller.
+func WithTag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, tagKey{}, tag)
+}
+
+// TagFromContext returns the tag set with WithTag, empty if there is none.
+func TagFromContext(ctx context.Context) string {
+	tag, _ := ctx.Value(tagKey{}).(string)
+	return tag
+}
+
+// GetContext is Get accounted under the tag of ctx, and run with its priority.
+func (e *Engine) GetContext(ctx context.Context, key string) ([]byte, error) {
+	if err := checkUserKey(key); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	var data []byte
+	var err error
+	if PriorityFromContext(ctx) == PriorityLow {
+		e.reads.yield()
+		data, err = e.get(key)
+		e.reads.charge(int64(len(data)))
+	} else {
+		done := e.reads.foregroundRead()
+		data, err = e.get(key)
+		done()
+	}
+	err = e.record(ctx, "get", key, len(data), start, err)
+	return data, err
+}
+
+// SetContext is Set accounted under the tag of ctx.
+func (e *Engine) SetContext(ctx context.Context, key string, value []byte) error {
+	if err := checkUserKey(key); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err := e.commit(key, value)
+	return e.record(ctx, "set", key, len(value), start, err)
+}
+
+// DeleteContext is Delete accounted under the tag of ctx.
+func (e *Engine) DeleteContext(ctx context.Context, key string) error {
+	if err := checkUserKey(key); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err := e.commit(key, []byte{})
+	return e.record(ctx, "delete", key, 0, start, err)
+}
+
+// OpStats sums up the operations of one kind issued under one tag since the
+// database was opened.
+type OpStats struct {
+	Tag   string
+	Op    string // "get", "set" or "delete".
+	Count uint64
+	Bytes uint64 // Bytes of the values read or written.
+	Total time.Duration
+	Max   time.Duration
+}
+
+// OpStats returns the operation stats sorted by tag and operation.
+func (e *Engine) OpStats() []OpStats {
+	results := []OpStats{}
+	e.stats.counters.Range(func(k, v any) bool {
+		key, counter := k.(opKey), v.(*opCounter)
+		results = append(results, OpStats{
+			Tag:   key.tag,
+			Op:    key.op,
+			Count: counter.count.Load(),
+			Bytes: counter.bytes.Load(),
+			Total: time.Duration(counter.nanos.Load()),
+			Max:   time.Duration(counter.max.Load()),
+		})
+		return true
+	})
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Tag != results[j].Tag {
+			return results[i].Tag < results[j].Tag
+		}
+		return results[i].Op < results[j].Op
+	})
+	return results
+}
+
+type opKey struct {
+	tag string
+	op  string
+}
+
+type opCounter struct {
+	count atomic.Uint64
+	bytes atomic.Uint64
+	nanos atomic.Int64
+	max   atomic.Int64
+}
+
+// opStats keeps an opCounter per tag and operation.
+type opStats struct {
+	counters sync.Map // opKey -> *opCounter
+	ids      *opIDs
+	slow     slowLog
+}
+
+// slowLogSize is the number of slow operations SlowOps remembers.
+const slowLogSize = 100
+
+// SlowOp is an operation that took longer than SlowOpThreshold.
+type SlowOp struct {
+	ID      string // ID the operation was logged under.
+	Time    time.Time
+	Tag     string
+	Op      string
+	Key     string
+	Elapsed time.Duration
+}
+
+// slowLog keeps the last slow operations in a ring.
+type slowLog struct {
+	mu   sync.Mutex
+	ops  [slowLogSize]SlowOp
+	next int // Position the next operation goes to.
+	size int
+}
+
+func (l *slowLog) add(op SlowOp) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.ops[l.next] = op
+	l.next = (l.next + 1) % slowLogSize
+	l.size = min(l.size+1, slowLogSize)
+}
+
+// SlowOps returns the last operations that took longer than SlowOpThreshold,
+// newest first, as logged by the slow log.
+func (e *Engine) SlowOps() []SlowOp {
+	l := &e.stats.slow
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ops := make([]SlowOp, l.size)
+	for i := range ops {
+		ops[i] = l.ops[(l.next-1-i+slowLogSize)%slowLogSize]
+	}
+	return ops
+}
+
+// record accounts an operation that started at start, logging it when it took
+// longer than SlowOpThreshold. A failed operation is logged too, and its error
+// returned as an OpError carrying the ID it was logged under.
+func (e *Engine) record(ctx context.Context, op, key string, bytes int, start time.Time, err error) error {
+	elapsed := time.Since(start)
+	tag := TagFromContext(ctx)
+
+	value, ok := e.stats.counters.Load(opKey{tag, op})
+	if !ok {
+		value, _ = e.stats.counters.LoadOrStore(opKey{tag, op}, &opCounter{})
+	}
+	counter := value.(*opCounter)
+	counter.count.Add(1)
+	counter.bytes.Add(uint64(bytes))
+	counter.nanos.Add(int64(elapsed))
+	for {
+		longest := counter.max.Load()
+		if int64(elapsed) <= longest || counter.max.CompareAndSwap(longest, int64(elapsed)) {
+			break
+		}
+	}
+
+	if e.Config.Metrics != nil {
+		var tags []string
+		if tag != "" {
+			tags = []string{"tag:" + tag}
+		}
+		e.Config.Metrics.Timing("ops."+op, elapsed, tags)
+	}
+
+	id := ""
+	if threshold := e.Config.SlowOpThreshold; threshold > 0 && elapsed > threshold {
+		id = e.stats.ids.new()
+		log.Printf("db engine: slow %s of %q took %v (tag %q, op %s)\n", op, key, elapsed, tag, id)
+		e.stats.slow.add(SlowOp{ID: id, Time: start, Tag: tag, Op: op, Key: key, Elapsed: elapsed})
+	}
+
+	if !failed(err) {
+		return err
+	}
+	if id == "" {
+		id = e.stats.ids.new()
+	}
+	log.Printf("db engine: %s of %q failed (tag %q, op %s): %v\n", op, key, tag, id, err)
+	return &OpError{ID: id, Op: op, Key: key, Err: err}
+}
@@ -0,0 +1,106 @@
+package goldb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/index_manager"
+	"github.com/hasssanezzz/goldb/internal/memtable"
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// TrashInfo describes a prefix dropped with DropPrefix that can still be restored.
+type TrashInfo = index_manager.TrashInfo
+
+// DropPrefix deletes every key starting with prefix, in as few batches as the
+// batch limits allow.
+// When TrashRetention is set the dropped pairs are moved to the trash first and
+// can be brought back with RestoreTrash until the retention runs out, which
+// guards against fat-fingered drops. Returns the trash id, empty when nothing
+// was kept.
+func (e *Engine) DropPrefix(prefix string) (string, error) {
+	opts := index_manager.NewScanOptions()
+	opts.Start, opts.End = prefix, shared.PrefixEnd(prefix)
+
+	pairs, err := e.indexManager.Items(opts)
+	if err != nil {
+		return "", fmt.Errorf("db engine can not list prefix (%q): %v", prefix, err)
+	}
+	if len(pairs) == 0 {
+		return "", nil
+	}
+
+	id := ""
+	if e.Config.TrashRetention > 0 {
+		// the trash may point at values that only the WAL holds durably
+		if err := e.syncValues(); err != nil {
+			return "", err
+		}
+		id, err = e.indexManager.WriteTrash(prefix, pairs, time.Now())
+		if err != nil {
+			return "", err
+		}
+	}
+
+	batch := e.NewBatch()
+	for _, pair := range pairs {
+		if !batch.fits(pair.Key, nil) {
+			if err := batch.Commit(); err != nil {
+				return "", err
+			}
+			batch = e.NewBatch()
+		}
+		batch.Delete(pair.Key)
+	}
+	if err := batch.Commit(); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// Trash returns the dropped prefixes that can still be restored, oldest first.
+func (e *Engine) Trash() ([]TrashInfo, error) {
+	return e.indexManager.ListTrash()
+}
+
+// RestoreTrash writes the pairs of a dropped prefix back and removes it from the
+// trash. Keys written again after the drop are overwritten with their dropped values.
+func (e *Engine) RestoreTrash(id string) error {
+	// the values are read under the value lock and committed outside of it, the
+	// trash is read again when a garbage collection moved them in between
+	var pairs []memtable.KVPair
+	loaded, generation, restored := false, uint64(0), 0
+	for !loaded || restored < len(pairs) {
+		e.values.mu.RLock()
+		if !loaded || e.values.generation.Load() != generation {
+			var err error
+			if pairs, err = e.indexManager.ReadTrash(id); err != nil {
+				e.values.mu.RUnlock()
+				return fmt.Errorf("db engine can not read trash (%q): %v", id, err)
+			}
+			loaded, generation = true, e.values.generation.Load()
+		}
+
+		batch := e.NewBatch()
+		for ; restored < len(pairs); restored++ {
+			pair := pairs[restored]
+			value, err := e.storageManager.ReadValue(pair.Value)
+			if err != nil {
+				e.values.mu.RUnlock()
+				return fmt.Errorf("db engine can not read key (%q): %v", pair.Key, err)
+			}
+			if !batch.fits(pair.Key, value) {
+				break
+			}
+			batch.Set(pair.Key, value)
+		}
+		e.values.mu.RUnlock()
+
+		if err := batch.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return e.indexManager.RemoveTrash(id)
+}
@@ -0,0 +1,34 @@
+package goldb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/wal"
+)
+
+// SetWithTTL sets the key like Set, to expire once ttl passed: Get then returns
+// ErrKeyNotFound and the scans skip the key, the compactions drop it. Setting
+// the key again, with or without a TTL, replaces the expiry. Expiry follows the
+// wall clock of the machine.
+func (e *Engine) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	if err := checkUserKey(key); err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return fmt.Errorf("db engine can not set key (%q) with a TTL of %v: it must be positive", key, ttl)
+	}
+	if len(value) == 0 {
+		return fmt.Errorf("db engine can not set key (%q) with a TTL: the value is empty", key)
+	}
+
+	start := time.Now()
+	err := e.commitEntry(wal.WALEntry{
+		Key:       key,
+		Value:     value,
+		Timestamp: start.UnixNano(),
+		ExpiresAt: start.Add(ttl).UnixNano(),
+	})
+	return e.record(context.Background(), "set", key, len(value), start, err)
+}
@@ -0,0 +1,167 @@
+package goldb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// TestIngestWithTTL ingests keys with and without TTLs and checks the ones
+// with a TTL expire, from the ingested table and after a reopen.
+func TestIngestWithTTL(t *testing.T) {
+	dir := t.TempDir()
+	e := openTestEngine(t, dir)
+	pairs := map[string][]byte{"kept": []byte("kept"), "expiring": []byte("expiring")}
+	if err := e.IngestWithTTL(pairs, map[string]time.Duration{"expiring": 200 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.IngestWithTTL(pairs, map[string]time.Duration{"missing": time.Second}); err == nil {
+		t.Fatal("a TTL is ingested for a key without a value")
+	}
+	if err := e.IngestWithTTL(pairs, map[string]time.Duration{"kept": 0}); err == nil {
+		t.Fatal("a zero TTL is ingested")
+	}
+	for key := range pairs {
+		if value, err := e.Get(key); err != nil || string(value) != key {
+			t.Fatalf("%q reads %q, %v right after the ingest", key, value, err)
+		}
+	}
+	e.Close()
+
+	time.Sleep(300 * time.Millisecond)
+	e = openTestEngine(t, dir)
+	defer e.Close()
+	if value, err := e.Get("kept"); err != nil || string(value) != "kept" {
+		t.Fatalf("the key without a TTL reads %q, %v", value, err)
+	}
+	if value, err := e.Get("expiring"); err == nil {
+		t.Fatalf("the expired key reads %q", value)
+	} else if _, ok := err.(*shared.ErrKeyNotFound); !ok {
+		t.Fatalf("the expired key fails with %v", err)
+	}
+}
+
+// TestExpiredKeysAreHidden writes keys with short and long TTLs and without,
+// in the tables and in the memtable, one of them over an older value, and
+// checks the expired ones are gone from Get, Scan, ScanPage and iterators, and
+// from the tables once they are compacted.
+func TestExpiredKeysAreHidden(t *testing.T) {
+	dir := t.TempDir()
+	e := openTestEngine(t, dir)
+	defer e.Close()
+
+	if err := e.Set("key-shadow", []byte("older")); err != nil {
+		t.Fatal(err)
+	}
+	flush := func() { e.pipeline.exclusive(func() error { e.flush(); return nil }) }
+	flush()
+	set := func(keys ...string) {
+		t.Helper()
+		for _, key := range keys {
+			ttl := time.Hour
+			if strings.Contains(key, "short") || key == "key-shadow" {
+				ttl = 200 * time.Millisecond
+			}
+			var err error
+			if strings.Contains(key, "plain") {
+				err = e.Set(key, []byte(key))
+			} else {
+				err = e.SetWithTTL(key, []byte(key), ttl)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// the first keys go to a table, the others stay in the memtable
+	set("key-1-plain", "key-1-short", "key-1-long", "key-shadow")
+	flush()
+	set("key-2-plain", "key-2-short", "key-2-long")
+
+	check := func(want ...string) {
+		t.Helper()
+		// the expired write to key-shadow hides the older value under it
+		for _, key := range []string{"key-1-plain", "key-1-short", "key-1-long", "key-2-plain", "key-2-short", "key-2-long", "key-shadow"} {
+			value, err := e.Get(key)
+			if slices.Contains(want, key) != (err == nil) || (err == nil && string(value) != key) {
+				t.Fatalf("%q reads %q, %v", key, value, err)
+			}
+		}
+		slices.Sort(want)
+
+		keys, err := e.Scan("key-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(keys, want) {
+			t.Fatalf("the scan lists %v, want %v", keys, want)
+		}
+
+		keys, cursor := []string{}, ""
+		for {
+			page, next, err := e.ScanPage("key-", 2, cursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, page...)
+			if cursor = next; cursor == "" {
+				break
+			}
+		}
+		if !slices.Equal(keys, want) {
+			t.Fatalf("the pages list %v, want %v", keys, want)
+		}
+
+		it, err := e.NewIterator()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer it.Close()
+		keys = []string{}
+		for it.Next() {
+			keys = append(keys, it.Key())
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(keys, want) {
+			t.Fatalf("the iterator walks %v, want %v", keys, want)
+		}
+	}
+	check("key-1-plain", "key-1-short", "key-1-long", "key-2-plain", "key-2-short", "key-2-long", "key-shadow")
+
+	time.Sleep(300 * time.Millisecond)
+	expired := []string{"key-1-short", "key-2-short", "key-shadow"}
+	check("key-1-plain", "key-1-long", "key-2-plain", "key-2-long")
+
+	// the compaction keeps nothing of the expired keys, not even tombstones
+	if err := e.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check("key-1-plain", "key-1-long", "key-2-plain", "key-2-long")
+	tables, err := filepath.Glob(filepath.Join(dir, "*_*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range tables {
+		name := filepath.Base(path)
+		if !strings.HasPrefix(name, e.Config.SSTableNamePrefix) && !strings.HasPrefix(name, e.Config.LevelFileNamePrefix) || filepath.Ext(name) != "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range expired {
+			if bytes.Contains(data, []byte(key+"\x00")) {
+				t.Fatalf("the compacted table %s holds the expired %q", name, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package goldb
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+	"github.com/hasssanezzz/goldb/internal/shared"
+	"github.com/hasssanezzz/goldb/internal/wal"
+)
+
+var errTxnDone = errors.New("transaction is already committed or rolled back")
+
+// ErrTxnConflict is returned when a key a transaction reads or writes was
+// written by someone else after the transaction began.
+type ErrTxnConflict struct{ Key string }
+
+func (e *ErrTxnConflict) Error() string {
+	return fmt.Sprintf("key %q was written after the transaction began", e.Key)
+}
+
+// txnTracker remembers the keys written while transactions are running, with the
+// sequence number of their latest write, so a transaction can tell whether a key
+// changed after it began, and the versions these writes superseded, so it and
+// the snapshots can still read the key as it was. Both are only remembered as
+// long as a transaction or snapshot that began before the write is running.
+type txnTracker struct {
+	mu       sync.Mutex
+	active   map[any]uint64                                     // Running transactions and open snapshots, with the sequence number they read at.
+	writes   map[string]uint64                                  // Key -> sequence number of its latest write.
+	versions map[string][]txnVersion                            // Key -> versions superseded while transactions are running, oldest first.
+	lookup   func(key string) (memtable.IndexNode, bool, error) // Current version of a key, set once the index is open.
+}
+
+// txnVersion is a version of a key superseded by the write numbered until. A key
+// that did not exist is kept as a delete.
+type txnVersion struct {
+	node  memtable.IndexNode
+	until uint64
+}
+
+func newTxnTracker() *txnTracker {
+	return &txnTracker{active: map[any]uint64{}, writes: map[string]uint64{}, versions: map[string][]txnVersion{}}
+}
+
+// record notes a write of key with sequence number seq. Writes record themselves
+// before they become visible, so a reader checking after a read never misses one,
+// and before they are applied, so the version they supersede is kept for the
+// transactions that began before them. The caller holds writeMu, no transaction
+// begins meanwhile.
+func (t *txnTracker) record(key string, seq uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.active) == 0 {
+		return
+	}
+	t.writes[key] = seq
+
+	oldest := t.oldest()
+	if oldest >= seq {
+		return
+	}
+	node, found, err := t.lookup(key)
+	if err != nil {
+		// a transaction reading the key falls back on a conflict
+		return
+	}
+	if !found {
+		node = memtable.IndexNode{}
+	}
+	versions := t.versions[key]
+	if node.Sequence >= seq || (len(versions) > 0 && versions[len(versions)-1].node.Equal(node)) {
+		// written by the same batch, or already superseded by an earlier write
+		return
+	}
+	t.versions[key] = append(versions, txnVersion{node: node, until: seq})
+}
+
+// version returns the version of key a transaction or snapshot reading at
+// sequence number seq sees, when a write after seq superseded it.
+func (t *txnTracker) version(key string, seq uint64) (memtable.IndexNode, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, version := range t.versions[key] {
+		if version.node.Sequence <= seq && seq < version.until {
+			return version.node, true
+		}
+	}
+	return memtable.IndexNode{}, false
+}
+
+// superseded returns the keys starting with prefix that have versions kept.
+func (t *txnTracker) superseded(prefix string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys := []string{}
+	for key := range t.versions {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// oldest returns the sequence number the oldest running transaction or snapshot
+// reads at, the caller holds mu and at least one of them is running.
+func (t *txnTracker) oldest() uint64 {
+	oldest := uint64(0)
+	first := true
+	for _, seq := range t.active {
+		if first || seq < oldest {
+			oldest, first = seq, false
+		}
+	}
+	return oldest
+}
+
+// writtenAfter reports whether key was written after sequence number seq.
+func (t *txnTracker) writtenAfter(key string, seq uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.writes[key] > seq
+}
+
+// begin registers a transaction or snapshot reading at sequence number seq, the
+// caller holds writeMu so no write is applied meanwhile.
+func (t *txnTracker) begin(reader any, seq uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active[reader] = seq
+}
+
+// end forgets the transaction or snapshot and the writes no running one cares about.
+func (t *txnTracker) end(reader any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.active, reader)
+
+	if len(t.active) == 0 {
+		clear(t.writes)
+		clear(t.versions)
+		return
+	}
+	oldest := t.oldest()
+	for key, seq := range t.writes {
+		if seq <= oldest {
+			delete(t.writes, key)
+		}
+	}
+	for key, versions := range t.versions {
+		// versions are superseded in order, the ones up to the oldest transaction are done
+		i := 0
+		for i < len(versions) && versions[i].until <= oldest {
+			i++
+		}
+		if i == len(versions) {
+			delete(t.versions, key)
+		} else if i > 0 {
+			t.versions[key] = slices.Clone(versions[i:])
+		}
+	}
+}
+
+// values adds to live the values of the versions kept for the transactions and
+// snapshots and not held by the index, for a garbage collection to keep them.
+func (t *txnTracker) values(live []memtable.IndexNode) []memtable.IndexNode {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.versions) == 0 {
+		return live
+	}
+
+	kept := make(map[uint32]struct{}, len(live))
+	for _, node := range live {
+		kept[node.Offset] = struct{}{}
+	}
+	for _, versions := range t.versions {
+		for _, version := range versions {
+			for _, record := range version.node.Records() {
+				if _, ok := kept[record.Offset]; !ok {
+					kept[record.Offset] = struct{}{}
+					live = append(live, record)
+				}
+			}
+		}
+	}
+	return live
+}
+
+// relocate points the kept versions at the new offsets of the values moved by a
+// garbage collection. The caller holds the value lock for writing.
+func (t *txnTracker) relocate(moved map[uint32]uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, versions := range t.versions {
+		for i := range versions {
+			if versions[i].node.Inline == nil {
+				versions[i].node.Relocate(moved)
+			}
+		}
+	}
+}
+
+// Txn is a transaction with snapshot isolation: its reads see the database as it
+// was when the transaction began, along with its own writes, and its writes are
+// committed together only if none of the keys it wrote were written by someone
+// else in the meantime.
+//
+// The versions the writes after the transaction began supersede are kept in
+// memory until no running transaction began before them, so reads return the
+// version of the snapshot whatever was written since. A Txn is meant to be used
+// by one goroutine.
+//
+//	txn := db.Begin()
+//	balance, err := txn.Get("balance")
+//	...
+//	txn.Set("balance", updated)
+//	if err := txn.Commit(); err != nil {
+//		// ErrTxnConflict: retry the transaction
+//	}
+type Txn struct {
+	engine   *Engine
+	sequence uint64            // Sequence number of the snapshot.
+	reads    map[string][]byte // Values read, so reading a key again returns the same value.
+	writes   map[string][]byte // Values written, an empty value is a delete.
+	order    []string          // Written keys in the order they were first written.
+	done     bool
+}
+
+// Begin starts a transaction on a snapshot of the writes visible so far.
+func (e *Engine) Begin() *Txn {
+	txn := &Txn{engine: e, reads: map[string][]byte{}, writes: map[string][]byte{}}
+
+	// no write is applied while the snapshot is taken
+	e.writeMu.Lock()
+	txn.sequence = e.sequence.Load()
+	e.txns.begin(txn, txn.sequence)
+	e.writeMu.Unlock()
+
+	return txn
+}
+
+// Get returns the value of key in the snapshot of the transaction, or the value
+// the transaction wrote. Returns ErrTxnConflict in the rare case the version of
+// the snapshot could not be kept.
+func (txn *Txn) Get(key string) ([]byte, error) {
+	if txn.done {
+		return nil, errTxnDone
+	}
+	if err := checkUserKey(key); err != nil {
+		return nil, err
+	}
+	if value, ok := txn.writes[key]; ok {
+		if len(value) == 0 {
+			return nil, &shared.ErrKeyNotFound{Key: key}
+		}
+		return value, nil
+	}
+	if value, ok := txn.reads[key]; ok {
+		if value == nil {
+			return nil, &shared.ErrKeyNotFound{Key: key}
+		}
+		return value, nil
+	}
+
+	value, err := txn.engine.getAt(key, txn.sequence)
+	if err != nil {
+		if _, ok := err.(*shared.ErrKeyNotFound); !ok {
+			return nil, err
+		}
+	}
+
+	txn.reads[key] = value
+	return value, err
+}
+
+// getAt returns the value key had once the writes up to sequence number seq were
+// applied.
+func (e *Engine) getAt(key string, seq uint64) ([]byte, error) {
+	e.values.mu.RLock()
+	indexNode, data, err := e.readAt(key, seq)
+	e.values.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return e.applyReadHook(key, indexNode, data)
+}
+
+// readAt reads key as it was once the writes up to sequence number seq were
+// applied: the version in the index unless a later write superseded it, in which
+// case the one kept for the running transactions and snapshots. The caller holds
+// the value lock.
+func (e *Engine) readAt(key string, seq uint64) (memtable.IndexNode, []byte, error) {
+	indexNode, found, err := e.indexManager.Lookup(key)
+	if err != nil {
+		return memtable.IndexNode{}, nil, fmt.Errorf("db engine can not locate key (%q): %v", key, err)
+	}
+	if indexNode, found, err = e.versionAt(key, seq, indexNode, found); err != nil {
+		return memtable.IndexNode{}, nil, err
+	}
+	if !found || indexNode.IsDeleted() || indexNode.Expired() || e.Config.Expired(key, indexNode.Timestamp) {
+		return memtable.IndexNode{}, nil, &shared.ErrKeyNotFound{Key: key}
+	}
+
+	data, err := e.storageManager.ReadValue(indexNode)
+	if err != nil {
+		if e, ok := err.(*shared.ErrKeyNotFound); ok {
+			e.Key = key
+			return memtable.IndexNode{}, nil, err
+		}
+		return memtable.IndexNode{}, nil, fmt.Errorf("db engine can not read key (%q): %v", key, err)
+	}
+	return indexNode, data, nil
+}
+
+// versionAt returns the version of key a read at sequence number seq sees, given
+// the one in the index. It fails with ErrTxnConflict when the version was
+// superseded but could not be kept.
+func (e *Engine) versionAt(key string, seq uint64, current memtable.IndexNode, found bool) (memtable.IndexNode, bool, error) {
+	// looked up after the index, a write the lookup may have seen kept its predecessor by now
+	if version, ok := e.txns.version(key, seq); ok {
+		return version, true, nil
+	}
+	if found && current.Sequence > seq {
+		return memtable.IndexNode{}, false, &ErrTxnConflict{Key: key}
+	}
+	return current, found, nil
+}
+
+// Set writes key when the transaction commits.
+func (txn *Txn) Set(key string, value []byte) error {
+	if txn.done {
+		return errTxnDone
+	}
+	if len([]byte(key)) > int(txn.engine.Config.KeySize) {
+		return &shared.ErrKeyTooLong{Key: key, KeySize: txn.engine.Config.KeySize}
+	}
+	if err := checkUserKey(key); err != nil {
+		return err
+	}
+	if _, ok := txn.writes[key]; !ok {
+		txn.order = append(txn.order, key)
+	}
+	txn.writes[key] = value
+	return nil
+}
+
+// Delete deletes key when the transaction commits.
+func (txn *Txn) Delete(key string) error {
+	return txn.Set(key, []byte{})
+}
+
+// Commit applies the writes of the transaction atomically. It fails with
+// ErrTxnConflict, writing nothing, if a key the transaction wrote was written by
+// someone else after the transaction began.
+func (txn *Txn) Commit() error {
+	if txn.done {
+		return errTxnDone
+	}
+	e := txn.engine
+	txn.done = true
+	defer e.txns.end(txn)
+
+	if len(txn.order) == 0 {
+		return nil
+	}
+
+	now := time.Now().UnixNano()
+	requests := make([]writeRequest, len(txn.order))
+	for i, key := range txn.order {
+		requests[i] = writeRequest{
+			entry: wal.WALEntry{Key: key, Value: txn.writes[key], Timestamp: now},
+			done:  make(chan error, 1),
+		}
+	}
+
+	validate := func() error {
+		for _, key := range txn.order {
+			if e.txns.writtenAfter(key, txn.sequence) {
+				return &ErrTxnConflict{Key: key}
+			}
+		}
+		return nil
+	}
+	e.pipeline.submitBatch(&commitBatch{requests: requests, validate: validate})
+
+	for _, req := range requests {
+		if err := <-req.done; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback discards the transaction.
+func (txn *Txn) Rollback() {
+	if txn.done {
+		return
+	}
+	txn.done = true
+	txn.engine.txns.end(txn)
+}
@@ -0,0 +1,123 @@
+package goldb
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// TestTxnReadsItsSnapshot overwrites, deletes and creates keys after a
+// transaction began, flushing, compacting and collecting the value garbage in
+// between, and checks the transaction still reads the keys as they were.
+func TestTxnReadsItsSnapshot(t *testing.T) {
+	e := openTestEngine(t, t.TempDir(), func(c *EngineConfig) {
+		c.MemtableSizeThreshold = 16
+	})
+	defer e.Close()
+
+	for i := 0; i < 40; i++ {
+		if err := e.Set(fmt.Sprintf("key-%02d", i), []byte(fmt.Sprintf("old-%02d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	txn := e.Begin()
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 40; i++ {
+			key := fmt.Sprintf("key-%02d", i)
+			var err error
+			if i%4 == 0 {
+				err = e.Delete(key)
+			} else {
+				err = e.Set(key, []byte(fmt.Sprintf("new-%d-%02d", round, i)))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := e.Set("created", []byte("after")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.CollectValueGarbage(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		value, err := txn.Get(key)
+		if err != nil {
+			t.Fatalf("reading %q: %v", key, err)
+		}
+		if want := fmt.Sprintf("old-%02d", i); string(value) != want {
+			t.Fatalf("%q is %q in the transaction, want %q", key, value, want)
+		}
+	}
+	if _, err := txn.Get("created"); err == nil {
+		t.Fatal("the transaction reads a key created after it began")
+	} else if _, ok := err.(*shared.ErrKeyNotFound); !ok {
+		t.Fatalf("reading a key created after the transaction began: %v", err)
+	}
+
+	// writing a key nobody else wrote commits
+	if err := txn.Set("txn-only", []byte("mine")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("committing: %v", err)
+	}
+	if len(e.txns.versions) != 0 || len(e.txns.writes) != 0 {
+		t.Fatalf("%d versions and %d writes are kept with no transaction running", len(e.txns.versions), len(e.txns.writes))
+	}
+}
+
+// TestTxnVersionsOutliveOnlyTheirReaders runs two transactions that began at
+// different writes and checks each reads its own version, and that the versions
+// only the first one needs are dropped once it is done.
+func TestTxnVersionsOutliveOnlyTheirReaders(t *testing.T) {
+	e := openTestEngine(t, t.TempDir())
+	defer e.Close()
+
+	if err := e.Set("key", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	first := e.Begin()
+	if err := e.Set("key", []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	second := e.Begin()
+	if err := e.Set("key", []byte("v3")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		txn  *Txn
+		want string
+	}{{first, "v1"}, {second, "v2"}} {
+		value, err := c.txn.Get("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(value) != c.want {
+			t.Fatalf("the transaction reads %q, want %q", value, c.want)
+		}
+	}
+
+	first.Rollback()
+	if n := len(e.txns.versions["key"]); n != 1 {
+		t.Fatalf("%d versions are kept for the running transaction, want 1", n)
+	}
+	second.Rollback()
+	if len(e.txns.versions) != 0 {
+		t.Fatalf("%d keys have versions kept with no transaction running", len(e.txns.versions))
+	}
+
+	value, err := e.Get("key")
+	if err != nil || string(value) != "v3" {
+		t.Fatalf("the key reads %q, %v, want v3", value, err)
+	}
+}
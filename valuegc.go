@@ -0,0 +1,175 @@
+package goldb
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hasssanezzz/goldb/internal/memtable"
+)
+
+// valueLock keeps the values where the index says they are while they are read.
+// A garbage collection of the value file moves them under the write lock.
+type valueLock struct {
+	mu         sync.RWMutex
+	generation atomic.Uint64 // Number of collections, an iterator reads no value moved since it was created.
+}
+
+// errValuesMoved is returned by the iterators created before a garbage collection
+// of the value file moved their values.
+var errValuesMoved = fmt.Errorf("values were moved by a garbage collection since the iterator was created")
+
+// ValueGCStats describes a garbage collection of the value file.
+type ValueGCStats struct {
+	LiveBytes      int64 // Size of the values kept.
+	ReclaimedBytes int64 // Space freed in the value file.
+}
+
+// CollectValueGarbage rewrites the value file with only the values the index
+// still refers to and points the index at their new location, freeing the
+// space of the values overwritten or deleted since they were written. Values
+// shared by several keys, through Link, CopyRange or DedupValues, are kept once;
+// deleted keys that can still be restored and the dropped prefixes in the trash
+// keep their values too.
+//
+// The memtable is flushed first, and writes wait until the collection is done.
+// Reads wait while the files are swapped; iterators created before the
+// collection fail to read values afterwards and have to be created again.
+// ValueGCThreshold runs it after a flush once the value file grew large enough.
+func (e *Engine) CollectValueGarbage() (ValueGCStats, error) {
+	var stats ValueGCStats
+	err := e.pipeline.exclusive(func() error {
+		// the WAL may point at values through links, flushing rewrites it
+		e.flush()
+		var err error
+		stats, err = e.collectValueGarbage()
+		return err
+	})
+	return stats, err
+}
+
+// collectValueGarbage runs a garbage collection of the value file. The caller
+// holds writeMu with every logged write applied, and the WAL points at no value.
+func (e *Engine) collectValueGarbage() (ValueGCStats, error) {
+	live, err := e.indexManager.LiveValues()
+	if err != nil {
+		return ValueGCStats{}, fmt.Errorf("db engine can not list the live values: %v", err)
+	}
+	return e.rewriteValues(live)
+}
+
+// rewriteValues moves the live values to a new value file and swaps it in.
+func (e *Engine) rewriteValues(live []memtable.IndexNode) (ValueGCStats, error) {
+	// the flusher syncs the value file, it must not see it swapped
+	e.flushMu.Lock()
+	defer e.flushMu.Unlock()
+
+	size, err := e.storageManager.Size()
+	if err != nil {
+		return ValueGCStats{}, err
+	}
+	if err := e.syncValues(); err != nil {
+		return ValueGCStats{}, err
+	}
+
+	// the values of the snapshots and transactions stay readable until they are done
+	live = e.txns.values(live)
+
+	path := filepath.Join(e.Config.Homepath, "data.bin")
+	staged := path + ".gc.tmp"
+	moved, err := e.storageManager.CopyTo(staged, live)
+	if err != nil {
+		return ValueGCStats{}, err
+	}
+
+	e.values.mu.Lock()
+	defer e.values.mu.Unlock()
+
+	if err := e.indexManager.RelocateValues(moved, map[string]string{staged: path}); err != nil {
+		return ValueGCStats{}, err
+	}
+	if err := e.storageManager.Reopen(); err != nil {
+		return ValueGCStats{}, err
+	}
+	e.values.generation.Add(1)
+	if e.dedup != nil {
+		e.dedup.relocate(moved)
+	}
+	e.txns.relocate(moved)
+
+	stats := ValueGCStats{}
+	for _, node := range live {
+		stats.LiveBytes += int64(node.Size)
+	}
+	stats.ReclaimedBytes = size - stats.LiveBytes
+	e.counters.valueBytesReclaimed.Add(uint64(stats.ReclaimedBytes))
+	e.liveValueBytes = stats.LiveBytes
+	invalidateReplicas(e.Config.Homepath)
+
+	log.Printf("db engine: collected the garbage of the value file, %d bytes live, %d reclaimed\n", stats.LiveBytes, stats.ReclaimedBytes)
+	return stats, nil
+}
+
+// collectValueGarbageIfNeeded runs a garbage collection once the value file is
+// ValueGCThreshold times larger than its live values. Those are only counted
+// again once the file grew past the threshold of the last count, so flushes do
+// not walk the index every time. The caller is flush.
+func (e *Engine) collectValueGarbageIfNeeded() {
+	if !e.valueGarbageDue() {
+		return
+	}
+	threshold := e.Config.ValueGCThreshold
+	size, err := e.storageManager.Size()
+	if err != nil {
+		log.Println("engine can not check the value file: ", err)
+		return
+	}
+
+	live, err := e.indexManager.LiveValues()
+	if err != nil {
+		log.Println("engine can not list the live values: ", err)
+		return
+	}
+	e.liveValueBytes = 0
+	for _, node := range live {
+		e.liveValueBytes += int64(node.Size)
+	}
+	if float64(size) <= threshold*float64(e.liveValueBytes) {
+		return
+	}
+
+	if _, err := e.rewriteValues(live); err != nil {
+		log.Println("engine can not collect the garbage of the value file: ", err)
+	}
+}
+
+// valueGarbageDue reports whether the value file grew past the threshold of the
+// last count of its live values. The caller holds writeMu.
+func (e *Engine) valueGarbageDue() bool {
+	threshold := e.Config.ValueGCThreshold
+	if threshold == 0 {
+		return false
+	}
+	size, err := e.storageManager.Size()
+	if err != nil {
+		log.Println("engine can not check the value file: ", err)
+		return false
+	}
+	return float64(size) > threshold*float64(e.liveValueBytes)
+}
+
+// relocate points the records at the new offsets of the values moved by a
+// garbage collection, forgetting the ones collected.
+func (d *dedupIndex) relocate(moved map[uint32]uint32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for sum, indexNode := range d.records {
+		if !indexNode.Relocate(moved) {
+			delete(d.records, sum)
+			continue
+		}
+		d.records[sum] = indexNode
+	}
+}
@@ -0,0 +1,212 @@
+package goldb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// gcValue is the value of key at version, large enough for the value file to
+// hold most of the bytes.
+func gcValue(key string, version int) []byte {
+	return append([]byte(fmt.Sprintf("%s=%d;", key, version)), bytes.Repeat([]byte{'v'}, 100)...)
+}
+
+// TestValueGCReclaimsSpace overwrites and deletes keys, some linked to others,
+// and checks a collection shrinks the value file to the live values, which
+// read back from where they were moved, before and after a reopen.
+func TestValueGCReclaimsSpace(t *testing.T) {
+	dir := t.TempDir()
+	e := openTestEngine(t, dir, func(c *EngineConfig) {
+		c.MemtableSizeThreshold = 16
+	})
+
+	const keys = 60
+	want := map[string][]byte{}
+	for version := 0; version < 3; version++ {
+		for i := 0; i < keys; i++ {
+			key := fmt.Sprintf("key-%02d", i)
+			if err := e.Set(key, gcValue(key, version)); err != nil {
+				t.Fatal(err)
+			}
+			want[key] = gcValue(key, version)
+		}
+	}
+	for i := 0; i < keys; i += 3 {
+		key := fmt.Sprintf("key-%02d", i)
+		if err := e.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+		delete(want, key)
+	}
+	if err := e.Link("key-01", "linked"); err != nil {
+		t.Fatal(err)
+	}
+	want["linked"] = want["key-01"]
+
+	path := filepath.Join(dir, "data.bin")
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := e.CollectValueGarbage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// two older versions of every key and the current one of the deleted keys
+	superseded := int64(2*keys+keys/3) * int64(len(gcValue("key-01", 2)))
+	if after.Size() != stats.LiveBytes || stats.ReclaimedBytes < superseded || before.Size()-after.Size() < superseded {
+		t.Fatalf("the collection reports %+v, the value file went from %d to %d bytes, want %d reclaimed", stats, before.Size(), after.Size(), superseded)
+	}
+
+	check := func() {
+		t.Helper()
+		for key, value := range want {
+			if got, err := e.Get(key); err != nil || !bytes.Equal(got, value) {
+				t.Fatalf("%q reads %q, %v, want %q", key, got, err, value)
+			}
+		}
+		for i := 0; i < keys; i += 3 {
+			key := fmt.Sprintf("key-%02d", i)
+			if _, err := e.Get(key); err == nil {
+				t.Fatalf("the deleted %q reads back", key)
+			} else if _, ok := err.(*shared.ErrKeyNotFound); !ok {
+				t.Fatalf("the deleted %q fails with %v", key, err)
+			}
+		}
+	}
+	check()
+	if err := e.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	check()
+	e.Close()
+
+	e = openTestEngine(t, dir)
+	defer e.Close()
+	check()
+}
+
+// TestValueGCAlongsideWritesAndSnapshots runs collections while writers set
+// their keys and read them back, and readers take snapshots and read them.
+// Every value read has to be the one of its key, an offset the collection left
+// stale reads another value or none. Run it with -race.
+func TestValueGCAlongsideWritesAndSnapshots(t *testing.T) {
+	e := openTestEngine(t, t.TempDir(), func(c *EngineConfig) {
+		c.MemtableSizeThreshold = 32
+	})
+	defer e.Close()
+
+	const writers, keys, writes = 4, 20, 200
+	key := func(w, k int) string { return fmt.Sprintf("w%d-%02d", w, k) }
+	for w := 0; w < writers; w++ {
+		for k := 0; k < keys; k++ {
+			if err := e.Set(key(w, k), gcValue(key(w, k), 0)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	errs := make(chan error, writers+2)
+	stop := make(chan struct{})
+	var wg, others sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 1; i <= writes; i++ {
+				k := key(w, i%keys)
+				if err := e.Set(k, gcValue(k, i)); err != nil {
+					errs <- fmt.Errorf("set %q: %v", k, err)
+					return
+				}
+				if got, err := e.Get(k); err != nil || !bytes.Equal(got, gcValue(k, i)) {
+					errs <- fmt.Errorf("writer %d reads %q as %q, %v, want version %d", w, k, got, err, i)
+					return
+				}
+			}
+		}(w)
+	}
+
+	others.Add(2)
+	go func() {
+		defer others.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := e.CollectValueGarbage(); err != nil {
+				errs <- fmt.Errorf("collection: %v", err)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	go func() {
+		defer others.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			s, err := e.Snapshot()
+			if err != nil {
+				errs <- fmt.Errorf("snapshot: %v", err)
+				return
+			}
+			// the snapshot is read again after the collections that ran meanwhile
+			for pass := 0; pass < 2; pass++ {
+				for w := 0; w < writers; w++ {
+					for k := 0; k < keys; k++ {
+						value, err := s.Get(key(w, k))
+						if err != nil || !strings.HasPrefix(string(value), key(w, k)+"=") {
+							s.Release()
+							errs <- fmt.Errorf("the snapshot reads %q as %q, %v", key(w, k), value, err)
+							return
+						}
+					}
+				}
+				time.Sleep(time.Millisecond)
+			}
+			s.Release()
+		}
+	}()
+
+	wg.Wait()
+	close(stop)
+	others.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if t.Failed() {
+		return
+	}
+
+	if _, err := e.CollectValueGarbage(); err != nil {
+		t.Fatal(err)
+	}
+	for w := 0; w < writers; w++ {
+		for k := 0; k < keys; k++ {
+			// the last version the writer set for the key
+			version := writes - (writes-k)%keys
+			if got, err := e.Get(key(w, k)); err != nil || !bytes.Equal(got, gcValue(key(w, k), version)) {
+				t.Fatalf("%q reads %q, %v, want version %d", key(w, k), got, err, version)
+			}
+		}
+	}
+}
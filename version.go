@@ -0,0 +1,102 @@
+package goldb
+
+import (
+	"slices"
+
+	"github.com/hasssanezzz/goldb/internal/shared"
+)
+
+// libraryVersion is the version of the library, bumped with every release.
+const libraryVersion = "0.1.0"
+
+// Names of the optional subsystems reported by Features.
+const (
+	FeatureCompression          = "compression"
+	FeatureValueDedup           = "value-dedup"
+	FeatureWALEncryption        = "wal-encryption"
+	FeatureWALArchive           = "wal-archive"
+	FeatureInlineValues         = "inline-values"
+	FeaturePartitionedFlush     = "partitioned-flush"
+	FeatureLeveledCompaction    = "leveled-compaction"
+	FeatureSizeTieredCompaction = "size-tiered-compaction"
+	FeatureFIFOCompaction       = "fifo-compaction"
+	FeatureCustomCompaction     = "custom-compaction"
+	FeatureBlockCache           = "block-cache"
+	FeatureSkiplistMemtable     = "skiplist-memtable"
+	FeatureRelaxedWrites        = "relaxed-writes"
+	FeatureWriteCoalescing      = "write-coalescing"
+	FeatureValueGC              = "value-gc"
+	FeatureUndelete             = "undelete"
+	FeatureTrash                = "trash"
+	FeatureMetrics              = "metrics"
+	FeatureSyncPoints           = "sync-points"
+)
+
+// VersionInfo describes the library, see Version.
+type VersionInfo struct {
+	Library string // Semantic version of the library.
+	Format  int    // Newest on-disk format version the library reads and writes.
+}
+
+// Version returns the version of the library and of the newest on-disk format
+// it supports, so tools can tell whether they can open a database.
+func Version() VersionInfo {
+	return VersionInfo{Library: libraryVersion, Format: formatVersion}
+}
+
+// Features describes what an open database supports, for tools and servers to
+// negotiate what they may ask of it.
+type Features struct {
+	VersionInfo
+	DatabaseFormat int      // Format version of the database, raised to Format when it is opened.
+	Subsystems     []string // Optional subsystems enabled for the database, sorted, see the Feature constants.
+}
+
+// Has reports whether the subsystem is enabled.
+func (f Features) Has(subsystem string) bool {
+	_, found := slices.BinarySearch(f.Subsystems, subsystem)
+	return found
+}
+
+// Features returns the versions of the library and of the database, and the
+// optional subsystems its configuration enables.
+func (e *Engine) Features() Features {
+	c := &e.Config
+	enabled := map[string]bool{
+		FeatureCompression:      c.Compression != nil,
+		FeatureValueDedup:       c.DedupValues,
+		FeatureWALEncryption:    c.WALEncryptionKey != nil || c.WALKeyProvider != nil,
+		FeatureWALArchive:       c.Archive != nil,
+		FeatureInlineValues:     c.InlineValueSize > 0,
+		FeaturePartitionedFlush: c.FlushFormat == shared.FlushPartitioned,
+		FeatureBlockCache:       c.BlockCacheSize > 0,
+		FeatureSkiplistMemtable: c.MemtableKind == shared.MemtableSkiplist,
+		FeatureRelaxedWrites:    c.RelaxedWrites,
+		FeatureWriteCoalescing:  c.WriteCoalesceWindow > 0,
+		FeatureValueGC:          c.ValueGCThreshold > 0,
+		FeatureUndelete:         c.UndeleteWindow > 0,
+		FeatureTrash:            c.TrashRetention > 0,
+		FeatureMetrics:          c.Metrics != nil,
+		FeatureSyncPoints:       SyncPointsEnabled,
+	}
+	switch c.CompactionStrategy.(type) {
+	case nil, shared.PickerStrategy:
+	case shared.LeveledStrategy:
+		enabled[FeatureLeveledCompaction] = true
+	case shared.SizeTieredStrategy:
+		enabled[FeatureSizeTieredCompaction] = true
+	case shared.FIFOStrategy:
+		enabled[FeatureFIFOCompaction] = true
+	default:
+		enabled[FeatureCustomCompaction] = true
+	}
+
+	subsystems := []string{}
+	for name, on := range enabled {
+		if on {
+			subsystems = append(subsystems, name)
+		}
+	}
+	slices.Sort(subsystems)
+	return Features{VersionInfo: Version(), DatabaseFormat: e.format, Subsystems: subsystems}
+}
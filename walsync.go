@@ -0,0 +1,54 @@
+package goldb
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/wal"
+)
+
+// defaultSyncInterval is how often the WAL is synced in SyncInterval mode when
+// no interval is configured.
+const defaultSyncInterval = 100 * time.Millisecond
+
+// walSyncer syncs the WAL in the background, bounding the writes a power loss
+// may take to those of the last interval without making every write wait on a
+// sync.
+type walSyncer struct {
+	wal  *wal.WAL
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newWALSyncer(w *wal.WAL, interval time.Duration) *walSyncer {
+	if interval <= 0 {
+		interval = defaultSyncInterval
+	}
+	s := &walSyncer{wal: w, stop: make(chan struct{})}
+	s.wg.Add(1)
+	go labeled("wal-sync", func() { s.run(interval) })
+	return s
+}
+
+func (s *walSyncer) run(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.wal.Sync(); err != nil {
+				log.Println("engine can not sync the WAL: ", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *walSyncer) close() {
+	close(s.stop)
+	s.wg.Wait()
+}